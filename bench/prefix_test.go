@@ -7,7 +7,7 @@ import (
 )
 
 type prefix_insert struct {
-	prefix    []byte
+	prefix    core.BitString
 	valueHash []byte
 }
 
@@ -68,7 +68,7 @@ func getPrefixKeyValueSigPairs(numPairs uint32) []prefix_insert {
 
 	for i := uint32(0); i < numPairs; i++ {
 
-		prefix := core.ConvertBitsToBytes(GenerateRandomByteArray(PREFIXBYTESIZE))
+		prefix := core.NewBitString(GenerateRandomByteArray(PREFIXBYTESIZE))
 		valueHash := GenerateRandomByteArray(VALUEHASHSIZE)
 
 		ins := prefix_insert{
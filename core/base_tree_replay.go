@@ -0,0 +1,309 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	libcrypto "github.com/huyuncong/MerkleSquare/lib/crypto"
+	"github.com/immesys/bw2/crypto"
+)
+
+// BaseTreeUpdate is a single append as it was inserted into the base tree.
+type BaseTreeUpdate struct {
+	IdentifierHash     []byte
+	ValueHash          []byte
+	Pos                uint32
+	VerificationPeriod uint64 // the period whose base tree the append went into
+}
+
+// EpochUpdates holds the appends rolled up into the update prefix tree of an
+// update epoch, in the order they were made. Appends made just before a
+// verification period ends are rolled up in the next period, so
+// VerificationPeriod, the period the epoch ended in, can be later than that
+// of its updates.
+type EpochUpdates struct {
+	Epoch              uint64
+	VerificationPeriod uint64
+	Updates            []BaseTreeUpdate
+}
+
+// UpdateHistoryProvider is implemented by partitions that can keep the updates
+// of every epoch, so that an auditor can rebuild their base trees. They only
+// keep them from the first epoch that ends after KeepUpdateHistory is called,
+// until PruneEpochUpdates drops them.
+type UpdateHistoryProvider interface {
+	KeepUpdateHistory()
+	GetEpochUpdates(epoch uint64) (*EpochUpdates, error)
+	PruneEpochUpdates(through uint64)
+}
+
+var _ UpdateHistoryProvider = (*Partition)(nil)
+var _ UpdateHistoryProvider = (*AggHistPartition)(nil)
+
+// StoredUpdatesAck is an auditor's signed statement that it has stored the
+// updates of a partition up to and including epoch Through, so that it no
+// longer needs the server to keep them.
+type StoredUpdatesAck struct {
+	AuditorKey []byte
+	Through    uint64
+	Signature  []byte
+}
+
+// storedUpdatesMessage is what auditors sign. It covers the partition
+// binding, so an acknowledgement cannot be replayed for another partition or
+// log.
+func storedUpdatesMessage(binding *PartitionBinding, through uint64) []byte {
+	return libcrypto.Hash([]byte("legolog stored updates"), binding.Hash(), uint64ToBytes(through))
+}
+
+// AckStoredUpdates signs, as the auditor with key pair (auditorSK,
+// auditorVK), that it stored the updates of the partition with binding up to
+// and including epoch through.
+func AckStoredUpdates(auditorSK []byte, auditorVK []byte, binding *PartitionBinding, through uint64) *StoredUpdatesAck {
+	signature := make([]byte, 64)
+	crypto.SignBlob(auditorSK, auditorVK, signature, storedUpdatesMessage(binding, through))
+	return &StoredUpdatesAck{
+		AuditorKey: append([]byte{}, auditorVK...),
+		Through:    through,
+		Signature:  signature,
+	}
+}
+
+// Verify checks that a was signed by its auditor for the partition with
+// binding.
+func (a *StoredUpdatesAck) Verify(binding *PartitionBinding) bool {
+	if len(a.AuditorKey) != 32 || len(a.Signature) != 64 {
+		return false
+	}
+	return crypto.VerifyBlob(a.AuditorKey, a.Signature, storedUpdatesMessage(binding, a.Through))
+}
+
+// updateHistory records updates as they are appended and, if keep is set,
+// keeps the updates of every epoch once it ends. Epochs are numbered from 1;
+// epochs holds those after pruned, in order.
+type updateHistory struct {
+	keep    bool
+	pending []BaseTreeUpdate
+	epochs  []*EpochUpdates
+	pruned  uint64
+}
+
+func (h *updateHistory) append(idHash []byte, valueHash []byte, pos uint32, verificationPeriod uint64) {
+	if !h.keep {
+		return
+	}
+	h.pending = append(h.pending, BaseTreeUpdate{idHash, valueHash, pos, verificationPeriod})
+}
+
+func (h *updateHistory) endEpoch(epoch uint64, verificationPeriod uint64) {
+	if !h.keep {
+		return
+	}
+	if len(h.epochs) == 0 {
+		h.pruned = epoch - 1
+	}
+	h.epochs = append(h.epochs, &EpochUpdates{
+		Epoch:              epoch,
+		VerificationPeriod: verificationPeriod,
+		Updates:            h.pending,
+	})
+	h.pending = nil
+}
+
+func (h *updateHistory) get(epoch uint64) (*EpochUpdates, error) {
+	if !h.keep {
+		return nil, errors.New("partition does not keep its update history")
+	}
+	if epoch != 0 && epoch <= h.pruned {
+		return nil, fmt.Errorf("updates of epoch %d were pruned", epoch)
+	}
+	if epoch == 0 || epoch-h.pruned > uint64(len(h.epochs)) {
+		return nil, fmt.Errorf("no updates for epoch %d", epoch)
+	}
+	return h.epochs[epoch-h.pruned-1], nil
+}
+
+// prune drops the updates of every epoch up to and including through.
+func (h *updateHistory) prune(through uint64) {
+	if through <= h.pruned {
+		return
+	}
+	n := through - h.pruned
+	if n > uint64(len(h.epochs)) {
+		n = uint64(len(h.epochs))
+	}
+	h.epochs = append([]*EpochUpdates{}, h.epochs[n:]...)
+	h.pruned += n
+}
+
+// replayedEpoch is what a BaseTreeReplayer remembers of an update epoch.
+type replayedEpoch struct {
+	updateSetRoot      []byte
+	verificationPeriod uint64
+}
+
+// BaseTreeReplayer rebuilds the base trees of a partition from the updates of
+// each of its epochs, to check that every published base tree is the previous
+// one with exactly the updates of its verification period merged in. With an
+// aggregated history, it also keeps the HistoryForest over the replayed base
+// trees, appending each one once all of its updates have been replayed.
+type BaseTreeReplayer struct {
+	cfg      Config
+	baseTree *persistentPrefixTree
+	epochs   []replayedEpoch
+	forest   *HistoryForest
+}
+
+func NewBaseTreeReplayer(cfg Config) *BaseTreeReplayer {
+	r := &BaseTreeReplayer{
+		cfg:      cfg,
+		baseTree: NewPersistentPrefixTree(),
+	}
+	if cfg.AggHistory {
+		r.forest = NewHistoryForest(cfg.AggHistoryDepth)
+	}
+	return r
+}
+
+// Epoch returns the last epoch whose updates have been replayed.
+func (r *BaseTreeReplayer) Epoch() uint64 {
+	return uint64(len(r.epochs))
+}
+
+// lastPeriod returns the verification period the last replayed epoch ended in.
+func (r *BaseTreeReplayer) lastPeriod() uint64 {
+	if len(r.epochs) == 0 {
+		return 0
+	}
+	return r.epochs[len(r.epochs)-1].verificationPeriod
+}
+
+// isFinal reports whether every update of the verification period has been
+// replayed. Updates are rolled up by the end of the first epoch of the next
+// period at the latest.
+func (r *BaseTreeReplayer) isFinal(verificationPeriod uint64) bool {
+	return verificationPeriod < r.lastPeriod()
+}
+
+// Apply replays the updates of the epoch after the last replayed one.
+func (r *BaseTreeReplayer) Apply(updates *EpochUpdates) error {
+	if updates.Epoch != r.Epoch()+1 {
+		return fmt.Errorf("expected updates for epoch %d, got epoch %d", r.Epoch()+1, updates.Epoch)
+	}
+	if updates.VerificationPeriod < r.lastPeriod() {
+		return fmt.Errorf("epoch %d ended in verification period %d, before the previous epoch", updates.Epoch, updates.VerificationPeriod)
+	}
+	idHashes := make([][]byte, len(updates.Updates))
+	valueHashes := make([][]byte, len(updates.Updates))
+	for i, update := range updates.Updates {
+		if update.VerificationPeriod < r.baseTree.currEpoch || update.VerificationPeriod > updates.VerificationPeriod {
+			return fmt.Errorf("update %d of epoch %d is for verification period %d, which is out of order", i, updates.Epoch, update.VerificationPeriod)
+		}
+		for r.baseTree.currEpoch < update.VerificationPeriod {
+			r.baseTree.NextEpoch()
+		}
+		r.baseTree.Insert(NewBitString(update.IdentifierHash), update.ValueHash, update.Pos)
+		idHashes[i], valueHashes[i] = update.IdentifierHash, update.ValueHash
+	}
+	for r.baseTree.currEpoch < updates.VerificationPeriod {
+		r.baseTree.NextEpoch()
+	}
+
+	updateSet, err := NewPrefixTreeFromUpdates(idHashes, valueHashes, true)
+	if err != nil {
+		return err
+	}
+	r.epochs = append(r.epochs, replayedEpoch{updateSet.getHash(), updates.VerificationPeriod})
+	return r.extendHistoryForest()
+}
+
+// extendHistoryForest appends to the HistoryForest the base tree of every
+// verification period that has become final.
+func (r *BaseTreeReplayer) extendHistoryForest() error {
+	if r.forest == nil {
+		return nil
+	}
+	for period := uint64(r.forest.Size); r.isFinal(period); period++ {
+		err := r.forest.Append(r.baseTree.getHash(period), period)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks digest against the replayed updates, which must reach up to
+// the digest's epoch. The update prefix trees in the digest must be those of
+// the replayed epochs, and each base tree in it whose updates have all been
+// replayed must match the replayed one.
+func (r *BaseTreeReplayer) Verify(digest *LegologDigest) error {
+	if digest.Epoch != r.Epoch() {
+		return fmt.Errorf("replayed updates up to epoch %d, but the digest is for epoch %d", r.Epoch(), digest.Epoch)
+	}
+	period := digest.VerificationPeriod
+	if r.lastPeriod() > period {
+		return fmt.Errorf("updates were rolled up in verification period %d, after the digest's period %d", r.lastPeriod(), period)
+	}
+
+	// the digest commits to the update prefix trees of the last verification
+	// period and the current one; with an aggregated history, those of the
+	// current period follow once more
+	var updateSetRoots, currentRoots [][]byte
+	for _, epoch := range r.epochs {
+		if epoch.verificationPeriod+1 == period || epoch.verificationPeriod == period {
+			updateSetRoots = append(updateSetRoots, epoch.updateSetRoot)
+		}
+		if epoch.verificationPeriod == period {
+			currentRoots = append(currentRoots, epoch.updateSetRoot)
+		}
+	}
+	if r.cfg.AggHistory {
+		updateSetRoots = append(updateSetRoots, currentRoots...)
+	}
+	if !equalByteSlices(updateSetRoots, digest.UpdateSetRoots) {
+		return errors.New("update prefix trees do not match the replayed updates")
+	}
+
+	if r.cfg.AggHistory {
+		return r.verifyHistoryForest(digest)
+	}
+	if period < 2 || len(digest.BaseTreeRoots) != 2 {
+		return errors.New("digest does not hold the last two base trees")
+	}
+	for i, basePeriod := range []uint64{period - 2, period - 1} {
+		if !r.isFinal(basePeriod) {
+			continue
+		}
+		if !bytes.Equal(r.baseTree.getHash(basePeriod), digest.BaseTreeRoots[i]) {
+			return fmt.Errorf("base tree of verification period %d does not match the replayed updates", basePeriod)
+		}
+	}
+	return nil
+}
+
+// verifyHistoryForest compares the roots of the HistoryForest over the
+// replayed base trees with the digest's, once every base tree it covers has
+// been replayed.
+func (r *BaseTreeReplayer) verifyHistoryForest(digest *LegologDigest) error {
+	size := digest.HistoryForestSize
+	if size == 0 || size > r.forest.Size {
+		return nil
+	}
+	if !equalByteSlices(r.forest.GetOldDigest(size).Roots, digest.HistoryForestRoots) {
+		return fmt.Errorf("history forest over %d base trees does not match the replayed updates", size)
+	}
+	return nil
+}
+
+func equalByteSlices(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
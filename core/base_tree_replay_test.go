@@ -0,0 +1,131 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/immesys/bw2/crypto"
+)
+
+func TestBaseTreeReplay(t *testing.T) {
+	for _, cfg := range []Config{{}, {AggHistory: true, AggHistoryDepth: 31}} {
+		var partition LegoLogPartition = NewPartition()
+		if cfg.AggHistory {
+			partition = NewAggHistPartition(cfg, "")
+		}
+		partition.(UpdateHistoryProvider).KeepUpdateHistory()
+		replayer := NewBaseTreeReplayer(cfg)
+		catchUp := func() {
+			digest := partition.GetDigest()
+			for epoch := replayer.Epoch() + 1; epoch <= digest.Epoch; epoch++ {
+				updates, err := partition.(UpdateHistoryProvider).GetEpochUpdates(epoch)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := replayer.Apply(updates); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := replayer.Verify(digest); err != nil {
+				t.Fatalf("agg history %t, epoch %d: %v", cfg.AggHistory, digest.Epoch, err)
+			}
+		}
+
+		for i := 0; i < 12; i++ {
+			key := []byte(strconv.Itoa(i % 5))
+			partition.Append(key, key, []byte(strconv.Itoa(i)), key)
+			if i%4 == 0 {
+				// leave the append to be rolled up in the next period
+				partition.IncrementVerificationPeriod()
+			}
+			partition.BulkAppend([][]byte{key, []byte("bulk")}, [][]byte{key, key}, [][]byte{key, key})
+			partition.IncrementUpdateEpoch()
+			if i%3 == 2 {
+				partition.IncrementVerificationPeriod()
+			}
+			catchUp()
+		}
+
+		if cfg.AggHistory {
+			// the replayer appends each base tree to its HistoryForest once,
+			// as soon as its period is final
+			if replayer.forest.Size != uint32(replayer.lastPeriod()) {
+				t.Errorf("expected a HistoryForest over %d base trees, got %d", replayer.lastPeriod(), replayer.forest.Size)
+			}
+			forged := *partition.GetDigest()
+			forged.HistoryForestRoots = append([][]byte{}, forged.HistoryForestRoots...)
+			forged.HistoryForestRoots[0] = append([]byte{}, forged.HistoryForestRoots[0]...)
+			forged.HistoryForestRoots[0][0] ^= 1
+			if err := replayer.Verify(&forged); err == nil {
+				t.Error("a forged history forest verified")
+			}
+		}
+
+		// an auditor replaying updates that differ from the ones merged into
+		// the base trees notices once the period is over
+		tampered := NewBaseTreeReplayer(cfg)
+		for epoch := uint64(1); epoch <= partition.GetDigest().Epoch; epoch++ {
+			updates, _ := partition.(UpdateHistoryProvider).GetEpochUpdates(epoch)
+			if epoch == 1 {
+				forged := *updates
+				forged.Updates = append([]BaseTreeUpdate{}, updates.Updates...)
+				forged.Updates[0].Pos += 1
+				updates = &forged
+			}
+			tampered.Apply(updates)
+		}
+		if err := tampered.Verify(partition.GetDigest()); err == nil {
+			t.Errorf("agg history %t: replaying different updates verified", cfg.AggHistory)
+		}
+
+		if err := replayer.Apply(&EpochUpdates{Epoch: replayer.Epoch() + 2}); err == nil {
+			t.Error("replayer skipped an epoch")
+		}
+
+		history := partition.(UpdateHistoryProvider)
+		history.PruneEpochUpdates(5)
+		if _, err := history.GetEpochUpdates(5); err == nil {
+			t.Error("got the updates of a pruned epoch")
+		}
+		if updates, err := history.GetEpochUpdates(6); err != nil || updates.Epoch != 6 {
+			t.Errorf("expected the updates of epoch 6 to be kept, got %v", err)
+		}
+	}
+}
+
+func TestStoredUpdatesAck(t *testing.T) {
+	auditorSK, auditorVK := crypto.GenerateKeypair()
+	binding := &PartitionBinding{LogID: []byte("log"), PartitionIndex: 1, NumPartitions: 2}
+	ack := AckStoredUpdates(auditorSK, auditorVK, binding, 7)
+	if !ack.Verify(binding) {
+		t.Fatal("acknowledgement did not verify")
+	}
+	other := &PartitionBinding{LogID: []byte("log"), PartitionIndex: 0, NumPartitions: 2}
+	if ack.Verify(other) {
+		t.Error("acknowledgement verified for another partition")
+	}
+	ack.Through++
+	if ack.Verify(binding) {
+		t.Error("acknowledgement verified for another epoch")
+	}
+}
+
+func TestUpdateHistoryOnlyKeptOnRequest(t *testing.T) {
+	partition := NewPartition()
+	partition.Append([]byte("a"), []byte("a"), []byte("a"), []byte("a"))
+	partition.IncrementUpdateEpoch()
+	if _, err := partition.GetEpochUpdates(1); err == nil {
+		t.Error("partition kept updates without being asked to")
+	}
+
+	// the history starts at the first epoch that ends once it is kept
+	partition.KeepUpdateHistory()
+	partition.Append([]byte("b"), []byte("b"), []byte("b"), []byte("b"))
+	partition.IncrementUpdateEpoch()
+	if _, err := partition.GetEpochUpdates(1); err == nil {
+		t.Error("got updates of an epoch that ended before the history was kept")
+	}
+	if updates, err := partition.GetEpochUpdates(2); err != nil || len(updates.Updates) != 1 {
+		t.Errorf("expected the update of epoch 2, got %+v, %v", updates, err)
+	}
+}
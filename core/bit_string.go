@@ -0,0 +1,180 @@
+package core
+
+import (
+	"strings"
+)
+
+// BitString is a string of bits packed eight to a byte, most significant bit
+// first. It is used for every key prefix and partial prefix in the prefix
+// trees and their proofs.
+//
+// Hashes are still computed over Unpack, the one-byte-per-bit form the trees
+// used before, so packing does not change any root hash.
+type BitString struct {
+	Packed []byte `json:"packed"`
+	Length int    `json:"length"`
+}
+
+// NewBitString returns the bit string holding all bits of b. b is not copied.
+func NewBitString(b []byte) BitString {
+	return BitString{Packed: b, Length: 8 * len(b)}
+}
+
+// PackBits packs bits given one per byte, as returned by ConvertBitsToBytes.
+func PackBits(bits []byte) BitString {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit != 0 {
+			packed[i/8] |= 1 << (7 - uint(i%8))
+		}
+	}
+	return BitString{Packed: packed, Length: len(bits)}
+}
+
+func (s BitString) Len() int {
+	return s.Length
+}
+
+// Bit returns the bit at index i as 0 or 1.
+func (s BitString) Bit(i int) byte {
+	return (s.Packed[i/8] >> (7 - uint(i%8))) & 1
+}
+
+// Unpack returns the bits one per byte. Partial prefixes are hashed in this
+// form; the empty string unpacks to nil.
+func (s BitString) Unpack() []byte {
+	if s.Length == 0 {
+		return nil
+	}
+	bits := make([]byte, s.Length)
+	for i := range bits {
+		bits[i] = s.Bit(i)
+	}
+	return bits
+}
+
+// Slice returns bits [from, to) as a new bit string.
+func (s BitString) Slice(from int, to int) BitString {
+	if from < 0 || to > s.Length || from > to {
+		panic("bit string slice out of range")
+	}
+	n := to - from
+	packed := make([]byte, (n+7)/8)
+	src := s.Packed[from/8:]
+	shift := uint(from % 8)
+	for i := range packed {
+		b := src[i] << shift
+		if shift != 0 && i+1 < len(src) {
+			b |= src[i+1] >> (8 - shift)
+		}
+		packed[i] = b
+	}
+	if n%8 != 0 {
+		// keep the bits past the end zeroed
+		packed[len(packed)-1] &= 0xff << (8 - uint(n%8))
+	}
+	return BitString{Packed: packed, Length: n}
+}
+
+// SliceFrom returns the bits from index from onwards as a new bit string.
+func (s BitString) SliceFrom(from int) BitString {
+	return s.Slice(from, s.Length)
+}
+
+// Append returns a new bit string holding the bits of s followed by those
+// of o.
+func (s BitString) Append(o BitString) BitString {
+	n := s.Length + o.Length
+	packed := make([]byte, (n+7)/8)
+	copy(packed, s.Packed[:(s.Length+7)/8])
+	if s.Length%8 == 0 {
+		copy(packed[s.Length/8:], o.Packed[:(o.Length+7)/8])
+		return BitString{Packed: packed, Length: n}
+	}
+	for i := 0; i < o.Length; i++ {
+		if o.Bit(i) != 0 {
+			j := s.Length + i
+			packed[j/8] |= 1 << (7 - uint(j%8))
+		}
+	}
+	return BitString{Packed: packed, Length: n}
+}
+
+// AppendBit returns a new bit string holding the bits of s followed by bit.
+func (s BitString) AppendBit(bit byte) BitString {
+	return s.Append(BitString{Packed: []byte{(bit & 1) << 7}, Length: 1})
+}
+
+// CommonPrefixLength returns the number of leading bits s and o agree on.
+// Bits past the end of either string are never compared, so strings decoded
+// from untrusted proofs cannot smuggle in differences through padding.
+func (s BitString) CommonPrefixLength(o BitString) int {
+	n := s.Length
+	if o.Length < n {
+		n = o.Length
+	}
+	i := 0
+	for i+8 <= n && s.Packed[i/8] == o.Packed[i/8] {
+		i += 8
+	}
+	for i < n && s.Bit(i) == o.Bit(i) {
+		i++
+	}
+	return i
+}
+
+// commonPrefixLengthFrom returns the number of bits o agrees on with s
+// starting at index from, without slicing s.
+func (s BitString) commonPrefixLengthFrom(from int, o BitString) int {
+	i := 0
+	for i < o.Length && from+i < s.Length && s.Bit(from+i) == o.Bit(i) {
+		i++
+	}
+	return i
+}
+
+// hasPrefixAt reports whether the bits of s starting at index from begin
+// with prefix.
+func (s BitString) hasPrefixAt(from int, prefix BitString) bool {
+	return s.commonPrefixLengthFrom(from, prefix) == prefix.Length
+}
+
+func (s BitString) Equal(o BitString) bool {
+	return s.Length == o.Length && s.CommonPrefixLength(o) == s.Length
+}
+
+// HasPrefix reports whether s begins with prefix.
+func (s BitString) HasPrefix(prefix BitString) bool {
+	return prefix.Length <= s.Length && s.CommonPrefixLength(prefix) == prefix.Length
+}
+
+// Compare orders bit strings lexicographically, returning -1, 0 or 1.
+func (s BitString) Compare(o BitString) int {
+	i := s.CommonPrefixLength(o)
+	switch {
+	case i == s.Length && i == o.Length:
+		return 0
+	case i == s.Length:
+		return -1
+	case i == o.Length:
+		return 1
+	case s.Bit(i) < o.Bit(i):
+		return -1
+	default:
+		return 1
+	}
+}
+
+// wellFormed reports whether Packed holds at least Length bits. Bit strings
+// taken from proofs must be checked before use.
+func (s BitString) wellFormed() bool {
+	return s.Length >= 0 && len(s.Packed)*8 >= s.Length
+}
+
+func (s BitString) String() string {
+	var b strings.Builder
+	for i := 0; i < s.Length; i++ {
+		b.WriteByte('0' + s.Bit(i))
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBitStringPackUnpack(t *testing.T) {
+	bits := []byte{1, 0, 1, 1, 0, 0, 0, 1, 1, 0, 1}
+	s := PackBits(bits)
+
+	if s.Len() != len(bits) {
+		t.Errorf("expected length %d, got %d", len(bits), s.Len())
+	}
+	if !bytes.Equal(s.Unpack(), bits) {
+		t.Errorf("expected %v, got %v", bits, s.Unpack())
+	}
+	if s.String() != "10110001101" {
+		t.Errorf("unexpected string %s", s)
+	}
+	if PackBits(nil).Unpack() != nil {
+		t.Error("empty bit string should unpack to nil")
+	}
+
+	name := []byte("kian")
+	if !bytes.Equal(NewBitString(name).Unpack(), ConvertBitsToBytes(name)) {
+		t.Error("NewBitString does not agree with ConvertBitsToBytes")
+	}
+}
+
+func TestBitStringSliceAppend(t *testing.T) {
+	bits := ConvertBitsToBytes([]byte("yuncong"))
+	s := PackBits(bits)
+
+	for from := 0; from <= len(bits); from += 3 {
+		for to := from; to <= len(bits); to += 5 {
+			slice := s.Slice(from, to)
+			if !bytes.Equal(slice.Unpack(), bits[from:to]) {
+				t.Fatalf("slice [%d, %d) is %s", from, to, slice)
+			}
+			if !s.Slice(0, from).Append(s.SliceFrom(from)).Equal(s) {
+				t.Fatalf("splitting at %d and appending does not round trip", from)
+			}
+		}
+	}
+
+	if !PackBits([]byte{0, 1}).AppendBit(1).Equal(PackBits([]byte{0, 1, 1})) {
+		t.Error("AppendBit appended the wrong bit")
+	}
+}
+
+func TestBitStringCompare(t *testing.T) {
+	a := PackBits([]byte{0, 1, 1})
+	b := PackBits([]byte{0, 1, 1, 0})
+	c := PackBits([]byte{1})
+
+	if a.CommonPrefixLength(b) != 3 || !b.HasPrefix(a) || a.HasPrefix(b) {
+		t.Error("prefix relation between a and b is wrong")
+	}
+	if a.Compare(b) != -1 || b.Compare(c) != -1 || c.Compare(a) != 1 || a.Compare(a) != 0 {
+		t.Error("compare gave the wrong order")
+	}
+
+	// differences in the padding bits must be ignored
+	padded := BitString{Packed: []byte{0x7f}, Length: 3}
+	if !padded.Equal(a) {
+		t.Error("padding bits should not affect equality")
+	}
+	if (BitString{Packed: []byte{0}, Length: 9}).wellFormed() {
+		t.Error("bit string longer than its packed bytes reported well formed")
+	}
+}
@@ -166,7 +166,7 @@ func (c *ChronTree) GetRootHash() []byte {
 
 func (c *ChronTree) Append(key []byte, value []byte, signature []byte) {
 	contentHash := ComputeContentHash(key, value, signature, c.numNodes)
-	hashVal := crypto.Hash(makePrefixFromKey(key), contentHash)
+	hashVal := crypto.Hash(makePrefixFromKey(key).Unpack(), contentHash)
 	leaf := leafChronNode{
 		hash: hashVal,
 		id:   c.numNodes + 1,
@@ -245,37 +245,67 @@ func (c *ChronTree) Append(key []byte, value []byte, signature []byte) {
 	c.numNodes += 1
 }
 
+// GenerateConsistencyProof proves that the tree with requestedSize leaves
+// extends the one with oldSize leaves. requestedSize may be smaller than the
+// current size, in which case the proof is built from the subtrees the tree
+// had at requestedSize leaves.
 func (c *ChronTree) GenerateConsistencyProof(oldSize uint32, requestedSize uint32) *MerkleExtensionProof {
 	proof := MerkleExtensionProof{}
-	c.generateConsistencyProof(oldSize, requestedSize, c.root, true, &proof)
+	if requestedSize > c.numNodes {
+		return &proof
+	}
+	c.generateConsistencyProof(oldSize, requestedSize, c.root, c.numNodes, true, &proof)
 	return &proof
 }
 
-func (c *ChronTree) generateConsistencyProof(m uint32, n uint32, currNode ChronNode, isComplete bool, proof *MerkleExtensionProof) {
+// generateConsistencyProof proves that the first n leaves of currNode extend
+// its first m leaves. currNode has currSize leaves.
+func (c *ChronTree) generateConsistencyProof(m uint32, n uint32, currNode ChronNode, currSize uint32, isComplete bool, proof *MerkleExtensionProof) {
 	if m == 0 { // Anything can extend an empty tree, no proof needed.
 		return
 	}
 	if m == n {
 		if !isComplete {
-			proof.PrefixHashes = append(proof.PrefixHashes, currNode.getHash())
+			proof.PrefixHashes = append(proof.PrefixHashes, prefixHash(currNode, currSize, n))
 		}
 		return
 	}
 
-	k := uint32(math.Pow(2, math.Floor(math.Log2(float64(n)))))
-	if k == n {
-		k /= 2
+	// The first n leaves lie in the left subtree until n exceeds its size
+	k := splitSize(currSize)
+	for n <= k {
+		currNode, currSize = currNode.getLeftChild(), k
+		k = splitSize(currSize)
 	}
 	if m <= k {
-		c.generateConsistencyProof(m, k, currNode.getLeftChild(), isComplete, proof)
-		proof.PrefixHashes = append(proof.PrefixHashes, currNode.getRightChild().getHash())
+		c.generateConsistencyProof(m, k, currNode.getLeftChild(), k, isComplete, proof)
+		proof.PrefixHashes = append(proof.PrefixHashes, prefixHash(currNode.getRightChild(), currSize-k, n-k))
 	}
 	if m > k {
-		c.generateConsistencyProof(m-k, n-k, currNode.getRightChild(), false, proof) // TODO: Is n-k right here?
+		c.generateConsistencyProof(m-k, n-k, currNode.getRightChild(), currSize-k, false, proof)
 		proof.PrefixHashes = append(proof.PrefixHashes, currNode.getLeftChild().getHash())
 	}
 }
 
+// prefixHash returns the root hash of the tree made of the first n leaves of
+// node, which has size leaves.
+func prefixHash(node ChronNode, size uint32, n uint32) []byte {
+	for n < size {
+		k := splitSize(size)
+		if n > k {
+			return crypto.Hash(node.getLeftChild().getHash(), prefixHash(node.getRightChild(), size-k, n-k))
+		}
+		node, size = node.getLeftChild(), k
+	}
+	return node.getHash()
+}
+
+// splitSize returns the number of leaves in the left subtree of a tree with n
+// leaves, the largest power of 2 smaller than n.
+func splitSize(n uint32) uint32 {
+	return uint32(math.Pow(2, math.Ceil(math.Log2(float64(n)))-1))
+}
+
 func VerifyConsistencyProof(oldDigest *Digest, newDigest *Digest, proof *MerkleExtensionProof) bool {
 	if oldDigest.Size == 0 { // Anything can extend an empty tree
 		return true
@@ -9,7 +9,7 @@ import (
 
 func TestChronTreeAppendLots(t *testing.T) {
 	tree := NewChronTree()
-	key := makePrefixFromKey([]byte{0b01})
+	key := makePrefixFromKey([]byte{0b01}).Unpack()
 	valueHash := crypto.Hash([]byte{0b1})
 	sigHash := crypto.Hash([]byte{0b1})
 	for i := 0; i < 200; i++ {
@@ -20,7 +20,7 @@ func TestChronTreeAppendLots(t *testing.T) {
 
 func TestChronTreeBasicGenerateConsistencyProof(t *testing.T) {
 	tree := NewChronTree()
-	key := makePrefixFromKey([]byte{0b01})
+	key := makePrefixFromKey([]byte{0b01}).Unpack()
 	valueHash := crypto.Hash([]byte{0b1})
 	sigHash := crypto.Hash([]byte{0b1})
 	for i := 0; i < 1; i++ {
@@ -32,7 +32,7 @@ func TestChronTreeBasicGenerateConsistencyProof(t *testing.T) {
 
 func TestChronTreeGenerateConsistencyProof(t *testing.T) {
 	tree := NewChronTree()
-	key := makePrefixFromKey([]byte{0b01})
+	key := makePrefixFromKey([]byte{0b01}).Unpack()
 	valueHash := crypto.Hash([]byte{0b1})
 	sigHash := crypto.Hash([]byte{0b1})
 	for i := 0; i < 5; i++ {
@@ -53,7 +53,7 @@ func TestVerifyConsistencyProof(t *testing.T) {
 
 func testVerifyConsistencyProof(oldSize int, newSize int, t *testing.T) {
 	tree := NewChronTree()
-	key := makePrefixFromKey([]byte{0b01})
+	key := makePrefixFromKey([]byte{0b01}).Unpack()
 	valueHash := crypto.Hash([]byte{0b1})
 	sigHash := crypto.Hash([]byte{0b1})
 	for i := 0; i < oldSize; i++ {
@@ -81,3 +81,21 @@ func testVerifyConsistencyProof(oldSize int, newSize int, t *testing.T) {
 		t.Error("VerifyConsistencyProof failed")
 	}
 }
+
+func TestVerifyConsistencyProofOfOldSizes(t *testing.T) {
+	tree := NewChronTree()
+	key := makePrefixFromKey([]byte{0b01}).Unpack()
+	var digests []*Digest
+	for i := 0; i < 37; i++ {
+		digests = append(digests, &Digest{Roots: [][]byte{tree.GetRootHash()}, Size: uint32(i)})
+		tree.Append(key, crypto.Hash([]byte{byte(i)}), []byte(""))
+	}
+	for oldSize := range digests {
+		for newSize := oldSize; newSize < len(digests); newSize++ {
+			proof := tree.GenerateConsistencyProof(uint32(oldSize), uint32(newSize))
+			if !VerifyConsistencyProof(digests[oldSize], digests[newSize], proof) {
+				t.Errorf("consistency proof from %d to %d leaves failed", oldSize, newSize)
+			}
+		}
+	}
+}
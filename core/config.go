@@ -14,6 +14,16 @@ type Config struct {
 	Verifier           string        `yaml:"verifier"`
 	AggHistory         bool          `yaml:"agg_history"`
 	AggHistoryDepth    uint32        `yaml:"agg_history_depth"`
+	LogID              string        `yaml:"log_id"`
+	FullAudit          bool          `yaml:"full_audit"`        // auditor replays every epoch's updates into its own base trees
+	FullAuditors       []string      `yaml:"full_auditors"`     // hex keys of the full auditors; the server prunes the updates all of them stored
+	AuditParallelism   int           `yaml:"audit_parallelism"` // partitions the auditor polls at once; 0 for the number of CPUs
+	PartitionTimeout   time.Duration `yaml:"partition_timeout"` // deadline for polling a partition; 0 for the update period
+	ServerKey          string        `yaml:"server_key"`        // hex key the server signs digests with; enables gossip
+	GossipPeers        []string      `yaml:"gossip_peers"`      // addresses of the Gossip services to exchange digests with
+	GossipDir          string        `yaml:"gossip_dir"`        // directory to exchange digests through files in, if any
+	Storage            string        `yaml:"storage"`           // storage backend: memory, leveldb or segment; empty for the default
+	StorageDir         string        `yaml:"storage_dir"`       // empty directory the server's storage keeps its files in; a temporary one, removed on exit, if empty
 }
 
 func ParseConfig(path string) (c Config, err error) {
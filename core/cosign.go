@@ -0,0 +1,85 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+
+	libcrypto "github.com/huyuncong/MerkleSquare/lib/crypto"
+	"github.com/immesys/bw2/crypto"
+)
+
+// Cosignature is a witness's signature over the digest of a partition. A
+// witness only cosigns a digest once it has verified that the digest is
+// consistent with every digest of the partition it verified before.
+type Cosignature struct {
+	WitnessKey []byte
+	Signature  []byte
+}
+
+// WitnessPolicy is the set of witnesses a client trusts, and how many of them
+// must cosign a digest before the client accepts it.
+type WitnessPolicy struct {
+	WitnessKeys [][]byte
+	Threshold   int
+}
+
+// cosignedMessage is what witnesses sign. The digest hash covers the
+// partition binding, so a cosignature cannot be replayed for another
+// partition or log.
+func cosignedMessage(digest *LegologDigest) []byte {
+	return libcrypto.Hash([]byte("legolog cosignature"), digest.Hash())
+}
+
+// CosignDigest signs digest as the witness with key pair (witnessSK,
+// witnessVK).
+func CosignDigest(witnessSK []byte, witnessVK []byte, digest *LegologDigest) *Cosignature {
+	signature := make([]byte, 64)
+	crypto.SignBlob(witnessSK, witnessVK, signature, cosignedMessage(digest))
+	return &Cosignature{
+		WitnessKey: append([]byte{}, witnessVK...),
+		Signature:  signature,
+	}
+}
+
+// Verify checks that c is a valid cosignature of digest by its witness.
+func (c *Cosignature) Verify(digest *LegologDigest) bool {
+	if len(c.WitnessKey) != 32 || len(c.Signature) != 64 {
+		return false
+	}
+	return crypto.VerifyBlob(c.WitnessKey, c.Signature, cosignedMessage(digest))
+}
+
+// Check returns an error unless at least Threshold of the policy's witnesses
+// cosigned digest. Cosignatures by other keys, invalid ones, and repeated
+// ones by the same witness are not counted.
+func (p *WitnessPolicy) Check(digest *LegologDigest, cosignatures []*Cosignature) error {
+	if p.Threshold <= 0 || p.Threshold > len(p.WitnessKeys) {
+		return fmt.Errorf("threshold of %d is not satisfiable by %d witnesses", p.Threshold, len(p.WitnessKeys))
+	}
+	cosigned := make([]bool, len(p.WitnessKeys))
+	count := 0
+	for _, cosignature := range cosignatures {
+		if cosignature == nil {
+			continue
+		}
+		witness := p.witnessIndex(cosignature.WitnessKey)
+		if witness < 0 || cosigned[witness] || !cosignature.Verify(digest) {
+			continue
+		}
+		cosigned[witness] = true
+		count++
+	}
+	if count < p.Threshold {
+		return fmt.Errorf("digest of epoch %d is cosigned by %d witnesses, %d needed", digest.Epoch, count, p.Threshold)
+	}
+	return nil
+}
+
+func (p *WitnessPolicy) witnessIndex(witnessKey []byte) int {
+	for i, key := range p.WitnessKeys {
+		if bytes.Equal(key, witnessKey) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/immesys/bw2/crypto"
+)
+
+func TestWitnessPolicy(t *testing.T) {
+	p := NewPartition()
+	p.Bind(PartitionBinding{LogID: []byte("log"), PartitionIndex: 0, NumPartitions: 2})
+	p.Append([]byte("a"), []byte("a"), []byte("a"), []byte("a"))
+	p.IncrementUpdateEpoch()
+	digest := p.GetDigest()
+
+	var keys [][]byte
+	var cosignatures []*Cosignature
+	for i := 0; i < 3; i++ {
+		sk, vk := crypto.GenerateKeypair()
+		keys = append(keys, vk)
+		cosignatures = append(cosignatures, CosignDigest(sk, vk, digest))
+	}
+	policy := &WitnessPolicy{WitnessKeys: keys, Threshold: 2}
+
+	if err := policy.Check(digest, cosignatures[:2]); err != nil {
+		t.Errorf("digest cosigned by 2 of 3 witnesses was rejected: %v", err)
+	}
+	if err := policy.Check(digest, cosignatures[:1]); err == nil {
+		t.Error("digest cosigned by 1 of 3 witnesses was accepted")
+	}
+	// the same witness cosigning twice counts once
+	if err := policy.Check(digest, []*Cosignature{cosignatures[0], cosignatures[0]}); err == nil {
+		t.Error("repeated cosignature was counted twice")
+	}
+	// witnesses the policy does not trust are not counted
+	untrustedSK, untrustedVK := crypto.GenerateKeypair()
+	untrusted := CosignDigest(untrustedSK, untrustedVK, digest)
+	if err := policy.Check(digest, []*Cosignature{cosignatures[0], untrusted}); err == nil {
+		t.Error("cosignature by an untrusted witness was counted")
+	}
+
+	// cosignatures do not carry over to another digest, even of another
+	// partition with the same contents
+	other := NewPartition()
+	other.Bind(PartitionBinding{LogID: []byte("log"), PartitionIndex: 1, NumPartitions: 2})
+	other.Append([]byte("a"), []byte("a"), []byte("a"), []byte("a"))
+	other.IncrementUpdateEpoch()
+	if err := policy.Check(other.GetDigest(), cosignatures); err == nil {
+		t.Error("cosignatures of one partition were accepted for another")
+	}
+
+	if err := (&WitnessPolicy{WitnessKeys: keys, Threshold: 4}).Check(digest, cosignatures); err == nil {
+		t.Error("unsatisfiable threshold was accepted")
+	}
+}
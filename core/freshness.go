@@ -0,0 +1,47 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// StaleDigestError is returned for a digest published longer ago than the
+// checker accepts. A server that stops publishing, or an auditor that keeps
+// handing out an old digest, would otherwise freeze the checker's view and
+// hide the key changes made since.
+type StaleDigestError struct {
+	Epoch        uint64
+	PublishedAt  time.Time // zero if the digest carries no publication time
+	MaxStaleness time.Duration
+}
+
+func (e *StaleDigestError) Error() string {
+	if e.PublishedAt.IsZero() {
+		return fmt.Sprintf("digest of epoch %d carries no publication time", e.Epoch)
+	}
+	return fmt.Sprintf("digest of epoch %d was published at %v, more than %v ago",
+		e.Epoch, e.PublishedAt.Format(time.RFC3339), e.MaxStaleness)
+}
+
+// PublicationTime returns when the server published the digest, or the zero
+// time if it was not published.
+func (d *LegologDigest) PublicationTime() time.Time {
+	if d.PublishedAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, d.PublishedAt)
+}
+
+// CheckFreshness returns a *StaleDigestError if digest was published more
+// than maxStaleness before now, or carries no publication time. A
+// maxStaleness of 0 accepts any digest.
+func CheckFreshness(digest *LegologDigest, maxStaleness time.Duration, now time.Time) error {
+	if maxStaleness == 0 {
+		return nil
+	}
+	publishedAt := digest.PublicationTime()
+	if publishedAt.IsZero() || now.Sub(publishedAt) > maxStaleness {
+		return &StaleDigestError{Epoch: digest.Epoch, PublishedAt: publishedAt, MaxStaleness: maxStaleness}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckFreshness(t *testing.T) {
+	now := time.Now()
+	digest := &LegologDigest{Epoch: 3, PublishedAt: now.Add(-time.Minute).UnixNano()}
+
+	if err := CheckFreshness(digest, 0, now); err != nil {
+		t.Errorf("no maximum staleness rejected a digest: %v", err)
+	}
+	if err := CheckFreshness(digest, time.Hour, now); err != nil {
+		t.Errorf("fresh digest was rejected: %v", err)
+	}
+	var staleErr *StaleDigestError
+	if err := CheckFreshness(digest, time.Second, now); !errors.As(err, &staleErr) {
+		t.Errorf("expected a StaleDigestError, got %v", err)
+	} else if !staleErr.PublishedAt.Equal(digest.PublicationTime()) {
+		t.Errorf("expected the error to carry the publication time")
+	}
+	if err := CheckFreshness(&LegologDigest{}, time.Hour, now); !errors.As(err, &staleErr) {
+		t.Errorf("expected a StaleDigestError for a digest without a publication time, got %v", err)
+	}
+
+	// the publication time is covered by the digest's hash, and so by the
+	// server's signature
+	republished := *digest
+	republished.PublishedAt = now.UnixNano()
+	if string(republished.Hash()) == string(digest.Hash()) {
+		t.Error("digest hash does not cover the publication time")
+	}
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	libcrypto "github.com/huyuncong/MerkleSquare/lib/crypto"
+)
+
+// GlobalDigest is the single root the server publishes each update epoch. It
+// is the root of a Merkle tree whose i-th leaf is the digest of partition i,
+// so comparing one hash is enough to agree on the state of every partition.
+type GlobalDigest struct {
+	Root          []byte
+	NumPartitions uint64
+	Epoch         uint64
+}
+
+// PartitionInclusionProof proves that a partition's digest is the leaf at
+// Index of the tree under a GlobalDigest.
+type PartitionInclusionProof struct {
+	Index    uint64
+	Siblings [][]byte // first is the sibling of the leaf, last is a child of the root
+}
+
+// Hash commits to every field of the digest.
+func (d *LegologDigest) Hash() []byte {
+	return libcrypto.Hash(
+		hashByteSlices(d.BaseTreeRoots),
+		uint64ToBytes(uint64(d.BaseTreeSize)),
+		libcrypto.Hash(d.UpdateLogRoot),
+		hashByteSlices(d.UpdateSetRoots),
+		uint64ToBytes(uint64(d.UpdateLogSize)),
+		uint64ToBytes(d.Epoch),
+		uint64ToBytes(d.VerificationPeriod),
+		libcrypto.Hash(d.HashChain),
+		hashByteSlices(d.HistoryForestRoots),
+		uint64ToBytes(uint64(d.HistoryForestSize)),
+		d.Binding.Hash(),
+		uint64ToBytes(uint64(d.PublishedAt)),
+	)
+}
+
+// NewGlobalDigest builds the global root over the digests of all partitions,
+// indexed by partition.
+func NewGlobalDigest(digests []*LegologDigest, epoch uint64) *GlobalDigest {
+	return &GlobalDigest{
+		Root:          globalTreeRoot(globalTreeLeaves(digests)),
+		NumPartitions: uint64(len(digests)),
+		Epoch:         epoch,
+	}
+}
+
+// GeneratePartitionInclusionProof proves that digests[index] is committed to
+// by the global digest built from digests.
+func GeneratePartitionInclusionProof(digests []*LegologDigest, index uint64) *PartitionInclusionProof {
+	if index >= uint64(len(digests)) {
+		return nil
+	}
+	return &PartitionInclusionProof{
+		Index:    index,
+		Siblings: globalTreeSiblings(globalTreeLeaves(digests), index),
+	}
+}
+
+// VerifyPartitionInclusionProof checks that digest is the digest of the
+// partition at proof.Index under global.
+func VerifyPartitionInclusionProof(global *GlobalDigest, digest *LegologDigest, proof *PartitionInclusionProof) bool {
+	if global == nil || digest == nil || proof == nil || proof.Index >= global.NumPartitions {
+		return false
+	}
+	root, ok := globalTreeRootFromPath(globalTreeLeaf(digest), proof.Index, global.NumPartitions, proof.Siblings)
+	return ok && bytes.Equal(root, global.Root)
+}
+
+// The tree over partitions splits n leaves into a complete left subtree of
+// the largest power of two below n and a right subtree of the rest, as in
+// RFC 6962. Leaves and interior nodes are hashed with different prefixes so
+// one cannot pass for the other.
+
+func globalTreeLeaf(digest *LegologDigest) []byte {
+	return libcrypto.Hash([]byte{0}, digest.Hash())
+}
+
+func globalTreeNode(left []byte, right []byte) []byte {
+	return libcrypto.Hash([]byte{1}, left, right)
+}
+
+func globalTreeLeaves(digests []*LegologDigest) [][]byte {
+	leaves := make([][]byte, len(digests))
+	for i, digest := range digests {
+		leaves[i] = globalTreeLeaf(digest)
+	}
+	return leaves
+}
+
+func globalTreeSplit(n uint64) uint64 {
+	k := uint64(1)
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+func globalTreeRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		return nil
+	case 1:
+		return leaves[0]
+	}
+	k := globalTreeSplit(uint64(len(leaves)))
+	return globalTreeNode(globalTreeRoot(leaves[:k]), globalTreeRoot(leaves[k:]))
+}
+
+func globalTreeSiblings(leaves [][]byte, index uint64) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := globalTreeSplit(uint64(len(leaves)))
+	if index < k {
+		return append(globalTreeSiblings(leaves[:k], index), globalTreeRoot(leaves[k:]))
+	}
+	return append(globalTreeSiblings(leaves[k:], index-k), globalTreeRoot(leaves[:k]))
+}
+
+// globalTreeRootFromPath recomputes the root of a tree of n leaves from the
+// leaf at index and its siblings. It reports false if the number of siblings
+// does not match the shape of the tree.
+func globalTreeRootFromPath(leaf []byte, index uint64, n uint64, siblings [][]byte) ([]byte, bool) {
+	if n == 1 {
+		return leaf, len(siblings) == 0
+	}
+	if len(siblings) == 0 {
+		return nil, false
+	}
+	k := globalTreeSplit(n)
+	top := siblings[len(siblings)-1]
+	rest := siblings[:len(siblings)-1]
+	if index < k {
+		left, ok := globalTreeRootFromPath(leaf, index, k, rest)
+		return globalTreeNode(left, top), ok
+	}
+	right, ok := globalTreeRootFromPath(leaf, index-k, n-k, rest)
+	return globalTreeNode(top, right), ok
+}
+
+func hashByteSlices(slices [][]byte) []byte {
+	hashes := make([][]byte, 0, len(slices)+1)
+	hashes = append(hashes, uint64ToBytes(uint64(len(slices))))
+	for _, s := range slices {
+		hashes = append(hashes, libcrypto.Hash(s))
+	}
+	return libcrypto.Hash(hashes...)
+}
+
+func uint64ToBytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
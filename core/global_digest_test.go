@@ -0,0 +1,62 @@
+package core
+
+import (
+	"testing"
+)
+
+func makeTestingDigests(n int) []*LegologDigest {
+	digests := make([]*LegologDigest, n)
+	for i := range digests {
+		digests[i] = &LegologDigest{
+			BaseTreeRoots:  [][]byte{generateRandomByteArray(32), generateRandomByteArray(32)},
+			UpdateLogRoot:  generateRandomByteArray(32),
+			UpdateSetRoots: [][]byte{generateRandomByteArray(32)},
+			Epoch:          uint64(i),
+		}
+	}
+	return digests
+}
+
+func TestPartitionInclusionProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		digests := makeTestingDigests(n)
+		global := NewGlobalDigest(digests, 7)
+		for i := range digests {
+			proof := GeneratePartitionInclusionProof(digests, uint64(i))
+			if !VerifyPartitionInclusionProof(global, digests[i], proof) {
+				t.Errorf("proof for partition %d of %d does not verify", i, n)
+			}
+			if n > 1 && VerifyPartitionInclusionProof(global, digests[(i+1)%n], proof) {
+				t.Errorf("proof for partition %d of %d verified another partition's digest", i, n)
+			}
+		}
+	}
+}
+
+func TestPartitionInclusionProofRejectsTampering(t *testing.T) {
+	digests := makeTestingDigests(5)
+	global := NewGlobalDigest(digests, 0)
+	proof := GeneratePartitionInclusionProof(digests, 3)
+
+	tampered := *digests[3]
+	tampered.Epoch++
+	if VerifyPartitionInclusionProof(global, &tampered, proof) {
+		t.Error("modified digest verified")
+	}
+
+	moved := *proof
+	moved.Index = 2
+	if VerifyPartitionInclusionProof(global, digests[3], &moved) {
+		t.Error("digest verified at the wrong index")
+	}
+
+	extended := *proof
+	extended.Siblings = append([][]byte{generateRandomByteArray(32)}, proof.Siblings...)
+	if VerifyPartitionInclusionProof(global, digests[3], &extended) {
+		t.Error("proof with too many siblings verified")
+	}
+
+	if GeneratePartitionInclusionProof(digests, 5) != nil {
+		t.Error("expected no proof for a partition out of range")
+	}
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	libcrypto "github.com/huyuncong/MerkleSquare/lib/crypto"
+)
+
+// HashChainLink holds what a partition hashes into its hash chain when a
+// verification period starts: the newest base tree roots and the epoch.
+type HashChainLink struct {
+	VerificationPeriod uint64
+	BaseTreeRoots      [][]byte
+	Epoch              uint64
+}
+
+func (l *HashChainLink) extend(binding *PartitionBinding, prevHashChain []byte) []byte {
+	ms := append([][]byte{binding.Hash()}, l.BaseTreeRoots...)
+	ms = append(ms, []byte(strconv.FormatUint(l.Epoch, 10)), prevHashChain)
+	return libcrypto.Hash(ms...)
+}
+
+// HashChainProof holds the links a partition added to its hash chain between
+// two of its heads, oldest first.
+type HashChainProof struct {
+	Links []HashChainLink
+}
+
+// HashChainProver is implemented by partitions that can prove how their hash
+// chain got from one head to another.
+type HashChainProver interface {
+	GenerateHashChainProof(oldHashChain []byte, newHashChain []byte) (*HashChainProof, error)
+}
+
+var _ HashChainProver = (*Partition)(nil)
+var _ HashChainProver = (*AggHistPartition)(nil)
+
+// hashChain keeps every link of a partition's hash chain along with the head
+// after each of them.
+type hashChain struct {
+	links []HashChainLink
+	heads [][]byte
+}
+
+func (c *hashChain) head() []byte {
+	if len(c.heads) == 0 {
+		return nil
+	}
+	return c.heads[len(c.heads)-1]
+}
+
+func (c *hashChain) reset() {
+	c.links, c.heads = nil, nil
+}
+
+func (c *hashChain) extend(binding *PartitionBinding, link HashChainLink) []byte {
+	c.links = append(c.links, link)
+	c.heads = append(c.heads, link.extend(binding, c.head()))
+	return c.head()
+}
+
+// indexOf returns the number of links up to head, which is 0 for the empty
+// chain, or -1 if the chain never had head.
+func (c *hashChain) indexOf(head []byte) int {
+	if len(head) == 0 {
+		return 0
+	}
+	for i := len(c.heads) - 1; i >= 0; i-- {
+		if bytes.Equal(c.heads[i], head) {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func (c *hashChain) generateProof(oldHead []byte, newHead []byte) (*HashChainProof, error) {
+	start, end := c.indexOf(oldHead), c.indexOf(newHead)
+	if start < 0 {
+		return nil, fmt.Errorf("hash chain never had head %x", oldHead)
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("hash chain never had head %x", newHead)
+	}
+	if end < start {
+		return nil, errors.New("new hash chain head precedes the old one")
+	}
+	return &HashChainProof{Links: append([]HashChainLink{}, c.links[start:end]...)}, nil
+}
+
+// VerifyHashChainExtension checks that proof gets the hash chain of oldDigest
+// to that of newDigest one verification period at a time, and that the last
+// link commits to the base tree roots published in newDigest. A nil
+// oldDigest stands for the partition before its first link.
+func VerifyHashChainExtension(oldDigest *LegologDigest, newDigest *LegologDigest, proof *HashChainProof) error {
+	if proof == nil {
+		proof = &HashChainProof{}
+	}
+	var head []byte
+	var period, epoch uint64
+	if oldDigest != nil {
+		if !bytes.Equal(oldDigest.Binding.Hash(), newDigest.Binding.Hash()) {
+			return errors.New("digests are bound to different partitions")
+		}
+		head, period, epoch = oldDigest.HashChain, oldDigest.VerificationPeriod, oldDigest.Epoch
+	}
+
+	for i, link := range proof.Links {
+		// the chain starts in whichever period the partition was bound in
+		if head != nil && link.VerificationPeriod != period+1 {
+			return fmt.Errorf("link %d is for verification period %d, expected %d", i, link.VerificationPeriod, period+1)
+		}
+		if link.Epoch < epoch {
+			return fmt.Errorf("link %d goes back from epoch %d to epoch %d", i, epoch, link.Epoch)
+		}
+		head = link.extend(&newDigest.Binding, head)
+		period, epoch = link.VerificationPeriod, link.Epoch
+	}
+
+	if head != nil && period != newDigest.VerificationPeriod {
+		return fmt.Errorf("hash chain reaches verification period %d, but the digest is for period %d", period, newDigest.VerificationPeriod)
+	}
+	if epoch > newDigest.Epoch {
+		return fmt.Errorf("hash chain reaches epoch %d, past the digest's epoch %d", epoch, newDigest.Epoch)
+	}
+	if len(proof.Links) != 0 {
+		// the last link commits to the newest base tree roots in the digest
+		roots := proof.Links[len(proof.Links)-1].BaseTreeRoots
+		if len(roots) > len(newDigest.BaseTreeRoots) ||
+			!equalByteSlices(roots, newDigest.BaseTreeRoots[len(newDigest.BaseTreeRoots)-len(roots):]) {
+			return errors.New("hash chain does not commit to the digest's base tree roots")
+		}
+	}
+	if !bytes.Equal(head, newDigest.HashChain) {
+		return errors.New("hash chain does not extend to the digest's hash chain")
+	}
+	return nil
+}
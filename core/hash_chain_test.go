@@ -0,0 +1,62 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHashChainExtension(t *testing.T) {
+	for _, cfg := range []Config{{}, {AggHistory: true, AggHistoryDepth: 31}} {
+		var partition LegoLogPartition = NewPartition()
+		if cfg.AggHistory {
+			partition = NewAggHistPartition(cfg, "")
+		}
+		partition.Bind(testBinding)
+		prover := partition.(HashChainProver)
+
+		digests := []*LegologDigest{partition.GetDigest()}
+		for i := 0; i < 6; i++ {
+			key := []byte(strconv.Itoa(i))
+			partition.Append(key, key, key, key)
+			partition.IncrementUpdateEpoch()
+			partition.IncrementVerificationPeriod()
+			digests = append(digests, partition.GetDigest())
+		}
+
+		// observers that skipped any number of periods can catch up
+		for i, oldDigest := range digests {
+			for _, newDigest := range digests[i:] {
+				proof, err := prover.GenerateHashChainProof(oldDigest.HashChain, newDigest.HashChain)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := VerifyHashChainExtension(oldDigest, newDigest, proof); err != nil {
+					t.Fatalf("agg history %t: periods %d to %d: %v", cfg.AggHistory, oldDigest.VerificationPeriod, newDigest.VerificationPeriod, err)
+				}
+			}
+		}
+		first, last := digests[0], digests[len(digests)-1]
+		proof, _ := prover.GenerateHashChainProof(nil, last.HashChain)
+		if err := VerifyHashChainExtension(nil, last, proof); err != nil {
+			t.Errorf("agg history %t: from the start: %v", cfg.AggHistory, err)
+		}
+
+		proof, _ = prover.GenerateHashChainProof(first.HashChain, last.HashChain)
+		skipped := &HashChainProof{Links: append([]HashChainLink{}, proof.Links[:1]...)}
+		skipped.Links = append(skipped.Links, proof.Links[2:]...)
+		if err := VerifyHashChainExtension(first, last, skipped); err == nil {
+			t.Errorf("agg history %t: hash chain with a missing period verified", cfg.AggHistory)
+		}
+		forged := &HashChainProof{Links: append([]HashChainLink{}, proof.Links...)}
+		forged.Links[0].BaseTreeRoots = [][]byte{[]byte("forged")}
+		if err := VerifyHashChainExtension(first, last, forged); err == nil {
+			t.Errorf("agg history %t: hash chain with a forged base tree verified", cfg.AggHistory)
+		}
+		if err := VerifyHashChainExtension(last, first, &HashChainProof{}); err == nil {
+			t.Errorf("agg history %t: rewound hash chain verified", cfg.AggHistory)
+		}
+		if _, err := prover.GenerateHashChainProof(last.HashChain, first.HashChain); err == nil {
+			t.Errorf("agg history %t: proved a rewound hash chain", cfg.AggHistory)
+		}
+	}
+}
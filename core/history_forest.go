@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"math/bits"
+
+	crypto "github.com/huyuncong/MerkleSquare/lib/crypto"
 )
 
 type HistoryForest struct {
@@ -18,9 +20,13 @@ type HistoryForest struct {
 // CORE METHODS
 //*******************************
 
-func (m *HistoryForest) Append(prefixTreeHash []byte, verificationPeriod uint64) {
+// Append adds the base tree hash of a verification period to the forest,
+// adding a root level first if the forest is full.
+func (m *HistoryForest) Append(prefixTreeHash []byte, verificationPeriod uint64) error {
 	if m.isFull() {
-		return // throw error?
+		if err := m.grow(); err != nil {
+			return err
+		}
 	}
 	node := m.next.(*LeafHistoryNode)
 	node.completeLeaf(verificationPeriod, prefixTreeHash, m.Size)
@@ -38,11 +44,18 @@ func (m *HistoryForest) Append(prefixTreeHash []byte, verificationPeriod uint64)
 
 	// check to see if tree is full
 	if m.isFull() {
-		return
+		return nil
 	}
-	newNode := p.getParent().createRightChild()
+	m.createNext(p.getParent())
+	return nil
+}
+
+// Creates the right child of parent and its leftmost descendants, and sets
+// the deepest one as the next leaf
+func (m *HistoryForest) createNext(parent HistoryNode) {
+	newNode := parent.createRightChild()
 	m.addNodeToMap(newNode)
-	p = p.getParent().getRightChild()
+	p := parent.getRightChild()
 
 	for p.getDepth() > 0 {
 		newNode = p.createLeftChild()
@@ -53,39 +66,43 @@ func (m *HistoryForest) Append(prefixTreeHash []byte, verificationPeriod uint64)
 	m.next = p
 }
 
-/*
-// GenerateExistenceProof generates an existence proof for a given key/height pair
-func (m *HistoryForest) GenerateExistenceProof(key []byte, pos uint32, height uint32, oldSize uint32) *MerkleExistenceProof {
+// Adds a level above the root of a full HistoryForest. The old root becomes
+// the left child of the new one, so the index of every existing node and the
+// roots of the forest stay the same.
+func (m *HistoryForest) grow() error {
+	if m.depth >= maxForestDepth {
+		return ErrForestFull
+	}
+	oldRoot := m.root
+	root := createRootHistoryNode(m.depth + 1).(*InternalHistoryNode)
+	root.leftChild = oldRoot
+	oldRoot.setParent(root)
+	m.root = root
+	m.depth++
+	m.addNodeToMap(root)
+
+	m.createNext(root)
+	return nil
+}
+
+// GenerateExistenceProof proves that the leaf at pos, the base tree hash of
+// verification period pos, is under a root of the HistoryForest as it was when
+// it contained oldSize leaves.
+func (m *HistoryForest) GenerateExistenceProof(pos uint32, oldSize uint32) *MerkleExistenceProof {
 
-	node := m.getNode(0, pos)
+	node := m.getLeafNode(pos)
 	siblings := []Sibling{}
-	prefixProofs := []MembershipProof{}
 	treeDepth := GetOldDepth(pos, oldSize)
-	prefix := makePrefixFromKey(key)
-
-	for node.getDepth() != treeDepth { // only thing that needs to change is m.depth to the depth of the tree that the key belongs to in new Size
-
-		if height > 0 {
-			height = height - 1
-		} else {
-
-			prefixTree, _ := node.getParent().getPrefixTree()
-			proof, _ := prefixTree.generateMembershipProof(prefix)
-			prefixProofs = append(prefixProofs, *proof)
-
-			sibling := node.getSibling()
-			siblings = append(siblings, sibling)
-		}
 
+	for node.getDepth() != treeDepth {
+		siblings = append(siblings, node.getSibling())
 		node = node.getParent()
 	}
 
 	return &MerkleExistenceProof{
-		Siblings:     siblings,
-		PrefixProofs: prefixProofs,
+		Siblings: siblings,
 	}
 }
-*/
 
 // GenerateExtensionProof generates an extension proof for a given digest
 func (m *HistoryForest) GenerateExtensionProof(oldSize uint32, requestedSize uint32) *MerkleExtensionProof {
@@ -127,6 +144,36 @@ func generateHistoryForestExtensionProof(node HistoryNode, proof *MerkleExtensio
 	proof.PrefixHashes = prefixHashes
 }
 
+//*******************************
+// VERIFICATION METHODS
+//*******************************
+
+// VerifyHistoryExistenceProof verifies that baseTreeHash is the leaf at pos of
+// the HistoryForest with the given digest.
+func VerifyHistoryExistenceProof(digest *Digest, baseTreeHash []byte, pos uint32, proof *MerkleExistenceProof) bool {
+
+	if proof == nil || pos >= digest.Size {
+		return false
+	}
+	rootIndex := getRootIndex(pos, digest.Size)
+	if rootIndex >= len(digest.Roots) || uint32(len(proof.Siblings)) != GetOldDepth(pos, digest.Size) {
+		return false
+	}
+
+	hash := baseTreeHash
+	shift := pos
+	for _, sib := range proof.Siblings {
+		if isRight(shift) {
+			hash = crypto.Hash(sib.Hash, hash)
+		} else {
+			hash = crypto.Hash(hash, sib.Hash)
+		}
+		shift = shift / 2
+	}
+
+	return bytes.Equal(digest.Roots[rootIndex], hash)
+}
+
 //*******************************
 // HELPER METHODS
 //*******************************
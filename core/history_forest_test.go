@@ -0,0 +1,76 @@
+package core
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	crypto "github.com/huyuncong/MerkleSquare/lib/crypto"
+)
+
+func TestHistoryForestExistenceProof(t *testing.T) {
+
+	m := NewHistoryForest(5)
+	numLeaves := 21
+	for i := 0; i < numLeaves; i++ {
+		m.Append(crypto.Hash([]byte(strconv.Itoa(i))), uint64(i))
+	}
+
+	// proofs against older digests still verify after the forest has grown
+	for size := uint32(1); size <= uint32(numLeaves); size++ {
+		digest := m.GetOldDigest(size)
+		for pos := uint32(0); pos < size; pos++ {
+			leaf := crypto.Hash([]byte(strconv.Itoa(int(pos))))
+			proof := m.GenerateExistenceProof(pos, size)
+			if !VerifyHistoryExistenceProof(digest, leaf, pos, proof) {
+				t.Fatalf("size %d, pos %d: proof did not verify", size, pos)
+			}
+			if VerifyHistoryExistenceProof(digest, crypto.Hash([]byte("other")), pos, proof) {
+				t.Fatalf("size %d, pos %d: proof verified for the wrong leaf", size, pos)
+			}
+		}
+	}
+
+	digest := m.GetDigest()
+	proof := m.GenerateExistenceProof(3, digest.Size)
+	if VerifyHistoryExistenceProof(digest, crypto.Hash([]byte("3")), 4, proof) {
+		t.Error("proof verified for the wrong position")
+	}
+	if VerifyHistoryExistenceProof(digest, crypto.Hash([]byte("3")), digest.Size, proof) {
+		t.Error("proof verified for a position past the end of the forest")
+	}
+}
+
+func TestHistoryForestGrows(t *testing.T) {
+
+	grown := NewHistoryForest(1)
+	m := NewHistoryForest(5)
+	for i := 0; i < 21; i++ {
+		leaf := crypto.Hash([]byte(strconv.Itoa(i)))
+		if err := grown.Append(leaf, uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+		m.Append(leaf, uint64(i))
+	}
+
+	if grown.depth != 5 {
+		t.Fatalf("expected the forest to grow to depth 5, got %d", grown.depth)
+	}
+	for size := uint32(1); size <= grown.Size; size++ {
+		digest := grown.GetOldDigest(size)
+		if !reflect.DeepEqual(digest, m.GetOldDigest(size)) {
+			t.Fatalf("size %d: digests differ", size)
+		}
+		for pos := uint32(0); pos < size; pos++ {
+			proof := grown.GenerateExistenceProof(pos, size)
+			if !VerifyHistoryExistenceProof(digest, crypto.Hash([]byte(strconv.Itoa(int(pos)))), pos, proof) {
+				t.Fatalf("size %d, pos %d: proof did not verify", size, pos)
+			}
+		}
+	}
+
+	full := NewHistoryForest(maxForestDepth)
+	if err := full.grow(); err != ErrForestFull {
+		t.Errorf("expected ErrForestFull, got %v", err)
+	}
+}
@@ -3,12 +3,21 @@ package core
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/bits"
 
 	crypto "github.com/huyuncong/MerkleSquare/lib/crypto"
 )
 
+// maxForestDepth is the deepest a MerkleSquare or HistoryForest can grow,
+// since the number of leaves is kept in a uint32
+const maxForestDepth = 31
+
+// ErrForestFull is returned when appending to a MerkleSquare or HistoryForest
+// that already holds the maximum number of leaves
+var ErrForestFull = errors.New("forest is full")
+
 // MerkleSquare instance
 type MerkleSquare struct {
 	Roots []MerkleNode
@@ -80,7 +89,7 @@ type Sibling struct {
 // LeafHash struct for proofs
 type LeafHash struct {
 	NodeContentHash []byte
-	Prefix          []byte
+	Prefix          BitString
 }
 
 // KeyHash struct for existence proof verification
@@ -93,10 +102,13 @@ type KeyHash struct {
 // CORE METHODS
 //*******************************
 
-// Append a new entry to the MerkleSquare object
-func (m *MerkleSquare) Append(key []byte, value []byte, signature []byte) {
+// Append a new entry to the MerkleSquare object, adding a root level first if
+// the MerkleSquare is full
+func (m *MerkleSquare) Append(key []byte, value []byte, signature []byte) error {
 	if m.isFull() {
-		return // throw error?
+		if err := m.grow(); err != nil {
+			return err
+		}
 	}
 	node := m.next.(*LeafNode)
 	node.completeLeaf(key, value, signature, m.Size)
@@ -115,11 +127,18 @@ func (m *MerkleSquare) Append(key []byte, value []byte, signature []byte) {
 
 	// check to see if tree is full
 	if m.isFull() {
-		return
+		return nil
 	}
-	newNode := p.getParent().createRightChild()
+	m.createNext(p.getParent())
+	return nil
+}
+
+// Creates the right child of parent and its leftmost descendants, and sets
+// the deepest one as the next leaf
+func (m *MerkleSquare) createNext(parent MerkleNode) {
+	newNode := parent.createRightChild()
 	m.addNodeToMap(newNode)
-	p = p.getParent().getRightChild()
+	p := parent.getRightChild()
 
 	for p.getDepth() > 0 {
 		newNode = p.createLeftChild()
@@ -130,6 +149,32 @@ func (m *MerkleSquare) Append(key []byte, value []byte, signature []byte) {
 	m.next = p
 }
 
+// Adds a level above the root of a full MerkleSquare. The old root becomes
+// the left child of the new one, so the index of every existing node and the
+// roots of the forest stay the same. The new root's prefix tree starts out
+// with every key appended so far.
+func (m *MerkleSquare) grow() error {
+	if m.depth >= maxForestDepth {
+		return ErrForestFull
+	}
+	oldRoot := m.root.(*InternalNode)
+	prefixTree, err := oldRoot.getPrefixTree().copyFast()
+	if err != nil {
+		return err
+	}
+	prefixTree.isComplete = false
+	root := createRootNode(m.depth + 1).(*InternalNode)
+	root.prefixTree = prefixTree
+	root.leftChild = oldRoot
+	oldRoot.setParent(root)
+	m.root = root
+	m.depth++
+	m.addNodeToMap(root)
+
+	m.createNext(root)
+	return nil
+}
+
 // GenerateExistenceProof generates an existence proof for a given key/height pair
 func (m *MerkleSquare) GenerateExistenceProof(key []byte, pos uint32, height uint32, oldSize uint32) *MerkleExistenceProof {
 
@@ -354,7 +399,7 @@ func (m *MerkleSquare) GenerateBatchedLookupProof(startSize uint32, endSize uint
 
 		root := oldRoots[j]
 		if root.isLeafNode() {
-			if !bytes.Equal(root.getPrefix(), prefix) {
+			if !root.getPrefix().Equal(prefix) {
 				leafHash.Prefix = root.getPrefix()
 				leafHash.NodeContentHash = root.getContentHash()
 			} else if len(keyPositions) == 0 || len(keyPositions) > 0 && root.getShift() != keyPositions[len(keyPositions)-1] {
@@ -532,7 +577,7 @@ func VerifyPKProof(digest *Digest, key []byte, value []byte, signature []byte, p
 
 		if i == len(digest.Roots)-1 && lastRootDepth == 0 {
 			hash := computeLeafHash(proof.LeafHash.Prefix, proof.LeafHash.NodeContentHash)
-			return bytes.Equal(hash, digest.Roots[i]) && !bytes.Equal(proof.LeafHash.Prefix, prefix)
+			return bytes.Equal(hash, digest.Roots[i]) && !proof.LeafHash.Prefix.Equal(prefix)
 		}
 
 		p := i - rootIndex
@@ -558,11 +603,11 @@ func VerifyNonexistenceProof(key []byte, mskPos uint32, newDigest *Digest, proof
 	}
 
 	if mskPos != 0 && GetOldDepth(mskPos-1, mskPos) == 0 {
-		if bytes.Equal(prefix, proof.LeafHash.Prefix) {
+		if prefix.Equal(proof.LeafHash.Prefix) {
 			return false
 		}
 
-		rootHash := crypto.Hash(proof.LeafHash.Prefix, proof.LeafHash.NodeContentHash)
+		rootHash := computeLeafHash(proof.LeafHash.Prefix, proof.LeafHash.NodeContentHash)
 		oldRootHashes = append(oldRootHashes, rootHash)
 	}
 
@@ -599,7 +644,7 @@ func VerifyBatchedLookupProof(startSize uint32, key []byte, keyHashes []KeyHash,
 			if proof.LeafHash.NodeContentHash != nil {
 				// case where doesn't equal
 				hash := computeLeafHash(proof.LeafHash.Prefix, proof.LeafHash.NodeContentHash)
-				if bytes.Equal(proof.LeafHash.Prefix, prefix) || !bytes.Equal(rootHash, hash) {
+				if proof.LeafHash.Prefix.Equal(prefix) || !bytes.Equal(rootHash, hash) {
 					return false, fmt.Errorf("Unable to verify nonmembership for leaf root %d", j)
 				}
 			} else {
@@ -640,14 +685,14 @@ func VerifyBatchedLookupProof(startSize uint32, key []byte, keyHashes []KeyHash,
 // HELPER METHODS
 //*******************************
 
-func verifySingularMembershipProof(rootHash []byte, proof MembershipProof, leftChildHash []byte, rightChildHash []byte, prefix []byte, otherHashes []KeyHash) bool {
+func verifySingularMembershipProof(rootHash []byte, proof MembershipProof, leftChildHash []byte, rightChildHash []byte, prefix BitString, otherHashes []KeyHash) bool {
 	prefixHash := computeRootHashMembership(prefix, &proof, otherHashes)
 	hash := crypto.Hash(leftChildHash, rightChildHash, prefixHash)
 
 	return bytes.Equal(hash, rootHash)
 }
 
-func verifySingularNonMembershipProof(rootHash []byte, proof NonMembershipProof, leftChildHash []byte, rightChildHash []byte, prefix []byte) bool {
+func verifySingularNonMembershipProof(rootHash []byte, proof NonMembershipProof, leftChildHash []byte, rightChildHash []byte, prefix BitString) bool {
 
 	prefixHash := computeRootHashNonMembership(prefix, &proof)
 	hash := crypto.Hash(leftChildHash, rightChildHash, prefixHash)
@@ -685,8 +730,11 @@ func ComputeLeafNodeHash(key []byte, value []byte, signature []byte, pos uint32)
 	return computeLeafHash(makePrefixFromKey(key), ComputeContentHash(key, value, signature, pos))
 }
 
-func computeLeafHash(prefix []byte, nodeContentHash []byte) []byte {
-	return crypto.Hash(prefix, nodeContentHash)
+func computeLeafHash(prefix BitString, nodeContentHash []byte) []byte {
+	if !prefix.wellFormed() {
+		return nil
+	}
+	return crypto.Hash(prefix.Unpack(), nodeContentHash)
 }
 
 func ComputeContentHash(key []byte, value []byte, signature []byte, pos uint32) []byte {
@@ -804,7 +852,7 @@ func (m *MerkleSquare) getOldRoots(oldSize uint32) []MerkleNode {
 }
 
 // Appends a value to all prefix trees up to the root (even for ghost nodes)
-func (m *MerkleSquare) appendToPrefixTrees(node MerkleNode, prefix []byte, valueHash []byte, pos uint32) {
+func (m *MerkleSquare) appendToPrefixTrees(node MerkleNode, prefix BitString, valueHash []byte, pos uint32) {
 
 	for node.getDepth() != m.depth {
 		node = node.getParent()
@@ -47,6 +47,39 @@ func TestAppend(t *testing.T) {
 	}
 }
 
+func TestAppendGrowsFullTree(t *testing.T) {
+
+	// a tree that started out too small must look exactly like one that
+	// was deep enough from the start
+	grown := createTestingTreeRepeatedKeys(21, 1, 5)
+	m := createTestingTreeRepeatedKeys(21, 5, 5)
+
+	if grown.Size != 21 || grown.depth != 5 {
+		t.Fatalf("expected 21 keys in a tree of depth 5, got %d keys at depth %d", grown.Size, grown.depth)
+	}
+
+	var size, pos uint32
+	for size = 1; size <= grown.Size; size++ {
+		if !reflect.DeepEqual(grown.GetOldDigest(size), m.GetOldDigest(size)) {
+			t.Fatalf("size %d: digests differ", size)
+		}
+		for pos = 0; pos < size; pos++ {
+			key := []byte(fmt.Sprintf("key%d", pos%5))
+			if !reflect.DeepEqual(grown.GenerateExistenceProof(key, pos, 0, size), m.GenerateExistenceProof(key, pos, 0, size)) {
+				t.Fatalf("size %d, pos %d: existence proofs differ", size, pos)
+			}
+		}
+		if size > 1 && !reflect.DeepEqual(grown.GenerateExtensionProof(size-1, size), m.GenerateExtensionProof(size-1, size)) {
+			t.Fatalf("size %d: extension proofs differ", size)
+		}
+	}
+
+	full := NewMerkleSquare(maxForestDepth)
+	if err := full.grow(); err != ErrForestFull {
+		t.Errorf("expected ErrForestFull, got %v", err)
+	}
+}
+
 func TestGenerateExistenceProof(t *testing.T) {
 
 	m0 := createTestingTree(7, 3)
@@ -743,7 +776,7 @@ func (m *MerkleSquare) generateKeyHash(key []byte, exclude ...uint32) []KeyHash
 
 		node := m.getNode(0, i)
 
-		if bytes.Equal(prefix, node.getPrefix()) {
+		if prefix.Equal(node.getPrefix()) {
 			res = append(res, KeyHash{
 				Hash: node.getContentHash(),
 				Pos:  node.getShift(),
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// MisbehaviorEvidence shows that the server signed two digests for a
+// partition that no honest server would sign: the newer one rolls back the
+// epoch or the update log of the older one, their update logs have the same
+// size but different roots, or, for a split view, they differ though they are
+// for the same epoch. The evidence is only the two signed digests, so anyone
+// with the server's key can check it without trusting whoever reports it. A
+// consistency proof that fails to verify is not evidence, since the server
+// does not sign the proofs it serves.
+type MisbehaviorEvidence struct {
+	PartitionIndex uint64
+	OldDigest      *LegologDigest
+	NewDigest      *LegologDigest
+
+	// whether the two digests are a split view, and the server's signatures
+	// of them
+	SplitView    bool
+	OldSignature []byte
+	NewSignature []byte
+}
+
+// Verify checks that the evidence is for partition PartitionIndex of the log
+// with logID and numPartitions, that the server with key serverVK signed both
+// digests, and that the digests really show misbehavior.
+func (e *MisbehaviorEvidence) Verify(logID []byte, numPartitions uint64, serverVK []byte) error {
+	if e.OldDigest == nil || e.NewDigest == nil {
+		return errors.New("evidence is missing a digest")
+	}
+	for _, digest := range []*LegologDigest{e.OldDigest, e.NewDigest} {
+		if err := digest.Binding.CheckLog(logID, e.PartitionIndex, numPartitions); err != nil {
+			return err
+		}
+	}
+	if err := e.VerifySignatures(serverVK); err != nil {
+		return err
+	}
+	if e.SplitView {
+		return checkSplitView(e.OldDigest, e.NewDigest)
+	}
+	return checkRollback(e.OldDigest, e.NewDigest)
+}
+
+// checkRollback returns nil if newDigest cannot follow oldDigest: its epoch or
+// its update log is behind that of oldDigest, or its update log has the same
+// size but a different root. The update log is never reset, so this holds
+// across verification periods too.
+func checkRollback(oldDigest *LegologDigest, newDigest *LegologDigest) error {
+	switch {
+	case newDigest.Epoch < oldDigest.Epoch:
+		return nil
+	case newDigest.UpdateLogSize < oldDigest.UpdateLogSize:
+		return nil
+	case newDigest.UpdateLogSize == oldDigest.UpdateLogSize && !bytes.Equal(newDigest.UpdateLogRoot, oldDigest.UpdateLogRoot):
+		return nil
+	}
+	return fmt.Errorf("digest of epoch %d can follow that of epoch %d", newDigest.Epoch, oldDigest.Epoch)
+}
+
+// VerifySignatures checks that the server with key serverVK signed both
+// digests of the evidence.
+func (e *MisbehaviorEvidence) VerifySignatures(serverVK []byte) error {
+	if e.OldSignature == nil || e.NewSignature == nil {
+		return errors.New("evidence is not signed")
+	}
+	if !(&SignedDigest{e.OldDigest, e.OldSignature}).Verify(serverVK) ||
+		!(&SignedDigest{e.NewDigest, e.NewSignature}).Verify(serverVK) {
+		return errors.New("server did not sign both digests")
+	}
+	return nil
+}
+
+// VerifyUpdateLogExtension checks that the update log committed to by
+// newDigest extends the one committed to by oldDigest. Anything extends a
+// missing or empty update log. The update log is never reset, so this holds
+// across verification periods too.
+func VerifyUpdateLogExtension(oldDigest *LegologDigest, newDigest *LegologDigest, proof *MerkleExtensionProof) bool {
+	if oldDigest == nil || oldDigest.UpdateLogSize == 0 {
+		return true
+	}
+	return VerifyConsistencyProof(
+		&Digest{Roots: [][]byte{oldDigest.UpdateLogRoot}, Size: oldDigest.UpdateLogSize},
+		&Digest{Roots: [][]byte{newDigest.UpdateLogRoot}, Size: newDigest.UpdateLogSize},
+		copyExtensionProof(proof),
+	)
+}
+
+// copyExtensionProof returns a copy of proof that VerifyConsistencyProof,
+// which modifies the proof it is given, can use.
+func copyExtensionProof(proof *MerkleExtensionProof) *MerkleExtensionProof {
+	if proof == nil {
+		return &MerkleExtensionProof{}
+	}
+	return &MerkleExtensionProof{
+		Siblings:     append([]Sibling{}, proof.Siblings...),
+		PrefixHashes: append([][]byte{}, proof.PrefixHashes...),
+	}
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/immesys/bw2/crypto"
+)
+
+func TestMisbehaviorEvidence(t *testing.T) {
+	logID := []byte("log")
+	serverSK, serverVK := crypto.GenerateKeypair()
+	publish := func(keys ...string) *SignedDigest {
+		p := NewPartition()
+		p.Bind(PartitionBinding{LogID: logID, PartitionIndex: 1, NumPartitions: 2})
+		for _, key := range keys {
+			p.Append([]byte(key), []byte(key), []byte(key), []byte(key))
+			p.IncrementUpdateEpoch()
+		}
+		return SignDigest(serverSK, serverVK, p.GetDigest())
+	}
+	evidenceOf := func(oldDigest *SignedDigest, newDigest *SignedDigest) *MisbehaviorEvidence {
+		return &MisbehaviorEvidence{
+			PartitionIndex: 1,
+			OldDigest:      oldDigest.Digest,
+			NewDigest:      newDigest.Digest,
+			OldSignature:   oldDigest.Signature,
+			NewSignature:   newDigest.Signature,
+		}
+	}
+
+	// two honest digests are never evidence, whatever proof came with them
+	verified := publish("a", "b")
+	if err := evidenceOf(verified, publish("a", "b", "c")).Verify(logID, 2, serverVK); err == nil {
+		t.Error("evidence against an honest extension verified")
+	}
+	// nor is a longer update log that does not extend the old one, as only
+	// an unsigned proof could show it
+	if err := evidenceOf(verified, publish("a", "x", "c")).Verify(logID, 2, serverVK); err == nil {
+		t.Error("evidence that needs a consistency proof verified")
+	}
+
+	for name, evidence := range map[string]*MisbehaviorEvidence{
+		"forked update log":      evidenceOf(verified, publish("a", "x")),
+		"rolled back epoch":      evidenceOf(verified, publish("a")),
+		"rolled back forked log": evidenceOf(verified, publish("x")),
+	} {
+		if err := evidence.Verify(logID, 2, serverVK); err != nil {
+			t.Errorf("evidence of a %s did not verify: %v", name, err)
+		}
+		if err := evidence.Verify([]byte("other log"), 2, serverVK); err == nil {
+			t.Errorf("evidence of a %s verified for a different log", name)
+		}
+		_, otherVK := crypto.GenerateKeypair()
+		if err := evidence.Verify(logID, 2, otherVK); err == nil {
+			t.Errorf("evidence of a %s verified against another server's key", name)
+		}
+		unsigned := *evidence
+		unsigned.NewSignature = nil
+		if err := unsigned.Verify(logID, 2, serverVK); err == nil {
+			t.Errorf("evidence of a %s verified without the server's signature", name)
+		}
+		evidence.PartitionIndex = 0
+		if err := evidence.Verify(logID, 2, serverVK); err == nil {
+			t.Errorf("evidence of a %s verified for a different partition", name)
+		}
+	}
+}
+
+func TestSplitViewEvidence(t *testing.T) {
+	logID := []byte("log")
+	serverSK, serverVK := crypto.GenerateKeypair()
+	publish := func(keys ...string) *SignedDigest {
+		p := NewPartition()
+		p.Bind(PartitionBinding{LogID: logID, PartitionIndex: 1, NumPartitions: 2})
+		for _, key := range keys {
+			p.Append([]byte(key), []byte(key), []byte(key), []byte(key))
+			p.IncrementUpdateEpoch()
+		}
+		return SignDigest(serverSK, serverVK, p.GetDigest())
+	}
+
+	honest := publish("a", "b")
+	if !honest.Verify(serverVK) {
+		t.Fatal("signed digest did not verify")
+	}
+	if honest.ConflictsWith(publish("a", "b")) {
+		t.Error("the same digest published twice conflicts")
+	}
+	if honest.ConflictsWith(publish("a", "b", "c")) {
+		t.Error("digests of different epochs conflict")
+	}
+	split := publish("a", "x")
+	if !honest.ConflictsWith(split) {
+		t.Fatal("different digests of the same epoch do not conflict")
+	}
+
+	evidence := NewSplitViewEvidence(honest, split)
+	if err := evidence.Verify(logID, 2, serverVK); err != nil {
+		t.Errorf("evidence of a split view did not verify: %v", err)
+	}
+	if err := evidence.VerifySignatures(serverVK); err != nil {
+		t.Errorf("signatures of a split view did not verify: %v", err)
+	}
+	if reversed := NewSplitViewEvidence(split, honest); reversed.OldDigest != evidence.OldDigest {
+		t.Error("evidence of the same split view depends on the order it was found in")
+	}
+	_, otherVK := crypto.GenerateKeypair()
+	if err := evidence.VerifySignatures(otherVK); err == nil {
+		t.Error("split view verified against another server's key")
+	}
+	if err := evidence.Verify(logID, 3, serverVK); err == nil {
+		t.Error("split view verified for a log with a different number of partitions")
+	}
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	crypto "github.com/huyuncong/MerkleSquare/lib/crypto"
+)
+
+// MonitoringProof proves the state of an identifier in every base tree
+// committed to by the HistoryForest roots that cover the verification periods
+// since a given one. A client that last checked its identifiers at that
+// period only needs these roots to catch up on what it missed.
+//
+// Unlike a lookup, which only opens the newest value of the identifier, a
+// monitoring proof opens every value appended to it since the head of its
+// value chain the monitor last verified, so that a value cannot be appended
+// and replaced again between two base trees without the monitor seeing it.
+type MonitoringProof struct {
+	HistoryForestSize uint32
+	BaseTreeRoots     [][]byte // roots covering the periods since, oldest first
+	BaseTreeProofs    []*MembershipOrNonmembershipProof
+	Values            []*ValueChainExtension // for each base tree the identifier is in
+}
+
+// ValueChainExtension opens the values of a leaf from the one that made a
+// known head of its hash chain onwards: extending PrecedingDigest with the
+// first value gives that head, and extending it with all of them the head of
+// the leaf. Every value appended after the known head is opened.
+//
+// A monitor that has not verified a head yet is shown the values from the
+// first appearance of the value it monitors, or only the newest value if it
+// has not appeared.
+type ValueChainExtension struct {
+	PrecedingDigest []byte
+	Values          []KeyHash
+}
+
+// MonitoringProver is implemented by partitions that keep an aggregated
+// history of their base trees.
+type MonitoringProver interface {
+	GenerateMonitoringProof(identifier []byte, value []byte, signature []byte, sinceVerificationPeriod uint64,
+		verifiedHead []byte) *MonitoringProof
+}
+
+var _ MonitoringProver = (*AggHistPartition)(nil)
+
+// GenerateMonitoringProof proves the values of identifier against each
+// HistoryForest root whose newest verification period is at least
+// sinceVerificationPeriod, opening those appended since verifiedHead, the
+// head of the identifier's value chain the monitor last verified, if any.
+func (p *AggHistPartition) GenerateMonitoringProof(identifier []byte, value []byte, signature []byte,
+	sinceVerificationPeriod uint64, verifiedHead []byte) *MonitoringProof {
+	id_hash := GetPrefixFromIdentifier(identifier)
+	// TODO: fix position eventually
+	valueHash := ConvertBitsToBytes(ComputeLeafNodeHash(identifier, value, signature, 0))
+
+	proof := &MonitoringProof{HistoryForestSize: p.baseTreeForest.Size}
+	head := verifiedHead
+	for _, histNode := range p.baseTreeForest.Roots {
+		if histNode.getVerificationPeriod() < sinceVerificationPeriod {
+			continue
+		}
+		baseTreeProof := p.generateBaseTreeProof(id_hash, histNode.getVerificationPeriod())
+		var extension *ValueChainExtension
+		if baseTreeProof.ValueExists {
+			values := p.baseTree.getLeaf(id_hash, histNode.getVerificationPeriod()).getValues()
+			extension = openValuesSince(values, head, valueHash)
+			// as for the verifier, the chain is followed from the first base
+			// tree that holds value
+			if head != nil || bytes.Equal(extension.Values[0].Hash, valueHash) {
+				head = valueChainDigest(values)
+			}
+			// the extension opens the newest value too
+			baseTreeProof.LeafValue = nil
+		}
+		proof.BaseTreeRoots = append(proof.BaseTreeRoots, histNode.getNewestLeafHash())
+		proof.BaseTreeProofs = append(proof.BaseTreeProofs, baseTreeProof)
+		proof.Values = append(proof.Values, extension)
+	}
+	return proof
+}
+
+// openValuesSince opens values from the one that made head, or, if head is
+// not in the chain, from the first with hash valueHash, or else only the
+// newest one.
+func openValuesSince(values []KeyHash, head []byte, valueHash []byte) *ValueChainExtension {
+	start := -1
+	var digest []byte
+	for i, value := range values {
+		digest = extendValueChain(digest, value)
+		if head != nil && bytes.Equal(digest, head) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		start = len(values) - 1
+		for i, value := range values {
+			if bytes.Equal(value.Hash, valueHash) {
+				start = i
+				break
+			}
+		}
+	}
+	return &ValueChainExtension{
+		PrecedingDigest: valueChainDigest(values[:start]),
+		Values:          values[start:],
+	}
+}
+
+// historyForestRootPeriods returns the newest verification period under each
+// root of a HistoryForest holding size verification periods, largest root
+// first, which is the order the forest keeps its roots in.
+func historyForestRootPeriods(size uint32) []uint64 {
+	periods := []uint64{}
+	var covered uint64 = 0
+	for bit := 31; bit >= 0; bit-- {
+		width := uint64(1) << uint(bit)
+		if uint64(size)&width != 0 {
+			covered += width
+			periods = append(periods, covered-1)
+		}
+	}
+	return periods
+}
+
+// ValidateMonitoringProof checks a monitoring proof for the periods since
+// sinceVerificationPeriod against digest, starting from verifiedHead, the
+// head of the identifier's value chain the monitor last verified, or nil if
+// it has not verified one. Once the chain reaches value, every value appended
+// after it must be one the owner appended: value itself or one of the hashes
+// in appended. It returns the head of the chain in the newest base tree
+// covered once it has reached value, or verifiedHead, and reports whether
+// value is the newest value there.
+func (v AggHistVerifier) ValidateMonitoringProof(digest *LegologDigest, proof *MonitoringProof, identifier []byte, value []byte,
+	signature []byte, pos uint64, masterVK []byte, sinceVerificationPeriod uint64, verifiedHead []byte, appended [][]byte) (
+	bool, []byte, error) {
+	if err := v.checkBinding(digest, identifier); err != nil {
+		return false, nil, err
+	}
+	if proof.HistoryForestSize != digest.HistoryForestSize {
+		return false, nil, fmt.Errorf("monitoring proof is for %d verification periods, digest has %d", proof.HistoryForestSize, digest.HistoryForestSize)
+	}
+	periods := historyForestRootPeriods(digest.HistoryForestSize)
+	if len(periods) != len(digest.BaseTreeRoots) {
+		return false, nil, errors.New("digest roots do not match the size of its history forest")
+	}
+	first := 0
+	for first < len(periods) && periods[first] < sinceVerificationPeriod {
+		first++
+	}
+	expectedRoots := digest.BaseTreeRoots[first:]
+	if len(proof.BaseTreeRoots) != len(expectedRoots) || len(proof.BaseTreeProofs) != len(expectedRoots) ||
+		len(proof.Values) != len(expectedRoots) {
+		return false, nil, fmt.Errorf("expected proofs against %d base trees, got %d", len(expectedRoots), len(proof.BaseTreeProofs))
+	}
+
+	// TODO: fix position eventually
+	valueHash := ConvertBitsToBytes(ComputeLeafNodeHash(identifier, value, signature, 0))
+	owned := func(hash []byte) bool {
+		if bytes.Equal(hash, valueHash) {
+			return true
+		}
+		for _, appendedHash := range appended {
+			if bytes.Equal(hash, appendedHash) {
+				return true
+			}
+		}
+		return false
+	}
+	head := verifiedHead
+	var newest []byte
+	for i, baseTreeProof := range proof.BaseTreeProofs {
+		if !bytes.Equal(proof.BaseTreeRoots[i], expectedRoots[i]) {
+			return false, nil, fmt.Errorf("base tree root %d does not match the digest", first+i)
+		}
+		if !baseTreeProof.ValueExists {
+			if head != nil {
+				return false, nil, fmt.Errorf("value is missing from base tree %d after appearing in an earlier one", first+i)
+			}
+			if baseTreeProof.NonMembershipProof == nil || !validateNonMembershipProof(baseTreeProof.NonMembershipProof, identifier, expectedRoots[i]) {
+				return false, nil, errors.New("non membership proof does not go through")
+			}
+			newest = nil
+			continue
+		}
+
+		extension := proof.Values[i]
+		if baseTreeProof.MembershipProof == nil || extension == nil || len(extension.Values) == 0 ||
+			(len(extension.PrecedingDigest) != 0 && len(extension.PrecedingDigest) != crypto.HashSize) {
+			return false, nil, fmt.Errorf("base tree %d: incomplete membership proof", first+i)
+		}
+		chainHead := extension.PrecedingDigest
+		for j, value := range extension.Values {
+			chainHead = extendValueChain(chainHead, value)
+			if j == 0 && head != nil && !bytes.Equal(chainHead, head) {
+				return false, nil, fmt.Errorf("base tree %d: values do not follow the verified head", first+i)
+			}
+		}
+		computedRoot := computeRootHashMembershipFromDigest(GetPrefixFromIdentifier(identifier), baseTreeProof.MembershipProof, chainHead)
+		if !bytes.Equal(computedRoot, expectedRoots[i]) {
+			return false, nil, fmt.Errorf("base tree %d: computed root doesn't match reported root", first+i)
+		}
+		newest = extension.Values[len(extension.Values)-1].Hash
+		// base trees from before value was appended hold only older values
+		// of the identifier
+		if head == nil && !bytes.Equal(extension.Values[0].Hash, valueHash) {
+			continue
+		}
+		// the value at the verified head was checked before
+		for _, value := range extension.Values[1:] {
+			if !owned(value.Hash) {
+				return false, nil, fmt.Errorf("a value the owner did not append was appended for the identifier by base tree %d", first+i)
+			}
+		}
+		head = chainHead
+	}
+
+	found := head != nil && bytes.Equal(newest, valueHash)
+	if found {
+		if err := verifyValueSignature(value, signature, pos, masterVK, false); err != nil {
+			return false, nil, err
+		}
+	}
+	return found, head, nil
+}
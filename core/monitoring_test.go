@@ -0,0 +1,168 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/immesys/bw2/crypto"
+)
+
+func signTestValue(masterSK []byte, masterVK []byte, value []byte) []byte {
+	signature := make([]byte, 64)
+	crypto.SignBlob(masterSK, masterVK, signature, append(value, []byte("0")...))
+	return signature
+}
+
+func TestMonitoringProof(t *testing.T) {
+	partition := NewAggHistPartition(testCfg, "")
+	partition.Bind(testBinding)
+	masterSK, masterVK := crypto.GenerateKeypair()
+	identifier := []byte("alice_key")
+	other := []byte("bob_key")
+	value := []byte("alice's key")
+	signature := signTestValue(masterSK, masterVK, value)
+
+	// alice's value first lands in the base tree of verification period 2,
+	// and the forest ends up with roots over periods 0-3 and 4-5
+	for period := 0; period < 7; period++ {
+		partition.Append(other, other, []byte{byte(period)}, []byte{byte(period)})
+		if period == 2 {
+			partition.Append(identifier, identifier, value, signature)
+		}
+		partition.IncrementUpdateEpoch()
+		partition.IncrementVerificationPeriod()
+	}
+	digest := partition.GetDigest()
+	if digest.HistoryForestSize != 6 {
+		t.Fatalf("expected 6 verification periods in the history forest, got %d", digest.HistoryForestSize)
+	}
+
+	var v AggHistVerifier
+	for since := uint64(0); since <= 6; since++ {
+		proof := partition.GenerateMonitoringProof(identifier, value, signature, since, nil)
+		found, _, err := v.ValidateMonitoringProof(digest, proof, identifier, value, signature, 0, masterVK, since, nil, nil)
+		if err != nil {
+			t.Fatalf("since %d: %v", since, err)
+		}
+		if found != (since < 6) {
+			t.Errorf("since %d: expected found to be %t", since, since < 6)
+		}
+		if since == 4 && len(proof.BaseTreeProofs) != 1 {
+			t.Errorf("expected a single root to cover period 4, got %d", len(proof.BaseTreeProofs))
+		}
+	}
+
+	// proofs that leave out part of the gap are rejected
+	proof := partition.GenerateMonitoringProof(identifier, value, signature, 4, nil)
+	if _, _, err := v.ValidateMonitoringProof(digest, proof, identifier, value, signature, 0, masterVK, 0, nil, nil); err == nil {
+		t.Error("proof that skips roots in the gap verified")
+	}
+
+	// once a newer value the owner appended is rolled up, the old one is no
+	// longer the newest value of the identifier
+	newValue := []byte("alice's new key")
+	newSignature := signTestValue(masterSK, masterVK, newValue)
+	newValueHash := ConvertBitsToBytes(ComputeLeafNodeHash(identifier, newValue, newSignature, 0))
+	partition.Append(identifier, identifier, newValue, newSignature)
+	partition.IncrementUpdateEpoch()
+	partition.IncrementVerificationPeriod()
+	partition.IncrementVerificationPeriod()
+	digest = partition.GetDigest()
+	proof = partition.GenerateMonitoringProof(identifier, value, signature, 0, nil)
+	if found, _, err := v.ValidateMonitoringProof(digest, proof, identifier, value, signature, 0, masterVK, 0, nil,
+		[][]byte{newValueHash}); err != nil || found {
+		t.Errorf("expected the replaced value not to be found, got %t, %v", found, err)
+	}
+	if _, _, err := v.ValidateMonitoringProof(digest, proof, identifier, value, signature, 0, masterVK, 0, nil, nil); err == nil {
+		t.Error("value the owner did not append verified")
+	}
+
+	// and a root after one that held it as the newest value is rejected
+	partition = NewAggHistPartition(testCfg, "")
+	partition.Bind(testBinding)
+	for period := 0; period < 7; period++ {
+		partition.Append(other, other, []byte{byte(period)}, []byte{byte(period)})
+		if period == 2 {
+			partition.Append(identifier, identifier, value, signature)
+		}
+		if period == 4 {
+			partition.Append(identifier, identifier, newValue, newSignature)
+		}
+		partition.IncrementUpdateEpoch()
+		partition.IncrementVerificationPeriod()
+	}
+	digest = partition.GetDigest()
+	proof = partition.GenerateMonitoringProof(identifier, value, signature, 0, nil)
+	if _, _, err := v.ValidateMonitoringProof(digest, proof, identifier, value, signature, 0, masterVK, 0, nil, nil); err == nil {
+		t.Error("value that was replaced verified as the newest")
+	}
+}
+
+func TestMonitoringProofOpensValuesSinceVerifiedHead(t *testing.T) {
+	partition := NewAggHistPartition(testCfg, "")
+	partition.Bind(testBinding)
+	masterSK, masterVK := crypto.GenerateKeypair()
+	identifier := []byte("alice_key")
+	value := []byte("alice's key")
+	signature := signTestValue(masterSK, masterVK, value)
+	// a base tree is added to the history forest once the period after it
+	// has ended
+	nextPeriod := func() {
+		partition.IncrementUpdateEpoch()
+		partition.IncrementVerificationPeriod()
+		partition.IncrementVerificationPeriod()
+	}
+
+	partition.Append(identifier, identifier, value, signature)
+	nextPeriod()
+	var v AggHistVerifier
+	digest := partition.GetDigest()
+	proof := partition.GenerateMonitoringProof(identifier, value, signature, 0, nil)
+	found, head, err := v.ValidateMonitoringProof(digest, proof, identifier, value, signature, 0, masterVK, 0, nil, nil)
+	if err != nil || !found {
+		t.Fatalf("expected the value to be found, got %t, %v", found, err)
+	}
+
+	// within the next period, another value is appended for alice and
+	// replaced by hers again, so her value is the newest one in the base tree
+	mallory := []byte("mallory's key")
+	partition.Append(identifier, identifier, mallory, signTestValue(masterSK, masterVK, mallory))
+	partition.Append(identifier, identifier, value, signature)
+	nextPeriod()
+	digest = partition.GetDigest()
+	proof = partition.GenerateMonitoringProof(identifier, value, signature, 2, head)
+	if len(proof.Values) != 1 || len(proof.Values[0].Values) != 3 {
+		t.Fatalf("expected the verified value and both appended after it to be opened, got %+v", proof.Values)
+	}
+	if _, _, err := v.ValidateMonitoringProof(digest, proof, identifier, value, signature, 0, masterVK, 2, head, nil); err == nil {
+		t.Error("value appended and replaced within a period went unnoticed")
+	}
+
+	// opening only the newest value does not link back to the verified head
+	proof.Values[0].PrecedingDigest = valueChainDigest(partition.baseTree.getLeaf(GetPrefixFromIdentifier(identifier), 2).getValues()[:2])
+	proof.Values[0].Values = proof.Values[0].Values[2:]
+	if _, _, err := v.ValidateMonitoringProof(digest, proof, identifier, value, signature, 0, masterVK, 2, head, nil); err == nil {
+		t.Error("proof that does not link back to the verified head verified")
+	}
+
+	// values the owner appended itself are accepted
+	newValue := []byte("alice's new key")
+	newSignature := signTestValue(masterSK, masterVK, newValue)
+	partition = NewAggHistPartition(testCfg, "")
+	partition.Bind(testBinding)
+	partition.Append(identifier, identifier, value, signature)
+	nextPeriod()
+	partition.Append(identifier, identifier, newValue, newSignature)
+	partition.Append(identifier, identifier, value, signature)
+	nextPeriod()
+	digest = partition.GetDigest()
+	proof = partition.GenerateMonitoringProof(identifier, value, signature, 2, head)
+	appended := [][]byte{ConvertBitsToBytes(ComputeLeafNodeHash(identifier, newValue, newSignature, 0))}
+	found, newHead, err := v.ValidateMonitoringProof(digest, proof, identifier, value, signature, 0, masterVK, 2, head, appended)
+	if err != nil || !found {
+		t.Fatalf("expected the value to be found, got %t, %v", found, err)
+	}
+	if bytes.Equal(newHead, head) {
+		t.Error("expected the verified head to move to the newest value")
+	}
+}
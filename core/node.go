@@ -42,7 +42,7 @@ type index struct {
 func (node *LeafNode) completeLeaf(key []byte, value []byte, signature []byte, pos uint32) {
 
 	contentHash := ComputeContentHash(key, value, signature, pos)
-	hashVal := crypto.Hash(makePrefixFromKey(key), contentHash)
+	hashVal := crypto.Hash(makePrefixFromKey(key).Unpack(), contentHash)
 
 	node.contentHash = contentHash
 	node.hash = hashVal
@@ -115,7 +115,7 @@ type MerkleNode interface {
 	getIndex() index
 	getSibling() Sibling
 	getContentHash() []byte
-	getPrefix() []byte
+	getPrefix() BitString
 	serialize() ([]byte, error)
 	getSize() int
 }
@@ -248,7 +248,7 @@ func (node *InternalNode) getPrefixTree() *prefixTree  { return node.prefixTree
 func (node *InternalNode) getShift() uint32            { return node.index.shift }
 func (node *InternalNode) getIndex() index             { return node.index }
 func (node *InternalNode) getContentHash() []byte      { return []byte("") }
-func (node *InternalNode) getPrefix() []byte           { return []byte("") }
+func (node *InternalNode) getPrefix() BitString        { return BitString{} }
 
 func (node *LeafNode) isComplete() bool             { return node.completed }
 func (node *LeafNode) isRightChild() bool           { return node.isRight }
@@ -267,4 +267,4 @@ func (node *LeafNode) getPrefixTree() *prefixTree   { return NewPrefixTree() }
 func (node *LeafNode) getShift() uint32             { return node.index.shift }
 func (node *LeafNode) getIndex() index              { return node.index }
 func (node *LeafNode) getContentHash() []byte       { return node.contentHash }
-func (node *LeafNode) getPrefix() []byte            { return makePrefixFromKey(node.key) }
+func (node *LeafNode) getPrefix() BitString         { return makePrefixFromKey(node.key) }
@@ -13,11 +13,15 @@ import (
 
 type LegoLogPartition interface {
 	Append(username []byte, identifier []byte, value []byte, signature []byte)
+	BulkAppend(identifiers [][]byte, values [][]byte, signatures [][]byte) error
 	GenerateExistenceProof(identifier []byte, value []byte, signature []byte) *LegologExistenceProof
 	IncrementUpdateEpoch()
 	IncrementVerificationPeriod() error
 	GetDigest() *LegologDigest
 	GetUpdateEpochConsistencyProof(oldSize uint32) *MerkleExtensionProof
+	// Bind commits the partition's digests to its place in the log. It must
+	// be called before the first append.
+	Bind(binding PartitionBinding)
 }
 
 // assert that Partition implements LegoLogPartition
@@ -34,11 +38,10 @@ TODO:
 type Partition struct {
 	baseTree *persistentPrefixTree
 
-	queryUpdateLog         *ChronTree
+	updateLog              updateLog
 	queryUpdatePrefixTrees []*prefixTree
 
 	verificationUpdatePrefixTrees []*prefixTree
-	verificationUpdateLog         *ChronTree
 
 	//  the updates from the current epoch that get rolled up into the set tree;
 	//  latestUpdates[0] = list of H(identifier), latestUpdates[1] = list of H(id, value, signature, pos)
@@ -47,8 +50,11 @@ type Partition struct {
 	verificationEpoch uint64
 	/* 	PublishedDigest *LegologDigest
 	 */
-	pos       uint32
-	hashChain []byte
+	pos     uint32
+	chain   hashChain
+	binding PartitionBinding
+
+	history updateHistory
 }
 
 // Digest struct for snapshots of the current state of MerkleSquare
@@ -61,8 +67,12 @@ type LegologDigest struct {
 	UpdateSetRoots     [][]byte
 	UpdateLogSize      uint32
 	Epoch              uint64
+	VerificationPeriod uint64
 	HashChain          []byte
 	HistoryForestRoots [][]byte
+	HistoryForestSize  uint32 // number of verification periods in the HistoryForest, if any
+	Binding            PartitionBinding
+	PublishedAt        int64 // when the server published the digest, in Unix nanoseconds; 0 if it was not published
 }
 
 type LegologExistenceProof struct {
@@ -90,7 +100,7 @@ func (p *MembershipOrNonmembershipProof) String() string {
 	} else {
 		buf.WriteString(fmt.Sprintf("\tNon-Membership: %s\n", p.NonMembershipProof))
 	}
-	buf.WriteString(fmt.Sprintf("\tLeaf Values: %v\n", p.LeafValues))
+	buf.WriteString(fmt.Sprintf("\tLeaf Value: %v\n", p.LeafValue))
 	return buf.String()
 }
 
@@ -126,11 +136,10 @@ func NewPartition() *Partition {
 	// }
 
 	p := &Partition{
-		baseTree:              NewPersistentPrefixTree(),
-		queryUpdateLog:        NewChronTree(),
-		verificationUpdateLog: NewChronTree(),
-		latestUpdates:         [][][]byte{{}, {}},
-		verificationEpoch:     0,
+		baseTree:          NewPersistentPrefixTree(),
+		updateLog:         newUpdateLog(),
+		latestUpdates:     [][][]byte{{}, {}},
+		verificationEpoch: 0,
 	}
 	p.IncrementVerificationPeriod()
 	p.IncrementVerificationPeriod()
@@ -151,29 +160,55 @@ func (p *Partition) GetDigest() *LegologDigest {
 	return &LegologDigest{
 		BaseTreeRoots:  [][]byte{p.baseTree.getHash(p.verificationEpoch - 2), p.baseTree.getHash(p.verificationEpoch - 1)},
 		BaseTreeSize:   uint32(p.baseTree.getSize(p.verificationEpoch - 2)), // TODO: @vivian is this the right tree?
-		UpdateLogRoot:  p.queryUpdateLog.root.getHash(),
+		UpdateLogRoot:  p.updateLog.root(),
 		UpdateSetRoots: updatePrefixTreeRoots,
-		UpdateLogSize:  p.queryUpdateLog.numNodes, // TODO: @vivian is this the right log?
+		UpdateLogSize:  p.updateLog.size(),
 		Epoch:          p.epoch,
-		HashChain:      p.hashChain,
+		HashChain:      p.chain.head(),
+		Binding:        p.binding,
+
+		VerificationPeriod: p.verificationEpoch,
+	}
+}
+
+// Bind restarts the hash chain so that every link commits to binding.
+func (p *Partition) Bind(binding PartitionBinding) {
+	p.binding = binding
+	p.chain.reset()
+	if p.verificationEpoch >= 2 {
+		p.extendHashChain()
 	}
 }
 
-func GetPrefixFromIdentifier(identifier []byte) []byte {
-	return ConvertBitsToBytes(libcrypto.Hash(identifier))
+func (p *Partition) extendHashChain() {
+	p.chain.extend(&p.binding, HashChainLink{
+		VerificationPeriod: p.verificationEpoch,
+		BaseTreeRoots:      [][]byte{p.baseTree.getHash(p.verificationEpoch - 2), p.baseTree.getHash(p.verificationEpoch - 1)},
+		Epoch:              p.epoch,
+	})
 }
 
+// GenerateHashChainProof returns the links of the hash chain between two of
+// its heads.
+func (p *Partition) GenerateHashChainProof(oldHashChain []byte, newHashChain []byte) (*HashChainProof, error) {
+	return p.chain.generateProof(oldHashChain, newHashChain)
+}
+
+func GetPrefixFromIdentifier(identifier []byte) BitString {
+	return NewBitString(libcrypto.Hash(identifier))
+}
+
+// GetUpdateEpochConsistencyProof proves that the update log published in the
+// digest extends its first oldSize epochs.
 func (p *Partition) GetUpdateEpochConsistencyProof(oldSize uint32) *MerkleExtensionProof {
-	newSize := p.verificationUpdateLog.numNodes
-	//fmt.Println("oldSize", oldSize, "newSize", newSize)
-	if newSize < oldSize {
-		return &MerkleExtensionProof{}
-	}
-	if oldSize > newSize {
-		panic("oldSize > newSize")
-	}
-	proof := p.verificationUpdateLog.GenerateConsistencyProof(oldSize, newSize)
-	return proof
+	return p.updateLog.consistencyProof(oldSize, p.updateLog.size())
+}
+
+// GenerateUpdateLogChainProof proves that the update log with newSize epochs
+// in verification period newPeriod extends the one with oldSize epochs in
+// oldPeriod, through its checkpoint at the end of each period in between.
+func (p *Partition) GenerateUpdateLogChainProof(oldPeriod uint64, oldSize uint32, newPeriod uint64, newSize uint32) (*UpdateLogChainProof, error) {
+	return p.updateLog.generateChainProof(oldPeriod, oldSize, newPeriod, newSize)
 }
 
 func (p *Partition) Append(username []byte, identifier []byte, value []byte, signature []byte) {
@@ -183,12 +218,13 @@ func (p *Partition) Append(username []byte, identifier []byte, value []byte, sig
 	 */id_hash := GetPrefixFromIdentifier(identifier)
 	hashBytes := ConvertBitsToBytes(ComputeLeafNodeHash(identifier, value, signature, 0)) // todo: fix this position
 	// fmt.Printf("before append, length of latestUpdates is %d\n", len(p.latestUpdates[0]))
-	p.latestUpdates[0] = append(p.latestUpdates[0], id_hash)
+	p.latestUpdates[0] = append(p.latestUpdates[0], id_hash.Packed)
 	p.latestUpdates[1] = append(p.latestUpdates[1], hashBytes)
 	// fmt.Printf("appended to latestUpdates[0], is now of length %d\n", len(p.latestUpdates[0]))
 	/* 	p.updateLog.Append(identifier, value, signature) */
 
 	p.baseTree.Insert(id_hash, hashBytes, p.pos)
+	p.history.append(id_hash.Packed, hashBytes, p.pos, p.verificationEpoch)
 	//p.nextVerificationBaseTree.PrefixAppend(id_hash, hashBytes, p.pos)
 	p.pos += 1
 	/* 	fmt.Printf("Leaving partition.go: Append\n")
@@ -232,12 +268,50 @@ func (p *Partition) Append(username []byte, identifier []byte, value []byte, sig
 	*/
 }
 
+// BulkAppend appends a batch of identifier-value pairs, as if Append had
+// been called for each of them in order, but inserts them into the base tree
+// in a single pass.
+func (p *Partition) BulkAppend(identifiers [][]byte, values [][]byte, signatures [][]byte) error {
+	if len(identifiers) != len(values) || len(identifiers) != len(signatures) {
+		return errors.New("identifiers, values and signatures are of unequal length")
+	}
+	idHashes := make([]BitString, len(identifiers))
+	valueHashes := make([][]byte, len(identifiers))
+	positions := make([]uint32, len(identifiers))
+	for i := range identifiers {
+		idHashes[i] = GetPrefixFromIdentifier(identifiers[i])
+		valueHashes[i] = ConvertBitsToBytes(ComputeLeafNodeHash(identifiers[i], values[i], signatures[i], 0)) // todo: fix this position
+		positions[i] = p.pos + uint32(i)
+	}
+
+	err := p.baseTree.BulkInsert(SortUpdates(idHashes, valueHashes, positions))
+	if err != nil {
+		return err
+	}
+	for i, idHash := range idHashes {
+		p.latestUpdates[0] = append(p.latestUpdates[0], idHash.Packed)
+		p.history.append(idHash.Packed, valueHashes[i], p.pos+uint32(i), p.verificationEpoch)
+	}
+	p.latestUpdates[1] = append(p.latestUpdates[1], valueHashes...)
+	p.pos += uint32(len(identifiers))
+	return nil
+}
+
 // MembershipProof or NonMembershipProof
 type MembershipOrNonmembershipProof struct {
 	MembershipProof    *MembershipProof
 	NonMembershipProof *NonMembershipProof // ask about this type!
 	ValueExists        bool
-	LeafValues         []KeyHash
+	LeafValue          *LeafValueProof // the proven value, for membership proofs
+}
+
+// proveLeafValue opens the newest value in values, or returns nil if there is
+// none. A value that is not the newest was superseded in this tree.
+func proveLeafValue(values []KeyHash) *LeafValueProof {
+	if len(values) == 0 {
+		return nil
+	}
+	return newLeafValueProof(values)
 }
 
 func (p *Partition) GenerateExistenceProof(identifier []byte, value []byte, signature []byte) *LegologExistenceProof {
@@ -251,7 +325,7 @@ func (p *Partition) GenerateExistenceProof(identifier []byte, value []byte, sign
 		MembershipProof:    nil,
 		NonMembershipProof: nil,
 		ValueExists:        false,
-		LeafValues:         []KeyHash{},
+		LeafValue:          nil,
 	}
 	proof.BaseTreeProofs = []*MembershipOrNonmembershipProof{baseTreeProof}
 
@@ -263,7 +337,7 @@ func (p *Partition) GenerateExistenceProof(identifier []byte, value []byte, sign
 		btProof, _ := p.baseTree.generateMembershipProof(id_hash, p.verificationEpoch-2)
 		proof.BaseTreeProofs[0].ValueExists = true
 		proof.BaseTreeProofs[0].MembershipProof = btProof
-		proof.BaseTreeProofs[0].LeafValues = leaf.getValues()
+		proof.BaseTreeProofs[0].LeafValue = proveLeafValue(leaf.getValues())
 	} else {
 		btProof := p.baseTree.generateNonMembershipProof(id_hash, p.verificationEpoch-2) // TODO: verify
 		proof.BaseTreeProofs[0].ValueExists = false
@@ -286,7 +360,7 @@ func (p *Partition) GenerateExistenceProof(identifier []byte, value []byte, sign
 					leafProof, _ := setTree.ProveExistence(id_hash)
 					updateLogProof.MembershipProof = leafProof
 					updateLogProof.ValueExists = true
-					updateLogProof.LeafValues = leaf.getValues()
+					updateLogProof.LeafValue = proveLeafValue(leaf.getValues())
 				} else {
 					leafProof, _ := setTree.ProveNonExistence(id_hash)
 					updateLogProof.MembershipProof = leafProof
@@ -302,13 +376,13 @@ func (p *Partition) GenerateExistenceProof(identifier []byte, value []byte, sign
 		updateLogProof := MembershipOrNonmembershipProof{
 			MembershipProof: nil, NonMembershipProof: nil,
 		}
-		id_hash := ConvertBitsToBytes(libcrypto.Hash(identifier))
+		id_hash := GetPrefixFromIdentifier(identifier)
 		// hashBytes := ConvertBitsToBytes(ComputeContentHash(identifier, value, signature, 0))
 		if prefixTree.getLeaf(id_hash) != nil {
 			leafProof, leafValues := prefixTree.generateMembershipProof(id_hash) // prefixTree.ProveExistence(id_hash)
 			updateLogProof.MembershipProof = leafProof
 			updateLogProof.ValueExists = true
-			updateLogProof.LeafValues = leafValues
+			updateLogProof.LeafValue = proveLeafValue(leafValues)
 		} else {
 			leafProof := prefixTree.generateNonMembershipProof(id_hash)
 
@@ -332,14 +406,14 @@ func (p *Partition) IncrementUpdateEpoch() {
 	// print p.latestupdates[0] and p.latestUpdates[1] in a readable format
 	// fmt.Printf("latestUpdates[0] of length %d: %v\n\n", len(p.latestUpdates[0]), p.latestUpdates[0])
 	// fmt.Printf("latestUpdates[1] of length %d: %v\n\n", len(p.latestUpdates[1]), p.latestUpdates[1])
-	prefixTree := NewPrefixTree()
-	for i, id_hash := range p.latestUpdates[0] {
-		prefixTree.PrefixAppend(id_hash, p.latestUpdates[1][i], 0)
+	prefixTree, err := NewPrefixTreeFromUpdates(p.latestUpdates[0], p.latestUpdates[1], true)
+	if err != nil {
+		panic(err)
 	}
 	p.queryUpdatePrefixTrees = append(p.queryUpdatePrefixTrees, prefixTree)
 	p.verificationUpdatePrefixTrees = append(p.verificationUpdatePrefixTrees, prefixTree)
 	for _, id_hash := range p.latestUpdates[0] {
-		hasKey := prefixTree.getLeaf(id_hash) != nil
+		hasKey := prefixTree.getLeaf(NewBitString(id_hash)) != nil
 		if hasKey {
 			// fmt.Println("server has key for hash ", id_hash)
 		} else {
@@ -348,20 +422,36 @@ func (p *Partition) IncrementUpdateEpoch() {
 	}
 	p.latestUpdates = [][][]byte{{}, {}}
 	p.epoch += 1
-	p.verificationUpdateLog.Append([]byte(strconv.Itoa(int(p.epoch))), prefixTree.getHash(), []byte(""))
-	p.queryUpdateLog.Append([]byte(strconv.Itoa(int(p.epoch))), prefixTree.getHash(), []byte(""))
+	p.history.endEpoch(p.epoch, p.verificationEpoch)
+	p.updateLog.append(p.epoch, prefixTree.getHash())
+}
+
+// KeepUpdateHistory makes the partition keep the updates rolled up in every
+// epoch from now on, for auditors that replay them.
+func (p *Partition) KeepUpdateHistory() {
+	p.history.keep = true
+}
+
+// GetEpochUpdates returns the updates rolled up in the given epoch.
+func (p *Partition) GetEpochUpdates(epoch uint64) (*EpochUpdates, error) {
+	return p.history.get(epoch)
+}
+
+// PruneEpochUpdates drops the updates of every epoch up to and including
+// through.
+func (p *Partition) PruneEpochUpdates(through uint64) {
+	p.history.prune(through)
 }
 
 func (p *Partition) IncrementVerificationPeriod() (err error) {
+	p.updateLog.endPeriod(p.verificationEpoch)
 	p.baseTree.NextEpoch()
 	p.verificationEpoch += 1
 
-	p.queryUpdateLog = p.verificationUpdateLog
 	p.queryUpdatePrefixTrees = p.verificationUpdatePrefixTrees
-	p.verificationUpdateLog = NewChronTree()
 	p.verificationUpdatePrefixTrees = []*prefixTree{}
 	if p.verificationEpoch >= 2 {
-		p.hashChain = libcrypto.Hash(p.baseTree.getHash(p.verificationEpoch-2), p.baseTree.getHash(p.verificationEpoch-1), []byte(strconv.FormatUint(p.epoch, 10)), p.hashChain)
+		p.extendHashChain()
 	}
 	return
 }
@@ -370,34 +460,22 @@ func validateExistenceProof(proof *MembershipOrNonmembershipProof, identifier []
 	existenceProof := proof
 	prefix := GetPrefixFromIdentifier(identifier)
 	startTime := time.Now()
-	computedRoot := computeRootHashMembership(prefix, existenceProof.MembershipProof, existenceProof.LeafValues)
+	computedRoot := computeRootHashMembershipForValue(prefix, existenceProof.MembershipProof, existenceProof.LeafValue)
 	elapsedTime := time.Since(startTime)
 	_ = elapsedTime
 	// fmt.Printf("time to compute root hash membership: %d ns\n", elapsedTime.Nanoseconds())
 	// fmt.Printf("length of copath nodes: %d\n", len(proof.MembershipProof.CopathNodes))
-	// fmt.Printf("length of leaf values : %d\n", len(proof.LeafValues))
 	// TODO: check that h(id, val) is is the same as what is in the actual tree; see
 	// idk wtf to do with signature
 
-	var foundMatch = false
 	// TODO: fix position eventually
 	expectedLeafNodeHash := ConvertBitsToBytes(ComputeLeafNodeHash(identifier, value, signature, 0))
-	for _, leafNodeHash := range existenceProof.LeafValues {
-		if bytes.Equal(leafNodeHash.Hash, expectedLeafNodeHash) {
-			foundMatch = true
-		}
-	}
-
-	if !foundMatch {
+	if existenceProof.LeafValue == nil || !bytes.Equal(existenceProof.LeafValue.Value.Hash, expectedLeafNodeHash) {
 		return false, errors.New("unable to find leaf node hash in provided merkle tree")
 	}
 
-	signatureValue := append(value, []byte(strconv.Itoa(int(pos)))...)
-	if isMK {
-		signatureValue = value
-	}
-	if !crypto.VerifyBlob(masterVK, signature, signatureValue) {
-		return false, errors.New("unable to verify signature on value")
+	if err := verifyValueSignature(value, signature, pos, masterVK, isMK); err != nil {
+		return false, err
 	}
 
 	if !bytes.Equal(computedRoot, reportedRoot) {
@@ -407,6 +485,19 @@ func validateExistenceProof(proof *MembershipOrNonmembershipProof, identifier []
 	return true, nil
 }
 
+// verifyValueSignature checks the owner's signature on a value appended at
+// pos; master keys are signed without their position.
+func verifyValueSignature(value []byte, signature []byte, pos uint64, masterVK []byte, isMK bool) error {
+	signatureValue := append(value, []byte(strconv.Itoa(int(pos)))...)
+	if isMK {
+		signatureValue = value
+	}
+	if !crypto.VerifyBlob(masterVK, signature, signatureValue) {
+		return errors.New("unable to verify signature on value")
+	}
+	return nil
+}
+
 type ProofVerifier interface {
 	ValidatePKProof(oldDigest *LegologDigest, proof *LegologExistenceProof, identifier []byte, value []byte, signature []byte, pos uint64, masterVK []byte) (bool, error)
 	ValidateMKProof(oldDigest *LegologDigest, proof *LegologExistenceProof, username []byte, value []byte, signature []byte, pos uint64, masterVK []byte) (bool, error)
@@ -426,26 +517,29 @@ func validateNonExistenceProof(proof *MembershipOrNonmembershipProof, identifier
 		return false, errors.New("expected nonexistence proof, but got existence")
 	}
 
+	if !nonexistenceProof.MembershipProof.LeafPartialPrefix.wellFormed() || !copathWellFormed(nonexistenceProof.MembershipProof.CopathNodes) {
+		return false, errors.New("malformed prefix in nonexistence proof")
+	}
 	fullPrefix := GetPrefixFromIdentifier(identifier)
-	frontierPrefix := append(getPrefix(nonexistenceProof.MembershipProof.CopathNodes), nonexistenceProof.MembershipProof.LeafPartialPrefix...)
-	if !bytes.HasPrefix(fullPrefix, frontierPrefix) {
+	frontierPrefix := getPrefix(nonexistenceProof.MembershipProof.CopathNodes).Append(nonexistenceProof.MembershipProof.LeafPartialPrefix)
+	if !fullPrefix.HasPrefix(frontierPrefix) {
 		return false, errors.New("prefix of nonexistence proof is not a prefix of the identifier")
 	}
 
-	if len(nonexistenceProof.LeafValues) != 1 {
+	if nonexistenceProof.LeafValue == nil || len(nonexistenceProof.LeafValue.PrecedingDigest) != 0 {
 		return false, errors.New("expected leaf node in non existence proof to only have one value")
 	}
 
-	leaf := nonexistenceProof.LeafValues[0]
+	leaf := nonexistenceProof.LeafValue.Value
 	if leaf.Pos != 0 {
 		return false, fmt.Errorf("expected frontier node position to be 0, but got %d", leaf.Pos)
 	}
 
-	if !bytes.Equal(leaf.Hash, frontierPrefix) {
-		return false, fmt.Errorf("Expected value stored in frontier node to be equal to prefix. Instead, got prefix=%b and value=%b", frontierPrefix, leaf.Hash)
+	if !bytes.Equal(leaf.Hash, frontierPrefix.Unpack()) {
+		return false, fmt.Errorf("Expected value stored in frontier node to be equal to prefix. Instead, got prefix=%s and value=%b", frontierPrefix, leaf.Hash)
 	}
 
-	computedRoot := computeRootHashMembership(frontierPrefix, nonexistenceProof.MembershipProof, nonexistenceProof.LeafValues)
+	computedRoot := computeRootHashMembershipForValue(frontierPrefix, nonexistenceProof.MembershipProof, nonexistenceProof.LeafValue)
 
 	if !bytes.Equal(computedRoot, reportedRoot) {
 		return false, errors.New(fmt.Sprintf("non-existence: computed root doesn't match reported root. Expected %s, computed %s", reportedRoot, computedRoot))
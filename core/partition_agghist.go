@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"strconv"
 	"time"
 )
 
@@ -14,10 +13,9 @@ type AggHistPartition struct {
 	baseTreeForest *HistoryForest
 	baseTree       *persistentPrefixTree
 
-	queryUpdateLog         *ChronTree
+	updateLog              updateLog
 	queryUpdatePrefixTrees []*prefixTree
 
-	verifyUpdateLog         *ChronTree
 	verifyUpdatePrefixTrees []*prefixTree
 
 	currUpdatePeriodUpdates [][][]byte
@@ -27,6 +25,11 @@ type AggHistPartition struct {
 	epoch              uint32
 	verificationPeriod uint64
 
+	chain   hashChain
+	binding PartitionBinding
+
+	history updateHistory
+
 	tmpdir string
 }
 
@@ -37,8 +40,7 @@ func NewAggHistPartition(cfg Config, tmpdir string) *AggHistPartition {
 		cfg:                     cfg,
 		baseTree:                NewPersistentPrefixTree(),
 		baseTreeForest:          NewHistoryForest(cfg.AggHistoryDepth),
-		queryUpdateLog:          NewChronTree(),
-		verifyUpdateLog:         NewChronTree(),
+		updateLog:               newUpdateLog(),
 		epoch:                   0,
 		currUpdatePeriodUpdates: [][][]byte{{}, {}},
 		currVerifyPeriodUpdates: [][][]byte{{}, {}},
@@ -51,53 +53,103 @@ func (p *AggHistPartition) Append(username []byte, identifier []byte, value []by
 	//fmt.Printf("partition_agghist.go: Append\n")
 	id_hash := GetPrefixFromIdentifier(identifier)
 	hashBytes := ConvertBitsToBytes(ComputeLeafNodeHash(identifier, value, signature, 0)) // todo: fix this position
-	p.currUpdatePeriodUpdates[0] = append(p.currUpdatePeriodUpdates[0], id_hash)
+	p.currUpdatePeriodUpdates[0] = append(p.currUpdatePeriodUpdates[0], id_hash.Packed)
 	p.currUpdatePeriodUpdates[1] = append(p.currUpdatePeriodUpdates[1], hashBytes)
-	p.currVerifyPeriodUpdates[0] = append(p.currVerifyPeriodUpdates[0], id_hash)
+	p.currVerifyPeriodUpdates[0] = append(p.currVerifyPeriodUpdates[0], id_hash.Packed)
 	p.currVerifyPeriodUpdates[1] = append(p.currVerifyPeriodUpdates[1], hashBytes)
 	p.baseTree.Insert(id_hash, hashBytes, 0)
+	p.history.append(id_hash.Packed, hashBytes, 0, p.verificationPeriod)
 	//fmt.Printf("Leaving partition_agghist.go: Append\n")
 }
 
+// BulkAppend appends a batch of identifier-value pairs, as if Append had
+// been called for each of them in order, but inserts them into the base tree
+// in a single pass.
+func (p *AggHistPartition) BulkAppend(identifiers [][]byte, values [][]byte, signatures [][]byte) error {
+	if len(identifiers) != len(values) || len(identifiers) != len(signatures) {
+		return errors.New("identifiers, values and signatures are of unequal length")
+	}
+	idHashes := make([]BitString, len(identifiers))
+	valueHashes := make([][]byte, len(identifiers))
+	positions := make([]uint32, len(identifiers))
+	for i := range identifiers {
+		idHashes[i] = GetPrefixFromIdentifier(identifiers[i])
+		valueHashes[i] = ConvertBitsToBytes(ComputeLeafNodeHash(identifiers[i], values[i], signatures[i], 0)) // todo: fix this position
+	}
+
+	err := p.baseTree.BulkInsert(SortUpdates(idHashes, valueHashes, positions))
+	if err != nil {
+		return err
+	}
+	for i, idHash := range idHashes {
+		p.currUpdatePeriodUpdates[0] = append(p.currUpdatePeriodUpdates[0], idHash.Packed)
+		p.currVerifyPeriodUpdates[0] = append(p.currVerifyPeriodUpdates[0], idHash.Packed)
+		p.history.append(idHash.Packed, valueHashes[i], positions[i], p.verificationPeriod)
+	}
+	p.currUpdatePeriodUpdates[1] = append(p.currUpdatePeriodUpdates[1], valueHashes...)
+	p.currVerifyPeriodUpdates[1] = append(p.currVerifyPeriodUpdates[1], valueHashes...)
+	return nil
+}
+
 func (p *AggHistPartition) IncrementUpdateEpoch() {
 	// add Hash(id), Hash(id, val, pos) []
 	// two arrays
-	prefixTree := NewPrefixTree()
-	for i, id_hash := range p.currUpdatePeriodUpdates[0] {
-		prefixTree.PrefixAppend(id_hash, p.currUpdatePeriodUpdates[1][i], 0)
+	prefixTree, err := NewPrefixTreeFromUpdates(p.currUpdatePeriodUpdates[0], p.currUpdatePeriodUpdates[1], true)
+	if err != nil {
+		panic(err)
 	}
 	p.queryUpdatePrefixTrees = append(p.queryUpdatePrefixTrees, prefixTree)
 	p.verifyUpdatePrefixTrees = append(p.verifyUpdatePrefixTrees, prefixTree)
 	for _, id_hash := range p.currUpdatePeriodUpdates[0] {
-		hasKey := prefixTree.getLeaf(id_hash) != nil
+		hasKey := prefixTree.getLeaf(NewBitString(id_hash)) != nil
 		if !hasKey {
 			fmt.Println("[should not happen] server doesn't have key for hash ", id_hash)
 		}
 	}
 	p.currUpdatePeriodUpdates = [][][]byte{{}, {}}
 	p.epoch += 1
-	p.queryUpdateLog.Append([]byte(strconv.Itoa(int(p.epoch))), prefixTree.getHash(), []byte(""))
-	p.verifyUpdateLog.Append([]byte(strconv.Itoa(int(p.epoch))), prefixTree.getHash(), []byte(""))
+	p.history.endEpoch(uint64(p.epoch), p.verificationPeriod)
+	p.updateLog.append(uint64(p.epoch), prefixTree.getHash())
 
 	// fmt.Println("partition_agghist.go: IncrementUpdateEpoch")
 	// fmt.Println(p.baseTreeForest.Roots, p.queryUpdateSetTrees, p.verifyUpdateSetTrees)
 }
 
+// KeepUpdateHistory makes the partition keep the updates rolled up in every
+// epoch from now on, for auditors that replay them.
+func (p *AggHistPartition) KeepUpdateHistory() {
+	p.history.keep = true
+}
+
+// GetEpochUpdates returns the updates rolled up in the given epoch.
+func (p *AggHistPartition) GetEpochUpdates(epoch uint64) (*EpochUpdates, error) {
+	return p.history.get(epoch)
+}
+
+// PruneEpochUpdates drops the updates of every epoch up to and including
+// through.
+func (p *AggHistPartition) PruneEpochUpdates(through uint64) {
+	p.history.prune(through)
+}
+
 func (p *AggHistPartition) IncrementVerificationPeriod() error {
+	p.updateLog.endPeriod(p.verificationPeriod)
 	p.verificationPeriod += 1
 	p.baseTree.NextEpoch()
 
 	// Stick the root prefix hash in forest
 	if p.verificationPeriod >= 2 {
-		p.baseTreeForest.Append(p.baseTree.getHash(p.verificationPeriod-2), p.verificationPeriod-2)
+		err := p.baseTreeForest.Append(p.baseTree.getHash(p.verificationPeriod-2), p.verificationPeriod-2)
+		if err != nil {
+			return err
+		}
+		p.extendHashChain()
 	}
 
-	// Set the next query update log to the old verify update log
+	// Set the next query update prefix trees to the old verify ones
 	p.currVerifyPeriodUpdates = [][][]byte{{}, {}}
-	p.queryUpdateLog = p.verifyUpdateLog
 	p.queryUpdatePrefixTrees = p.verifyUpdatePrefixTrees
-	// Clear the verify update log
-	p.verifyUpdateLog = NewChronTree()
+	// Clear the verify update prefix trees
 	p.verifyUpdatePrefixTrees = []*prefixTree{}
 	// fmt.Println("partition_agghist.go: IncrementVerificationPeriod")
 	// fmt.Println(p.baseTreeForest.Roots, p.queryUpdateSetTrees, p.verifyUpdateSetTrees)
@@ -124,26 +176,7 @@ func (p *AggHistPartition) GenerateExistenceProof(identifier []byte, value []byt
 
 	for _, histNode := range p.baseTreeForest.Roots {
 		startTime := time.Now()
-		baseTreeProof := &MembershipOrNonmembershipProof{
-			MembershipProof:    nil,
-			NonMembershipProof: nil,
-			ValueExists:        false,
-			LeafValues:         []KeyHash{},
-		}
-		leaf := p.baseTree.getLeaf(id_hash, histNode.getVerificationPeriod())
-
-		if leaf != nil {
-			btProof, _ := p.baseTree.generateMembershipProof(id_hash, histNode.getVerificationPeriod())
-			baseTreeProof.ValueExists = true
-			baseTreeProof.MembershipProof = btProof
-			baseTreeProof.LeafValues = leaf.getValues()
-		} else {
-			btProof := p.baseTree.generateNonMembershipProof(id_hash, histNode.getVerificationPeriod()) // TODO: verify
-			baseTreeProof.ValueExists = false
-			baseTreeProof.NonMembershipProof = btProof
-			//fmt.Println("base tree proof", *btProof)
-		}
-		proof.BaseTreeProofs = append(proof.BaseTreeProofs, baseTreeProof)
+		proof.BaseTreeProofs = append(proof.BaseTreeProofs, p.generateBaseTreeProof(id_hash, histNode.getVerificationPeriod()))
 		timeTaken := time.Since(startTime)
 		_ = timeTaken
 		// fmt.Printf("Time taken to generate base tree proof %d: %d ns\n", i, timeTaken.Nanoseconds())
@@ -162,7 +195,7 @@ func (p *AggHistPartition) GenerateExistenceProof(identifier []byte, value []byt
 				leafProof, _ := setTree.ProveExistence(id_hash)
 				updateLogProof.MembershipProof = leafProof
 				updateLogProof.ValueExists = true
-				updateLogProof.LeafValues = leaf.getValues()
+				updateLogProof.LeafValue = proveLeafValue(leaf.getValues())
 			} else {
 				leafProof, _ := setTree.ProveNonExistence(id_hash)
 				updateLogProof.MembershipProof = leafProof
@@ -184,7 +217,7 @@ func (p *AggHistPartition) GenerateExistenceProof(identifier []byte, value []byt
 			leafProof, leafValues := prefixTree.generateMembershipProof(id_hash) // prefixTree.ProveExistence(id_hash)
 			updateLogProof.MembershipProof = leafProof
 			updateLogProof.ValueExists = true
-			updateLogProof.LeafValues = leafValues
+			updateLogProof.LeafValue = proveLeafValue(leafValues)
 		} else {
 			leafProof := prefixTree.generateNonMembershipProof(id_hash)
 
@@ -200,6 +233,42 @@ func (p *AggHistPartition) GenerateExistenceProof(identifier []byte, value []byt
 	return &proof
 }
 
+// generateBaseTreeProof proves membership or non-membership of id_hash in the
+// base tree as of a verification period.
+func (p *AggHistPartition) generateBaseTreeProof(id_hash BitString, verificationPeriod uint64) *MembershipOrNonmembershipProof {
+	baseTreeProof := &MembershipOrNonmembershipProof{
+		MembershipProof:    nil,
+		NonMembershipProof: nil,
+		ValueExists:        false,
+		LeafValue:          nil,
+	}
+	leaf := p.baseTree.getLeaf(id_hash, verificationPeriod)
+
+	if leaf != nil {
+		btProof, _ := p.baseTree.generateMembershipProof(id_hash, verificationPeriod)
+		baseTreeProof.ValueExists = true
+		baseTreeProof.MembershipProof = btProof
+		baseTreeProof.LeafValue = proveLeafValue(leaf.getValues())
+	} else {
+		btProof := p.baseTree.generateNonMembershipProof(id_hash, verificationPeriod) // TODO: verify
+		baseTreeProof.ValueExists = false
+		baseTreeProof.NonMembershipProof = btProof
+		//fmt.Println("base tree proof", *btProof)
+	}
+	return baseTreeProof
+}
+
+// GenerateBaseTreeInclusionProof returns the base tree root of a verification
+// period and proves that it is committed to by the digest's HistoryForest
+// roots, so that a single base tree can be checked without the others.
+func (p *AggHistPartition) GenerateBaseTreeInclusionProof(verificationPeriod uint64) ([]byte, *MerkleExistenceProof, error) {
+	if verificationPeriod >= uint64(p.baseTreeForest.Size) {
+		return nil, nil, fmt.Errorf("verification period %d is not in the history forest", verificationPeriod)
+	}
+	pos := uint32(verificationPeriod)
+	return p.baseTreeForest.getLeafNode(pos).getHash(), p.baseTreeForest.GenerateExistenceProof(pos, p.baseTreeForest.Size), nil
+}
+
 func (p *AggHistPartition) GetDigest() *LegologDigest {
 	/*
 		updateSetRoots := make([][]byte, 0)
@@ -221,19 +290,75 @@ func (p *AggHistPartition) GetDigest() *LegologDigest {
 	}
 	return &LegologDigest{
 		BaseTreeRoots:  baseTreeRoots,
-		UpdateLogRoot:  p.verifyUpdateLog.GetRootHash(),
+		UpdateLogRoot:  p.updateLog.root(),
 		UpdateSetRoots: updatePrefixTreeRoots,
+		UpdateLogSize:  p.updateLog.size(),
+		Epoch:          uint64(p.epoch),
+		HashChain:      p.chain.head(),
+		Binding:        p.binding,
+
+		VerificationPeriod: p.verificationPeriod,
+		HistoryForestRoots: p.baseTreeForest.GetDigest().Roots,
+		HistoryForestSize:  p.baseTreeForest.Size,
 	}
 }
 
+// Bind restarts the hash chain so that every link commits to binding.
+func (p *AggHistPartition) Bind(binding PartitionBinding) {
+	p.binding = binding
+	p.chain.reset()
+	if p.verificationPeriod >= 2 {
+		p.extendHashChain()
+	}
+}
+
+func (p *AggHistPartition) extendHashChain() {
+	p.chain.extend(&p.binding, HashChainLink{
+		VerificationPeriod: p.verificationPeriod,
+		BaseTreeRoots:      [][]byte{p.baseTree.getHash(p.verificationPeriod - 2)},
+		Epoch:              uint64(p.epoch),
+	})
+}
+
+// GenerateHashChainProof returns the links of the hash chain between two of
+// its heads.
+func (p *AggHistPartition) GenerateHashChainProof(oldHashChain []byte, newHashChain []byte) (*HashChainProof, error) {
+	return p.chain.generateProof(oldHashChain, newHashChain)
+}
+
 func (p *AggHistPartition) GetUpdateEpochConsistencyProof(oldSize uint32) *MerkleExtensionProof {
-	return nil
+	return p.updateLog.consistencyProof(oldSize, p.updateLog.size())
+}
+
+// GenerateUpdateLogChainProof proves that the update log with newSize epochs
+// in verification period newPeriod extends the one with oldSize epochs in
+// oldPeriod, through its checkpoint at the end of each period in between.
+func (p *AggHistPartition) GenerateUpdateLogChainProof(oldPeriod uint64, oldSize uint32, newPeriod uint64, newSize uint32) (*UpdateLogChainProof, error) {
+	return p.updateLog.generateChainProof(oldPeriod, oldSize, newPeriod, newSize)
 }
 
+// AggHistVerifier checks proofs against the digest of the partition the
+// identifier belongs to. If NumPartitions is set, digests must also be bound
+// to a log with LogID and that many partitions.
 type AggHistVerifier struct {
+	LogID         []byte
+	NumPartitions uint64
 }
 
-func (AggHistVerifier) ValidatePKProof(oldDigest *LegologDigest, proof *LegologExistenceProof, identifier []byte, value []byte, signature []byte, pos uint64, masterVK []byte) (bool, error) {
+func (v AggHistVerifier) checkBinding(digest *LegologDigest, identifier []byte) error {
+	if err := digest.Binding.CheckIdentifier(identifier); err != nil {
+		return err
+	}
+	if v.NumPartitions == 0 {
+		return nil
+	}
+	return digest.Binding.CheckLog(v.LogID, digest.Binding.PartitionIndex, v.NumPartitions)
+}
+
+func (v AggHistVerifier) ValidatePKProof(oldDigest *LegologDigest, proof *LegologExistenceProof, identifier []byte, value []byte, signature []byte, pos uint64, masterVK []byte) (bool, error) {
+	if err := v.checkBinding(oldDigest, identifier); err != nil {
+		return false, err
+	}
 	var latestExistenceProof *MembershipOrNonmembershipProof = nil
 	var latestTreeRoot []byte
 	idx := 0
@@ -292,7 +417,10 @@ func (AggHistVerifier) ValidatePKProof(oldDigest *LegologDigest, proof *LegologE
 	return true, nil
 }
 
-func (AggHistVerifier) ValidatePKProofMonitoring(oldDigest *LegologDigest, proof *LegologExistenceProof, identifier []byte, value []byte, signature []byte, pos uint64, masterVK []byte) (bool, error) {
+func (v AggHistVerifier) ValidatePKProofMonitoring(oldDigest *LegologDigest, proof *LegologExistenceProof, identifier []byte, value []byte, signature []byte, pos uint64, masterVK []byte) (bool, error) {
+	if err := v.checkBinding(oldDigest, identifier); err != nil {
+		return false, err
+	}
 	var latestExistenceProof *MembershipOrNonmembershipProof = nil
 	var latestTreeRoot []byte
 	idx := 0
@@ -371,8 +499,11 @@ func validateNonMembershipProof(proof *NonMembershipProof, id []byte, rootHash [
 }
 
 // Prove that this is the first value in the tree
-func (AggHistVerifier) ValidateMKProof(oldDigest *LegologDigest, proof *LegologExistenceProof, username []byte, value []byte, signature []byte, pos uint64, masterVK []byte) (bool, error) {
+func (v AggHistVerifier) ValidateMKProof(oldDigest *LegologDigest, proof *LegologExistenceProof, username []byte, value []byte, signature []byte, pos uint64, masterVK []byte) (bool, error) {
 	identifier := append(username, []byte("MK")...)
+	if err := v.checkBinding(oldDigest, identifier); err != nil {
+		return false, err
+	}
 	for i, baseTreeProof := range proof.BaseTreeProofs {
 		if baseTreeProof.ValueExists {
 			return false, errors.New("MK should not exist in the tree yet")
@@ -410,3 +541,19 @@ func (AggHistVerifier) ValidateMKProof(oldDigest *LegologDigest, proof *LegologE
 	}
 	return true, nil
 }
+
+// ValidateBaseTreeInclusionProof checks that baseTreeRoot is the base tree of
+// verificationPeriod under the digest's HistoryForest roots.
+func (v AggHistVerifier) ValidateBaseTreeInclusionProof(digest *LegologDigest, verificationPeriod uint64, baseTreeRoot []byte, proof *MerkleExistenceProof) error {
+	if verificationPeriod >= uint64(digest.HistoryForestSize) {
+		return fmt.Errorf("verification period %d is not in the history forest", verificationPeriod)
+	}
+	forestDigest := &Digest{
+		Roots: digest.HistoryForestRoots,
+		Size:  digest.HistoryForestSize,
+	}
+	if !VerifyHistoryExistenceProof(forestDigest, baseTreeRoot, uint32(verificationPeriod), proof) {
+		return fmt.Errorf("base tree of verification period %d is not in the history forest", verificationPeriod)
+	}
+	return nil
+}
@@ -2,7 +2,10 @@ package core
 
 import (
 	// "fmt"
+	"bytes"
+	"encoding/hex"
 	"fmt"
+	"strconv"
 	"testing"
 	"time"
 
@@ -16,6 +19,9 @@ var testCfg Config = Config{
 	AggHistoryDepth: 31,
 }
 
+// the binding of the only partition of a log, which every identifier maps to
+var testBinding = PartitionBinding{PartitionIndex: 0, NumPartitions: 1}
+
 func TestPartitionAggHistAppend(t *testing.T) {
 	partition := NewAggHistPartition(testCfg, "")
 	for i := 0; i < 32; i++ {
@@ -34,6 +40,7 @@ func TestPartitionIncrementUpdate(t *testing.T) {
 
 func TestPartitionValidateProof(t *testing.T) {
 	partition := NewAggHistPartition(testCfg, "")
+	partition.Bind(testBinding)
 	masterSK, masterVK := crypto.GenerateKeypair()
 
 	for i := 0; i < 32; i++ {
@@ -78,6 +85,7 @@ func TestPartitionNonAggValidateProof(t *testing.T) {
 
 	// partition := NewAggHistPartition(testCfg)
 	partition := NewPartition()
+	partition.Bind(testBinding)
 	masterSK, masterVK := crypto.GenerateKeypair()
 
 	for i := 0; i < 32; i++ {
@@ -113,8 +121,57 @@ func TestPartitionNonAggValidateProof(t *testing.T) {
 	}
 }
 
+// TestPartitionRootsAreStable pins the roots a partition publishes for a fixed
+// sequence of appends, so that a change to how prefixes or values are encoded
+// cannot silently change every root a client or auditor has already seen.
+func TestPartitionRootsAreStable(t *testing.T) {
+	partition := NewPartition()
+	for i := 0; i < 24; i++ {
+		if i%8 == 7 {
+			partition.IncrementUpdateEpoch()
+		}
+		if i == 16 {
+			partition.IncrementVerificationPeriod()
+		}
+		partition.Append(nil, []byte(fmt.Sprintf("identifier %d", i%20)), []byte(fmt.Sprintf("value %d", i)), []byte("signature"))
+	}
+	partition.IncrementUpdateEpoch()
+	partition.IncrementVerificationPeriod()
+	digest := partition.GetDigest()
+
+	roots := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"first base tree root", digest.BaseTreeRoots[0], "79f1e6559892eba523291e07aca842b3ce417ea6d8a426ae5bd75d5b501c5a30"},
+		{"second base tree root", digest.BaseTreeRoots[1], "dab26d581f0135c7933d0948d5219079a317b7ed4efb13326cefcb8a47d959fa"},
+		{"update log root", digest.UpdateLogRoot, "0f3438d15487dc5359e85de8e942a58aacb3f199c874e4d1aed5ade825efb194"},
+	}
+	wantUpdateSetRoots := []string{
+		"40b092a1e874813a661cc86d5cfd2bfcd5bb5b28cb64ede7bf266b6672293073",
+		"0a44817a0cf94a125b70e08a81c8bb56fd61d7f60e3476339977ed23e57e6eb2",
+	}
+	if len(digest.UpdateSetRoots) != len(wantUpdateSetRoots) {
+		t.Fatalf("expected %d update set roots, got %d", len(wantUpdateSetRoots), len(digest.UpdateSetRoots))
+	}
+	for i, want := range wantUpdateSetRoots {
+		roots = append(roots, struct {
+			name string
+			got  []byte
+			want string
+		}{fmt.Sprintf("update set root %d", i), digest.UpdateSetRoots[i], want})
+	}
+	for _, root := range roots {
+		if got := hex.EncodeToString(root.got); got != root.want {
+			t.Errorf("%s changed: expected %s, got %s", root.name, root.want, got)
+		}
+	}
+}
+
 func TestInsanity(t *testing.T) {
 	partition := NewPartition()
+	partition.Bind(testBinding)
 	_, masterVK := crypto.GenerateKeypair()
 	identifier := []byte("\x1c\"\xeeO0\x9b\x9ci\xe6\xb9\x17Q\x96\x88\"١\x1aU\xd4<@\xb3`3\xa7>k¥\xd3\xd5")
 	username := identifier
@@ -139,6 +196,7 @@ func TestInsanity(t *testing.T) {
 
 func TestBroken(t *testing.T) {
 	partition := NewPartition()
+	partition.Bind(testBinding)
 
 	for i := 0; i < 64; i++ {
 		id := make([]byte, 32)
@@ -203,3 +261,35 @@ func TestPreloading(t *testing.T) {
 	end := time.Now()
 	fmt.Printf("Preloading %d appends to 1 partition took %f seconds", numPreloadAppends, end.Sub(start).Seconds())
 }
+
+func TestBaseTreeInclusionProof(t *testing.T) {
+	partition := NewAggHistPartition(testCfg, "")
+	partition.Bind(testBinding)
+	for period := 0; period < 8; period++ {
+		key := []byte(strconv.Itoa(period))
+		partition.Append(key, key, key, key)
+		partition.IncrementUpdateEpoch()
+		partition.IncrementVerificationPeriod()
+	}
+	digest := partition.GetDigest()
+
+	var v AggHistVerifier
+	var prevRoot []byte
+	for period := uint64(0); period < uint64(digest.HistoryForestSize); period++ {
+		root, proof, err := partition.GenerateBaseTreeInclusionProof(period)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.ValidateBaseTreeInclusionProof(digest, period, root, proof); err != nil {
+			t.Errorf("period %d: %v", period, err)
+		}
+		// the proof binds the root to its verification period
+		if prevRoot != nil && !bytes.Equal(root, prevRoot) && v.ValidateBaseTreeInclusionProof(digest, period-1, root, proof) == nil {
+			t.Errorf("period %d: proof verified for the previous period", period)
+		}
+		prevRoot = root
+	}
+	if _, _, err := partition.GenerateBaseTreeInclusionProof(uint64(digest.HistoryForestSize)); err == nil {
+		t.Error("expected an error for a period not yet in the history forest")
+	}
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	libcrypto "github.com/huyuncong/MerkleSquare/lib/crypto"
+)
+
+// PartitionBinding ties a partition's digests to its place in a log, so that
+// a digest of one partition cannot be passed off as a digest of another
+// partition or of another log.
+type PartitionBinding struct {
+	LogID          []byte
+	PartitionIndex uint64
+	NumPartitions  uint64
+}
+
+// PartitionIndexForIdentifier returns the partition an identifier is stored
+// in when the log is split into numPartitions partitions.
+func PartitionIndexForIdentifier(identifier []byte, numPartitions uint64) uint64 {
+	return binary.BigEndian.Uint64(libcrypto.Hash(identifier)) % numPartitions
+}
+
+// Hash commits to every field of the binding.
+func (b *PartitionBinding) Hash() []byte {
+	return libcrypto.Hash(
+		libcrypto.Hash(b.LogID),
+		uint64ToBytes(b.PartitionIndex),
+		uint64ToBytes(b.NumPartitions),
+	)
+}
+
+// CheckIdentifier returns an error unless identifier belongs to the bound
+// partition.
+func (b *PartitionBinding) CheckIdentifier(identifier []byte) error {
+	if b.NumPartitions == 0 {
+		return errors.New("digest is not bound to a partition")
+	}
+	if expected := PartitionIndexForIdentifier(identifier, b.NumPartitions); b.PartitionIndex != expected {
+		return fmt.Errorf("digest is bound to partition %d, but the identifier belongs to partition %d", b.PartitionIndex, expected)
+	}
+	return nil
+}
+
+// CheckLog returns an error unless the binding is for partition index of a
+// log with the given identifier and number of partitions.
+func (b *PartitionBinding) CheckLog(logID []byte, index uint64, numPartitions uint64) error {
+	if !bytes.Equal(b.LogID, logID) {
+		return fmt.Errorf("digest is bound to log %q, expected %q", b.LogID, logID)
+	}
+	if b.NumPartitions != numPartitions || b.PartitionIndex != index {
+		return fmt.Errorf("digest is bound to partition %d of %d, expected %d of %d", b.PartitionIndex, b.NumPartitions, index, numPartitions)
+	}
+	return nil
+}
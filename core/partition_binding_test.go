@@ -0,0 +1,74 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/immesys/bw2/crypto"
+)
+
+func TestPartitionBindingIsCommitted(t *testing.T) {
+	first := NewPartition()
+	second := NewPartition()
+	first.Bind(PartitionBinding{LogID: []byte("log"), PartitionIndex: 0, NumPartitions: 2})
+	second.Bind(PartitionBinding{LogID: []byte("log"), PartitionIndex: 1, NumPartitions: 2})
+
+	if bytes.Equal(first.GetDigest().HashChain, second.GetDigest().HashChain) {
+		t.Error("hash chains of empty partitions with different bindings are equal")
+	}
+	if bytes.Equal(first.GetDigest().Hash(), second.GetDigest().Hash()) {
+		t.Error("digests of empty partitions with different bindings are equal")
+	}
+
+	relabeled := *first.GetDigest()
+	relabeled.Binding = second.GetDigest().Binding
+	if bytes.Equal(relabeled.Hash(), first.GetDigest().Hash()) {
+		t.Error("changing the binding did not change the digest hash")
+	}
+}
+
+func TestAggHistVerifierRejectsSubstitutedDigest(t *testing.T) {
+	const numPartitions = 4
+	logID := []byte("log")
+	identifier := []byte("alice")
+	index := PartitionIndexForIdentifier(identifier, numPartitions)
+
+	partitions := make([]*Partition, numPartitions)
+	for i := range partitions {
+		partitions[i] = NewPartition()
+		partitions[i].Bind(PartitionBinding{LogID: logID, PartitionIndex: uint64(i), NumPartitions: numPartitions})
+	}
+	// the same entry appended to every partition gives every partition a
+	// valid proof for it, so only the binding tells them apart
+	masterSK, masterVK := crypto.GenerateKeypair()
+	value := []byte("value")
+	signature := make([]byte, 64)
+	crypto.SignBlob(masterSK, masterVK, signature, append(value, []byte("0")...))
+	for _, partition := range partitions {
+		partition.Append(identifier, identifier, value, signature)
+		partition.IncrementUpdateEpoch()
+	}
+
+	v := AggHistVerifier{LogID: logID, NumPartitions: numPartitions}
+	for i, partition := range partitions {
+		proof := partition.GenerateExistenceProof(identifier, value, signature)
+		ok, err := v.ValidatePKProof(partition.GetDigest(), proof, identifier, value, signature, 0, masterVK)
+		if uint64(i) == index && (!ok || err != nil) {
+			t.Errorf("proof from the identifier's own partition failed: %v", err)
+		}
+		if uint64(i) != index && ok {
+			t.Errorf("proof from partition %d verified for an identifier of partition %d", i, index)
+		}
+	}
+
+	own := partitions[index]
+	proof := own.GenerateExistenceProof(identifier, value, signature)
+	other := AggHistVerifier{LogID: []byte("another log"), NumPartitions: numPartitions}
+	if ok, _ := other.ValidatePKProof(own.GetDigest(), proof, identifier, value, signature, 0, masterVK); ok {
+		t.Error("digest of another log verified")
+	}
+	unbound := NewPartition()
+	if err := unbound.GetDigest().Binding.CheckIdentifier(identifier); err == nil {
+		t.Error("unbound digest passed the identifier check")
+	}
+}
@@ -12,7 +12,7 @@ type metadata struct {
 	hash       []byte
 	values     []KeyHash
 	epoch      uint64
-	prefix     []byte
+	prefix     BitString
 	prev       *metadata
 	next       *metadata
 	leftChild  *metadata
@@ -38,7 +38,7 @@ func (m *metadata) makeNextMetadata(epoch uint64) *metadata {
 		if epoch > m.parent.epoch {
 			metadataToEdit = m.parent.makeNextMetadata(epoch)
 		}
-		if m.prefix[0] == 0 {
+		if m.prefix.Bit(0) == 0 {
 			metadataToEdit.leftChild = ret
 		} else {
 			metadataToEdit.rightChild = ret
@@ -97,14 +97,14 @@ func (p *persistentPrefixTree) InsertAndPrefixify(key []byte, valHash []byte, po
 }
 
 // TODO: compressed prefix matching
-func (p *persistentPrefixTree) Insert(prefix []byte, valHash []byte, pos uint32) {
+func (p *persistentPrefixTree) Insert(prefix BitString, valHash []byte, pos uint32) {
 	var prev *metadata
 	var curr = p.currRoot
 
 	i := 0
-	for i < len(prefix) {
+	for i < prefix.Len() {
 		prev = curr
-		currBit := prefix[i]
+		currBit := prefix.Bit(i)
 		if currBit == 0 {
 			curr = curr.leftChild
 		} else {
@@ -116,7 +116,7 @@ func (p *persistentPrefixTree) Insert(prefix []byte, valHash []byte, pos uint32)
 				hash:       nil,
 				values:     []KeyHash{{valHash, pos}},
 				epoch:      p.currEpoch,
-				prefix:     prefix[i:],
+				prefix:     prefix.SliceFrom(i),
 				prev:       nil,
 				next:       nil,
 				leftChild:  nil,
@@ -133,42 +133,37 @@ func (p *persistentPrefixTree) Insert(prefix []byte, valHash []byte, pos uint32)
 			return
 		}
 
-		j := uint32(0)
-		for j < uint32(len(curr.prefix)) {
-			if prefix[i] == curr.prefix[j] {
-				i++
-				j++
+		j := prefix.commonPrefixLengthFrom(i, curr.prefix)
+		i += j
+		if j < curr.prefix.Len() {
+			newParent := p.splitNode(curr, uint32(j))
+			md := &metadata{
+				hash:       nil,
+				values:     []KeyHash{{valHash, pos}},
+				epoch:      p.currEpoch,
+				prefix:     prefix.SliceFrom(i),
+				prev:       nil,
+				next:       nil,
+				leftChild:  nil,
+				rightChild: nil,
+				parent:     newParent,
+			}
+			if prefix.Bit(i) == 0 {
+				newParent.leftChild = md
 			} else {
-				newParent := p.splitNode(curr, j)
-				md := &metadata{
-					hash:       nil,
-					values:     []KeyHash{{valHash, pos}},
-					epoch:      p.currEpoch,
-					prefix:     prefix[i:],
-					prev:       nil,
-					next:       nil,
-					leftChild:  nil,
-					rightChild: nil,
-					parent:     newParent,
-				}
-				if prefix[i] == 0 {
-					newParent.leftChild = md
-				} else {
-					newParent.rightChild = md
-				}
-				p.updateHashesFromLeaf(md)
-				p.sizesAtEpoch[p.currEpoch] += 1
-				return
+				newParent.rightChild = md
 			}
+			p.updateHashesFromLeaf(md)
+			p.sizesAtEpoch[p.currEpoch] += 1
+			return
 		}
 	}
 	leaf := curr
 	if leaf.epoch < p.currEpoch {
-		m := leaf.makeNextMetadata(p.currEpoch)
-		m.values = append(m.values, KeyHash{valHash, pos})
-	} else {
-		leaf.values = append(leaf.values, KeyHash{valHash, pos})
+		// rehash from the new version, the old one must keep its hash
+		leaf = leaf.makeNextMetadata(p.currEpoch)
 	}
+	leaf.values = append(leaf.values, KeyHash{valHash, pos})
 	p.updateHashesFromLeaf(leaf)
 }
 
@@ -178,7 +173,7 @@ func (p *persistentPrefixTree) splitNode(node *metadata, idx uint32) *metadata {
 		hash:       nil,
 		values:     nil, // top half is never a leaf
 		epoch:      p.currEpoch,
-		prefix:     node.prefix[:idx],
+		prefix:     node.prefix.Slice(0, int(idx)),
 		prev:       nil,
 		next:       nil,
 		leftChild:  nil,
@@ -189,7 +184,7 @@ func (p *persistentPrefixTree) splitNode(node *metadata, idx uint32) *metadata {
 		hash:       nil,
 		values:     node.values, // in case bottom half was a leaf
 		epoch:      p.currEpoch,
-		prefix:     node.prefix[idx:],
+		prefix:     node.prefix.SliceFrom(int(idx)),
 		prev:       nil,
 		next:       nil,
 		leftChild:  node.leftChild,
@@ -218,7 +213,7 @@ func (p *persistentPrefixTree) splitNode(node *metadata, idx uint32) *metadata {
 	}
 
 	botHalf.updateHash(p.currEpoch)
-	if botHalf.prefix[0] == 0 {
+	if botHalf.prefix.Bit(0) == 0 {
 		topHalf.leftChild = botHalf
 	} else {
 		topHalf.rightChild = botHalf
@@ -239,7 +234,7 @@ func (p *persistentPrefixTree) splitNode(node *metadata, idx uint32) *metadata {
 		// }
 		m = parent.makeNextMetadata(p.currEpoch)
 	}
-	if node.prefix[0] == 0 {
+	if node.prefix.Bit(0) == 0 {
 		m.leftChild = topHalf
 	} else {
 		m.rightChild = topHalf
@@ -252,6 +247,7 @@ func (node *metadata) updateLeftChild(child *metadata, currEpoch uint64) {
 	if currEpoch > node.epoch {
 		m := node.makeNextMetadata(currEpoch)
 		m.leftChild = child
+		child.parent = m
 	} else {
 		node.leftChild = child
 	}
@@ -261,6 +257,7 @@ func (node *metadata) updateRightChild(child *metadata, currEpoch uint64) {
 	if currEpoch > node.epoch {
 		m := node.makeNextMetadata(currEpoch)
 		m.rightChild = child
+		child.parent = m
 	} else {
 		node.rightChild = child
 	}
@@ -293,7 +290,7 @@ func (node *metadata) updateHash(currEpoch uint64) {
 	if node.leftChild == nil && node.rightChild == nil {
 		h = leafHash(node.prefix, node.values) // update for leaf node
 	} else {
-		h = crypto.Hash(node.prefix, leftHash, rightHash) // update for internal node
+		h = crypto.Hash(node.prefix.Unpack(), leftHash, rightHash) // update for internal node
 	}
 	if bytes.Compare(h, node.hash) != 0 {
 		if node.epoch < currEpoch {
@@ -305,7 +302,7 @@ func (node *metadata) updateHash(currEpoch uint64) {
 	}
 }
 
-func (p *persistentPrefixTree) GetLeaf(prefix []byte, epoch uint64) *metadata {
+func (p *persistentPrefixTree) GetLeaf(prefix BitString, epoch uint64) *metadata {
 	ret, err := p.LookupPath(prefix, epoch)
 	if err != nil {
 		return nil
@@ -313,7 +310,7 @@ func (p *persistentPrefixTree) GetLeaf(prefix []byte, epoch uint64) *metadata {
 	return ret[len(ret)-1]
 }
 
-func (p *persistentPrefixTree) LookupPath(prefix []byte, epoch uint64) ([]*metadata, error) {
+func (p *persistentPrefixTree) LookupPath(prefix BitString, epoch uint64) ([]*metadata, error) {
 	if epoch > p.currEpoch {
 		return nil, errors.New("epoch hasn't occurred yet")
 	}
@@ -321,11 +318,11 @@ func (p *persistentPrefixTree) LookupPath(prefix []byte, epoch uint64) ([]*metad
 	var ret []*metadata = nil
 	var curr *metadata = p.getRootAtEpoch(epoch)
 	i := uint32(0)
-	for i < uint32(len(prefix)) {
+	for i < uint32(prefix.Len()) {
 		if curr.epoch > epoch {
 			panic("unreachable?")
 		}
-		if prefix[i] == 0 {
+		if prefix.Bit(int(i)) == 0 {
 			if curr.leftChild == nil {
 				return nil, fmt.Errorf("key doesn't exist in tree at epoch %d", epoch)
 			}
@@ -341,9 +338,9 @@ func (p *persistentPrefixTree) LookupPath(prefix []byte, epoch uint64) ([]*metad
 		}
 
 		partialPrefix := curr.prefix
-		if bytes.Compare(prefix[i:i+uint32(len(partialPrefix))], partialPrefix) == 0 {
+		if prefix.hasPrefixAt(int(i), partialPrefix) {
 			ret = append(ret, curr)
-			i += uint32(len(partialPrefix))
+			i += uint32(partialPrefix.Len())
 			continue
 		} else {
 			return nil, fmt.Errorf("key doesn't exist in tree at epoch %d", epoch)
@@ -361,13 +358,13 @@ func (p *persistentPrefixTree) NextEpoch() {
 
 func (p *persistentPrefixTree) StringAtEpoch(epoch uint64) string {
 	currLevel := []*metadata{p.getRootAtEpoch(epoch)}
-	currLevelParentPrefix := [][]byte{nil}
+	currLevelParentPrefix := []BitString{{}}
 	ret := fmt.Sprintf("Tree at epoch %d:\n", epoch)
 	level := 0
 	for len(currLevel) > 0 {
 		ret += fmt.Sprintf("Level %d: ", level)
 		nextLevel := []*metadata{}
-		nextLevelParentPrefix := [][]byte{}
+		nextLevelParentPrefix := []BitString{}
 		for i, node := range currLevel {
 			m := node
 			if m.parent != nil {
@@ -417,15 +414,15 @@ func (m *metadata) String() string {
 // }
 
 // basically the same as LookupPath minus the ancestors of the leaf
-func (tree *persistentPrefixTree) getLeaf(prefix []byte, epoch uint64) *metadata {
+func (tree *persistentPrefixTree) getLeaf(prefix BitString, epoch uint64) *metadata {
 	var curr *metadata = tree.getRootAtEpoch(epoch)
 	i := uint32(0)
 
-	for i < uint32(len(prefix)) {
+	for i < uint32(prefix.Len()) {
 		if curr.epoch > epoch {
 			panic("unreachable?")
 		}
-		if prefix[i] == 0 {
+		if prefix.Bit(int(i)) == 0 {
 			if curr.leftChild == nil {
 				return nil
 			}
@@ -441,8 +438,8 @@ func (tree *persistentPrefixTree) getLeaf(prefix []byte, epoch uint64) *metadata
 		}
 		partialPrefix := curr.prefix
 		//fmt.Printf("%x\n%x \n", prefix[i:i+uint32(len(partialPrefix))], partialPrefix)
-		if bytes.Compare(prefix[i:i+uint32(len(partialPrefix))], partialPrefix) == 0 {
-			i += uint32(len(partialPrefix))
+		if prefix.hasPrefixAt(int(i), partialPrefix) {
+			i += uint32(partialPrefix.Len())
 			continue
 		} else {
 			return nil // leaf doesn't exist in tree at this epoch
@@ -452,7 +449,7 @@ func (tree *persistentPrefixTree) getLeaf(prefix []byte, epoch uint64) *metadata
 
 }
 
-func (tree *persistentPrefixTree) generateMembershipProof(prefix []byte, epoch uint64) (proof *MembershipProof, leafValues []KeyHash) {
+func (tree *persistentPrefixTree) generateMembershipProof(prefix BitString, epoch uint64) (proof *MembershipProof, leafValues []KeyHash) {
 	var leaf *metadata = tree.getLeaf(prefix, epoch)
 	if leaf == nil {
 		return nil, nil
@@ -468,22 +465,22 @@ func (tree *persistentPrefixTree) generateMembershipProof(prefix []byte, epoch u
 	}, leaf.values
 }
 
-func (tree *persistentPrefixTree) generateNonMembershipProof(prefix []byte, epoch uint64) *NonMembershipProof {
+func (tree *persistentPrefixTree) generateNonMembershipProof(prefix BitString, epoch uint64) *NonMembershipProof {
 
 	var prev *metadata
 	var curr *metadata = tree.getRootAtEpoch(epoch)
 	i := uint32(0)
 
-	var conflictingPrefix []byte = nil
+	var conflictingPrefix BitString
 
 	var missingNodeIsLeftChild bool
-	for i < uint32(len(prefix)) {
+	for i < uint32(prefix.Len()) {
 		if curr.epoch > epoch {
 			panic("unreachable?")
 		}
 
 		prev = curr
-		if prefix[i] == 0 {
+		if prefix.Bit(int(i)) == 0 {
 			if curr.leftChild == nil {
 				curr = nil
 				missingNodeIsLeftChild = true
@@ -506,7 +503,7 @@ func (tree *persistentPrefixTree) generateNonMembershipProof(prefix []byte, epoc
 			missingMetadata := &metadata{
 				hash:       nil,
 				epoch:      epoch,
-				prefix:     []byte{prefix[i]},
+				prefix:     prefix.Slice(int(i), int(i)+1),
 				prev:       nil,
 				next:       nil,
 				leftChild:  nil,
@@ -520,9 +517,9 @@ func (tree *persistentPrefixTree) generateNonMembershipProof(prefix []byte, epoc
 			}
 		}
 		partialPrefix := curr.prefix
-		conflictingPrefix = append(conflictingPrefix, partialPrefix...)
-		if bytes.Compare(prefix[i:i+uint32(len(partialPrefix))], partialPrefix) == 0 {
-			i += uint32(len(partialPrefix))
+		conflictingPrefix = conflictingPrefix.Append(partialPrefix)
+		if prefix.hasPrefixAt(int(i), partialPrefix) {
+			i += uint32(partialPrefix.Len())
 			continue
 		} else {
 			// WARNING: Again, this seems to miss something.
@@ -566,30 +563,30 @@ func (p *persistentPrefixTree) buildCopathFromMissingNode(startingNodeMeta *meta
 			OtherChildHash: siblingHash,
 		})
 
-	copath = append(copath, p.buildCopathFromNodeFromRoot(parentMeta, nil, epoch)...)
+	copath = append(copath, p.buildCopathFromNodeFromRoot(parentMeta, BitString{}, epoch)...)
 	return copath
 }
 
-func (p *persistentPrefixTree) getPath(leaf *metadata, fullPrefix []byte, epoch uint64) []*metadata {
+func (p *persistentPrefixTree) getPath(leaf *metadata, fullPrefix BitString, epoch uint64) []*metadata {
 	curr := p.getRootAtEpoch(epoch)
 	i := 0
 	ret := []*metadata{}
 	for curr != leaf {
 		ret = append(ret, curr)
-		var leftPrefix, rightPrefix []byte
+		var leftPrefix, rightPrefix BitString
 		if curr.leftChild != nil {
 			leftPrefix = curr.leftChild.prefix
-			if (len(fullPrefix)-i) >= len(leftPrefix) && bytes.Equal(fullPrefix[i:i+len(leftPrefix)], leftPrefix) {
+			if fullPrefix.hasPrefixAt(i, leftPrefix) {
 				curr = curr.leftChild
-				i += len(leftPrefix)
+				i += leftPrefix.Len()
 				continue
 			}
 		}
 		if curr.rightChild != nil {
 			rightPrefix = curr.rightChild.prefix
-			if (len(fullPrefix)-i) >= len(rightPrefix) && bytes.Equal(fullPrefix[i:i+len(rightPrefix)], rightPrefix) {
+			if fullPrefix.hasPrefixAt(i, rightPrefix) {
 				curr = curr.rightChild
-				i += len(rightPrefix)
+				i += rightPrefix.Len()
 				continue
 			}
 		}
@@ -600,7 +597,7 @@ func (p *persistentPrefixTree) getPath(leaf *metadata, fullPrefix []byte, epoch
 	return ret
 }
 
-func (p *persistentPrefixTree) buildCopathFromNodeFromRoot(leaf *metadata, fullPrefix []byte, epoch uint64) []forNodeOnCopath {
+func (p *persistentPrefixTree) buildCopathFromNodeFromRoot(leaf *metadata, fullPrefix BitString, epoch uint64) []forNodeOnCopath {
 	path := p.getPath(leaf, fullPrefix, epoch)
 	copath := []forNodeOnCopath{}
 	curr := p.getRootAtEpoch(epoch)
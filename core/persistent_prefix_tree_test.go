@@ -14,20 +14,20 @@ import (
 func TestPrintTree(t *testing.T) {
 	fmt.Println("Starting TestPrintTree")
 	tree := NewPersistentPrefixTree()
-	prefix := []byte{0b1, 0b1, 0b1}
+	prefix := PackBits([]byte{0b1, 0b1, 0b1})
 	valueHash := crypto.Hash([]byte{0b1})
 	tree.Insert(prefix, valueHash, 0)
 	//fmt.Printf("%v\n", tree)
 	tree.NextEpoch()
-	prefix = []byte{0b1, 0b0}
+	prefix = PackBits([]byte{0b1, 0b0})
 	tree.Insert(prefix, valueHash, 1)
 	tree.NextEpoch()
-	prefix = []byte{0b1, 0b1, 0b0}
+	prefix = PackBits([]byte{0b1, 0b1, 0b0})
 	tree.Insert(prefix, valueHash, 2)
 
-	res, err := tree.LookupPath([]byte{0b1, 0b1, 0b0}, 2)
+	res, err := tree.LookupPath(PackBits([]byte{0b1, 0b1, 0b0}), 2)
 	fmt.Println(res, err)
-	res, err = tree.LookupPath([]byte{0b1, 0b1, 0b0}, 1)
+	res, err = tree.LookupPath(PackBits([]byte{0b1, 0b1, 0b0}), 1)
 	fmt.Println(err)
 	fmt.Printf("%s\n", tree.StringAtEpoch(0))
 	fmt.Printf("%s\n", tree.StringAtEpoch(1))
@@ -36,13 +36,13 @@ func TestPrintTree(t *testing.T) {
 
 func TestSimpleMembershipProof(t *testing.T) {
 	tree := NewPersistentPrefixTree()
-	prefix := []byte{0b0}
+	prefix := PackBits([]byte{0b0})
 	valueHash := crypto.Hash([]byte{0b0})
 	tree.Insert(prefix, valueHash, 0)
 	tree.NextEpoch()
 
-	proof, values := tree.generateMembershipProof([]byte{0b0}, tree.currEpoch)
-	rootHash := computeRootHashMembership([]byte{0b0}, proof, values)
+	proof, values := tree.generateMembershipProof(PackBits([]byte{0b0}), tree.currEpoch)
+	rootHash := computeRootHashMembership(PackBits([]byte{0b0}), proof, values)
 	if !bytes.Equal(rootHash, tree.currRoot.hash) {
 		t.Error("Membership proof failed")
 	}
@@ -50,18 +50,18 @@ func TestSimpleMembershipProof(t *testing.T) {
 
 func TestMembershipProof(t *testing.T) {
 	tree := NewPersistentPrefixTree()
-	prefix := []byte{0b1, 0b1, 0b1}
+	prefix := PackBits([]byte{0b1, 0b1, 0b1})
 	valueHash := crypto.Hash([]byte{0b1})
 	tree.Insert(prefix, valueHash, 0)
 	tree.NextEpoch()
-	prefix = []byte{0b1, 0b0}
+	prefix = PackBits([]byte{0b1, 0b0})
 	tree.Insert(prefix, valueHash, 1)
 	tree.NextEpoch()
-	prefix = []byte{0b1, 0b1, 0b0}
+	prefix = PackBits([]byte{0b1, 0b1, 0b0})
 	tree.Insert(prefix, valueHash, 2)
 
-	proof, values := tree.generateMembershipProof([]byte{0b1, 0b1, 0b0}, tree.currEpoch)
-	rootHash := computeRootHashMembership([]byte{0b1, 0b1, 0b0}, proof, values)
+	proof, values := tree.generateMembershipProof(PackBits([]byte{0b1, 0b1, 0b0}), tree.currEpoch)
+	rootHash := computeRootHashMembership(PackBits([]byte{0b1, 0b1, 0b0}), proof, values)
 	if !bytes.Equal(rootHash, tree.currRoot.hash) {
 		t.Error("Membership proof failed")
 	}
@@ -69,13 +69,13 @@ func TestMembershipProof(t *testing.T) {
 
 func TestSimpleNonMbershipProof(t *testing.T) {
 	tree := NewPersistentPrefixTree()
-	prefix := []byte{0b1, 0b1, 0b1}
+	prefix := PackBits([]byte{0b1, 0b1, 0b1})
 	valueHash := crypto.Hash([]byte{0b1})
 	tree.Insert(prefix, valueHash, 0)
 	tree.NextEpoch()
 
-	proof := tree.generateNonMembershipProof([]byte{0b1, 0b0, 0b0}, tree.currEpoch)
-	rootHash := computeRootHashNonMembership([]byte{0b1, 0b0, 0b0}, proof)
+	proof := tree.generateNonMembershipProof(PackBits([]byte{0b1, 0b0, 0b0}), tree.currEpoch)
+	rootHash := computeRootHashNonMembership(PackBits([]byte{0b1, 0b0, 0b0}), proof)
 	if !bytes.Equal(rootHash, tree.currRoot.hash) {
 		t.Error("NonMembership proof failed")
 	}
@@ -83,19 +83,19 @@ func TestSimpleNonMbershipProof(t *testing.T) {
 
 func TestNonMembershipProof(t *testing.T) {
 	tree := NewPersistentPrefixTree()
-	prefix := []byte{0b1, 0b1, 0b1}
+	prefix := PackBits([]byte{0b1, 0b1, 0b1})
 	valueHash := crypto.Hash([]byte{0b1})
 	tree.Insert(prefix, valueHash, 0)
 	tree.NextEpoch()
-	prefix = []byte{0b1, 0b0, 1}
+	prefix = PackBits([]byte{0b1, 0b0, 1})
 	tree.Insert(prefix, valueHash, 1)
 	tree.NextEpoch()
-	prefix = []byte{0b1, 0b1, 0b0}
+	prefix = PackBits([]byte{0b1, 0b1, 0b0})
 	tree.Insert(prefix, valueHash, 2)
 	tree.NextEpoch()
 
-	proof := tree.generateNonMembershipProof([]byte{0b1, 0b0, 0b0}, tree.currEpoch)
-	rootHash := computeRootHashNonMembership([]byte{0b1, 0b0, 0b0}, proof)
+	proof := tree.generateNonMembershipProof(PackBits([]byte{0b1, 0b0, 0b0}), tree.currEpoch)
+	rootHash := computeRootHashNonMembership(PackBits([]byte{0b1, 0b0, 0b0}), proof)
 	if !bytes.Equal(rootHash, tree.currRoot.hash) {
 		t.Error("NonMembership proof failed")
 	}
@@ -103,18 +103,18 @@ func TestNonMembershipProof(t *testing.T) {
 
 func TestNonMembershipProofInPast(t *testing.T) {
 	tree := NewPersistentPrefixTree()
-	prefix := []byte{0b1, 0b1, 0b1}
+	prefix := PackBits([]byte{0b1, 0b1, 0b1})
 	valueHash := crypto.Hash([]byte{0b1})
 	tree.Insert(prefix, valueHash, 0)
 	tree.NextEpoch()
-	prefix = []byte{0b1, 0b0}
+	prefix = PackBits([]byte{0b1, 0b0})
 	tree.Insert(prefix, valueHash, 1)
 	tree.NextEpoch()
-	prefix = []byte{0b1, 0b1, 0b0}
+	prefix = PackBits([]byte{0b1, 0b1, 0b0})
 	tree.Insert(prefix, valueHash, 2)
 
-	proof := tree.generateNonMembershipProof([]byte{0b1, 0b1, 0b0}, tree.currEpoch-1)
-	rootHash := computeRootHashNonMembership([]byte{0b1, 0b1, 0b0}, proof)
+	proof := tree.generateNonMembershipProof(PackBits([]byte{0b1, 0b1, 0b0}), tree.currEpoch-1)
+	rootHash := computeRootHashNonMembership(PackBits([]byte{0b1, 0b1, 0b0}), proof)
 	if !bytes.Equal(rootHash, tree.getRootAtEpoch(tree.currEpoch-1).hash) {
 		// TODO: check that this is how we check proofs against roots in the past
 		t.Error("NonMembership proof failed")
@@ -123,13 +123,13 @@ func TestNonMembershipProofInPast(t *testing.T) {
 
 func TestBigMembershipProof(t *testing.T) {
 	tree := NewPersistentPrefixTree()
-	prefix := []byte{0b1, 0b1, 0b1}
+	prefix := PackBits([]byte{0b1, 0b1, 0b1})
 	valueHash := crypto.Hash([]byte{0b1})
 	tree.Insert(prefix, valueHash, 0)
 	tree.NextEpoch()
 
-	proof := tree.generateNonMembershipProof([]byte{0b1, 0b0, 0b0}, tree.currEpoch)
-	rootHash := computeRootHashNonMembership([]byte{0b1, 0b0, 0b0}, proof)
+	proof := tree.generateNonMembershipProof(PackBits([]byte{0b1, 0b0, 0b0}), tree.currEpoch)
+	rootHash := computeRootHashNonMembership(PackBits([]byte{0b1, 0b0, 0b0}), proof)
 	if !bytes.Equal(rootHash, tree.currRoot.hash) {
 		t.Error("NonMembership proof failed")
 	}
@@ -344,7 +344,7 @@ func TestConsistentTrees(t *testing.T) {
 	fmt.Println(tree.StringAtEpoch(tree.currEpoch))
 }
 
-func toBits(s string) []byte {
+func toBits(s string) BitString {
 	bs := []byte(s)
 	result := make([]byte, len(s))
 	for i := 0; i < len(s); i++ {
@@ -354,5 +354,100 @@ func toBits(s string) []byte {
 			result[i] = 1
 		}
 	}
-	return result
+	return PackBits(result)
+}
+
+func TestInsertIntoLeafOfEarlierEpoch(t *testing.T) {
+	pt := NewPersistentPrefixTree()
+	key := []byte{0x5b, 0x4, 0xf8, 0xe, 0x50, 0xfd, 0x1, 0xb1, 0xf, 0xdb}
+	other := []byte{0x22, 0xdc, 0x53, 0x44, 0x49, 0x84, 0x92, 0xc2, 0x1b, 0xaa}
+	prefix := makePrefixFromKey(key)
+	pt.Insert(prefix, crypto.Hash(key, []byte{0}), 0)
+	pt.Insert(makePrefixFromKey(other), crypto.Hash(other, []byte{0}), 1)
+	pt.NextEpoch()
+	oldHash := append([]byte{}, pt.getHash(0)...)
+
+	// appending to the leaf in a later epoch copies it, leaving the version
+	// of the earlier epoch and its hash as they were
+	pt.Insert(prefix, crypto.Hash(key, []byte{1}), 2)
+	if !bytes.Equal(pt.getHash(0), oldHash) {
+		t.Error("insert changed the root hash of an earlier epoch")
+	}
+	proof, values := pt.generateMembershipProof(prefix, 0)
+	if len(values) != 1 || !bytes.Equal(computeRootHashMembership(prefix, proof, values), oldHash) {
+		t.Error("membership proof at the earlier epoch failed")
+	}
+
+	// while the new version is rehashed with the value
+	proof, values = pt.generateMembershipProof(prefix, pt.currEpoch)
+	if len(values) != 2 {
+		t.Fatalf("expected 2 values in the current epoch, got %d", len(values))
+	}
+	if !bytes.Equal(computeRootHashMembership(prefix, proof, values), pt.getHash(pt.currEpoch)) {
+		t.Error("membership proof at the current epoch failed")
+	}
+	if bytes.Equal(pt.getHash(pt.currEpoch), oldHash) {
+		t.Error("root hash of the current epoch does not cover the new value")
+	}
+}
+
+func TestBulkInsertMatchesInsert(t *testing.T) {
+	math_rand.Seed(1)
+
+	expected := NewPersistentPrefixTree()
+	tree := NewPersistentPrefixTree()
+
+	for epoch := 0; epoch < 5; epoch++ {
+		var prefixes []BitString
+		var valueHashes [][]byte
+		var positions []uint32
+		for i := 0; i < 200; i++ {
+			// draw ids from a small range so later epochs hit existing leaves
+			id := make([]byte, 32)
+			binary.LittleEndian.PutUint32(id, uint32(math_rand.Intn(500)))
+			prefix := makePrefixFromKey(id)
+			valueHash := crypto.Hash(id, []byte{byte(epoch)})
+			pos := uint32(epoch*200 + i)
+
+			expected.Insert(prefix, valueHash, pos)
+			prefixes = append(prefixes, prefix)
+			valueHashes = append(valueHashes, valueHash)
+			positions = append(positions, pos)
+		}
+
+		err := tree.BulkInsert(SortUpdates(prefixes, valueHashes, positions))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tree.getHash(tree.currEpoch), expected.getHash(expected.currEpoch)) {
+			t.Errorf("epoch %d: bulk root %x, expected %x", epoch, tree.getHash(tree.currEpoch), expected.getHash(expected.currEpoch))
+		}
+		if tree.getSize(tree.currEpoch) != expected.getSize(expected.currEpoch) {
+			t.Errorf("epoch %d: bulk size %d, expected %d", epoch, tree.getSize(tree.currEpoch), expected.getSize(expected.currEpoch))
+		}
+		for _, prefix := range prefixes[:10] {
+			proof, values := tree.generateMembershipProof(prefix, tree.currEpoch)
+			if !bytes.Equal(computeRootHashMembership(prefix, proof, values), tree.getHash(tree.currEpoch)) {
+				t.Errorf("epoch %d: membership proof failed", epoch)
+			}
+		}
+
+		tree.NextEpoch()
+		expected.NextEpoch()
+	}
+
+	// earlier snapshots must be left untouched by later bulk inserts
+	for epoch := uint64(0); epoch < tree.currEpoch; epoch++ {
+		if !bytes.Equal(tree.getHash(epoch), expected.getHash(epoch)) {
+			t.Errorf("snapshot at epoch %d changed", epoch)
+		}
+	}
+}
+
+func TestBulkInsertRejectsUnsorted(t *testing.T) {
+	tree := NewPersistentPrefixTree()
+	err := tree.BulkInsert([]BitString{toBits("011"), toBits("010")}, [][]byte{nil, nil}, []uint32{0, 1})
+	if err == nil {
+		t.Error("expected an error for unsorted entries")
+	}
 }
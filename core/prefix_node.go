@@ -12,7 +12,7 @@ type internalNode struct {
 	hash          []byte
 	leftChild     prefixNode
 	rightChild    prefixNode
-	partialPrefix []byte
+	partialPrefix BitString
 }
 
 // Leaf node representation in Prefix Tree
@@ -20,10 +20,10 @@ type leafNode struct {
 	parent        prefixNode
 	hash          []byte
 	values        []KeyHash
-	partialPrefix []byte
+	partialPrefix BitString
 }
 
-func newInteriorNode(parent prefixNode, partialPrefix []byte) *internalNode {
+func newInteriorNode(parent prefixNode, partialPrefix BitString) *internalNode {
 
 	res := &internalNode{
 		hash:          nil,
@@ -35,8 +35,8 @@ func newInteriorNode(parent prefixNode, partialPrefix []byte) *internalNode {
 	return res
 }
 
-func newLeafNode(parent prefixNode, valueHash []byte, pos uint32, partialPrefix []byte) *leafNode {
-	if partialPrefix == nil || len(partialPrefix) <= 0 {
+func newLeafNode(parent prefixNode, valueHash []byte, pos uint32, partialPrefix BitString) *leafNode {
+	if partialPrefix.Len() <= 0 {
 		panic("cannot create a leaf branch without a partial prefix")
 	}
 
@@ -70,43 +70,43 @@ type prefixNode interface {
 	getParent() prefixNode
 	getRightChild() prefixNode
 	getLeftChild() prefixNode
-	getPartialPrefix() []byte
-	setPartialPrefix(newPrefix []byte)
+	getPartialPrefix() BitString
+	setPartialPrefix(newPrefix BitString)
 	getValues() []KeyHash
 	addValue(valueHash []byte, pos uint32)
 	getSibling() prefixNode
 	addChild(child prefixNode)
-	getChild(prefix []byte, nextPrefixByteIndex uint32) prefixNode
+	getChild(prefix BitString, nextPrefixByteIndex uint32) prefixNode
 	updateHash()
 	serialize() ([]byte, error)
 	getSize() int
 	getNumNodes() int
 }
 
-func (node *internalNode) isLeafNode() bool                  { return false }
-func (node *internalNode) isLeftChild() bool                 { return node.partialPrefix[0] == 0 }
-func (node *internalNode) getHash() []byte                   { return node.hash }
-func (node *internalNode) setParent(parent prefixNode)       { node.parent = parent }
-func (node *internalNode) getParent() prefixNode             { return node.parent }
-func (node *internalNode) getRightChild() prefixNode         { return node.rightChild }
-func (node *internalNode) getLeftChild() prefixNode          { return node.leftChild }
-func (node *internalNode) getPartialPrefix() []byte          { return node.partialPrefix }
-func (node *internalNode) setPartialPrefix(newPrefix []byte) { node.partialPrefix = newPrefix }
-func (node *internalNode) getValues() []KeyHash              { return nil }
+func (node *internalNode) isLeafNode() bool                     { return false }
+func (node *internalNode) isLeftChild() bool                    { return node.partialPrefix.Bit(0) == 0 }
+func (node *internalNode) getHash() []byte                      { return node.hash }
+func (node *internalNode) setParent(parent prefixNode)          { node.parent = parent }
+func (node *internalNode) getParent() prefixNode                { return node.parent }
+func (node *internalNode) getRightChild() prefixNode            { return node.rightChild }
+func (node *internalNode) getLeftChild() prefixNode             { return node.leftChild }
+func (node *internalNode) getPartialPrefix() BitString          { return node.partialPrefix }
+func (node *internalNode) setPartialPrefix(newPrefix BitString) { node.partialPrefix = newPrefix }
+func (node *internalNode) getValues() []KeyHash                 { return nil }
 func (node *internalNode) addValue(valueHash []byte, pos uint32) {
 	return
 }
 func (node *internalNode) getSibling() prefixNode { return getSibling(node) }
 func (node *internalNode) addChild(child prefixNode) {
 	child.setParent(node)
-	if child.getPartialPrefix()[0] == 0 {
+	if child.getPartialPrefix().Bit(0) == 0 {
 		node.leftChild = child
 	} else {
 		node.rightChild = child
 	}
 }
-func (node *internalNode) getChild(prefix []byte, nextPrefixByteIndex uint32) prefixNode {
-	nextPrefixByte := prefix[nextPrefixByteIndex]
+func (node *internalNode) getChild(prefix BitString, nextPrefixByteIndex uint32) prefixNode {
+	nextPrefixByte := prefix.Bit(int(nextPrefixByteIndex))
 	if nextPrefixByte == 0 {
 		return node.getLeftChild()
 	}
@@ -123,7 +123,7 @@ func (node *internalNode) updateHash() {
 	if node.rightChild != nil {
 		rightHash = node.rightChild.getHash()
 	}
-	node.hash = crypto.Hash(node.partialPrefix, leftHash, rightHash)
+	node.hash = crypto.Hash(node.partialPrefix.Unpack(), leftHash, rightHash)
 }
 
 func (node *internalNode) getSize() int {
@@ -133,10 +133,7 @@ func (node *internalNode) getSize() int {
 
 	// size of partialPrefix and hash
 	total += binary.Size(node.getHash())
-	if node.getPartialPrefix() != nil {
-		// total += binary.Size(node.getPartialPrefix()) / 8
-		total += binary.Size(node.getPartialPrefix())
-	}
+	total += binary.Size(node.getPartialPrefix().Packed)
 	// fmt.Println(total)
 
 	// right tree, if exists
@@ -167,23 +164,23 @@ func (node *internalNode) getNumNodes() int {
 	return total
 }
 
-func (node *leafNode) isLeafNode() bool                  { return true }
-func (node *leafNode) isLeftChild() bool                 { return node.partialPrefix[0] == 0 }
-func (node *leafNode) getHash() []byte                   { return node.hash }
-func (node *leafNode) setParent(parent prefixNode)       { node.parent = parent }
-func (node *leafNode) getParent() prefixNode             { return node.parent }
-func (node *leafNode) getRightChild() prefixNode         { return nil }
-func (node *leafNode) getLeftChild() prefixNode          { return nil }
-func (node *leafNode) getPartialPrefix() []byte          { return node.partialPrefix }
-func (node *leafNode) setPartialPrefix(newPrefix []byte) { node.partialPrefix = newPrefix }
-func (node *leafNode) getValues() []KeyHash              { return node.values }
+func (node *leafNode) isLeafNode() bool                     { return true }
+func (node *leafNode) isLeftChild() bool                    { return node.partialPrefix.Bit(0) == 0 }
+func (node *leafNode) getHash() []byte                      { return node.hash }
+func (node *leafNode) setParent(parent prefixNode)          { node.parent = parent }
+func (node *leafNode) getParent() prefixNode                { return node.parent }
+func (node *leafNode) getRightChild() prefixNode            { return nil }
+func (node *leafNode) getLeftChild() prefixNode             { return nil }
+func (node *leafNode) getPartialPrefix() BitString          { return node.partialPrefix }
+func (node *leafNode) setPartialPrefix(newPrefix BitString) { node.partialPrefix = newPrefix }
+func (node *leafNode) getValues() []KeyHash                 { return node.values }
 func (node *leafNode) addValue(valueHash []byte, pos uint32) {
 	node.values = append(node.values, KeyHash{valueHash, pos})
 }
-func (node *leafNode) getSibling() prefixNode                                        { return getSibling(node) }
-func (node *leafNode) addChild(child prefixNode)                                     {}
-func (node *leafNode) getChild(prefix []byte, nextPrefixByteIndex uint32) prefixNode { return nil }
-func (node *leafNode) updateHash()                                                   { node.hash = leafHash(node.partialPrefix, node.values) }
+func (node *leafNode) getSibling() prefixNode                                           { return getSibling(node) }
+func (node *leafNode) addChild(child prefixNode)                                        {}
+func (node *leafNode) getChild(prefix BitString, nextPrefixByteIndex uint32) prefixNode { return nil }
+func (node *leafNode) updateHash()                                                      { node.hash = leafHash(node.partialPrefix, node.values) }
 
 func (node *leafNode) getSize() int {
 
@@ -191,9 +188,7 @@ func (node *leafNode) getSize() int {
 	total := pointerSizeInBytes
 
 	// size of partialPrefix and hash
-	// total += binary.Size(node.getPartialPrefix())/8 + binary.Size(node.getHash())
-	total += binary.Size(node.getPartialPrefix()) + binary.Size(node.getHash())
-	// fmt.Println(binary.Size(node.getPartialPrefix()) / 8)
+	total += binary.Size(node.getPartialPrefix().Packed) + binary.Size(node.getHash())
 
 	// size of KeyHash values
 	for _, value := range node.getValues() {
@@ -209,13 +204,29 @@ func (node *leafNode) getNumNodes() int {
 	return 1
 }
 
-func leafHash(partialPrefix []byte, values []KeyHash) []byte {
-	var flattenedValueHashes []byte
+// leafHash commits to the values of a leaf through a hash chain, so that a
+// single value can be proven with the digest of the values before it instead
+// of the whole list (see LeafValueProof).
+func leafHash(partialPrefix BitString, values []KeyHash) []byte {
+	return leafHashFromDigest(partialPrefix, valueChainDigest(values))
+}
+
+func leafHashFromDigest(partialPrefix BitString, valuesDigest []byte) []byte {
+	return crypto.Hash(partialPrefix.Unpack(), valuesDigest)
+}
+
+// valueChainDigest returns the head of the hash chain over values; it is nil
+// for a leaf without values.
+func valueChainDigest(values []KeyHash) []byte {
+	var digest []byte
 	for _, value := range values {
-		flattenedValueHashes = append(flattenedValueHashes, value.Hash...)
-		posAsBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint32(posAsBytes, value.Pos)
-		flattenedValueHashes = append(flattenedValueHashes, posAsBytes...)
+		digest = extendValueChain(digest, value)
 	}
-	return crypto.Hash(partialPrefix, flattenedValueHashes)
+	return digest
+}
+
+func extendValueChain(digest []byte, value KeyHash) []byte {
+	posAsBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(posAsBytes, value.Pos)
+	return crypto.Hash(digest, posAsBytes, value.Hash)
 }
@@ -10,9 +10,9 @@ import (
 )
 
 type prefixAppend struct {
-	Prefix []byte `json:"prefix"`
-	Value  []byte `json:"value"`
-	Pos    uint32 `json:"pos"`
+	Prefix BitString `json:"prefix"`
+	Value  []byte    `json:"value"`
+	Pos    uint32    `json:"pos"`
 }
 
 // TODO: Maybe we need to make it public in the future. We can decide later
@@ -26,21 +26,32 @@ type prefixTree struct {
 // for node on path to root, store onpath partial prefix, and the hash of the offpath child,
 type forNodeOnCopath struct {
 	// for root there is no partial prefix
-	PartialPrefix []byte
+	PartialPrefix BitString
 	//the child node that isn't on path (struct for starting node stores node itself)
 	OtherChildHash []byte
 }
 
 // MembershipProof ...
 type MembershipProof struct {
-	LeafPartialPrefix []byte
+	LeafPartialPrefix BitString
 	CopathNodes       []forNodeOnCopath //first is leaf's sibling, last is root
 }
 
+// LeafValueProof opens the newest value of a leaf against the head of the
+// leaf's hash chain, which the leaf hash commits to. The values stored before
+// it are summarized by their chain digest, so the proof has the same size
+// however long the leaf's history. An older value is proven against the tree
+// of the epoch in which it was the newest: every update prefix tree and every
+// version of the base tree commits to the chain head of its own epoch.
+type LeafValueProof struct {
+	Value           KeyHash
+	PrecedingDigest []byte // nil if Value is the leaf's first value
+}
+
 // NonMembershipProof ...
 type NonMembershipProof struct {
 	EndNodeHash          []byte            // for empty nodes, will be nil
-	EndNodePartialPrefix []byte            // for empty nodes, will be the (one) next-expected byte
+	EndNodePartialPrefix BitString         // for empty nodes, will be the (one) next-expected bit
 	CopathNodes          []forNodeOnCopath //first is node at bottom of path, last is root
 }
 
@@ -52,7 +63,7 @@ func NewPrefixTree() *prefixTree {
 			hash:          nil,
 			leftChild:     nil,
 			rightChild:    nil,
-			partialPrefix: nil,
+			partialPrefix: BitString{},
 		},
 		isComplete: false,
 	}
@@ -60,11 +71,11 @@ func NewPrefixTree() *prefixTree {
 	return res
 }
 
-func makePrefixFromKey(key []byte) []byte {
-	return ConvertBitsToBytes(crypto.Hash(key))
+func makePrefixFromKey(key []byte) BitString {
+	return NewBitString(crypto.Hash(key))
 }
-func MakePrefixFromKey(key []byte) []byte {
-	return ConvertBitsToBytes(crypto.Hash(key))
+func MakePrefixFromKey(key []byte) BitString {
+	return NewBitString(crypto.Hash(key))
 }
 
 func ConvertBitsToBytes(asBits []byte) []byte {
@@ -88,7 +99,7 @@ func getBit(prefix []byte, i uint32) byte {
 	return (prefix[i/8] >> (7 - i%8)) & 1
 }
 
-func (tree *prefixTree) PrefixAppend(prefix []byte, valueHash []byte, pos uint32) (err error) { //// TODO: add hashes and parents
+func (tree *prefixTree) PrefixAppend(prefix BitString, valueHash []byte, pos uint32) (err error) { //// TODO: add hashes and parents
 
 	if tree.isComplete {
 		err = errors.New("cannot append to completed prefix tree")
@@ -101,29 +112,25 @@ func (tree *prefixTree) PrefixAppend(prefix []byte, valueHash []byte, pos uint32
 	var curr prefixNode = tree.root
 	i := uint32(0)
 
-	for i < uint32(len(prefix)) {
+	for i < uint32(prefix.Len()) {
 
 		prev = curr
 		curr = curr.getChild(prefix, i)
 
 		if curr == nil { //this child had not been made yet
-			leaf := newLeafNode(prev, valueHash, pos, prefix[i:])
+			leaf := newLeafNode(prev, valueHash, pos, prefix.SliceFrom(int(i)))
 			tree.updateHashesFromLeaf(leaf)
 			return
 		}
 
-		j := uint32(0)
-		for j < uint32(len(curr.getPartialPrefix())) {
-			if prefix[i] == curr.getPartialPrefix()[j] {
-				i++
-				j++
-			} else {
-				newParent := splitCompressedNode(curr, prev, j)
-				curr.updateHash()
-				leaf := newLeafNode(newParent, valueHash, pos, prefix[i:])
-				tree.updateHashesFromLeaf(leaf)
-				return
-			}
+		j := uint32(prefix.commonPrefixLengthFrom(int(i), curr.getPartialPrefix()))
+		i += j
+		if j < uint32(curr.getPartialPrefix().Len()) {
+			newParent := splitCompressedNode(curr, prev, j)
+			curr.updateHash()
+			leaf := newLeafNode(newParent, valueHash, pos, prefix.SliceFrom(int(i)))
+			tree.updateHashesFromLeaf(leaf)
+			return
 		}
 	}
 	leaf := curr
@@ -132,20 +139,20 @@ func (tree *prefixTree) PrefixAppend(prefix []byte, valueHash []byte, pos uint32
 	return
 }
 
-func (tree *prefixTree) getLeaf(prefix []byte) prefixNode {
+func (tree *prefixTree) getLeaf(prefix BitString) prefixNode {
 
 	var curr prefixNode = tree.root
 	i := uint32(0)
 
-	for i < uint32(len(prefix)) {
+	for i < uint32(prefix.Len()) {
 
 		curr = curr.getChild(prefix, i)
 		if curr == nil {
 			return nil //key doesn't exist in tree
 		}
 		partialPrefix := curr.getPartialPrefix()
-		if bytes.Compare(prefix[i:i+uint32(len(partialPrefix))], partialPrefix) == 0 {
-			i += uint32(len(partialPrefix))
+		if prefix.hasPrefixAt(int(i), partialPrefix) {
+			i += uint32(partialPrefix.Len())
 			continue
 		} else {
 			return nil //key doesn't exist in tree
@@ -155,7 +162,7 @@ func (tree *prefixTree) getLeaf(prefix []byte) prefixNode {
 
 }
 
-func (tree *prefixTree) generateMembershipProof(prefix []byte) (proof *MembershipProof, leafValues []KeyHash) {
+func (tree *prefixTree) generateMembershipProof(prefix BitString) (proof *MembershipProof, leafValues []KeyHash) {
 	leaf := tree.getLeaf(prefix)
 	if leaf == nil {
 		return nil, nil
@@ -171,13 +178,32 @@ func (tree *prefixTree) generateMembershipProof(prefix []byte) (proof *Membershi
 	}, leaf.getValues()
 }
 
-func (tree *prefixTree) generateNonMembershipProof(prefix []byte) *NonMembershipProof {
+// newLeafValueProof opens the newest of values, the head of the hash chain
+// over values. values must not be empty.
+func newLeafValueProof(values []KeyHash) *LeafValueProof {
+	last := len(values) - 1
+	return &LeafValueProof{
+		Value:           values[last],
+		PrecedingDigest: valueChainDigest(values[:last]),
+	}
+}
+
+// leafDigest recomputes the head of the leaf's hash chain, or returns nil if
+// the proof is malformed.
+func (p *LeafValueProof) leafDigest() []byte {
+	if len(p.PrecedingDigest) != 0 && len(p.PrecedingDigest) != crypto.HashSize {
+		return nil
+	}
+	return extendValueChain(p.PrecedingDigest, p.Value)
+}
+
+func (tree *prefixTree) generateNonMembershipProof(prefix BitString) *NonMembershipProof {
 
 	var prev prefixNode
 	var curr prefixNode = tree.root
 	i := uint32(0)
 
-	for i < uint32(len(prefix)) {
+	for i < uint32(prefix.Len()) {
 
 		prev = curr
 		curr = curr.getChild(prefix, i)
@@ -188,7 +214,7 @@ func (tree *prefixTree) generateNonMembershipProof(prefix []byte) *NonMembership
 			}
 			missingNode := &internalNode{
 				parent:        tree.root,
-				partialPrefix: []byte{prefix[i]},
+				partialPrefix: prefix.Slice(int(i), int(i)+1),
 			}
 			return &NonMembershipProof{
 				EndNodeHash:          nil,
@@ -197,8 +223,8 @@ func (tree *prefixTree) generateNonMembershipProof(prefix []byte) *NonMembership
 			}
 		}
 		partialPrefix := curr.getPartialPrefix()
-		if bytes.Compare(prefix[i:i+uint32(len(partialPrefix))], partialPrefix) == 0 {
-			i += uint32(len(partialPrefix))
+		if prefix.hasPrefixAt(int(i), partialPrefix) {
+			i += uint32(partialPrefix.Len())
 			continue
 		} else {
 			// WARNING: Again, this seems to miss something.
@@ -238,15 +264,15 @@ func (tree *prefixTree) buildCopathFromNode(startingNode prefixNode) []forNodeOn
 }
 
 func splitCompressedNode(nodeToSplit prefixNode, parent prefixNode, index uint32) *internalNode {
-	prefixLength := uint32(len(nodeToSplit.getPartialPrefix()))
+	prefixLength := uint32(nodeToSplit.getPartialPrefix().Len())
 	if prefixLength <= 1 {
 		panic("can't split a non-compressed node")
 	} else if index == 0 || index >= prefixLength {
 		panic("given index doesn't split the prefix into 2 peices")
 	}
-	intermediateNode := newInteriorNode(parent, nodeToSplit.getPartialPrefix()[0:index])
+	intermediateNode := newInteriorNode(parent, nodeToSplit.getPartialPrefix().Slice(0, int(index)))
 
-	nodeToSplit.setPartialPrefix(nodeToSplit.getPartialPrefix()[index:])
+	nodeToSplit.setPartialPrefix(nodeToSplit.getPartialPrefix().SliceFrom(int(index)))
 	intermediateNode.addChild(nodeToSplit)
 
 	return intermediateNode
@@ -276,28 +302,39 @@ func (tree *prefixTree) complete() {
 
 type merkleProof struct {
 	endNodeHash          []byte
-	endNodePartialPrefix []byte
+	endNodePartialPrefix BitString
 	copath               []forNodeOnCopath
 }
 
-func getPrefix(copath []forNodeOnCopath) []byte {
-	prefixInProof := []byte{}
+func getPrefix(copath []forNodeOnCopath) BitString {
+	prefixInProof := BitString{}
 	for i := len(copath) - 1; i >= 0; i-- {
-		prefixInProof = append(prefixInProof, copath[i].PartialPrefix...)
+		prefixInProof = prefixInProof.Append(copath[i].PartialPrefix)
 	}
 	return prefixInProof
 }
 
+// copathWellFormed reports whether every partial prefix in a proof's copath
+// can be read safely.
+func copathWellFormed(copath []forNodeOnCopath) bool {
+	for _, nodeOnCopath := range copath {
+		if !nodeOnCopath.PartialPrefix.wellFormed() {
+			return false
+		}
+	}
+	return true
+}
+
 // calculating hashes along the copath gives same root hash as expected
-func getRootHash(endNodeHash []byte, endNodePartialPrefix []byte, copath []forNodeOnCopath) []byte {
+func getRootHash(endNodeHash []byte, endNodePartialPrefix BitString, copath []forNodeOnCopath) []byte {
 	currHash := endNodeHash
-	comingFromLeft := endNodePartialPrefix[0] == 0
+	comingFromLeft := endNodePartialPrefix.Bit(0) == 0
 	var leftHash, rightHash []byte
 	for i, nodeOnCopath := range copath {
 		if i != len(copath)-1 { // not root
 			if nodeOnCopath.OtherChildHash == nil {
 				panic("there is an empty node in copath, that isn't a child of root")
-			} else if nodeOnCopath.PartialPrefix == nil {
+			} else if nodeOnCopath.PartialPrefix.Len() == 0 {
 				panic("all nodes other than root should have a partial prefix")
 			}
 		}
@@ -309,9 +346,9 @@ func getRootHash(endNodeHash []byte, endNodePartialPrefix []byte, copath []forNo
 			rightHash = currHash
 		}
 		//nodeOnCopath in the while loop will always be an internal node
-		currHash = crypto.Hash(nodeOnCopath.PartialPrefix, leftHash, rightHash)
+		currHash = crypto.Hash(nodeOnCopath.PartialPrefix.Unpack(), leftHash, rightHash)
 		if i != len(copath)-1 { //otherwise is is root
-			comingFromLeft = nodeOnCopath.PartialPrefix[0] == 0
+			comingFromLeft = nodeOnCopath.PartialPrefix.Bit(0) == 0
 		}
 	}
 	return currHash
@@ -321,20 +358,43 @@ func getRootHash(endNodeHash []byte, endNodePartialPrefix []byte, copath []forNo
 // For verifyMembershipProof, the input should contain all the key-value pairs in the leaf node,
 // including positions and signatures.
 // TODO: will add this after key-value store implemented
-func computeRootHashMembership(prefix []byte, proof *MembershipProof, leafValues []KeyHash) (rootHash []byte) {
-	if !bytes.Equal(prefix, append(getPrefix(proof.CopathNodes), proof.LeafPartialPrefix...)) {
+func computeRootHashMembership(prefix BitString, proof *MembershipProof, leafValues []KeyHash) (rootHash []byte) {
+	return computeRootHashMembershipFromDigest(prefix, proof, valueChainDigest(leafValues))
+}
+
+// computeRootHashMembershipForValue is computeRootHashMembership for a proof
+// that opens a single value of the leaf.
+func computeRootHashMembershipForValue(prefix BitString, proof *MembershipProof, valueProof *LeafValueProof) (rootHash []byte) {
+	if proof == nil || valueProof == nil {
+		return nil
+	}
+	digest := valueProof.leafDigest()
+	if digest == nil {
+		return nil //malformed proof
+	}
+	return computeRootHashMembershipFromDigest(prefix, proof, digest)
+}
+
+func computeRootHashMembershipFromDigest(prefix BitString, proof *MembershipProof, valuesDigest []byte) (rootHash []byte) {
+	if !proof.LeafPartialPrefix.wellFormed() || proof.LeafPartialPrefix.Len() == 0 || !copathWellFormed(proof.CopathNodes) {
+		return nil //malformed proof
+	}
+	if !prefix.Equal(getPrefix(proof.CopathNodes).Append(proof.LeafPartialPrefix)) {
 		return nil //copath in proof leads somewhere other than key's leaf node
 	}
-	return getRootHash(leafHash(proof.LeafPartialPrefix, leafValues), proof.LeafPartialPrefix, proof.CopathNodes)
+	return getRootHash(leafHashFromDigest(proof.LeafPartialPrefix, valuesDigest), proof.LeafPartialPrefix, proof.CopathNodes)
 }
 
-func computeRootHashNonMembership(prefix []byte, proof *NonMembershipProof) (rootHash []byte) {
+func computeRootHashNonMembership(prefix BitString, proof *NonMembershipProof) (rootHash []byte) {
+	if !proof.EndNodePartialPrefix.wellFormed() || proof.EndNodePartialPrefix.Len() == 0 || !copathWellFormed(proof.CopathNodes) {
+		return nil //malformed proof
+	}
 	copathPartialPrefix := getPrefix(proof.CopathNodes)
-	if !bytes.HasPrefix(prefix, copathPartialPrefix) {
+	if !prefix.HasPrefix(copathPartialPrefix) || prefix.Len() == copathPartialPrefix.Len() {
 		return nil //copath forms prefix that isn't a frontal partial slice of prefix=crypto.Hash(key)
 	}
-	remainingPrefix := prefix[len(copathPartialPrefix):]
-	if remainingPrefix[0] != proof.EndNodePartialPrefix[0] {
+	remainingPrefix := prefix.SliceFrom(copathPartialPrefix.Len())
+	if remainingPrefix.Bit(0) != proof.EndNodePartialPrefix.Bit(0) {
 		return nil //proof's endNode is in the copath (sibling to on-path node) instead of on-path to key
 	}
 	if proof.EndNodeHash == nil { //should be an empty node under root
@@ -342,7 +402,7 @@ func computeRootHashNonMembership(prefix []byte, proof *NonMembershipProof) (roo
 			panic("endNode is an empty node that isn't a child of root (endNodeHash should only be nil for empty nodes, empty node can only exist as children of root, and copath should have len==1 if endNode is child of root)")
 		}
 	} else { // a compressed node exists that would've been split if prefix was in the tree
-		if bytes.HasPrefix(remainingPrefix, proof.EndNodePartialPrefix) {
+		if remainingPrefix.HasPrefix(proof.EndNodePartialPrefix) {
 			return nil //proof's endNode is a compressed node whose partial prefix matches key's path, key could exist under endNode
 		}
 	}
@@ -389,7 +449,7 @@ func (tree *prefixTree) copy() (*prefixTree, error) {
 	return ret, nil
 }
 
-func (p *prefixTree) HasValue(prefix []byte, value []byte) bool {
+func (p *prefixTree) HasValue(prefix BitString, value []byte) bool {
 	if p.getLeaf(prefix) == nil {
 		return false
 	}
@@ -410,10 +470,10 @@ func (p *prefixTree) String() string {
 		nextLevel := []prefixNode{}
 		for _, node := range currLevel {
 			if node.getParent() != nil {
-				ret += fmt.Sprintf("%d<-", node.getParent().getPartialPrefix())
+				ret += fmt.Sprintf("%v<-", node.getParent().getPartialPrefix())
 			}
 			ret += fmt.Sprintf("%x\t", node.getHash()[:10])
-			if node.getLeftChild()!= nil {
+			if node.getLeftChild() != nil {
 				nextLevel = append(nextLevel, node.getLeftChild())
 			}
 			if node.getRightChild() != nil {
@@ -425,4 +485,4 @@ func (p *prefixTree) String() string {
 		level += 1
 	}
 	return ret
-}
\ No newline at end of file
+}
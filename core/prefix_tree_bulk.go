@@ -0,0 +1,145 @@
+package core
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Subtrees holding fewer appends than this are always built on the calling
+// goroutine; spawning below this size costs more than it saves.
+const parallelBuildMinAppends = 1 << 10
+
+// newPrefixTreeFromAppends builds a prefix tree holding the given appends in
+// one pass. The appends are sorted by prefix and the compressed tree is built
+// bottom-up, so every node is hashed exactly once instead of once per append
+// below it as with PrefixAppend. Appends sharing a prefix keep their relative
+// order, so the resulting root hash is identical to appending them one by one.
+// If parallel is set, large disjoint subtrees are built concurrently.
+func newPrefixTreeFromAppends(appends []prefixAppend, parallel bool) (*prefixTree, error) {
+	sorted := make([]prefixAppend, len(appends))
+	copy(sorted, appends)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Prefix.Compare(sorted[j].Prefix) < 0
+	})
+
+	tree := NewPrefixTree()
+	tree.appends = sorted
+	if len(sorted) == 0 {
+		return tree, nil
+	}
+
+	left, right := splitSortedAppends(sorted, 0)
+	var leftChild, rightChild prefixNode
+	var leftErr, rightErr error
+	buildChildren(
+		func() { leftChild, leftErr = buildPrefixSubtree(left, 0, parallel) },
+		func() { rightChild, rightErr = buildPrefixSubtree(right, 0, parallel) },
+		parallel && len(sorted) >= parallelBuildMinAppends,
+	)
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	if rightErr != nil {
+		return nil, rightErr
+	}
+	if leftChild != nil {
+		tree.root.addChild(leftChild)
+	}
+	if rightChild != nil {
+		tree.root.addChild(rightChild)
+	}
+	tree.root.updateHash()
+	return tree, nil
+}
+
+// NewPrefixTreeFromUpdates builds the prefix tree for one update epoch, given
+// the H(identifier) hashes and value hashes collected during that epoch.
+func NewPrefixTreeFromUpdates(idHashes [][]byte, valueHashes [][]byte, parallel bool) (*prefixTree, error) {
+	if len(idHashes) != len(valueHashes) {
+		return nil, errors.New("idHashes and valueHashes are of unequal length")
+	}
+	appends := make([]prefixAppend, len(idHashes))
+	for i := range idHashes {
+		appends[i] = prefixAppend{NewBitString(idHashes[i]), valueHashes[i], 0}
+	}
+	return newPrefixTreeFromAppends(appends, parallel)
+}
+
+// buildPrefixSubtree builds the subtree holding the sorted appends, all of
+// which agree on their first depth bits. The returned node has a partial
+// prefix starting at bit depth and has already been hashed.
+func buildPrefixSubtree(appends []prefixAppend, depth int, parallel bool) (prefixNode, error) {
+	if len(appends) == 0 {
+		return nil, nil
+	}
+	first, last := appends[0].Prefix, appends[len(appends)-1].Prefix
+	if first.Len() <= depth {
+		return nil, errors.New("prefix is a prefix of another appended prefix")
+	}
+
+	// since appends are sorted, the first and last prefixes share the
+	// shortest common prefix of the whole range
+	end := first.CommonPrefixLength(last)
+
+	if end == first.Len() && end == last.Len() {
+		leaf := &leafNode{
+			values:        make([]KeyHash, 0, len(appends)),
+			partialPrefix: first.SliceFrom(depth),
+		}
+		for _, a := range appends {
+			leaf.values = append(leaf.values, KeyHash{a.Value, a.Pos})
+		}
+		leaf.updateHash()
+		return leaf, nil
+	}
+	if end == first.Len() || end == last.Len() {
+		return nil, errors.New("prefix is a prefix of another appended prefix")
+	}
+
+	node := &internalNode{
+		partialPrefix: first.Slice(depth, end),
+	}
+	left, right := splitSortedAppends(appends, end)
+	var leftChild, rightChild prefixNode
+	var leftErr, rightErr error
+	buildChildren(
+		func() { leftChild, leftErr = buildPrefixSubtree(left, end, parallel) },
+		func() { rightChild, rightErr = buildPrefixSubtree(right, end, parallel) },
+		parallel && len(appends) >= parallelBuildMinAppends,
+	)
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	if rightErr != nil {
+		return nil, rightErr
+	}
+	node.addChild(leftChild)
+	node.addChild(rightChild)
+	node.updateHash()
+	return node, nil
+}
+
+// splitSortedAppends splits sorted appends by their bit at index i.
+func splitSortedAppends(appends []prefixAppend, i int) (zeros []prefixAppend, ones []prefixAppend) {
+	split := sort.Search(len(appends), func(j int) bool {
+		return appends[j].Prefix.Len() > i && appends[j].Prefix.Bit(i) != 0
+	})
+	return appends[:split], appends[split:]
+}
+
+func buildChildren(buildLeft func(), buildRight func(), concurrently bool) {
+	if !concurrently {
+		buildLeft()
+		buildRight()
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		buildLeft()
+		wg.Done()
+	}()
+	buildRight()
+	wg.Wait()
+}
@@ -9,7 +9,7 @@ func (tree *prefixTree) copyFast() (*prefixTree, error) {
 			hash:          []byte(tree.root.hash),
 			leftChild:     nil,
 			rightChild:    nil,
-			partialPrefix: []byte(tree.root.partialPrefix),
+			partialPrefix: tree.root.partialPrefix,
 		},
 		isComplete: tree.isComplete,
 		appends:    make([]prefixAppend, len(tree.appends)),
@@ -50,7 +50,7 @@ func deepCopyInternalNode(parent prefixNode, node prefixNode) (prefixNode, error
 			parent:        parent,
 			hash:          []byte(node.getHash()),
 			values:        make([]KeyHash, 0, len(node.getValues())),
-			partialPrefix: []byte(node.getPartialPrefix()),
+			partialPrefix: node.getPartialPrefix(),
 		}
 		// fmt.Printf("num values: %d, size of values hash: %d\n", len(node.getValues()), len(node.getValues()[0].Hash))
 		ret.values = append(ret.values, node.getValues()...)
@@ -68,7 +68,7 @@ func deepCopyInternalNode(parent prefixNode, node prefixNode) (prefixNode, error
 		hash:          []byte(node.getHash()),
 		leftChild:     nil,
 		rightChild:    nil,
-		partialPrefix: []byte(node.getPartialPrefix()),
+		partialPrefix: node.getPartialPrefix(),
 	}
 
 	leftChild, err := deepCopyInternalNode(ret, node.getLeftChild())
@@ -98,7 +98,7 @@ func deepCopyInternalNodeIterative(parent prefixNode, node prefixNode) (prefixNo
 			parent:        parent,
 			hash:          []byte(node.getHash()),
 			values:        make([]KeyHash, 0, len(node.getValues())),
-			partialPrefix: []byte(node.getPartialPrefix()),
+			partialPrefix: node.getPartialPrefix(),
 		}
 		ret.values = append(ret.values, node.getValues()...)
 		for i := 0; i < len(ret.values); i++ {
@@ -115,7 +115,7 @@ func deepCopyInternalNodeIterative(parent prefixNode, node prefixNode) (prefixNo
 		hash:          []byte(node.getHash()),
 		leftChild:     nil,
 		rightChild:    nil,
-		partialPrefix: []byte(node.getPartialPrefix()),
+		partialPrefix: node.getPartialPrefix(),
 	}
 	clone := ret
 
@@ -152,7 +152,7 @@ func deepCopyInternalNodeIterative(parent prefixNode, node prefixNode) (prefixNo
 					hash:          []byte(child.getHash()),
 					leftChild:     nil,
 					rightChild:    nil,
-					partialPrefix: []byte(child.getPartialPrefix()),
+					partialPrefix: child.getPartialPrefix(),
 				}
 				clone.leftChild = internalNode
 
@@ -181,7 +181,7 @@ func deepCopyInternalNodeIterative(parent prefixNode, node prefixNode) (prefixNo
 					hash:          []byte(child.getHash()),
 					leftChild:     nil,
 					rightChild:    nil,
-					partialPrefix: []byte(child.getPartialPrefix()),
+					partialPrefix: child.getPartialPrefix(),
 				}
 				clone.rightChild = internalNode
 
@@ -206,7 +206,7 @@ func deepCopyInternalNodeIterative(parent prefixNode, node prefixNode) (prefixNo
 		hash:          []byte(node.getHash()),
 		leftChild:     nil,
 		rightChild:    nil,
-		partialPrefix: []byte(node.getPartialPrefix()),
+		partialPrefix: node.getPartialPrefix(),
 	} */
 	return ret, nil
 
@@ -217,7 +217,7 @@ func cloneLeafNode(leaf prefixNode, parent prefixNode) *leafNode {
 		parent:        parent,
 		hash:          []byte(leaf.getHash()),
 		values:        make([]KeyHash, 0, len(leaf.getValues())),
-		partialPrefix: []byte(leaf.getPartialPrefix()),
+		partialPrefix: leaf.getPartialPrefix(),
 	}
 	newChild.values = append(newChild.values, leaf.getValues()...)
 	return newChild
@@ -42,7 +42,7 @@ func TestPrefixAppendOnce(t *testing.T) {
 	if !leaf.isLeafNode() {
 		t.Error()
 	}
-	if !bytes.Equal(makePrefixFromLeaf(leaf, t), prefix) {
+	if !makePrefixFromLeaf(leaf, t).Equal(prefix) {
 		t.Error()
 	}
 	if !bytes.Equal(leaf.getValues()[0].Hash, valueHash) {
@@ -55,8 +55,8 @@ func TestPrefixAppendOnce(t *testing.T) {
 
 func TestPrefixAppendTwoKeys(t *testing.T) {
 	tree := NewPrefixTree()
-	prefix0 := []byte{0b0, 0b1}
-	prefix1 := []byte{0b0, 0b0}
+	prefix0 := PackBits([]byte{0b0, 0b1})
+	prefix1 := PackBits([]byte{0b0, 0b0})
 	valueHash0 := crypto.Hash([]byte{0b101})
 	valueHash1 := crypto.Hash([]byte{0b110})
 	pos0 := uint32(31)
@@ -66,7 +66,7 @@ func TestPrefixAppendTwoKeys(t *testing.T) {
 	tree.PrefixAppend(prefix1, valueHash1, pos1)
 	var leafL, leafR prefixNode
 
-	if prefix0[0] == prefix1[0] {
+	if prefix0.Bit(0) == prefix1.Bit(0) {
 		//For example,
 		//				root
 		//				/
@@ -100,16 +100,16 @@ func TestPrefixAppendTwoKeys(t *testing.T) {
 	prefixL := makePrefixFromLeaf(leafL, t)
 	prefixR := makePrefixFromLeaf(leafR, t)
 	var leaf0, leaf1 prefixNode
-	if bytes.Equal(prefixL, prefixR) {
+	if prefixL.Equal(prefixR) {
 		t.Error()
 	}
-	if bytes.Equal(prefixL, prefix0) {
-		if !bytes.Equal(prefixR, prefix1) {
+	if prefixL.Equal(prefix0) {
+		if !prefixR.Equal(prefix1) {
 			t.Error()
 		}
 		leaf0, leaf1 = leafL, leafR
-	} else if bytes.Equal(prefixL, prefix1) {
-		if !bytes.Equal(prefixR, prefix0) {
+	} else if prefixL.Equal(prefix1) {
+		if !prefixR.Equal(prefix0) {
 			t.Error()
 		}
 		leaf0, leaf1 = leafR, leafL
@@ -143,7 +143,7 @@ func TestPrefixAppendTwoValuesToOneKey(t *testing.T) {
 		t.Error()
 	}
 
-	if !bytes.Equal(prefix, makePrefixFromLeaf(leaf, t)) {
+	if !prefix.Equal(makePrefixFromLeaf(leaf, t)) {
 		t.Error()
 	}
 
@@ -168,7 +168,7 @@ func TestAppendMultiple(t *testing.T) {
 	tree, prefixes, valuesPerPrefix := prepareTestingTree(20, 3)
 	for i := range prefixes {
 		leaf := tree.getLeaf(prefixes[i])
-		if !bytes.Equal(prefixes[i], makePrefixFromLeaf(leaf, t)) {
+		if !prefixes[i].Equal(makePrefixFromLeaf(leaf, t)) {
 			t.Error()
 		}
 		if !bytes.Equal(leafHash(leaf.getPartialPrefix(), valuesPerPrefix[i]), leaf.getHash()) {
@@ -198,6 +198,41 @@ func TestPrefixMembershipProof(t *testing.T) {
 	}
 }
 
+func TestPrefixLeafValueProof(t *testing.T) {
+	tree, prefixes, _ := prepareTestingTree(20, 3)
+	for _, p := range prefixes {
+		membershipProof, leafValues := tree.generateMembershipProof(p)
+		newest := newLeafValueProof(leafValues)
+		if !bytes.Equal(computeRootHashMembershipForValue(p, membershipProof, newest), tree.root.hash) {
+			t.Errorf("proof of the newest of %d values does not verify", len(leafValues))
+		}
+		if !bytes.Equal(newest.Value.Hash, leafValues[len(leafValues)-1].Hash) {
+			t.Error("proof does not open the newest value")
+		}
+
+		// an older value is not the chain head, so it cannot stand in for
+		// the newest one
+		if len(leafValues) > 1 {
+			stale := &LeafValueProof{Value: leafValues[0]}
+			if bytes.Equal(computeRootHashMembershipForValue(p, membershipProof, stale), tree.root.hash) {
+				t.Error("proof of a superseded value verified")
+			}
+		}
+
+		tampered := *newest
+		tampered.Value = KeyHash{Hash: []byte("not in leaf"), Pos: newest.Value.Pos}
+		if bytes.Equal(computeRootHashMembershipForValue(p, membershipProof, &tampered), tree.root.hash) {
+			t.Error("proof of a value not in the leaf verified")
+		}
+
+		malformed := *newest
+		malformed.PrecedingDigest = []byte{1}
+		if computeRootHashMembershipForValue(p, membershipProof, &malformed) != nil {
+			t.Error("proof with a malformed digest should not produce a root")
+		}
+	}
+}
+
 func TestPrefixNonMembershipProof(t *testing.T) {
 	tree, prefixes, _ := prepareTestingTree(20, 3)
 
@@ -238,14 +273,12 @@ func getOnlyChild(node prefixNode, t *testing.T) prefixNode {
 	return nil
 }
 
-func makePrefixFromLeaf(node prefixNode, t *testing.T) (totalPrefix []byte) {
+func makePrefixFromLeaf(node prefixNode, t *testing.T) (totalPrefix BitString) {
 	if !node.isLeafNode() {
 		t.Error()
 	}
 	for node.getParent() != nil {
-		b := make([]byte, len(node.getPartialPrefix()))
-		copy(b, node.getPartialPrefix())
-		totalPrefix = append(b, totalPrefix...)
+		totalPrefix = node.getPartialPrefix().Append(totalPrefix)
 		node = node.getParent()
 	}
 	return
@@ -263,17 +296,17 @@ func generateRandomByteArray(size uint32) []byte {
 	return res
 }
 
-func getRandomPrefix() []byte {
+func getRandomPrefix() BitString {
 	return makePrefixFromKey(generateRandomByteArray(PREFIXVRFSIZE))
 }
 
-func getRandomPrefixNotInList(existingPrefixes [][]byte) (randPrefix []byte) {
+func getRandomPrefixNotInList(existingPrefixes []BitString) (randPrefix BitString) {
 	foundNonmemberPrefix := false
 	for foundNonmemberPrefix == false {
 		randPrefix = getRandomPrefix()
 		matched := false
 		for _, presentPrefix := range existingPrefixes {
-			if bytes.Equal(randPrefix, presentPrefix) {
+			if randPrefix.Equal(presentPrefix) {
 				foundNonmemberPrefix = true
 			}
 		}
@@ -284,7 +317,7 @@ func getRandomPrefixNotInList(existingPrefixes [][]byte) (randPrefix []byte) {
 	return
 }
 
-func prepareTestingTree(numKeys uint32, numValsPerKey uint32) (t *prefixTree, prefixes [][]byte, valsPerPrefix [][]KeyHash) {
+func prepareTestingTree(numKeys uint32, numValsPerKey uint32) (t *prefixTree, prefixes []BitString, valsPerPrefix [][]KeyHash) {
 	t = NewPrefixTree()
 	var val, key uint32
 
@@ -310,9 +343,9 @@ func TestPrefixTreeCopy(t *testing.T) {
 
 	prefix := NewPrefixTree()
 
-	prefix.PrefixAppend(ConvertBitsToBytes([]byte("vivian")), []byte("hash1"), 0)
-	prefix.PrefixAppend(ConvertBitsToBytes([]byte("akshay")), []byte("hash2"), 1)
-	prefix.PrefixAppend(ConvertBitsToBytes([]byte("akshit")), []byte("hash3"), 2)
+	prefix.PrefixAppend(NewBitString([]byte("vivian")), []byte("hash1"), 0)
+	prefix.PrefixAppend(NewBitString([]byte("akshay")), []byte("hash2"), 1)
+	prefix.PrefixAppend(NewBitString([]byte("akshit")), []byte("hash3"), 2)
 
 	result, err := prefix.copyFast()
 	if err != nil {
@@ -326,11 +359,49 @@ func TestPrefixTreeCopy(t *testing.T) {
 
 func TestPrefixTreeNonMembership(t *testing.T) {
 	tree := NewPrefixTree()
-	tree.PrefixAppend([]byte{0b0}, nil, 0)
-	proof := tree.generateNonMembershipProof([]byte{0b1})
-	computedRootHash := computeRootHashNonMembership([]byte{0b1}, proof)
+	tree.PrefixAppend(PackBits([]byte{0b0}), nil, 0)
+	proof := tree.generateNonMembershipProof(PackBits([]byte{0b1}))
+	computedRootHash := computeRootHashNonMembership(PackBits([]byte{0b1}), proof)
 	ok := bytes.Equal(computedRootHash, tree.getHash())
 	if !ok {
 		t.Error("Non-membership proof failed")
 	}
-}
\ No newline at end of file
+}
+func TestPrefixTreeFromAppendsMatchesPrefixAppend(t *testing.T) {
+	for _, numKeys := range []uint32{0, 1, 2, 20, 3000} {
+		expected, prefixes, valsPerPrefix := prepareTestingTree(numKeys, 3)
+
+		var appends []prefixAppend
+		for _, val := range []int{0, 1, 2} {
+			for i, p := range prefixes {
+				appends = append(appends, prefixAppend{p, valsPerPrefix[i][val].Hash, valsPerPrefix[i][val].Pos})
+			}
+		}
+
+		for _, parallel := range []bool{false, true} {
+			tree, err := newPrefixTreeFromAppends(appends, parallel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tree.getHash(), expected.getHash()) {
+				t.Errorf("%d keys, parallel %t: root hash %x, expected %x", numKeys, parallel, tree.getHash(), expected.getHash())
+			}
+			for _, p := range prefixes {
+				proof, leafValues := tree.generateMembershipProof(p)
+				if proof == nil || !bytes.Equal(computeRootHashMembership(p, proof, leafValues), expected.getHash()) {
+					t.Errorf("%d keys, parallel %t: membership proof failed", numKeys, parallel)
+				}
+			}
+		}
+	}
+}
+
+func TestPrefixTreeFromAppendsRejectsNestedPrefixes(t *testing.T) {
+	appends := []prefixAppend{
+		{PackBits([]byte{0, 1}), []byte("hash1"), 0},
+		{PackBits([]byte{0, 1, 1}), []byte("hash2"), 0},
+	}
+	if _, err := newPrefixTreeFromAppends(appends, false); err == nil {
+		t.Error("expected an error for a prefix nested inside another")
+	}
+}
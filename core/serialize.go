@@ -45,19 +45,19 @@ type JSONInternalNode struct {
 
 // JSONPrefixLeafNode representation
 type JSONPrefixLeafNode struct {
-	Hash          []byte `json:"hash"`
-	Values        []byte `json:"values"`
-	PartialPrefix []byte `json:"partialPrefix"`
+	Hash          []byte    `json:"hash"`
+	Values        []byte    `json:"values"`
+	PartialPrefix BitString `json:"partialPrefix"`
 }
 
 // JSONPrefixInternalNode representation
 type JSONPrefixInternalNode struct {
-	Hash          []byte `json:"hash"`
-	LeftChild     []byte `json:"leftChild"`
-	RightChild    []byte `json:"rightChild"`
-	PartialPrefix []byte `json:"partialPrefix"`
-	LeftLeaf      bool   `json:"leftLeaf"`
-	RightLeaf     bool   `json:"rightLeaf"`
+	Hash          []byte    `json:"hash"`
+	LeftChild     []byte    `json:"leftChild"`
+	RightChild    []byte    `json:"rightChild"`
+	PartialPrefix BitString `json:"partialPrefix"`
+	LeftLeaf      bool      `json:"leftLeaf"`
+	RightLeaf     bool      `json:"rightLeaf"`
 }
 
 //*******************************
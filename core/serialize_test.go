@@ -127,7 +127,7 @@ func TestPrefixLeafNodeSerialization(t *testing.T) {
 			Hash: []byte("2"),
 			Pos:  3,
 		}},
-		partialPrefix: []byte("4"),
+		partialPrefix: NewBitString([]byte("4")),
 	}
 
 	buf, err := leafNode.serialize()
@@ -153,7 +153,7 @@ func TestPrefixInternalNodeSerialization(t *testing.T) {
 			Hash: []byte("2"),
 			Pos:  3,
 		}},
-		partialPrefix: []byte("4"),
+		partialPrefix: NewBitString([]byte("4")),
 	}
 
 	leafNode1 := &leafNode{
@@ -162,14 +162,14 @@ func TestPrefixInternalNodeSerialization(t *testing.T) {
 			Hash: []byte("6"),
 			Pos:  7,
 		}},
-		partialPrefix: []byte("8"),
+		partialPrefix: NewBitString([]byte("8")),
 	}
 
 	internalNode := &internalNode{
 		hash:          []byte("9"),
 		leftChild:     leafNode0,
 		rightChild:    leafNode1,
-		partialPrefix: []byte("10"),
+		partialPrefix: NewBitString([]byte("10")),
 	}
 
 	leafNode0.setParent(internalNode)
@@ -194,9 +194,9 @@ func TestPrefixTreeSerialization(t *testing.T) {
 
 	prefix := NewPrefixTree()
 
-	prefix.PrefixAppend(ConvertBitsToBytes([]byte("kian")), []byte("hash1"), 0)
-	prefix.PrefixAppend(ConvertBitsToBytes([]byte("yuncong")), []byte("hash2"), 1)
-	prefix.PrefixAppend(ConvertBitsToBytes([]byte("raluca")), []byte("hash3"), 2)
+	prefix.PrefixAppend(NewBitString([]byte("kian")), []byte("hash1"), 0)
+	prefix.PrefixAppend(NewBitString([]byte("yuncong")), []byte("hash2"), 1)
+	prefix.PrefixAppend(NewBitString([]byte("raluca")), []byte("hash3"), 2)
 
 	buf, err := prefix.serialize()
 	if err != nil {
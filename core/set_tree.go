@@ -16,12 +16,12 @@ type SetTree struct {
 	prefix_len int
 }
 
-func NewSetTree(prefixes [][]byte, valHashes [][]byte) (res *SetTree, err error) {
+func NewSetTree(prefixes []BitString, valHashes [][]byte) (res *SetTree, err error) {
 	if len(prefixes) != len(valHashes) {
 		err = errors.New("prefixes and valHashes are of unequal length")
 	}
 	//prefixLen := int(math.Ceil(math.Log2(float64(len(prefixes)))))
-	prefixLen := len(prefixes[0])
+	prefixLen := prefixes[0].Len()
 
 	// prefixes = [H(identifier1), H(identifier2), ...]
 	res = &SetTree{
@@ -30,14 +30,14 @@ func NewSetTree(prefixes [][]byte, valHashes [][]byte) (res *SetTree, err error)
 	}
 
 	for i := range prefixes {
-		err = res.tree.PrefixAppend(prefixes[i][:prefixLen], valHashes[i], 1)
+		err = res.tree.PrefixAppend(prefixes[i].Slice(0, prefixLen), valHashes[i], 1)
 		if err != nil {
 			return
 		}
 	}
-	frontier := computeFrontier(res.tree.root, []byte{}, 1, prefixLen) // TODO: Check depth is not off-by-one here
+	frontier := computeFrontier(res.tree.root, BitString{}, 1, prefixLen) // TODO: Check depth is not off-by-one here
 	for _, item := range frontier {
-		err = res.tree.PrefixAppend(item, item, 0)
+		err = res.tree.PrefixAppend(item, item.Unpack(), 0)
 		if err != nil {
 			return
 		}
@@ -45,33 +45,34 @@ func NewSetTree(prefixes [][]byte, valHashes [][]byte) (res *SetTree, err error)
 	return
 }
 
-func computeFrontier(curr prefixNode, prefixSoFar []byte, depth int, maxDepth int) (res [][]byte) {
+func computeFrontier(curr prefixNode, prefixSoFar BitString, depth int, maxDepth int) (res []BitString) {
 	if depth == maxDepth {
 		return
 	}
 	partialPrefix := curr.getPartialPrefix()
-	if len(partialPrefix) > 1 {
+	if partialPrefix.Len() > 1 {
 		// We are in the compressed part of the prefix tree
-		for _, bit := range partialPrefix {
+		for i := 0; i < partialPrefix.Len(); i++ {
+			bit := partialPrefix.Bit(i)
 			// The other bit is a part of the frontier
 			frontierBit := 1 - bit
-			frontierHash := append(prefixSoFar, frontierBit)
+			frontierHash := prefixSoFar.AppendBit(frontierBit)
 			res = append(res, frontierHash)
 
-			prefixSoFar = append(prefixSoFar, bit)
+			prefixSoFar = prefixSoFar.AppendBit(bit)
 		}
-		depth += len(partialPrefix) - 1
-	} else if len(partialPrefix) == 1 {
-		prefixSoFar = append(prefixSoFar, partialPrefix[0])
+		depth += partialPrefix.Len() - 1
+	} else if partialPrefix.Len() == 1 {
+		prefixSoFar = prefixSoFar.Append(partialPrefix)
 	}
 	if curr.getLeftChild() == nil {
-		frontierHash := append(prefixSoFar, 0)
+		frontierHash := prefixSoFar.AppendBit(0)
 		res = append(res, frontierHash)
 	} else {
 		computeFrontier(curr.getLeftChild(), prefixSoFar, depth+1, maxDepth)
 	}
 	if curr.getRightChild() == nil {
-		frontierHash := append(prefixSoFar, 1)
+		frontierHash := prefixSoFar.AppendBit(1)
 		res = append(res, frontierHash)
 	} else {
 		computeFrontier(curr.getRightChild(), prefixSoFar, depth+1, maxDepth)
@@ -79,39 +80,39 @@ func computeFrontier(curr prefixNode, prefixSoFar []byte, depth int, maxDepth in
 	return
 }
 
-func (s *SetTree) ProveExistence(prefix []byte) (proof *MembershipProof, leafValues []KeyHash) {
-	prefix = prefix[:s.prefix_len]
+func (s *SetTree) ProveExistence(prefix BitString) (proof *MembershipProof, leafValues []KeyHash) {
+	prefix = prefix.Slice(0, s.prefix_len)
 	return s.tree.generateMembershipProof(prefix)
 }
 
-func (s *SetTree) ProveNonExistence(prefix []byte) (proof *MembershipProof, leafValues []KeyHash) {
+func (s *SetTree) ProveNonExistence(prefix BitString) (proof *MembershipProof, leafValues []KeyHash) {
 	/*
 		XXX: This probably doesn't need any special logic for handling compression because computeFrontier should expand
 			any compressed nodes that are not in the set.
 	*/
 	var curr prefixNode = s.tree.root
-	var prefixToProve []byte
-	for _, bit := range prefix {
+	provedBits := 0
+	for ; provedBits < prefix.Len(); provedBits++ {
 		if curr.getRightChild() == nil && curr.getLeftChild() == nil {
 			break
 		}
-		if bit == 0 {
+		if prefix.Bit(provedBits) == 0 {
 			curr = curr.getLeftChild()
 		} else {
 			curr = curr.getRightChild()
 		}
-		prefixToProve = append(prefixToProve, bit)
 	}
+	prefixToProve := prefix.Slice(0, provedBits)
 	fmt.Println("prefixToProve", prefixToProve)
 	return s.tree.generateMembershipProof(prefixToProve)
 }
 
-func (s *SetTree) HasKey(prefix []byte) bool {
-	prefix = prefix[:s.prefix_len]
+func (s *SetTree) HasKey(prefix BitString) bool {
+	prefix = prefix.Slice(0, s.prefix_len)
 	return s.tree.getLeaf(prefix) != nil
 }
 
-func (s *SetTree) HasValue(prefix []byte, value []byte) bool {
+func (s *SetTree) HasValue(prefix BitString, value []byte) bool {
 	if !s.HasKey(prefix) {
 		return false
 	}
@@ -125,7 +126,7 @@ func (s *SetTree) HasValue(prefix []byte, value []byte) bool {
 
 }
 
-func (s *SetTree) GetLeaf(prefix []byte) prefixNode {
-	prefix = prefix[:s.prefix_len]
+func (s *SetTree) GetLeaf(prefix BitString) prefixNode {
+	prefix = prefix.Slice(0, s.prefix_len)
 	return s.tree.getLeaf(prefix)
 }
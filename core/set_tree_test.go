@@ -6,7 +6,7 @@ import (
 )
 
 func TestSimpleSetTree(t *testing.T) {
-	prefixes := [][]byte{{0b0, 0b0}, {0b0, 0b1}, {0b1, 0b0}, {0b1, 0b1}}
+	prefixes := packAll([][]byte{{0b0, 0b0}, {0b0, 0b1}, {0b1, 0b0}, {0b1, 0b1}})
 	valHashes := [][]byte{{0b0, 0b0}, {0b0, 0b1}, {0b1, 0b0}, {0b1, 0b1}}
 	setTree, _ := NewSetTree(prefixes, valHashes)
 
@@ -14,7 +14,7 @@ func TestSimpleSetTree(t *testing.T) {
 }
 
 func TestConflict(t *testing.T) {
-	prefixes := [][]byte{{0b0}, {0b0}}
+	prefixes := packAll([][]byte{{0b0}, {0b0}})
 	valHashes := [][]byte{{0b0, 0b0}, {0b0, 0b1}}
 	setTree, _ := NewSetTree(prefixes, valHashes)
 
@@ -22,14 +22,14 @@ func TestConflict(t *testing.T) {
 }
 
 func TestCompressed(t *testing.T) {
-	prefixes := [][]byte{{0b0, 0b0, 0b1}, {0b0, 0b0, 0b0}}
+	prefixes := packAll([][]byte{{0b0, 0b0, 0b1}, {0b0, 0b0, 0b0}})
 	valHashes := [][]byte{{0b0, 0b0}, {0b0, 0b1}}
 	setTree, _ := NewSetTree(prefixes, valHashes)
 
 	CheckSetTreeConstruction(t, setTree, prefixes, valHashes)
 }
 func TestLongerPrefix(t *testing.T) {
-	prefixes := [][]byte{{0b0, 0b1}, {0b1, 0b0}}
+	prefixes := packAll([][]byte{{0b0, 0b1}, {0b1, 0b0}})
 	valHashes := [][]byte{{0b0, 0b0}, {0b0, 0b1}}
 	setTree, _ := NewSetTree(prefixes, valHashes)
 
@@ -37,8 +37,8 @@ func TestLongerPrefix(t *testing.T) {
 }
 
 func TestFullHashLengthPrefixes(t *testing.T) {
-	prefixes := [][]byte{{0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1},
-		{0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0}}
+	prefixes := packAll([][]byte{{0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1},
+		{0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0}})
 	valHashes := [][]byte{{0b0, 0b0}, {0b0, 0b1}}
 	setTree, _ := NewSetTree(prefixes, valHashes)
 
@@ -46,7 +46,7 @@ func TestFullHashLengthPrefixes(t *testing.T) {
 }
 
 func TestAnotherFullHashLengthPrefixes(t *testing.T) {
-	prefixes := [][]byte{{0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1}, {0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1}}
+	prefixes := packAll([][]byte{{0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1}, {0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1}})
 	valHashes := [][]byte{{0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1}, {0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b1, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b1, 0b0, 0b0, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b1, 0b1, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b1, 0b1, 0b1, 0b0, 0b0, 0b1, 0b0, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b1, 0b1, 0b0, 0b0, 0b0, 0b1, 0b0, 0b1, 0b0, 0b0}}
 	setTree, _ := NewSetTree(prefixes, valHashes)
 
@@ -55,7 +55,7 @@ func TestAnotherFullHashLengthPrefixes(t *testing.T) {
 
 // TODO: fix the test below
 /* func TestSingleEntry(t *testing.T) {
-	prefixes := [][]byte{{0b1, 0b0}}
+	prefixes := packAll([][]byte{{0b1, 0b0}})
 	valHashes := [][]byte{{0b0, 0b0}}
 	setTree, _ := NewSetTree(prefixes, valHashes)
 
@@ -63,16 +63,25 @@ func TestAnotherFullHashLengthPrefixes(t *testing.T) {
 } */
 
 /* Not a test, just a helper function */
-func CheckSetTreeConstruction(t *testing.T, setTree *SetTree, prefixes [][]byte, valHashes [][]byte) {
+func packAll(prefixes [][]byte) []BitString {
+	packed := make([]BitString, len(prefixes))
+	for i, prefix := range prefixes {
+		packed[i] = PackBits(prefix)
+	}
+	return packed
+}
+
+/* Not a test, just a helper function */
+func CheckSetTreeConstruction(t *testing.T, setTree *SetTree, prefixes []BitString, valHashes [][]byte) {
 	for _, prefix := range prefixes {
 		if !setTree.HasKey(prefix) {
-			t.Errorf("Set tree does not have key %b", prefix)
+			t.Errorf("Set tree does not have key %s", prefix)
 		}
 	}
 
 	for i, prefix := range prefixes {
 		if !setTree.HasKey(prefix) {
-			t.Errorf("Set tree does not have key %b", prefix)
+			t.Errorf("Set tree does not have key %s", prefix)
 			continue
 		}
 		found := false
@@ -102,17 +111,17 @@ func TestNonexistence(t *testing.T) {
 		   0        1
 		  0          1
 	*/
-	prefixes := [][]byte{{0b0, 0b0, 0b0}, {0b1, 0b1, 0b1}, {0b0, 0b0, 0b1}}
+	prefixes := packAll([][]byte{{0b0, 0b0, 0b0}, {0b1, 0b1, 0b1}, {0b0, 0b0, 0b1}})
 	valHashes := [][]byte{{0b0, 0b0, 0b0}, {0b0, 0b0, 0b01}, {0b0, 0b1, 0b0}}
 	setTree, _ := NewSetTree(prefixes, valHashes)
 
 	CheckSetTreeConstruction(t, setTree, prefixes, valHashes)
 
-	prefix := []byte{0b0, 0b1, 0b1}
+	prefix := PackBits([]byte{0b0, 0b1, 0b1})
 
 	proof, leafValues := setTree.ProveNonExistence(prefix)
 	_ = leafValues
 	if proof == nil {
-		t.Errorf("Prove for prefix %b was nil", prefix)
+		t.Errorf("Prove for prefix %s was nil", prefix)
 	}
 }
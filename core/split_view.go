@@ -0,0 +1,81 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	libcrypto "github.com/huyuncong/MerkleSquare/lib/crypto"
+	"github.com/immesys/bw2/crypto"
+)
+
+// SignedDigest is a digest of a partition as the server published it, with
+// the server's signature over it. Signed digests are what auditors and
+// clients gossip, so that a server showing them different digests can be
+// held to both.
+type SignedDigest struct {
+	Digest    *LegologDigest
+	Signature []byte
+}
+
+// signedDigestMessage is what the server signs. The digest hash covers the
+// partition binding, the epoch and the verification period.
+func signedDigestMessage(digest *LegologDigest) []byte {
+	return libcrypto.Hash([]byte("legolog published digest"), digest.Hash())
+}
+
+// SignDigest signs digest as the server with key pair (serverSK, serverVK).
+func SignDigest(serverSK []byte, serverVK []byte, digest *LegologDigest) *SignedDigest {
+	signature := make([]byte, 64)
+	crypto.SignBlob(serverSK, serverVK, signature, signedDigestMessage(digest))
+	return &SignedDigest{Digest: digest, Signature: signature}
+}
+
+// Verify checks that the server with key serverVK signed the digest.
+func (s *SignedDigest) Verify(serverVK []byte) bool {
+	if s.Digest == nil || len(serverVK) != 32 || len(s.Signature) != 64 {
+		return false
+	}
+	return crypto.VerifyBlob(serverVK, s.Signature, signedDigestMessage(s.Digest))
+}
+
+// ConflictsWith reports whether s and other are different digests published
+// for the same partition of the same log in the same epoch and verification
+// period. An honest server publishes exactly one digest for each.
+func (s *SignedDigest) ConflictsWith(other *SignedDigest) bool {
+	return checkSplitView(s.Digest, other.Digest) == nil
+}
+
+// NewSplitViewEvidence returns evidence that the server published both a and
+// b, which conflict. The digests are ordered by hash, so that the same split
+// view reported by different parties gives the same evidence.
+func NewSplitViewEvidence(a *SignedDigest, b *SignedDigest) *MisbehaviorEvidence {
+	if bytes.Compare(a.Digest.Hash(), b.Digest.Hash()) > 0 {
+		a, b = b, a
+	}
+	return &MisbehaviorEvidence{
+		PartitionIndex: a.Digest.Binding.PartitionIndex,
+		OldDigest:      a.Digest,
+		NewDigest:      b.Digest,
+		SplitView:      true,
+		OldSignature:   a.Signature,
+		NewSignature:   b.Signature,
+	}
+}
+
+func checkSplitView(a *LegologDigest, b *LegologDigest) error {
+	if a == nil || b == nil {
+		return errors.New("split view is missing a digest")
+	}
+	if !bytes.Equal(a.Binding.Hash(), b.Binding.Hash()) {
+		return errors.New("digests are bound to different partitions")
+	}
+	if a.Epoch != b.Epoch || a.VerificationPeriod != b.VerificationPeriod {
+		return fmt.Errorf("digests are for epoch %d in verification period %d and epoch %d in verification period %d",
+			a.Epoch, a.VerificationPeriod, b.Epoch, b.VerificationPeriod)
+	}
+	if bytes.Equal(a.Hash(), b.Hash()) {
+		return errors.New("digests are the same")
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// UpdateLogCheckpoint is the state of a partition's update log when a
+// verification period ended.
+type UpdateLogCheckpoint struct {
+	VerificationPeriod uint64
+	Root               []byte
+	Size               uint32
+}
+
+// UpdateLogChainProof proves that a partition's update log got from the one
+// in an old digest to the one in a new digest through its checkpoint at the
+// end of every verification period in between. Proofs[i] shows that
+// Checkpoints[i] extends the update log before it, and the last proof shows
+// that the new digest extends the last checkpoint.
+type UpdateLogChainProof struct {
+	Checkpoints []UpdateLogCheckpoint
+	Proofs      []*MerkleExtensionProof
+}
+
+// UpdateLogChainProver is implemented by partitions that can prove how their
+// update log grew across verification periods.
+type UpdateLogChainProver interface {
+	GenerateUpdateLogChainProof(oldPeriod uint64, oldSize uint32, newPeriod uint64, newSize uint32) (*UpdateLogChainProof, error)
+}
+
+var _ UpdateLogChainProver = (*Partition)(nil)
+var _ UpdateLogChainProver = (*AggHistPartition)(nil)
+
+// updateLog is a partition's update log. It is never reset, so that it can
+// prove consistency between any two of its past sizes.
+type updateLog struct {
+	tree        *ChronTree
+	checkpoints []UpdateLogCheckpoint
+}
+
+func newUpdateLog() updateLog {
+	return updateLog{tree: NewChronTree()}
+}
+
+func (l *updateLog) append(epoch uint64, updateSetRoot []byte) {
+	l.tree.Append([]byte(strconv.FormatUint(epoch, 10)), updateSetRoot, []byte(""))
+}
+
+func (l *updateLog) root() []byte {
+	return l.tree.GetRootHash()
+}
+
+func (l *updateLog) size() uint32 {
+	return l.tree.numNodes
+}
+
+// endPeriod records the update log as it is at the end of the verification
+// period.
+func (l *updateLog) endPeriod(verificationPeriod uint64) {
+	l.checkpoints = append(l.checkpoints, UpdateLogCheckpoint{
+		VerificationPeriod: verificationPeriod,
+		Root:               l.root(),
+		Size:               l.size(),
+	})
+}
+
+func (l *updateLog) consistencyProof(oldSize uint32, newSize uint32) *MerkleExtensionProof {
+	if newSize < oldSize || newSize > l.size() {
+		return &MerkleExtensionProof{}
+	}
+	return l.tree.GenerateConsistencyProof(oldSize, newSize)
+}
+
+func (l *updateLog) generateChainProof(oldPeriod uint64, oldSize uint32, newPeriod uint64, newSize uint32) (*UpdateLogChainProof, error) {
+	if newPeriod < oldPeriod || newSize < oldSize {
+		return nil, errors.New("new update log precedes the old one")
+	}
+	if newSize > l.size() {
+		return nil, fmt.Errorf("update log has %d epochs, not %d", l.size(), newSize)
+	}
+	proof := &UpdateLogChainProof{}
+	size := oldSize
+	for _, checkpoint := range l.checkpoints {
+		if checkpoint.VerificationPeriod < oldPeriod || checkpoint.VerificationPeriod >= newPeriod {
+			continue
+		}
+		if checkpoint.Size < size || checkpoint.Size > newSize {
+			return nil, fmt.Errorf("update log had %d epochs at the end of verification period %d, which is out of range",
+				checkpoint.Size, checkpoint.VerificationPeriod)
+		}
+		proof.Checkpoints = append(proof.Checkpoints, checkpoint)
+		proof.Proofs = append(proof.Proofs, l.consistencyProof(size, checkpoint.Size))
+		size = checkpoint.Size
+	}
+	if uint64(len(proof.Checkpoints)) != newPeriod-oldPeriod {
+		return nil, fmt.Errorf("no update log checkpoints for verification periods %d to %d", oldPeriod, newPeriod)
+	}
+	proof.Proofs = append(proof.Proofs, l.consistencyProof(size, newSize))
+	return proof, nil
+}
+
+// VerifyUpdateLogChain checks that proof gets the update log of oldDigest to
+// that of newDigest through one checkpoint for each verification period that
+// ended in between.
+func VerifyUpdateLogChain(oldDigest *LegologDigest, newDigest *LegologDigest, proof *UpdateLogChainProof) error {
+	if newDigest.VerificationPeriod < oldDigest.VerificationPeriod {
+		return errors.New("new digest precedes the old one")
+	}
+	if uint64(len(proof.Checkpoints)) != newDigest.VerificationPeriod-oldDigest.VerificationPeriod ||
+		len(proof.Proofs) != len(proof.Checkpoints)+1 {
+		return fmt.Errorf("expected a checkpoint for each of the %d verification periods in between",
+			newDigest.VerificationPeriod-oldDigest.VerificationPeriod)
+	}
+	states := []UpdateLogCheckpoint{{oldDigest.VerificationPeriod, oldDigest.UpdateLogRoot, oldDigest.UpdateLogSize}}
+	states = append(states, proof.Checkpoints...)
+	states = append(states, UpdateLogCheckpoint{newDigest.VerificationPeriod, newDigest.UpdateLogRoot, newDigest.UpdateLogSize})
+	for i, extensionProof := range proof.Proofs {
+		from, to := states[i], states[i+1]
+		if i < len(proof.Checkpoints) && to.VerificationPeriod != oldDigest.VerificationPeriod+uint64(i) {
+			return fmt.Errorf("checkpoint %d is for verification period %d, expected %d",
+				i, to.VerificationPeriod, oldDigest.VerificationPeriod+uint64(i))
+		}
+		if to.Size < from.Size {
+			return fmt.Errorf("update log shrank from %d to %d epochs in verification period %d", from.Size, to.Size, to.VerificationPeriod)
+		}
+		if !VerifyConsistencyProof(
+			&Digest{Roots: [][]byte{from.Root}, Size: from.Size},
+			&Digest{Roots: [][]byte{to.Root}, Size: to.Size},
+			copyExtensionProof(extensionProof),
+		) {
+			return fmt.Errorf("update log of verification period %d does not extend that of period %d", to.VerificationPeriod, from.VerificationPeriod)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestUpdateLogChain(t *testing.T) {
+	for _, cfg := range []Config{{}, {AggHistory: true, AggHistoryDepth: 31}} {
+		var partition LegoLogPartition = NewPartition()
+		if cfg.AggHistory {
+			partition = NewAggHistPartition(cfg, "")
+		}
+		prover := partition.(UpdateLogChainProver)
+
+		digests := []*LegologDigest{partition.GetDigest()}
+		for i := 0; i < 8; i++ {
+			key := []byte(strconv.Itoa(i))
+			partition.Append(key, key, key, key)
+			partition.IncrementUpdateEpoch()
+			if i%3 != 1 {
+				partition.IncrementVerificationPeriod()
+			}
+			if i == 4 {
+				// a verification period without any epochs
+				partition.IncrementVerificationPeriod()
+			}
+			digests = append(digests, partition.GetDigest())
+		}
+
+		// auditors that missed any number of periods can catch up
+		for i, oldDigest := range digests {
+			for _, newDigest := range digests[i:] {
+				proof, err := prover.GenerateUpdateLogChainProof(oldDigest.VerificationPeriod, oldDigest.UpdateLogSize,
+					newDigest.VerificationPeriod, newDigest.UpdateLogSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := VerifyUpdateLogChain(oldDigest, newDigest, proof); err != nil {
+					t.Fatalf("agg history %t: periods %d to %d: %v", cfg.AggHistory, oldDigest.VerificationPeriod, newDigest.VerificationPeriod, err)
+				}
+			}
+		}
+
+		// the update log is never reset, so a single proof spans periods too
+		first, last := digests[1], digests[len(digests)-1]
+		if !VerifyUpdateLogExtension(first, last, partition.GetUpdateEpochConsistencyProof(first.UpdateLogSize)) {
+			t.Errorf("agg history %t: update log does not extend across verification periods", cfg.AggHistory)
+		}
+
+		proof, _ := prover.GenerateUpdateLogChainProof(first.VerificationPeriod, first.UpdateLogSize,
+			last.VerificationPeriod, last.UpdateLogSize)
+		skipped := &UpdateLogChainProof{
+			Checkpoints: append([]UpdateLogCheckpoint{}, proof.Checkpoints[1:]...),
+			Proofs:      append([]*MerkleExtensionProof{}, proof.Proofs[1:]...),
+		}
+		if err := VerifyUpdateLogChain(first, last, skipped); err == nil {
+			t.Errorf("agg history %t: update log chain with a missing period verified", cfg.AggHistory)
+		}
+		forged := &UpdateLogChainProof{
+			Checkpoints: append([]UpdateLogCheckpoint{}, proof.Checkpoints...),
+			Proofs:      proof.Proofs,
+		}
+		forged.Checkpoints[1].Root = []byte("forged")
+		if err := VerifyUpdateLogChain(first, last, forged); err == nil {
+			t.Errorf("agg history %t: update log chain with a forged checkpoint verified", cfg.AggHistory)
+		}
+		if _, err := prover.GenerateUpdateLogChainProof(last.VerificationPeriod, last.UpdateLogSize,
+			first.VerificationPeriod, first.UpdateLogSize); err == nil {
+			t.Errorf("agg history %t: proved a rewound update log", cfg.AggHistory)
+		}
+	}
+}
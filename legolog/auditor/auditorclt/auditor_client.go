@@ -16,7 +16,21 @@ type Client interface {
 	// GetEpochUpdate fetches latest verified checkpoint from the auditor.
 	//GetEpochUpdate(ctx context.Context) (*legolog_grpcint.GetEpochUpdateResponse, error)
 	GetEpochUpdate(ctx context.Context) ([]*core.LegologDigest, []uint64, []uint64, error)
-	GetEpochUpdateForPartition(ctx context.Context, partition uint64) (*core.LegologDigest, uint64, uint64, error)
+	// GetEpochUpdateForPartition fetches the latest verified digest of a
+	// partition, with the global digest it was verified under and the proof
+	// of its inclusion there.
+	GetEpochUpdateForPartition(ctx context.Context, partition uint64) (*core.LegologDigest, *core.GlobalDigest, *core.PartitionInclusionProof, error)
+	// GetGlobalDigest fetches the latest global digest verified by the auditor.
+	GetGlobalDigest(ctx context.Context) (*core.GlobalDigest, error)
+	// GetMisbehaviorReports fetches the evidence of server misbehavior the
+	// auditor has collected, from report startIndex on.
+	GetMisbehaviorReports(ctx context.Context, startIndex uint64) ([]*core.MisbehaviorEvidence, error)
+	// GetCosignedCheckpoint fetches the latest digest of a partition verified
+	// by the auditor, and its cosignature of it as a witness.
+	GetCosignedCheckpoint(ctx context.Context, partition uint64) (*core.LegologDigest, *core.Cosignature, error)
+	// CosignCheckpoint asks the auditor to cosign a digest of a partition,
+	// which it only does if it has verified the digest itself.
+	CosignCheckpoint(ctx context.Context, partition uint64, digest *core.LegologDigest) (*core.Cosignature, error)
 }
 
 // assert that auditorClient implements auditorclt.Client interfact
@@ -54,16 +68,104 @@ func (a *auditorClient) GetEpochUpdate(ctx context.Context) (digests []*core.Leg
 	return
 }
 
-// GetEpochUpdateForPartition fetches latest verified checkpoint from the auditor for a given partition.
-func (a *auditorClient) GetEpochUpdateForPartition(ctx context.Context, partition uint64) (*core.LegologDigest, uint64, uint64, error) {
+// GetEpochUpdateForPartition fetches latest verified checkpoint from the auditor for a given partition,
+// with the global digest it was verified under and the proof of its inclusion there.
+func (a *auditorClient) GetEpochUpdateForPartition(ctx context.Context, partition uint64) (
+	*core.LegologDigest, *core.GlobalDigest, *core.PartitionInclusionProof, error) {
 	resp, err := a.client.GetEpochUpdateForPartition(ctx, &legolog_grpcint.GetEpochUpdateForPartitionRequest{Partition: partition})
 	if err != nil {
-		return nil, 0, 0, err
+		return nil, nil, nil, err
 	}
-	cp := resp.GetCkPoint()
 	var digest core.LegologDigest
-	json.Unmarshal(cp.GetMarshaledDigest(), &digest)
-	numLeaves := cp.GetNumLeaves()
-	epoch := cp.GetEpoch()
-	return &digest, numLeaves, epoch, nil
+	err = json.Unmarshal(resp.GetCkPoint().GetMarshaledDigest(), &digest)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var globalDigest core.GlobalDigest
+	err = json.Unmarshal(resp.GetMarshaledGlobalDigest(), &globalDigest)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var proof core.PartitionInclusionProof
+	err = json.Unmarshal(resp.GetPartitionProof(), &proof)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &digest, &globalDigest, &proof, nil
+}
+
+// GetGlobalDigest fetches the latest global digest verified by the auditor.
+func (a *auditorClient) GetGlobalDigest(ctx context.Context) (*core.GlobalDigest, error) {
+	resp, err := a.client.GetGlobalDigest(ctx, &legolog_grpcint.GetGlobalDigestRequest{})
+	if err != nil {
+		return nil, err
+	}
+	var globalDigest core.GlobalDigest
+	err = json.Unmarshal(resp.GetMarshaledGlobalDigest(), &globalDigest)
+	if err != nil {
+		return nil, err
+	}
+	return &globalDigest, nil
+}
+
+// GetMisbehaviorReports fetches the evidence of server misbehavior the auditor
+// has collected, from report startIndex on. Callers should check each report
+// with MisbehaviorEvidence.Verify.
+func (a *auditorClient) GetMisbehaviorReports(ctx context.Context, startIndex uint64) ([]*core.MisbehaviorEvidence, error) {
+	resp, err := a.client.GetMisbehaviorReports(ctx, &legolog_grpcint.GetMisbehaviorReportsRequest{StartIndex: startIndex})
+	if err != nil {
+		return nil, err
+	}
+	var reports []*core.MisbehaviorEvidence
+	for _, marshaledReport := range resp.GetMarshaledReports() {
+		var report core.MisbehaviorEvidence
+		err = json.Unmarshal(marshaledReport, &report)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, &report)
+	}
+	return reports, nil
+}
+
+// GetCosignedCheckpoint fetches the latest digest of a partition verified by
+// the auditor, and its cosignature of it as a witness.
+func (a *auditorClient) GetCosignedCheckpoint(ctx context.Context, partition uint64) (*core.LegologDigest, *core.Cosignature, error) {
+	resp, err := a.client.GetCosignedCheckpoint(ctx, &legolog_grpcint.GetCosignedCheckpointRequest{Partition: partition})
+	if err != nil {
+		return nil, nil, err
+	}
+	var digest core.LegologDigest
+	err = json.Unmarshal(resp.GetMarshaledDigest(), &digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	var cosignature core.Cosignature
+	err = json.Unmarshal(resp.GetMarshaledCosignature(), &cosignature)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &digest, &cosignature, nil
+}
+
+// CosignCheckpoint asks the auditor to cosign a digest of a partition, which
+// it only does if it has verified the digest itself.
+func (a *auditorClient) CosignCheckpoint(ctx context.Context, partition uint64, digest *core.LegologDigest) (*core.Cosignature, error) {
+	marshaledDigest, err := json.Marshal(digest)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.CosignCheckpoint(ctx, &legolog_grpcint.CosignCheckpointRequest{
+		Partition:       partition,
+		MarshaledDigest: marshaledDigest,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var cosignature core.Cosignature
+	err = json.Unmarshal(resp.GetMarshaledCosignature(), &cosignature)
+	if err != nil {
+		return nil, err
+	}
+	return &cosignature, nil
 }
@@ -3,29 +3,135 @@ package auditorsrv
 
 import (
 	legolog "MerkleSquare/legolog/client"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
 	"time"
 
 	server "MerkleSquare/legolog/server"
 
 	"github.com/huyuncong/MerkleSquare/core"
+	"github.com/huyuncong/MerkleSquare/legolog/gossip"
 	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
+	"github.com/huyuncong/MerkleSquare/lib/storage"
+	"github.com/immesys/bw2/crypto"
 )
 
+// Keys under which the auditor stores verified checkpoints, misbehavior
+// reports, its witness key and, in a full audit, the updates it replayed. The
+// last verified global epoch is stored under its own key, which
+// latestCheckpointKey holds the epoch of; the checkpoint it supersedes is
+// deleted. Reports are numbered from 0, and misbehaviorCountKey holds how many
+// there are. The updates of each epoch are stored under updatesKeyPrefix
+// followed by the partition and the epoch.
+const (
+	checkpointKeyPrefix  = "auditor/checkpoint/"
+	latestCheckpointKey  = "auditor/latest"
+	misbehaviorKeyPrefix = "auditor/misbehavior/"
+	misbehaviorCountKey  = "auditor/misbehavior-count"
+	witnessKeyKey        = "auditor/witness-key"
+	updatesKeyPrefix     = "auditor/updates/"
+)
+
+// defaultPartitionTimeout bounds polling a partition when neither a timeout
+// nor an update period is configured.
+const defaultPartitionTimeout = 10 * time.Second
+
+// defaultResubscribeDelay is how long the auditor waits before subscribing to
+// checkpoints again when no update period is configured.
+const defaultResubscribeDelay = time.Second
+
+// cosignedHistory is how many of the digests it verified last for each
+// partition the auditor will still cosign, so that clients can gather
+// cosignatures from witnesses that are an epoch or so apart.
+const cosignedHistory = 16
+
+var errNotReady = errors.New("auditor has not verified the server's state yet")
+var errNotVerified = errors.New("auditor has not verified the digest")
+
 type Auditor struct {
 	client legolog.BasicClient
+	db     storage.Storage
 
+	// the verified state, which only the audit loop writes, under stateLock,
+	// and reads without it; the grpc handlers read it under stateLock
 	epochs                  uint64
 	UpdateCheckpoints       []*legolog_grpcint.CheckPoint
 	UpdateDigests           []*core.LegologDigest
 	VerificationCheckpoints []*legolog_grpcint.CheckPoint
+	GlobalDigest            *core.GlobalDigest
+	stateLock               sync.RWMutex
+
+	// the server's signatures of UpdateDigests, if it signs them, which are
+	// what evidence that a later digest cannot follow them is made of
+	updateSignatures [][]byte
+
+	// the global digest each partition's update checkpoint was verified
+	// under, and the marshaled proof of its inclusion there, which clients
+	// check; they are only known once a partition verified since a restart
+	partitionGlobalDigests []*core.GlobalDigest
+	partitionProofs        [][]byte
+
+	// ready is set once a checkpoint of every partition has been verified
+	// against the state the auditor started from
+	ready     bool
+	readyLock sync.RWMutex
+
+	statuses   []PartitionStatus
+	statusLock sync.RWMutex
+
+	misbehaviorReports []*core.MisbehaviorEvidence
+	reportsLock        sync.RWMutex
+
+	// the auditor's key as a witness, and the digests it last verified for
+	// each partition, with its cosignatures
+	witnessSK    []byte
+	witnessVK    []byte
+	cosigned     [][]cosignedDigest
+	cosignedLock sync.RWMutex
+
+	// the signed digests the auditor gossips with its peers, and the key
+	// the server signs them with, if gossip is enabled
+	gossip      *gossip.Pool
+	gossipPeers []legolog_grpcint.GossipClient
+	serverVK    []byte
+
+	// in a full audit, the base trees of each partition rebuilt from the
+	// updates of every epoch; they are rebuilt from the first epoch after a
+	// restart, from the updates stored up to storedUpdates
+	replayers     []*core.BaseTreeReplayer
+	storedUpdates []uint64
 
 	config  core.Config
 	stopper chan struct{}
 }
 
+// cosignedDigest is a digest the auditor verified and cosigned as a witness.
+type cosignedDigest struct {
+	digest      *core.LegologDigest
+	hash        []byte
+	cosignature *core.Cosignature
+}
+
+// witnessKey is how the auditor stores its witness key.
+type witnessKey struct {
+	SK []byte
+	VK []byte
+}
+
+// auditorState is what the auditor stores for each verified checkpoint.
+type auditorState struct {
+	UpdateCheckpoints       []*legolog_grpcint.CheckPoint
+	UpdateSignatures        [][]byte
+	VerificationCheckpoints []*legolog_grpcint.CheckPoint
+	GlobalDigest            *core.GlobalDigest
+}
+
 func NewAuditorWithManualConfig(serverAddr string, verificationPeriod time.Duration, updatePeriod time.Duration, partitions uint64, createClient bool) (
 	*Auditor, error) {
 	config :=
@@ -42,6 +148,7 @@ func NewAuditorWithManualConfig(serverAddr string, verificationPeriod time.Durat
 		config:  config,
 		stopper: make(chan struct{}),
 	}
+	Auditor.witnessSK, Auditor.witnessVK = crypto.GenerateKeypair()
 	var err error = nil
 	if createClient {
 		Auditor.client, err = legolog.NewLegologClient(serverAddr)
@@ -51,8 +158,8 @@ func NewAuditorWithManualConfig(serverAddr string, verificationPeriod time.Durat
 	}
 
 	Auditor.initializeCheckpoints(config.Partitions)
-	if updatePeriod != 0 {
-		go Auditor.QueryLoop()
+	if updatePeriod != 0 && createClient {
+		go Auditor.SubscribeLoop()
 	}
 
 	return Auditor, nil
@@ -62,6 +169,11 @@ func (a *Auditor) initializeCheckpoints(numPartitions uint64) {
 	a.UpdateCheckpoints = make([]*legolog_grpcint.CheckPoint, numPartitions)
 	a.VerificationCheckpoints = make([]*legolog_grpcint.CheckPoint, numPartitions)
 	a.UpdateDigests = make([]*core.LegologDigest, numPartitions)
+	a.updateSignatures = make([][]byte, numPartitions)
+	a.partitionGlobalDigests = make([]*core.GlobalDigest, numPartitions)
+	a.partitionProofs = make([][]byte, numPartitions)
+	a.statuses = make([]PartitionStatus, numPartitions)
+	a.cosigned = make([][]cosignedDigest, numPartitions)
 
 	for i := uint64(0); i < numPartitions; i++ {
 		a.UpdateCheckpoints[i] = nil
@@ -71,13 +183,18 @@ func (a *Auditor) initializeCheckpoints(numPartitions uint64) {
 
 }
 
-func NewAuditor(serverAddr string, configFile string) (
+// NewAuditor creates an auditor that stores every checkpoint it verifies in
+// db, if db is not nil. If db already holds a verified checkpoint, the auditor
+// resumes from it, and only serves clients once the server's current
+// checkpoint has been verified to extend it.
+func NewAuditor(serverAddr string, configFile string, db storage.Storage) (
 	*Auditor, error) {
 	config, err := core.ParseConfig(configFile)
 	if err != nil {
 		return nil, err
 	}
 	Auditor := &Auditor{
+		db:      db,
 		config:  config,
 		stopper: make(chan struct{}),
 	}
@@ -88,103 +205,392 @@ func NewAuditor(serverAddr string, configFile string) (
 
 	// initialize the update checkpoints array with partition checkpoints
 	Auditor.initializeCheckpoints(config.Partitions)
-	go Auditor.QueryLoop()
+	err = Auditor.loadState(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	err = Auditor.loadWitnessKey(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	err = Auditor.enableGossipFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	go Auditor.SubscribeLoop()
 
 	return Auditor, nil
 }
 
-func (a *Auditor) QueryLoop() {
-	updateTimer := time.NewTimer(a.config.UpdatePeriod)
-	defer updateTimer.Stop()
-	var updateTicker *time.Ticker
-	untilNextUpdateEpoch := updateTimer.C
+// loadState restores the last checkpoint stored in the auditor's database.
+func (a *Auditor) loadState(ctx context.Context) error {
+	if a.db == nil {
+		return nil
+	}
+	latest, err := a.db.Get(ctx, []byte(latestCheckpointKey))
+	if err != nil || latest == nil {
+		return err
+	}
+	marshaledState, err := a.db.Get(ctx, []byte(checkpointKeyPrefix+string(latest)))
+	if err != nil {
+		return err
+	}
+	if marshaledState == nil {
+		return fmt.Errorf("missing stored checkpoint for epoch %s", latest)
+	}
+	var state auditorState
+	err = json.Unmarshal(marshaledState, &state)
+	if err != nil {
+		return err
+	}
+	if uint64(len(state.UpdateCheckpoints)) != a.config.Partitions {
+		return fmt.Errorf("stored checkpoint has %d partitions, expected %d", len(state.UpdateCheckpoints), a.config.Partitions)
+	}
 
-	verificationTimer := time.NewTimer(a.config.VerificationPeriod)
-	defer verificationTimer.Stop()
-	var verificationTicker *time.Ticker
-	untilNextVerificationPeriod := verificationTimer.C
+	digests := make([]*core.LegologDigest, len(state.UpdateCheckpoints))
+	for i, checkpoint := range state.UpdateCheckpoints {
+		digests[i] = new(core.LegologDigest)
+		err = json.Unmarshal(checkpoint.GetMarshaledDigest(), digests[i])
+		if err != nil {
+			return err
+		}
+	}
+	a.UpdateCheckpoints = state.UpdateCheckpoints
+	a.UpdateDigests = digests
+	if len(state.UpdateSignatures) == len(state.UpdateCheckpoints) {
+		a.updateSignatures = state.UpdateSignatures
+	}
+	if len(state.VerificationCheckpoints) == len(state.UpdateCheckpoints) {
+		a.VerificationCheckpoints = state.VerificationCheckpoints
+	}
+	a.GlobalDigest = state.GlobalDigest
+	return a.loadMisbehaviorReports(ctx)
+}
 
-queryLoop:
-	for {
-		select {
-		case <-untilNextUpdateEpoch:
-			//NOTE: Debug
-			fmt.Println("Querying Auditor for Update Period", time.Now())
-			if updateTicker == nil {
-				fmt.Println("Starting update Ticker")
-				updateTimer.Stop()
-				updateTicker = time.NewTicker(a.config.UpdatePeriod)
-				untilNextUpdateEpoch = updateTicker.C
-			}
-			a.QueryServerUpdatePeriod()
-
-		case <-untilNextVerificationPeriod:
-			fmt.Println("Querying Auditor for Verification Period", time.Now())
-			if verificationTicker == nil {
-				fmt.Println("Starting verification Ticker")
-				verificationTimer.Stop()
-				verificationTicker = time.NewTicker(a.config.VerificationPeriod)
-				untilNextVerificationPeriod = verificationTicker.C
+// loadWitnessKey restores the auditor's witness key from its database, or
+// generates one and stores it there, so that clients can keep trusting the
+// auditor across restarts.
+func (a *Auditor) loadWitnessKey(ctx context.Context) error {
+	if a.db != nil {
+		marshaledKey, err := a.db.Get(ctx, []byte(witnessKeyKey))
+		if err != nil {
+			return err
+		}
+		if marshaledKey != nil {
+			var key witnessKey
+			err := json.Unmarshal(marshaledKey, &key)
+			if err != nil {
+				return fmt.Errorf("stored witness key: %v", err)
 			}
-			a.QueryServerVerificationPeriod()
-
-		case <-a.stopper:
-			fmt.Println("Stopping query loop!")
-			break queryLoop
+			a.witnessSK, a.witnessVK = key.SK, key.VK
+			return nil
 		}
 	}
-	if updateTicker != nil {
-		updateTicker.Stop()
+	a.witnessSK, a.witnessVK = crypto.GenerateKeypair()
+	if a.db == nil {
+		return nil
 	}
-	if verificationTicker != nil {
-		verificationTicker.Stop()
+	marshaledKey, err := json.Marshal(&witnessKey{SK: a.witnessSK, VK: a.witnessVK})
+	if err != nil {
+		return err
 	}
+	return a.db.Put(ctx, []byte(witnessKeyKey), marshaledKey)
 }
 
-func (a *Auditor) QueryServerUpdatePeriod() {
-	responses, err := a.queryServerUpdatePeriod()
-	if err != nil {
-		fmt.Printf("%v\n", err)
+// WitnessKey returns the key with which the auditor cosigns the digests it
+// verifies. Clients that trust the auditor as a witness are configured with
+// it.
+func (a *Auditor) WitnessKey() []byte {
+	return a.witnessVK
+}
+
+// cosign records that the auditor verified the digest of partition i, and
+// cosigns it.
+func (a *Auditor) cosign(i uint64, digest *core.LegologDigest) {
+	hash := digest.Hash()
+	a.cosignedLock.Lock()
+	defer a.cosignedLock.Unlock()
+	history := a.cosigned[i]
+	if len(history) != 0 && bytes.Equal(history[len(history)-1].hash, hash) {
 		return
 	}
+	history = append(history, cosignedDigest{
+		digest:      digest,
+		hash:        hash,
+		cosignature: core.CosignDigest(a.witnessSK, a.witnessVK, digest),
+	})
+	if len(history) > cosignedHistory {
+		history = history[len(history)-cosignedHistory:]
+	}
+	a.cosigned[i] = history
+}
 
-	digests, proofs := a.getContentsFromCheckpointResponses(responses)
+// latestCosigned returns the last digest of partition i the auditor cosigned.
+func (a *Auditor) latestCosigned(i uint64) (*cosignedDigest, error) {
+	a.cosignedLock.RLock()
+	defer a.cosignedLock.RUnlock()
+	if i >= uint64(len(a.cosigned)) {
+		return nil, fmt.Errorf("no partition %d", i)
+	}
+	history := a.cosigned[i]
+	if len(history) == 0 {
+		return nil, errNotReady
+	}
+	latest := history[len(history)-1]
+	return &latest, nil
+}
 
-	proven := a.isConsistencyProofValid(digests, proofs)
-	if !proven {
-		var prevEpoch uint64 = 0
-		if a.UpdateDigests[0] != nil {
-			prevEpoch = a.UpdateDigests[0].Epoch
+// findCosigned returns the auditor's cosignature of digest, if it is one of
+// the digests of partition i it verified recently.
+func (a *Auditor) findCosigned(i uint64, digest *core.LegologDigest) (*core.Cosignature, error) {
+	a.cosignedLock.RLock()
+	defer a.cosignedLock.RUnlock()
+	if i >= uint64(len(a.cosigned)) {
+		return nil, fmt.Errorf("no partition %d", i)
+	}
+	hash := digest.Hash()
+	for _, cosigned := range a.cosigned[i] {
+		if bytes.Equal(cosigned.hash, hash) {
+			return cosigned.cosignature, nil
 		}
-		fmt.Printf("Could not prove epoch %v is an extension of epoch %v\n",
-			responses[0].Checkpoint.GetEpoch(), prevEpoch)
 	}
+	return nil, errNotVerified
+}
 
-	var updateCheckpoints []*legolog_grpcint.CheckPoint
-	for _, r := range responses {
-		updateCheckpoints = append(updateCheckpoints, r.Checkpoint)
+func (a *Auditor) loadMisbehaviorReports(ctx context.Context) error {
+	count, err := a.db.Get(ctx, []byte(misbehaviorCountKey))
+	if err != nil || count == nil {
+		return err
 	}
-	a.UpdateCheckpoints = updateCheckpoints
-	a.UpdateDigests = digests
+	numReports, err := strconv.Atoi(string(count))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < numReports; i++ {
+		marshaledReport, err := a.db.Get(ctx, []byte(misbehaviorKeyPrefix+strconv.Itoa(i)))
+		if err != nil {
+			return err
+		}
+		if marshaledReport == nil {
+			return fmt.Errorf("missing stored misbehavior report %d", i)
+		}
+		var report core.MisbehaviorEvidence
+		err = json.Unmarshal(marshaledReport, &report)
+		if err != nil {
+			return fmt.Errorf("misbehavior report %d: %v", i, err)
+		}
+		a.misbehaviorReports = append(a.misbehaviorReports, &report)
+	}
+	return nil
 }
 
-func (a *Auditor) QueryServerVerificationPeriod() {
-	response, err := a.queryServerUpdatePeriod()
+// saveState stores the current checkpoint, which must have been verified, and
+// deletes the one it supersedes in the same batch.
+func (a *Auditor) saveState(ctx context.Context) error {
+	if a.db == nil || a.GlobalDigest == nil {
+		return nil
+	}
+	marshaledState, err := json.Marshal(&auditorState{
+		UpdateCheckpoints:       a.UpdateCheckpoints,
+		UpdateSignatures:        a.updateSignatures,
+		VerificationCheckpoints: a.VerificationCheckpoints,
+		GlobalDigest:            a.GlobalDigest,
+	})
 	if err != nil {
-		fmt.Printf("%v\n", err)
-		return
+		return err
+	}
+	latest, err := a.db.Get(ctx, []byte(latestCheckpointKey))
+	if err != nil {
+		return err
+	}
+	epoch := strconv.FormatUint(a.GlobalDigest.Epoch, 10)
+	batch := new(storage.Batch)
+	batch.Put([]byte(checkpointKeyPrefix+epoch), marshaledState)
+	batch.Put([]byte(latestCheckpointKey), []byte(epoch))
+	if latest != nil && string(latest) != epoch {
+		batch.Delete([]byte(checkpointKeyPrefix + string(latest)))
+	}
+	return a.db.Write(ctx, batch)
+}
+
+// reportMisbehavior records and stores evidence that has not been reported
+// before.
+func (a *Auditor) reportMisbehavior(ctx context.Context, evidence []*core.MisbehaviorEvidence) {
+	a.reportsLock.Lock()
+	defer a.reportsLock.Unlock()
+	for _, e := range evidence {
+		if a.isReported(e) {
+			continue
+		}
+		if e.SplitView {
+			fmt.Printf("Server misbehaved in partition %d: digests %x and %x are both for epoch %d\n",
+				e.PartitionIndex, e.OldDigest.Hash(), e.NewDigest.Hash(), e.OldDigest.Epoch)
+		} else {
+			fmt.Printf("Server misbehaved in partition %d: digest %x does not extend digest %x\n",
+				e.PartitionIndex, e.NewDigest.Hash(), e.OldDigest.Hash())
+		}
+		a.misbehaviorReports = append(a.misbehaviorReports, e)
+		if err := a.saveMisbehaviorReport(ctx, len(a.misbehaviorReports)-1, e); err != nil {
+			fmt.Printf("Could not store misbehavior report: %v\n", err)
+		}
+	}
+}
+
+// Must be called with reportsLock held.
+func (a *Auditor) isReported(e *core.MisbehaviorEvidence) bool {
+	for _, report := range a.misbehaviorReports {
+		if report.PartitionIndex == e.PartitionIndex &&
+			bytes.Equal(report.OldDigest.Hash(), e.OldDigest.Hash()) &&
+			bytes.Equal(report.NewDigest.Hash(), e.NewDigest.Hash()) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveMisbehaviorReport stores a report and the new number of reports in one
+// batch, so that the count never covers a report that was not stored.
+func (a *Auditor) saveMisbehaviorReport(ctx context.Context, index int, e *core.MisbehaviorEvidence) error {
+	if a.db == nil {
+		return nil
+	}
+	marshaledReport, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	batch := new(storage.Batch)
+	batch.Put([]byte(misbehaviorKeyPrefix+strconv.Itoa(index)), marshaledReport)
+	batch.Put([]byte(misbehaviorCountKey), []byte(strconv.Itoa(index+1)))
+	return a.db.Write(ctx, batch)
+}
+
+// MisbehaviorReports returns the misbehavior reports from startIndex on.
+func (a *Auditor) MisbehaviorReports(startIndex uint64) []*core.MisbehaviorEvidence {
+	a.reportsLock.RLock()
+	defer a.reportsLock.RUnlock()
+	if startIndex >= uint64(len(a.misbehaviorReports)) {
+		return nil
+	}
+	return append([]*core.MisbehaviorEvidence{}, a.misbehaviorReports[startIndex:]...)
+}
+
+func (a *Auditor) isReady() bool {
+	a.readyLock.RLock()
+	defer a.readyLock.RUnlock()
+	return a.ready
+}
+
+func (a *Auditor) setReady() {
+	a.readyLock.Lock()
+	defer a.readyLock.Unlock()
+	a.ready = true
+}
+
+// SubscribeLoop verifies checkpoints as the server pushes them. Whenever the
+// stream ends, the auditor polls the server once and subscribes again after
+// an update period.
+func (a *Auditor) SubscribeLoop() {
+	delay := a.config.UpdatePeriod
+	if delay == 0 {
+		delay = defaultResubscribeDelay
+	}
+	for {
+		err := a.followCheckpoints()
+		select {
+		case <-a.stopper:
+			fmt.Println("Stopping subscribe loop!")
+			return
+		default:
+		}
+		fmt.Printf("Checkpoint stream ended, polling instead: %v\n", err)
+		a.QueryServerUpdatePeriod()
+
+		select {
+		case <-time.After(delay):
+		case <-a.stopper:
+			fmt.Println("Stopping subscribe loop!")
+			return
+		}
 	}
+}
 
-	// TODO: EXTENSION PROOF FOR VERIFICATION PERIOD HISTORY FORESTS
-	if a.config.AggHistory {
+// followCheckpoints subscribes to the checkpoints of every partition and
+// verifies each batch the server pushes, until the stream ends or the
+// auditor stops.
+func (a *Auditor) followCheckpoints() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-a.stopper:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
+	sizes := make([]uint64, a.config.Partitions)
+	for i := range sizes {
+		sizes[i] = a.oldUpdateLogSize(uint64(i))
+	}
+	stream, err := a.client.SubscribeCheckpoints(ctx, &legolog_grpcint.SubscribeCheckpointsRequest{OldSizes: sizes})
+	if err != nil {
+		return err
 	}
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		a.verifyStreamedCheckpoints(response, sizes)
+	}
+}
 
-	var verificationCheckpoints []*legolog_grpcint.CheckPoint
-	for _, r := range response {
-		verificationCheckpoints = append(verificationCheckpoints, r.Checkpoint)
+// verifyStreamedCheckpoints verifies a batch of checkpoints pushed by the
+// server. Their consistency proofs start from the update log sizes last sent
+// on the stream, which are kept in sizes; a partition the auditor did not
+// advance that far, or that is missing from the batch, is polled instead.
+func (a *Auditor) verifyStreamedCheckpoints(response *legolog_grpcint.SubscribeCheckpointsResponse, sizes []uint64) {
+	checkpoints := make([]*legolog_grpcint.GetNewCheckPointResponse, a.config.Partitions)
+	for j, i := range response.GetPartitionIndices() {
+		if i < a.config.Partitions && j < len(response.GetCheckpoints()) {
+			checkpoints[i] = response.GetCheckpoints()[j]
+		}
+	}
+	if a.config.FullAudit {
+		a.initializeReplayers()
 	}
-	a.VerificationCheckpoints = verificationCheckpoints
+	results := make([]*partitionResult, a.config.Partitions)
+	a.forEachPartition(func(ctx context.Context, i uint64) {
+		if checkpoints[i] == nil {
+			results[i] = a.pollUpdateCheckpoint(ctx, i)
+			return
+		}
+		if sizes[i] == a.oldUpdateLogSize(i) {
+			results[i] = a.verifyCheckpoint(ctx, i, checkpoints[i])
+		} else {
+			results[i] = a.pollUpdateCheckpoint(ctx, i)
+		}
+		if digest, _, err := getContentsFromCheckpointResponse(checkpoints[i]); err == nil {
+			sizes[i] = uint64(digest.UpdateLogSize)
+		}
+	})
+	a.applyResults(results)
+}
+
+// QueryServerUpdatePeriod fetches and verifies the checkpoint of every
+// partition, with at most the configured number of partitions in flight.
+// Each partition that verifies advances to its new checkpoint on its own, so
+// a slow or misbehaving partition only holds back itself; the global digest
+// advances once every partition verified under the same one.
+func (a *Auditor) QueryServerUpdatePeriod() {
+	if a.config.FullAudit {
+		a.initializeReplayers()
+	}
+	results := make([]*partitionResult, a.config.Partitions)
+	a.forEachPartition(func(ctx context.Context, i uint64) {
+		results[i] = a.pollUpdateCheckpoint(ctx, i)
+	})
+	a.applyResults(results)
 }
 
 /* TODO: Implement if needed in eval
@@ -207,26 +613,279 @@ func (a *Auditor) QueryServerForSize() int {
 }
 */
 
-func (a *Auditor) queryServerUpdatePeriod() ([]*legolog_grpcint.GetNewCheckPointResponse, error) {
-	var res []*legolog_grpcint.GetNewCheckPointResponse
-	for i := uint64(0); i < a.config.Partitions; i++ {
-		var oldSize uint64 = 0
-		if a.UpdateDigests[i] != nil {
-			// used to use a.UpdateCheckpoints[i].NumLeaves(), but I'm porting everything away from UpdateCheckpoints for ease of use
-			oldSize = uint64(a.UpdateDigests[i].BaseTreeSize)
+// partitionResult is the outcome of polling a single partition. Only a result
+// without err advances the partition.
+type partitionResult struct {
+	checkpoint     *legolog_grpcint.CheckPoint
+	digest         *core.LegologDigest
+	signature      []byte
+	globalDigest   *core.GlobalDigest
+	partitionProof []byte // marshaled inclusion of digest under globalDigest
+	evidence       *core.MisbehaviorEvidence
+	err            error
+}
+
+// PartitionStatus is what the auditor knows about a partition after polling
+// it.
+type PartitionStatus struct {
+	VerifiedEpoch       uint64    // epoch of the last verified digest
+	LastAttempt         time.Time // when the partition was last polled
+	LastVerified        time.Time // zero until a checkpoint has been verified
+	ConsecutiveFailures int
+	LastError           error // why the last poll failed, if it did
+}
+
+// PartitionStatuses returns the status of every partition.
+func (a *Auditor) PartitionStatuses() []PartitionStatus {
+	a.statusLock.RLock()
+	defer a.statusLock.RUnlock()
+	return append([]PartitionStatus{}, a.statuses...)
+}
+
+func (a *Auditor) recordStatus(i uint64, result *partitionResult) {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+	status := &a.statuses[i]
+	status.LastAttempt = time.Now()
+	status.LastError = result.err
+	if result.err != nil {
+		status.ConsecutiveFailures++
+		return
+	}
+	status.VerifiedEpoch = result.digest.Epoch
+	status.LastVerified = status.LastAttempt
+	status.ConsecutiveFailures = 0
+}
+
+// allPartitionsVerified reports whether every partition has been verified
+// since the auditor started.
+func (a *Auditor) allPartitionsVerified() bool {
+	a.statusLock.RLock()
+	defer a.statusLock.RUnlock()
+	for _, status := range a.statuses {
+		if status.LastVerified.IsZero() {
+			return false
 		}
-		checkpointRequest := &legolog_grpcint.GetNewCheckPointRequest{
-			OldSize:        oldSize,
+	}
+	return true
+}
+
+func (a *Auditor) parallelism() int {
+	if a.config.AuditParallelism > 0 {
+		return a.config.AuditParallelism
+	}
+	return runtime.NumCPU()
+}
+
+func (a *Auditor) partitionTimeout() time.Duration {
+	if a.config.PartitionTimeout > 0 {
+		return a.config.PartitionTimeout
+	}
+	if a.config.UpdatePeriod > 0 {
+		return a.config.UpdatePeriod
+	}
+	return defaultPartitionTimeout
+}
+
+// forEachPartition calls f for every partition, with at most parallelism()
+// calls running at once, each under its own deadline.
+func (a *Auditor) forEachPartition(f func(ctx context.Context, i uint64)) {
+	sem := make(chan struct{}, a.parallelism())
+	var wg sync.WaitGroup
+	for i := uint64(0); i < a.config.Partitions; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i uint64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(context.Background(), a.partitionTimeout())
+			defer cancel()
+			f(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// oldUpdateLogSize returns the size of the update log of the last verified
+// digest of partition i, which the consistency proof is checked against.
+func (a *Auditor) oldUpdateLogSize(i uint64) uint64 {
+	if a.UpdateDigests[i] == nil {
+		return 0
+	}
+	return uint64(a.UpdateDigests[i].UpdateLogSize)
+}
+
+// pollUpdateCheckpoint fetches the update checkpoint of partition i and
+// verifies it against the last verified one.
+func (a *Auditor) pollUpdateCheckpoint(ctx context.Context, i uint64) *partitionResult {
+	response, err := a.client.GetNewUpdateCheckPoint(ctx, &legolog_grpcint.GetNewCheckPointRequest{
+		OldSize:        a.oldUpdateLogSize(i),
+		PartitionIndex: i,
+	})
+	if err != nil {
+		return &partitionResult{err: err}
+	}
+	return a.verifyCheckpoint(ctx, i, response)
+}
+
+// verifyCheckpoint verifies a checkpoint the server published for partition
+// i against the last verified one.
+func (a *Auditor) verifyCheckpoint(ctx context.Context, i uint64,
+	response *legolog_grpcint.GetNewCheckPointResponse) *partitionResult {
+	digest, proof, err := getContentsFromCheckpointResponse(response)
+	if err != nil {
+		return &partitionResult{err: err}
+	}
+	err = a.gossipDigest(digest, response.GetDigestSignature())
+	if err != nil {
+		return &partitionResult{err: err}
+	}
+	result := &partitionResult{checkpoint: response.Checkpoint, digest: digest}
+	if a.gossip != nil {
+		result.signature = response.GetDigestSignature()
+	}
+	result.globalDigest, result.err = a.verifyGlobalDigest(i, response, digest)
+	if result.err != nil {
+		return result
+	}
+	result.partitionProof = response.GetPartitionProof()
+	result.evidence, result.err = a.verifyPartition(ctx, i, digest, result.signature, proof)
+	return result
+}
+
+// verifyPartition checks that the new digest of partition i, with the
+// server's signature if it signs digests, is bound to it and extends the last
+// verified one. It returns evidence if the two signed digests alone show that
+// the server forked the partition's history; a proof that fails to verify
+// only fails the partition, since the server does not sign its proofs.
+func (a *Auditor) verifyPartition(ctx context.Context, i uint64, digest *core.LegologDigest, signature []byte,
+	proof *core.MerkleExtensionProof) (*core.MisbehaviorEvidence, error) {
+	err := digest.Binding.CheckLog([]byte(a.config.LogID), i, a.config.Partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	// the auditor only advances past checkpoints that extend the last
+	// verified one, including the one it resumed from after a restart
+	oldDigest := a.UpdateDigests[i]
+	if oldDigest != nil {
+		e := &core.MisbehaviorEvidence{
 			PartitionIndex: i,
+			OldDigest:      oldDigest,
+			NewDigest:      digest,
+			OldSignature:   a.updateSignatures[i],
+			NewSignature:   signature,
+		}
+		if e.Verify([]byte(a.config.LogID), a.config.Partitions, a.serverVK) == nil {
+			return e, fmt.Errorf("server signed epoch %v, which cannot follow epoch %v", digest.Epoch, oldDigest.Epoch)
+		}
+		if !core.VerifyUpdateLogExtension(oldDigest, digest, proof) {
+			return nil, fmt.Errorf("could not prove epoch %v is an extension of epoch %v", digest.Epoch, oldDigest.Epoch)
 		}
-		response, err := a.client.GetNewUpdateCheckPoint(
-			context.Background(), checkpointRequest)
+		if digest.Epoch < oldDigest.Epoch {
+			return nil, fmt.Errorf("rolled back from epoch %v to epoch %v", oldDigest.Epoch, digest.Epoch)
+		}
+		if digest.PublishedAt < oldDigest.PublishedAt {
+			return nil, fmt.Errorf("publication time went back from %v to %v",
+				oldDigest.PublicationTime(), digest.PublicationTime())
+		}
+	}
+	if a.client == nil {
+		return nil, nil
+	}
+	err = a.verifyUpdateLogChain(ctx, i, oldDigest, digest)
+	if err != nil {
+		return nil, err
+	}
+	err = a.verifyHashChain(ctx, i, oldDigest, digest)
+	if err != nil {
+		return nil, err
+	}
+	if a.config.FullAudit {
+		err = a.replayUpdates(ctx, i, digest)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("could not verify base trees against the server's updates: %v", err)
 		}
-		res = append(res, response)
 	}
-	return res, nil
+	return nil, nil
+}
+
+// applyResults advances every partition that verified to its new checkpoint,
+// which is also its verification checkpoint if it starts a verification
+// period, and records the status of each. The global digest only advances when every
+// partition verified under the same one.
+func (a *Auditor) applyResults(results []*partitionResult) {
+	ctx := context.Background()
+	var evidence []*core.MisbehaviorEvidence
+	progress := false
+	for i, result := range results {
+		a.recordStatus(uint64(i), result)
+		if result.evidence != nil {
+			evidence = append(evidence, result.evidence)
+		}
+		if result.err != nil {
+			fmt.Printf("Could not verify partition %d: %v\n", i, result.err)
+			continue
+		}
+		a.cosign(uint64(i), result.digest)
+		a.stateLock.Lock()
+		// the checkpoint that starts a verification period is also kept as
+		// the partition's verification checkpoint
+		if result.checkpoint != nil && (a.VerificationCheckpoints[i] == nil ||
+			a.UpdateDigests[i] == nil || result.digest.VerificationPeriod > a.UpdateDigests[i].VerificationPeriod) {
+			a.VerificationCheckpoints[i] = result.checkpoint
+		}
+		a.UpdateDigests[i] = result.digest
+		a.updateSignatures[i] = result.signature
+		a.partitionGlobalDigests[i] = result.globalDigest
+		a.partitionProofs[i] = result.partitionProof
+		if result.checkpoint != nil {
+			a.UpdateCheckpoints[i] = result.checkpoint
+		}
+		a.stateLock.Unlock()
+		progress = true
+	}
+	if len(evidence) != 0 {
+		a.reportMisbehavior(ctx, evidence)
+	}
+	if !progress {
+		return
+	}
+
+	if globalDigest := commonGlobalDigest(results); globalDigest != nil {
+		if a.GlobalDigest != nil && globalDigest.Epoch < a.GlobalDigest.Epoch {
+			fmt.Printf("Server rolled back from epoch %v to epoch %v\n", a.GlobalDigest.Epoch, globalDigest.Epoch)
+		} else {
+			a.stateLock.Lock()
+			a.GlobalDigest = globalDigest
+			a.stateLock.Unlock()
+		}
+	}
+	if err := a.saveState(ctx); err != nil {
+		fmt.Printf("Could not store verified checkpoint: %v\n", err)
+		return
+	}
+	if a.allPartitionsVerified() {
+		a.setReady()
+	}
+}
+
+// commonGlobalDigest returns the global digest every partition verified
+// under, or nil if some partition did not verify or they disagree.
+func commonGlobalDigest(results []*partitionResult) *core.GlobalDigest {
+	var globalDigest *core.GlobalDigest
+	for _, result := range results {
+		if result.err != nil || result.globalDigest == nil {
+			return nil
+		}
+		if globalDigest == nil {
+			globalDigest = result.globalDigest
+		} else if !bytes.Equal(globalDigest.Root, result.globalDigest.Root) ||
+			globalDigest.NumPartitions != result.globalDigest.NumPartitions {
+			return nil
+		}
+	}
+	return globalDigest
 }
 
 /*
@@ -234,106 +893,190 @@ hacky function used for benchmarking that accomplishes the functionality of `Que
 without using a client (directly uses partitionServers instead, passed in from the test file)
 */
 func (a *Auditor) QueryServerUpdatePeriodWithoutClient(partitionServers []*server.PartitionServer) {
-	// initialize digest and proof array
-	var digests []*core.LegologDigest
-	var proofs []*core.MerkleExtensionProof
-	for i := uint64(0); i < a.config.Partitions; i++ {
-		var oldSize uint64 = 0
-		if a.UpdateDigests[i] != nil {
-			// used to use a.UpdateCheckpoints[i].NumLeaves(), but I'm porting everything away from UpdateCheckpoints for ease of use
-			oldSize = uint64(a.UpdateDigests[i].UpdateLogSize)
-		}
+	results := make([]*partitionResult, a.config.Partitions)
+	a.forEachPartition(func(ctx context.Context, i uint64) {
 		/*
 			replicate GetNewUpdateCheckPoint
 		*/
 		digest := partitionServers[i].PublishedDigest
-		// proof := new(core.MerkleExtensionProof) // TODO: copy logic for getting the proof
-		proof := partitionServers[i].Partition.GetUpdateEpochConsistencyProof(uint32(oldSize))
-		_ = oldSize // old size would be needed to generate this proof, but for now its not i guess?
-		digests = append(digests, &digest)
-		proofs = append(proofs, proof)
+		proof := partitionServers[i].Partition.GetUpdateEpochConsistencyProof(uint32(a.oldUpdateLogSize(i)))
+		result := &partitionResult{digest: &digest}
+		result.evidence, result.err = a.verifyPartition(ctx, i, &digest, nil, proof)
+		results[i] = result
+	})
+	a.applyResults(results)
+}
+
+// verifyUpdateLogChain checks that the update log of the partition's new
+// digest extends that of the last verified one through its state at the end
+// of every verification period the auditor missed.
+func (a *Auditor) verifyUpdateLogChain(ctx context.Context, i uint64, oldDigest *core.LegologDigest,
+	newDigest *core.LegologDigest) error {
+	if oldDigest == nil || oldDigest.VerificationPeriod == newDigest.VerificationPeriod {
+		return nil
+	}
+	response, err := a.client.GetUpdateLogChainProof(ctx, &legolog_grpcint.GetUpdateLogChainProofRequest{
+		PartitionIndex:        i,
+		OldVerificationPeriod: oldDigest.VerificationPeriod,
+		OldSize:               uint64(oldDigest.UpdateLogSize),
+		NewVerificationPeriod: newDigest.VerificationPeriod,
+		NewSize:               uint64(newDigest.UpdateLogSize),
+	})
+	if err != nil {
+		return err
+	}
+	var proof core.UpdateLogChainProof
+	err = json.Unmarshal(response.GetProof(), &proof)
+	if err != nil {
+		return err
 	}
+	return core.VerifyUpdateLogChain(oldDigest, newDigest, &proof)
+}
 
-	proven := a.isConsistencyProofValid(digests, proofs)
-	// fmt.Printf("%t\n", proven)
-	var prevEpoch uint64 = 0
-	if a.UpdateDigests[0] != nil {
-		prevEpoch = a.UpdateDigests[0].Epoch
+// verifyHashChain checks that the hash chain of the partition's new digest
+// extends that of the last verified one, across any verification periods
+// the auditor missed.
+func (a *Auditor) verifyHashChain(ctx context.Context, i uint64, oldDigest *core.LegologDigest,
+	newDigest *core.LegologDigest) error {
+	if oldDigest == nil || bytes.Equal(oldDigest.HashChain, newDigest.HashChain) {
+		return nil
 	}
-	_ = prevEpoch
-	if !proven {
-		fmt.Printf("Could not prove epoch %v is an extension of epoch %v\n", digests[0].Epoch, prevEpoch)
-	} else {
-		// fmt.Printf("Proved epoch %v is an extension of epoch %v\n", digests[0].Epoch, prevEpoch)
+	response, err := a.client.GetHashChainProof(ctx, &legolog_grpcint.GetHashChainProofRequest{
+		PartitionIndex: i,
+		OldHashChain:   oldDigest.HashChain,
+		NewHashChain:   newDigest.HashChain,
+	})
+	if err != nil {
+		return err
 	}
-	/* 	a.isConsistencyProofValid(digests, proofs) */
-	a.UpdateDigests = digests
+	var proof core.HashChainProof
+	err = json.Unmarshal(response.GetProof(), &proof)
+	if err != nil {
+		return err
+	}
+	return core.VerifyHashChainExtension(oldDigest, newDigest, &proof)
 }
 
-func (a *Auditor) queryServerVerificationPeriod() ([]*legolog_grpcint.GetNewCheckPointResponse, error) {
-	var res []*legolog_grpcint.GetNewCheckPointResponse
-	for i := uint64(0); i < a.config.Partitions; i++ {
-		checkpointRequest := &legolog_grpcint.GetNewCheckPointRequest{
-			OldSize:        a.VerificationCheckpoints[i].GetNumLeaves(),
-			PartitionIndex: i,
+func (a *Auditor) initializeReplayers() {
+	if a.replayers != nil {
+		return
+	}
+	a.replayers = make([]*core.BaseTreeReplayer, a.config.Partitions)
+	a.storedUpdates = make([]uint64, a.config.Partitions)
+	for i := range a.replayers {
+		a.replayers[i] = core.NewBaseTreeReplayer(a.config)
+	}
+}
+
+// replayUpdates fetches the updates of the partition up to the epoch of its
+// new digest, replays them into the auditor's own base trees and checks that
+// the digest matches them. The replayers must have been initialized.
+func (a *Auditor) replayUpdates(ctx context.Context, i uint64, digest *core.LegologDigest) error {
+	replayer := a.replayers[i]
+	for epoch := replayer.Epoch() + 1; epoch <= digest.Epoch; epoch++ {
+		updates, err := a.epochUpdates(ctx, i, epoch, &digest.Binding)
+		if err != nil {
+			return err
 		}
-		response, err := a.client.GetNewVerifyCheckPoint(
-			context.Background(), checkpointRequest)
+		err = replayer.Apply(updates)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		res = append(res, response)
 	}
-	return res, nil
+	return replayer.Verify(digest)
 }
 
-func (a *Auditor) isConsistencyProofValid(digests []*core.LegologDigest, proofs []*core.MerkleExtensionProof) bool {
-	allVerified := true
-
-	for i, newDigest := range digests {
-		oldDigest := a.UpdateDigests[i]
-
-		extensionProof := proofs[i]
-
-		var oldRoots [][]byte = nil
-		var oldSize uint32 = 0
-		if oldDigest != nil {
-			oldRoots = [][]byte{oldDigest.UpdateLogRoot}
-			oldSize = oldDigest.UpdateLogSize
+// epochUpdates returns the updates of partition i in epoch from the auditor's
+// database, or from the server if they are not stored there yet, in which
+// case they are stored. Requests to the server acknowledge the updates stored
+// before, so that the server can prune them.
+func (a *Auditor) epochUpdates(ctx context.Context, i uint64, epoch uint64,
+	binding *core.PartitionBinding) (*core.EpochUpdates, error) {
+	key := []byte(updatesKeyPrefix + strconv.FormatUint(i, 10) + "/" + strconv.FormatUint(epoch, 10))
+	var updates core.EpochUpdates
+	if a.db != nil {
+		marshaledUpdates, err := a.db.Get(ctx, key)
+		if err != nil {
+			return nil, err
 		}
-		oldDigestForProof := &core.Digest{
-			Roots: oldRoots,
-			Size:  oldSize, // TODO: correct this size
+		if marshaledUpdates != nil {
+			err = json.Unmarshal(marshaledUpdates, &updates)
+			if err != nil {
+				return nil, err
+			}
+			a.storedUpdates[i] = epoch
+			return &updates, nil
 		}
-		newDigestForProof := &core.Digest{
-			Roots: [][]byte{newDigest.UpdateLogRoot},
-			Size:  newDigest.UpdateLogSize, // TODO: correct this size
+	}
+
+	req := &legolog_grpcint.GetEpochUpdatesRequest{PartitionIndex: i, Epoch: epoch}
+	if a.storedUpdates[i] != 0 {
+		ack := core.AckStoredUpdates(a.witnessSK, a.witnessVK, binding, a.storedUpdates[i])
+		marshaledAck, err := json.Marshal(ack)
+		if err != nil {
+			return nil, err
 		}
+		req.MarshaledAck = marshaledAck
+	}
+	response, err := a.client.GetEpochUpdates(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(response.GetMarshaledUpdates(), &updates)
+	if err != nil {
+		return nil, err
+	}
+	if a.db != nil {
+		err = a.db.Put(ctx, key, response.GetMarshaledUpdates())
+		if err != nil {
+			return nil, err
+		}
+		a.storedUpdates[i] = epoch
+	}
+	return &updates, nil
+}
 
-		allVerified = allVerified && core.VerifyConsistencyProof(oldDigestForProof, newDigestForProof, extensionProof)
+// verifyGlobalDigest checks that the global digest partition i was published
+// under covers exactly the configured partitions, and that the partition's
+// digest is included in it at its own index.
+func (a *Auditor) verifyGlobalDigest(i uint64, response *legolog_grpcint.GetNewCheckPointResponse,
+	digest *core.LegologDigest) (*core.GlobalDigest, error) {
+	var globalDigest core.GlobalDigest
+	err := json.Unmarshal(response.GetMarshaledGlobalDigest(), &globalDigest)
+	if err != nil {
+		return nil, err
 	}
-	return allVerified
+	if globalDigest.NumPartitions != a.config.Partitions {
+		return nil, errors.New("global digest does not cover the configured partitions")
+	}
+
+	var inclusionProof core.PartitionInclusionProof
+	err = json.Unmarshal(response.GetPartitionProof(), &inclusionProof)
+	if err != nil {
+		return nil, err
+	}
+	if inclusionProof.Index != i ||
+		!core.VerifyPartitionInclusionProof(&globalDigest, digest, &inclusionProof) {
+		return nil, errors.New("digest is not included in the global digest")
+	}
+	return &globalDigest, nil
 }
 
 /*
-extract the proofs and digests from checkpoint responses into two arrays. unmarshall the digests and proofs before
-inserting them into the array. return the two arrays.
+extract the digest and proof from a checkpoint response, unmarshaling both.
 */
-func (a *Auditor) getContentsFromCheckpointResponses(responses []*legolog_grpcint.GetNewCheckPointResponse) ([]*core.LegologDigest, []*core.MerkleExtensionProof) {
-	var digests []*core.LegologDigest
-	var proofs []*core.MerkleExtensionProof
-	for _, response := range responses {
-		marshaledNewDigest := response.Checkpoint.MarshaledDigest
-		var newDigest = new(core.LegologDigest)
-		json.Unmarshal(marshaledNewDigest, &newDigest)
-		digests = append(digests, newDigest)
-
-		marshaledProof := response.Proof
-		var extensionProof = new(core.MerkleExtensionProof)
-		json.Unmarshal(marshaledProof, &extensionProof)
-		proofs = append(proofs, extensionProof)
+func getContentsFromCheckpointResponse(response *legolog_grpcint.GetNewCheckPointResponse) (*core.LegologDigest, *core.MerkleExtensionProof, error) {
+	var digest = new(core.LegologDigest)
+	err := json.Unmarshal(response.GetCheckpoint().GetMarshaledDigest(), digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	var extensionProof = new(core.MerkleExtensionProof)
+	err = json.Unmarshal(response.GetProof(), extensionProof)
+	if err != nil {
+		return nil, nil, err
 	}
-	return digests, proofs
+	return digest, extensionProof, nil
 }
 
 /*
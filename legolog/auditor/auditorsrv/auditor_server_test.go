@@ -0,0 +1,815 @@
+package auditorsrv
+
+import (
+	legolog "MerkleSquare/legolog/client"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/huyuncong/MerkleSquare/core"
+	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
+	"github.com/huyuncong/MerkleSquare/lib/storage"
+	"github.com/immesys/bw2/crypto"
+)
+
+const testLogID = "test-log"
+
+// fakeServer publishes checkpoints of its partitions the way the server does.
+type fakeServer struct {
+	legolog.BasicClient
+	partitions []*core.Partition
+	epoch      uint64
+
+	// forgePositions makes the server serve updates other than the ones it
+	// merged into its base trees
+	forgePositions bool
+	// stalled partitions do not answer until the request's deadline
+	stalled map[uint64]bool
+	// skipUpdateLogCheckpoint makes the server leave out the update log
+	// checkpoint of the first period in its update log chain proofs
+	skipUpdateLogCheckpoint bool
+	// tamperProof makes the server serve garbage consistency proofs with
+	// its honest checkpoints
+	tamperProof bool
+
+	// published signals checkpoint streams that a new batch is out, and each
+	// stream signals recvs before it waits for the next batch
+	published chan struct{}
+	recvs     chan struct{}
+
+	// the key the server signs checkpoints with, if any
+	signingSK []byte
+	signingVK []byte
+	// publishedAt, if not zero, is the publication time stamped on the
+	// checkpoints the server serves
+	publishedAt int64
+}
+
+func newFakeServer(numPartitions int) *fakeServer {
+	f := &fakeServer{}
+	for i := 0; i < numPartitions; i++ {
+		partition := core.NewPartition()
+		partition.Bind(core.PartitionBinding{
+			LogID:          []byte(testLogID),
+			PartitionIndex: uint64(i),
+			NumPartitions:  uint64(numPartitions),
+		})
+		partition.KeepUpdateHistory()
+		f.partitions = append(f.partitions, partition)
+	}
+	return f
+}
+
+func (f *fakeServer) nextEpoch(key string) {
+	for _, partition := range f.partitions {
+		partition.Append([]byte(key), []byte(key), []byte(key), []byte(key))
+		partition.IncrementUpdateEpoch()
+	}
+	f.epoch++
+}
+
+func (f *fakeServer) nextPeriod() {
+	for _, partition := range f.partitions {
+		partition.IncrementVerificationPeriod()
+	}
+}
+
+func (f *fakeServer) GetEpochUpdates(ctx context.Context, req *legolog_grpcint.GetEpochUpdatesRequest) (
+	*legolog_grpcint.GetEpochUpdatesResponse, error) {
+	// the server prunes the updates its only auditor stored
+	if len(req.MarshaledAck) != 0 {
+		var ack core.StoredUpdatesAck
+		json.Unmarshal(req.MarshaledAck, &ack)
+		partition := f.partitions[req.PartitionIndex]
+		if !ack.Verify(&partition.GetDigest().Binding) {
+			return nil, errors.New("acknowledgement is not signed")
+		}
+		partition.PruneEpochUpdates(ack.Through)
+	}
+	updates, err := f.partitions[req.PartitionIndex].GetEpochUpdates(req.Epoch)
+	if err != nil {
+		return nil, err
+	}
+	if f.forgePositions {
+		forged := *updates
+		forged.Updates = append([]core.BaseTreeUpdate{}, updates.Updates...)
+		for i := range forged.Updates {
+			forged.Updates[i].Pos++
+		}
+		updates = &forged
+	}
+	marshaledUpdates, _ := json.Marshal(updates)
+	return &legolog_grpcint.GetEpochUpdatesResponse{MarshaledUpdates: marshaledUpdates}, nil
+}
+
+func (f *fakeServer) GetHashChainProof(ctx context.Context, req *legolog_grpcint.GetHashChainProofRequest) (
+	*legolog_grpcint.GetHashChainProofResponse, error) {
+	proof, err := f.partitions[req.PartitionIndex].GenerateHashChainProof(req.OldHashChain, req.NewHashChain)
+	if err != nil {
+		return nil, err
+	}
+	marshaledProof, _ := json.Marshal(proof)
+	return &legolog_grpcint.GetHashChainProofResponse{Proof: marshaledProof}, nil
+}
+
+func (f *fakeServer) GetUpdateLogChainProof(ctx context.Context, req *legolog_grpcint.GetUpdateLogChainProofRequest) (
+	*legolog_grpcint.GetUpdateLogChainProofResponse, error) {
+	proof, err := f.partitions[req.PartitionIndex].GenerateUpdateLogChainProof(req.OldVerificationPeriod,
+		uint32(req.OldSize), req.NewVerificationPeriod, uint32(req.NewSize))
+	if err != nil {
+		return nil, err
+	}
+	if f.skipUpdateLogCheckpoint && len(proof.Checkpoints) != 0 {
+		proof.Checkpoints = proof.Checkpoints[1:]
+		proof.Proofs = proof.Proofs[1:]
+	}
+	marshaledProof, _ := json.Marshal(proof)
+	return &legolog_grpcint.GetUpdateLogChainProofResponse{Proof: marshaledProof}, nil
+}
+
+func (f *fakeServer) GetNewUpdateCheckPoint(ctx context.Context, req *legolog_grpcint.GetNewCheckPointRequest) (
+	*legolog_grpcint.GetNewCheckPointResponse, error) {
+	if f.stalled[req.PartitionIndex] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	digests := make([]*core.LegologDigest, len(f.partitions))
+	for i, partition := range f.partitions {
+		digests[i] = partition.GetDigest()
+		digests[i].PublishedAt = f.publishedAt
+	}
+	marshaledDigest, _ := json.Marshal(digests[req.PartitionIndex])
+	marshaledGlobalDigest, _ := json.Marshal(core.NewGlobalDigest(digests, f.epoch))
+	marshaledPartitionProof, _ := json.Marshal(core.GeneratePartitionInclusionProof(digests, req.PartitionIndex))
+	proof := f.partitions[req.PartitionIndex].GetUpdateEpochConsistencyProof(uint32(req.OldSize))
+	if f.tamperProof {
+		proof = &core.MerkleExtensionProof{PrefixHashes: [][]byte{[]byte("garbage")}}
+	}
+	marshaledProof, _ := json.Marshal(proof)
+	var signature []byte
+	if f.signingSK != nil {
+		signature = core.SignDigest(f.signingSK, f.signingVK, digests[req.PartitionIndex]).Signature
+	}
+	return &legolog_grpcint.GetNewCheckPointResponse{
+		Checkpoint:            &legolog_grpcint.CheckPoint{MarshaledDigest: marshaledDigest},
+		Proof:                 marshaledProof,
+		MarshaledGlobalDigest: marshaledGlobalDigest,
+		PartitionProof:        marshaledPartitionProof,
+		DigestSignature:       signature,
+	}, nil
+}
+
+func (f *fakeServer) SubscribeCheckpoints(ctx context.Context, req *legolog_grpcint.SubscribeCheckpointsRequest) (
+	legolog_grpcint.LegoLog_SubscribeCheckpointsClient, error) {
+	return &fakeCheckpointStream{ctx: ctx, server: f, sizes: req.OldSizes}, nil
+}
+
+// fakeCheckpointStream pushes the checkpoints of every partition right away
+// and then each time the server publishes, proving each consistent with the
+// last one it pushed.
+type fakeCheckpointStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	server *fakeServer
+	sizes  []uint64
+	sent   bool
+}
+
+func (s *fakeCheckpointStream) Recv() (*legolog_grpcint.SubscribeCheckpointsResponse, error) {
+	s.server.recvs <- struct{}{}
+	if s.sent {
+		select {
+		case <-s.server.published:
+		case <-s.ctx.Done():
+			return nil, s.ctx.Err()
+		}
+	}
+	s.sent = true
+	response := &legolog_grpcint.SubscribeCheckpointsResponse{}
+	for i := range s.server.partitions {
+		checkpoint, _ := s.server.GetNewUpdateCheckPoint(s.ctx, &legolog_grpcint.GetNewCheckPointRequest{
+			OldSize:        s.sizes[i],
+			PartitionIndex: uint64(i),
+		})
+		s.sizes[i] = uint64(s.server.partitions[i].GetDigest().UpdateLogSize)
+		response.PartitionIndices = append(response.PartitionIndices, uint64(i))
+		response.Checkpoints = append(response.Checkpoints, checkpoint)
+	}
+	return response, nil
+}
+
+func newTestAuditor(t *testing.T, client legolog.BasicClient, db storage.Storage) *Auditor {
+	a := &Auditor{
+		client:  client,
+		db:      db,
+		config:  core.Config{Partitions: 2, LogID: testLogID},
+		stopper: make(chan struct{}),
+	}
+	a.initializeCheckpoints(a.config.Partitions)
+	if err := a.loadState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.loadWitnessKey(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuditorResumesFromStoredCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewMapStorage()
+	server := newFakeServer(2)
+	server.nextEpoch("0")
+
+	a := newTestAuditor(t, server, db)
+	if _, err := a.GetGlobalDigest(ctx, &legolog_grpcint.GetGlobalDigestRequest{}); err != errNotReady {
+		t.Fatalf("expected the auditor to refuse clients before verifying, got %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		a.QueryServerUpdatePeriod()
+		server.nextEpoch(strconv.Itoa(i))
+	}
+	if _, err := a.GetGlobalDigest(ctx, &legolog_grpcint.GetGlobalDigestRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	stored := a.GlobalDigest
+
+	// a server presenting a different history after the restart is refused
+	forked := newFakeServer(2)
+	for i := 0; i < 5; i++ {
+		forked.nextEpoch("forked" + strconv.Itoa(i))
+	}
+	a = newTestAuditor(t, forked, db)
+	if a.GlobalDigest == nil || a.GlobalDigest.Epoch != stored.Epoch {
+		t.Fatalf("expected to resume from epoch %d", stored.Epoch)
+	}
+	a.QueryServerUpdatePeriod()
+	if _, err := a.GetEpochUpdate(ctx, &legolog_grpcint.GetEpochUpdateRequest{}); err != errNotReady {
+		t.Fatalf("auditor accepted a forked history after restarting, got %v", err)
+	}
+
+	// the honest server extends the stored state
+	a = newTestAuditor(t, server, db)
+	a.QueryServerUpdatePeriod()
+	if !a.isReady() {
+		t.Fatal("auditor did not accept a checkpoint extending its stored state")
+	}
+	if a.GlobalDigest.Epoch != server.epoch {
+		t.Errorf("expected epoch %d, got %d", server.epoch, a.GlobalDigest.Epoch)
+	}
+}
+
+// failingStorage fails every read.
+type failingStorage struct {
+	storage.Storage
+}
+
+var errStorage = errors.New("storage failed")
+
+func (failingStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return nil, errStorage
+}
+
+func TestAuditorKeepsOnlyLatestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewMapStorage()
+	server := newFakeServer(2)
+	a := newTestAuditor(t, server, db)
+	for i := 0; i < 3; i++ {
+		server.nextEpoch(strconv.Itoa(i))
+		a.QueryServerUpdatePeriod()
+	}
+	var keys []string
+	err := db.Iterate(ctx, storage.PrefixRange([]byte(checkpointKeyPrefix)), func(key []byte, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := checkpointKeyPrefix + strconv.FormatUint(a.GlobalDigest.Epoch, 10)
+	if len(keys) != 1 || keys[0] != latest {
+		t.Errorf("expected only %s to be stored, got %v", latest, keys)
+	}
+
+	a = &Auditor{db: failingStorage{db}, config: a.config}
+	a.initializeCheckpoints(a.config.Partitions)
+	if err := a.loadState(ctx); !errors.Is(err, errStorage) {
+		t.Errorf("expected loading the state to fail with the storage, got %v", err)
+	}
+	if err := a.loadWitnessKey(ctx); !errors.Is(err, errStorage) {
+		t.Errorf("expected loading the witness key to fail with the storage, got %v", err)
+	}
+}
+
+func TestAuditorServesWhileVerifying(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(2)
+	server.nextEpoch("0")
+	a := newTestAuditor(t, server, storage.NewMapStorage())
+	a.QueryServerUpdatePeriod()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 3; i++ {
+			server.nextEpoch(strconv.Itoa(i))
+			a.QueryServerUpdatePeriod()
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			response, err := a.GetEpochUpdateForPartition(ctx, &legolog_grpcint.GetEpochUpdateForPartitionRequest{Partition: 1})
+			if err != nil || response.GetCkPoint() == nil {
+				t.Fatalf("expected the checkpoint of partition 1, got %v", err)
+			}
+			var digest core.LegologDigest
+			var globalDigest core.GlobalDigest
+			var inclusionProof core.PartitionInclusionProof
+			json.Unmarshal(response.GetCkPoint().GetMarshaledDigest(), &digest)
+			json.Unmarshal(response.GetMarshaledGlobalDigest(), &globalDigest)
+			json.Unmarshal(response.GetPartitionProof(), &inclusionProof)
+			if inclusionProof.Index != 1 || !core.VerifyPartitionInclusionProof(&globalDigest, &digest, &inclusionProof) {
+				t.Error("expected the checkpoint to be included in the global digest served with it")
+			}
+			if _, err := a.GetEpochUpdateForPartition(ctx, &legolog_grpcint.GetEpochUpdateForPartitionRequest{Partition: 2}); err == nil {
+				t.Error("expected no checkpoint for a partition out of range")
+			}
+			return
+		default:
+		}
+		if _, err := a.GetEpochUpdate(ctx, &legolog_grpcint.GetEpochUpdateRequest{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.GetGlobalDigest(ctx, &legolog_grpcint.GetGlobalDigestRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAuditorReportsMisbehavior(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewMapStorage()
+	serverSK, serverVK := crypto.GenerateKeypair()
+	newSigningServer := func(keys ...string) *fakeServer {
+		server := newFakeServer(2)
+		server.signingSK, server.signingVK = serverSK, serverVK
+		for _, key := range keys {
+			server.nextEpoch(key)
+		}
+		return server
+	}
+	server := newSigningServer("0")
+	a := newTestAuditor(t, server, db)
+	a.EnableGossip(serverVK)
+	a.QueryServerUpdatePeriod()
+	server.nextEpoch("1")
+	a.QueryServerUpdatePeriod()
+	verified := a.GlobalDigest
+
+	// a proof that does not verify between two honest checkpoints, as one
+	// corrupted on the way, is not evidence of misbehavior
+	tampered := newSigningServer("0", "1", "2")
+	tampered.tamperProof = true
+	a.client = tampered
+	a.QueryServerUpdatePeriod()
+	if a.GlobalDigest != verified {
+		t.Fatal("auditor advanced to a checkpoint it could not prove extends the verified one")
+	}
+	if reports := a.MisbehaviorReports(0); len(reports) != 0 {
+		t.Fatalf("expected no reports of a tampered proof, got %d", len(reports))
+	}
+
+	// the server signs a checkpoint that rolls back the log
+	a.client = newSigningServer("0")
+	a.QueryServerUpdatePeriod()
+	a.QueryServerUpdatePeriod()
+	if a.GlobalDigest != verified {
+		t.Fatal("auditor advanced to a checkpoint that rolls back the verified one")
+	}
+
+	response, err := a.GetMisbehaviorReports(ctx, &legolog_grpcint.GetMisbehaviorReportsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.GetMarshaledReports()) != 2 {
+		t.Fatalf("expected a single report for each partition, got %d", len(response.GetMarshaledReports()))
+	}
+	for i, marshaledReport := range response.GetMarshaledReports() {
+		var report core.MisbehaviorEvidence
+		if err := json.Unmarshal(marshaledReport, &report); err != nil {
+			t.Fatal(err)
+		}
+		if err := report.Verify([]byte(testLogID), 2, serverVK); err != nil {
+			t.Errorf("report %d did not verify: %v", i, err)
+		}
+	}
+
+	// reports survive a restart
+	a = newTestAuditor(t, server, db)
+	if len(a.MisbehaviorReports(1)) != 1 {
+		t.Errorf("expected to reload both reports, got %d", len(a.MisbehaviorReports(0)))
+	}
+
+	// without the server's key the auditor cannot tell a fork from a bad
+	// proof, so it refuses to advance but reports nothing
+	unsigned := newFakeServer(2)
+	unsigned.nextEpoch("0")
+	b := newTestAuditor(t, unsigned, storage.NewMapStorage())
+	b.QueryServerUpdatePeriod()
+	b.client = newFakeServer(2)
+	b.QueryServerUpdatePeriod()
+	if len(b.MisbehaviorReports(0)) != 0 {
+		t.Error("auditor reported digests the server did not sign")
+	}
+}
+
+func TestAuditorCosignsVerifiedDigests(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewMapStorage()
+	server := newFakeServer(2)
+	server.nextEpoch("0")
+	a := newTestAuditor(t, server, db)
+	a.QueryServerUpdatePeriod()
+	older := a.UpdateDigests[0]
+	server.nextEpoch("1")
+	a.QueryServerUpdatePeriod()
+	policy := &core.WitnessPolicy{WitnessKeys: [][]byte{a.WitnessKey()}, Threshold: 1}
+
+	response, err := a.GetCosignedCheckpoint(ctx, &legolog_grpcint.GetCosignedCheckpointRequest{Partition: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var digest core.LegologDigest
+	var cosignature core.Cosignature
+	if err := json.Unmarshal(response.GetMarshaledDigest(), &digest); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(response.GetMarshaledCosignature(), &cosignature); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(digest.Hash(), a.UpdateDigests[0].Hash()) {
+		t.Error("cosigned checkpoint is not the latest verified one")
+	}
+	if err := policy.Check(&digest, []*core.Cosignature{&cosignature}); err != nil {
+		t.Error(err)
+	}
+
+	// digests verified before are still cosigned on request, others are not
+	cosign := func(digest *core.LegologDigest) (*core.Cosignature, error) {
+		marshaledDigest, _ := json.Marshal(digest)
+		response, err := a.CosignCheckpoint(ctx, &legolog_grpcint.CosignCheckpointRequest{Partition: 0, MarshaledDigest: marshaledDigest})
+		if err != nil {
+			return nil, err
+		}
+		var cosignature core.Cosignature
+		return &cosignature, json.Unmarshal(response.GetMarshaledCosignature(), &cosignature)
+	}
+	olderCosignature, err := cosign(older)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Check(older, []*core.Cosignature{olderCosignature}); err != nil {
+		t.Error(err)
+	}
+	forked := newFakeServer(2)
+	forked.nextEpoch("forked")
+	forked.nextEpoch("1")
+	if _, err := cosign(forked.partitions[0].GetDigest()); err != errNotVerified {
+		t.Errorf("expected the auditor to refuse to cosign a digest it did not verify, got %v", err)
+	}
+	marshaledOlder, _ := json.Marshal(older)
+	if _, err := a.CosignCheckpoint(ctx, &legolog_grpcint.CosignCheckpointRequest{Partition: 2, MarshaledDigest: marshaledOlder}); err == nil {
+		t.Error("cosigned a digest of a partition that does not exist")
+	}
+
+	// the witness key survives a restart
+	if !bytes.Equal(newTestAuditor(t, server, db).WitnessKey(), a.WitnessKey()) {
+		t.Error("auditor changed its witness key across a restart")
+	}
+}
+
+// localGossipPeer calls the Gossip service of another auditor directly.
+type localGossipPeer struct {
+	auditor *Auditor
+}
+
+func (l *localGossipPeer) ExchangeDigests(ctx context.Context, req *legolog_grpcint.ExchangeDigestsRequest,
+	opts ...grpc.CallOption) (*legolog_grpcint.ExchangeDigestsResponse, error) {
+	return l.auditor.GossipPool().ExchangeDigests(ctx, req)
+}
+
+func TestAuditorGossipsSplitView(t *testing.T) {
+	serverSK, serverVK := crypto.GenerateKeypair()
+	newSigningServer := func(keys ...string) *fakeServer {
+		server := newFakeServer(2)
+		server.signingSK, server.signingVK = serverSK, serverVK
+		for _, key := range keys {
+			server.nextEpoch(key)
+		}
+		return server
+	}
+
+	// the server shows each auditor a history of its own, each consistent
+	// on its own
+	a := newTestAuditor(t, newSigningServer("0", "1"), storage.NewMapStorage())
+	a.EnableGossip(serverVK)
+	b := newTestAuditor(t, newSigningServer("0", "forked"), storage.NewMapStorage())
+	b.EnableGossip(serverVK)
+	a.QueryServerUpdatePeriod()
+	b.QueryServerUpdatePeriod()
+	if !a.isReady() || !b.isReady() {
+		t.Fatal("auditors did not accept the server's checkpoints")
+	}
+
+	a.gossipPeers = []legolog_grpcint.GossipClient{&localGossipPeer{b}}
+	a.Gossip()
+	for name, auditor := range map[string]*Auditor{"a": a, "b": b} {
+		reports := auditor.MisbehaviorReports(0)
+		if len(reports) != 2 {
+			t.Fatalf("expected auditor %s to report a split view of each partition, got %d reports", name, len(reports))
+		}
+		for _, report := range reports {
+			if err := report.Verify([]byte(testLogID), 2, serverVK); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	// once gossip is enabled, checkpoints the server did not sign are refused
+	c := newTestAuditor(t, newFakeServer(2), storage.NewMapStorage())
+	c.EnableGossip(serverVK)
+	c.QueryServerUpdatePeriod()
+	if c.isReady() {
+		t.Error("auditor accepted checkpoints the server did not sign")
+	}
+}
+
+func TestAuditorSignsConsistencyEvidence(t *testing.T) {
+	serverSK, serverVK := crypto.GenerateKeypair()
+	newSigningServer := func(keys ...string) *fakeServer {
+		server := newFakeServer(2)
+		server.signingSK, server.signingVK = serverSK, serverVK
+		for _, key := range keys {
+			server.nextEpoch(key)
+		}
+		return server
+	}
+	db := storage.NewMapStorage()
+	a := newTestAuditor(t, newSigningServer("0", "1"), db)
+	a.EnableGossip(serverVK)
+	a.QueryServerUpdatePeriod()
+
+	// the stored signatures make up evidence found after a restart
+	a = newTestAuditor(t, newSigningServer("0", "forked"), db)
+	a.EnableGossip(serverVK)
+	a.QueryServerUpdatePeriod()
+	reports := a.MisbehaviorReports(0)
+	if len(reports) != 2 {
+		t.Fatalf("expected a report for each partition, got %d", len(reports))
+	}
+	for _, report := range reports {
+		if report.SplitView {
+			t.Error("expected evidence of a digest that does not extend the verified one")
+		}
+		if err := report.Verify([]byte(testLogID), 2, serverVK); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestAuditorFullAudit(t *testing.T) {
+	server := newFakeServer(2)
+	db := storage.NewMapStorage()
+	a := newTestAuditor(t, server, db)
+	a.config.FullAudit = true
+	for i := 0; i < 6; i++ {
+		server.nextEpoch(strconv.Itoa(i))
+		if i%2 == 1 {
+			server.nextPeriod()
+		}
+		a.QueryServerUpdatePeriod()
+		if !a.isReady() || a.GlobalDigest.Epoch != server.epoch {
+			t.Fatalf("auditor did not accept epoch %d of an honest server", server.epoch)
+		}
+	}
+	if _, err := server.partitions[0].GetEpochUpdates(1); err == nil {
+		t.Error("server kept the updates the auditor stored")
+	}
+
+	// after a restart, the auditor replays the updates it stored
+	a = newTestAuditor(t, server, db)
+	a.config.FullAudit = true
+	server.nextEpoch("6")
+	a.QueryServerUpdatePeriod()
+	if !a.isReady() || a.GlobalDigest.Epoch != server.epoch {
+		t.Fatal("auditor did not resume its full audit from the updates it stored")
+	}
+
+	// the positions of updates are not part of the update prefix trees, so
+	// only replaying them into the base trees catches the forgery
+	server.forgePositions = true
+	a = newTestAuditor(t, server, storage.NewMapStorage())
+	a.QueryServerUpdatePeriod()
+	if !a.isReady() {
+		t.Fatal("auditor without a full audit did not accept the checkpoint")
+	}
+	a = newTestAuditor(t, server, storage.NewMapStorage())
+	a.config.FullAudit = true
+	a.QueryServerUpdatePeriod()
+	if a.isReady() {
+		t.Fatal("full audit accepted base trees that do not match the served updates")
+	}
+}
+
+func TestAuditorChecksHashChain(t *testing.T) {
+	server := newFakeServer(2)
+	for _, key := range []string{"0", "1"} {
+		server.nextEpoch(key)
+		server.nextPeriod()
+	}
+	a := newTestAuditor(t, server, storage.NewMapStorage())
+	a.QueryServerUpdatePeriod()
+	verified := a.GlobalDigest
+
+	// a history that moved an update into another verification period has
+	// the same update log, so only the hash chain shows the fork
+	forked := newFakeServer(2)
+	forked.nextEpoch("0")
+	forked.nextEpoch("1")
+	forked.nextPeriod()
+	forked.nextPeriod()
+	forked.nextEpoch("2")
+	forked.nextPeriod()
+	a.client = forked
+	a.QueryServerUpdatePeriod()
+	if a.GlobalDigest != verified {
+		t.Fatal("auditor accepted a forked verification period history")
+	}
+
+	a.client = server
+	for _, key := range []string{"2", "3"} {
+		server.nextEpoch(key)
+		server.nextPeriod()
+	}
+	a.QueryServerUpdatePeriod()
+	if a.GlobalDigest.Epoch != server.epoch {
+		t.Fatal("auditor did not accept an honest history after skipping verification periods")
+	}
+}
+
+func TestAuditorRejectsPublicationTimeGoingBack(t *testing.T) {
+	server := newFakeServer(2)
+	server.nextEpoch("0")
+	now := time.Now()
+	server.publishedAt = now.UnixNano()
+	a := newTestAuditor(t, server, storage.NewMapStorage())
+	a.QueryServerUpdatePeriod()
+	verified := a.GlobalDigest
+
+	// a server that backdates its checkpoints could pass off an old digest
+	// as fresh to clients that bound its staleness
+	server.nextEpoch("1")
+	server.publishedAt = now.Add(-time.Hour).UnixNano()
+	a.QueryServerUpdatePeriod()
+	if a.GlobalDigest != verified {
+		t.Fatal("auditor accepted a checkpoint published before the last verified one")
+	}
+
+	server.publishedAt = now.Add(time.Second).UnixNano()
+	a.QueryServerUpdatePeriod()
+	if a.GlobalDigest.Epoch != server.epoch {
+		t.Fatal("auditor did not accept a checkpoint published after the last verified one")
+	}
+}
+
+func TestAuditorPollsPartitionsIndependently(t *testing.T) {
+	server := newFakeServer(2)
+	server.nextEpoch("0")
+	a := newTestAuditor(t, server, storage.NewMapStorage())
+	a.config.PartitionTimeout = 50 * time.Millisecond
+	a.QueryServerUpdatePeriod()
+
+	// a stalled partition does not hold back the other one
+	server.nextEpoch("1")
+	server.stalled = map[uint64]bool{1: true}
+	a.QueryServerUpdatePeriod()
+	a.QueryServerUpdatePeriod()
+	if a.UpdateDigests[0].Epoch != server.epoch {
+		t.Fatalf("expected partition 0 to reach epoch %d, got %d", server.epoch, a.UpdateDigests[0].Epoch)
+	}
+	if a.UpdateDigests[1].Epoch != 1 {
+		t.Fatalf("stalled partition advanced to epoch %d", a.UpdateDigests[1].Epoch)
+	}
+	if a.GlobalDigest.Epoch != 1 {
+		t.Fatal("global digest advanced without every partition verifying under it")
+	}
+	statuses := a.PartitionStatuses()
+	if statuses[0].LastError != nil || statuses[0].VerifiedEpoch != server.epoch {
+		t.Errorf("unexpected status of partition 0: %+v", statuses[0])
+	}
+	if statuses[1].LastError == nil || statuses[1].ConsecutiveFailures != 2 || statuses[1].VerifiedEpoch != 1 {
+		t.Errorf("unexpected status of stalled partition: %+v", statuses[1])
+	}
+
+	server.stalled = nil
+	a.QueryServerUpdatePeriod()
+	if a.GlobalDigest.Epoch != server.epoch || a.PartitionStatuses()[1].ConsecutiveFailures != 0 {
+		t.Fatal("auditor did not catch up once the partition answered again")
+	}
+}
+
+func TestAuditorWaitsForEveryPartition(t *testing.T) {
+	server := newFakeServer(2)
+	server.nextEpoch("0")
+	server.stalled = map[uint64]bool{0: true}
+	a := newTestAuditor(t, server, storage.NewMapStorage())
+	a.config.PartitionTimeout = 10 * time.Millisecond
+	a.QueryServerUpdatePeriod()
+	if a.isReady() {
+		t.Fatal("auditor served clients before verifying every partition")
+	}
+	server.stalled = nil
+	a.QueryServerUpdatePeriod()
+	if !a.isReady() {
+		t.Fatal("auditor did not become ready once every partition verified")
+	}
+}
+
+func TestAuditorCatchesUpAcrossVerificationPeriods(t *testing.T) {
+	for _, skipCheckpoint := range []bool{false, true} {
+		server := newFakeServer(2)
+		server.nextEpoch("0")
+		a := newTestAuditor(t, server, storage.NewMapStorage())
+		a.QueryServerUpdatePeriod()
+
+		// the auditor misses several verification periods
+		server.skipUpdateLogCheckpoint = skipCheckpoint
+		for i := 1; i <= 4; i++ {
+			server.nextEpoch(strconv.Itoa(i))
+			server.nextPeriod()
+		}
+		a.QueryServerUpdatePeriod()
+		caughtUp := a.GlobalDigest.Epoch == server.epoch
+		if caughtUp == skipCheckpoint {
+			t.Errorf("skipping a checkpoint %t: auditor caught up %t", skipCheckpoint, caughtUp)
+		}
+		if caughtUp && a.VerificationCheckpoints[0] != a.UpdateCheckpoints[0] {
+			t.Error("expected the polled checkpoint starting the verification period")
+		}
+	}
+}
+
+func TestAuditorFollowsCheckpointStream(t *testing.T) {
+	server := newFakeServer(2)
+	server.published, server.recvs = make(chan struct{}), make(chan struct{})
+	server.nextEpoch("0")
+	a := newTestAuditor(t, server, storage.NewMapStorage())
+	a.QueryServerUpdatePeriod()
+	server.nextEpoch("1")
+
+	done := make(chan error)
+	go func() { done <- a.followCheckpoints() }()
+	// each receive after the first means the previous batch was handled
+	<-server.recvs
+	<-server.recvs
+	if a.GlobalDigest.Epoch != server.epoch {
+		t.Fatalf("auditor did not verify the checkpoints pushed on subscribing")
+	}
+
+	for _, key := range []string{"2", "3"} {
+		server.nextEpoch(key)
+		server.nextPeriod()
+		server.published <- struct{}{}
+		<-server.recvs
+		if a.GlobalDigest.Epoch != server.epoch {
+			t.Fatalf("auditor did not verify the checkpoints of epoch %d", server.epoch)
+		}
+	}
+	for i, checkpoint := range a.VerificationCheckpoints {
+		if checkpoint != a.UpdateCheckpoints[i] {
+			t.Errorf("partition %d: expected the checkpoint starting the verification period", i)
+		}
+	}
+
+	// a partition the auditor did not advance is polled instead
+	a.UpdateDigests[1] = nil
+	server.nextEpoch("4")
+	server.published <- struct{}{}
+	<-server.recvs
+	if a.GlobalDigest.Epoch != server.epoch {
+		t.Fatal("auditor did not recover a partition behind the stream")
+	}
+
+	close(a.stopper)
+	if err := <-done; err == nil {
+		t.Error("expected the stream to end with the auditor")
+	}
+}
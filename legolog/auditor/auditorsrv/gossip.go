@@ -0,0 +1,110 @@
+package auditorsrv
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/huyuncong/MerkleSquare/core"
+	"github.com/huyuncong/MerkleSquare/legolog/gossip"
+)
+
+// defaultGossipPeriod is how often the auditor gossips when no update period
+// is configured.
+const defaultGossipPeriod = time.Second
+
+// EnableGossip makes the auditor require the server's signature on every
+// checkpoint, and keep the signed digests it sees in a pool it gossips with
+// other auditors and clients. Split views found in the pool are reported as
+// misbehavior.
+func (a *Auditor) EnableGossip(serverVK []byte) *gossip.Pool {
+	a.serverVK = serverVK
+	a.gossip = gossip.NewPool(serverVK, func(e *core.MisbehaviorEvidence) {
+		a.reportMisbehavior(context.Background(), []*core.MisbehaviorEvidence{e})
+	})
+	return a.gossip
+}
+
+// GossipPool returns the auditor's pool of signed digests, which serves the
+// Gossip service, or nil if gossip is not enabled.
+func (a *Auditor) GossipPool() *gossip.Pool {
+	return a.gossip
+}
+
+// enableGossipFromConfig enables gossip if the config names the server's key,
+// and starts gossiping with the configured peers.
+func (a *Auditor) enableGossipFromConfig() error {
+	if a.config.ServerKey == "" {
+		return nil
+	}
+	serverVK, err := hex.DecodeString(a.config.ServerKey)
+	if err != nil {
+		return fmt.Errorf("server key: %v", err)
+	}
+	a.EnableGossip(serverVK)
+	for _, addr := range a.config.GossipPeers {
+		peer, err := gossip.Dial(addr)
+		if err != nil {
+			return err
+		}
+		a.gossipPeers = append(a.gossipPeers, peer)
+	}
+	if len(a.gossipPeers) != 0 || a.config.GossipDir != "" {
+		go a.GossipLoop()
+	}
+	return nil
+}
+
+// gossipDigest adds a digest the server sent to the auditor's pool, if gossip
+// is enabled, in which case the digest must be signed.
+func (a *Auditor) gossipDigest(digest *core.LegologDigest, signature []byte) error {
+	if a.gossip == nil {
+		return nil
+	}
+	_, err := a.gossip.Add(&core.SignedDigest{Digest: digest, Signature: signature})
+	if err != nil {
+		return fmt.Errorf("could not gossip checkpoint of epoch %d: %w", digest.Epoch, err)
+	}
+	return nil
+}
+
+// GossipLoop exchanges digests with the auditor's peers every update period,
+// until the auditor is stopped.
+func (a *Auditor) GossipLoop() {
+	period := a.config.UpdatePeriod
+	if period == 0 {
+		period = defaultGossipPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.Gossip()
+		case <-a.stopper:
+			fmt.Println("Stopping gossip loop!")
+			return
+		}
+	}
+}
+
+// Gossip exchanges digests once with every peer and through the gossip
+// directory, if one is configured. Split views found are reported by the
+// pool.
+func (a *Auditor) Gossip() {
+	for i, peer := range a.gossipPeers {
+		ctx, cancel := context.WithTimeout(context.Background(), a.partitionTimeout())
+		_, err := a.gossip.GossipWith(ctx, peer)
+		cancel()
+		if err != nil {
+			fmt.Printf("Could not gossip with peer %d: %v\n", i, err)
+		}
+	}
+	if a.config.GossipDir != "" {
+		name := "auditor-" + hex.EncodeToString(a.witnessVK)
+		if _, err := a.gossip.ExchangeDir(a.config.GossipDir, name); err != nil {
+			fmt.Printf("Could not gossip through %s: %v\n", a.config.GossipDir, err)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package auditorsrv
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 
+	"github.com/huyuncong/MerkleSquare/core"
 	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
 )
 
@@ -17,24 +20,172 @@ func (a *Auditor) GetEpochUpdate(ctx context.Context,
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	if !a.isReady() {
+		return nil, errNotReady
+	}
+
+	a.stateLock.RLock()
+	checkpoints := append([]*legolog_grpcint.CheckPoint{}, a.UpdateCheckpoints...)
+	globalDigest := a.GlobalDigest
+	a.stateLock.RUnlock()
+	marshaledGlobalDigest, err := json.Marshal(globalDigest)
+	if err != nil {
+		return nil, err
+	}
 
 	return &legolog_grpcint.GetEpochUpdateResponse{
-		CkPoints: a.UpdateCheckpoints,
+		CkPoints:              checkpoints,
+		MarshaledGlobalDigest: marshaledGlobalDigest,
 	}, nil
 }
 
 // GetEpochUpdateForPartition implements server-side logic for client requesting epoch
 // update from the auditor for a partition. The auditor periodically queries the server and
 // maintains the latest checkpoint, so when client requests the latest
-// checkpoint the auditor can simply return the cached checkpoint.
+// checkpoint the auditor can simply return the cached checkpoint, along with
+// the global digest it was verified under and the proof of its inclusion.
 func (a *Auditor) GetEpochUpdateForPartition(ctx context.Context,
 	req *legolog_grpcint.GetEpochUpdateForPartitionRequest) (*legolog_grpcint.GetEpochUpdateForPartitionResponse, error) {
 
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	if !a.isReady() {
+		return nil, errNotReady
+	}
+
+	a.stateLock.RLock()
+	defer a.stateLock.RUnlock()
+	i := req.GetPartition()
+	if i >= uint64(len(a.UpdateCheckpoints)) {
+		return nil, fmt.Errorf("no partition %d", i)
+	}
+	if a.partitionGlobalDigests[i] == nil {
+		return nil, errNotReady
+	}
+	marshaledGlobalDigest, err := json.Marshal(a.partitionGlobalDigests[i])
+	if err != nil {
+		return nil, err
+	}
 
 	return &legolog_grpcint.GetEpochUpdateForPartitionResponse{
-		CkPoint: a.UpdateCheckpoints[req.GetPartition()],
+		CkPoint:               a.UpdateCheckpoints[i],
+		MarshaledGlobalDigest: marshaledGlobalDigest,
+		PartitionProof:        a.partitionProofs[i],
+	}, nil
+}
+
+// GetGlobalDigest implements server-side logic for client requesting the
+// latest global digest verified by the auditor. Comparing it is enough to
+// agree on the state of every partition.
+func (a *Auditor) GetGlobalDigest(ctx context.Context,
+	req *legolog_grpcint.GetGlobalDigestRequest) (*legolog_grpcint.GetGlobalDigestResponse, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !a.isReady() {
+		return nil, errNotReady
+	}
+
+	a.stateLock.RLock()
+	globalDigest := a.GlobalDigest
+	a.stateLock.RUnlock()
+	marshaledGlobalDigest, err := json.Marshal(globalDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &legolog_grpcint.GetGlobalDigestResponse{
+		MarshaledGlobalDigest: marshaledGlobalDigest,
+	}, nil
+}
+
+// GetMisbehaviorReports implements server-side logic for requesting the
+// evidence of server misbehavior the auditor has collected, from a given
+// report on. Each report can be checked without trusting the auditor, so
+// they are served even before the auditor is ready.
+func (a *Auditor) GetMisbehaviorReports(ctx context.Context,
+	req *legolog_grpcint.GetMisbehaviorReportsRequest) (*legolog_grpcint.GetMisbehaviorReportsResponse, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var marshaledReports [][]byte
+	for _, report := range a.MisbehaviorReports(req.GetStartIndex()) {
+		marshaledReport, err := json.Marshal(report)
+		if err != nil {
+			return nil, err
+		}
+		marshaledReports = append(marshaledReports, marshaledReport)
+	}
+
+	return &legolog_grpcint.GetMisbehaviorReportsResponse{
+		MarshaledReports: marshaledReports,
+	}, nil
+}
+
+// GetCosignedCheckpoint implements server-side logic for a client requesting
+// the latest digest of a partition the auditor verified, together with the
+// auditor's cosignature of it as a witness.
+func (a *Auditor) GetCosignedCheckpoint(ctx context.Context,
+	req *legolog_grpcint.GetCosignedCheckpointRequest) (*legolog_grpcint.GetCosignedCheckpointResponse, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !a.isReady() {
+		return nil, errNotReady
+	}
+
+	cosigned, err := a.latestCosigned(req.GetPartition())
+	if err != nil {
+		return nil, err
+	}
+	marshaledDigest, err := json.Marshal(cosigned.digest)
+	if err != nil {
+		return nil, err
+	}
+	marshaledCosignature, err := json.Marshal(cosigned.cosignature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &legolog_grpcint.GetCosignedCheckpointResponse{
+		MarshaledDigest:      marshaledDigest,
+		MarshaledCosignature: marshaledCosignature,
+	}, nil
+}
+
+// CosignCheckpoint implements server-side logic for a client asking the
+// auditor to cosign a digest of a partition it got from another witness. The
+// auditor only cosigns digests it has verified itself.
+func (a *Auditor) CosignCheckpoint(ctx context.Context,
+	req *legolog_grpcint.CosignCheckpointRequest) (*legolog_grpcint.CosignCheckpointResponse, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !a.isReady() {
+		return nil, errNotReady
+	}
+
+	var digest core.LegologDigest
+	err := json.Unmarshal(req.GetMarshaledDigest(), &digest)
+	if err != nil {
+		return nil, err
+	}
+	cosignature, err := a.findCosigned(req.GetPartition(), &digest)
+	if err != nil {
+		return nil, err
+	}
+	marshaledCosignature, err := json.Marshal(cosignature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &legolog_grpcint.CosignCheckpointResponse{
+		MarshaledCosignature: marshaledCosignature,
 	}, nil
 }
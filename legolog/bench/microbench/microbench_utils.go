@@ -268,10 +268,10 @@ func getSiblingSize(sib *core.Sibling) int {
 func getMemProofSize(memProof *core.MembershipProof) int {
 	total := 0
 
-	total += binary.Size(memProof.LeafPartialPrefix) / 8
+	total += binary.Size(memProof.LeafPartialPrefix.Packed)
 
 	for _, copathNode := range memProof.CopathNodes {
-		total += binary.Size(copathNode.OtherChildHash) + binary.Size(copathNode.PartialPrefix)/8
+		total += binary.Size(copathNode.OtherChildHash) + binary.Size(copathNode.PartialPrefix.Packed)
 	}
 
 	return total
@@ -281,10 +281,10 @@ func getNonMemProofSize(nonMemProof *core.NonMembershipProof) int {
 
 	total := 0
 
-	total += binary.Size(nonMemProof.EndNodeHash) + binary.Size(nonMemProof.EndNodePartialPrefix)/8
+	total += binary.Size(nonMemProof.EndNodeHash) + binary.Size(nonMemProof.EndNodePartialPrefix.Packed)
 
 	for _, copathNode := range nonMemProof.CopathNodes {
-		total += binary.Size(copathNode.OtherChildHash) + binary.Size(copathNode.PartialPrefix)/8
+		total += binary.Size(copathNode.OtherChildHash) + binary.Size(copathNode.PartialPrefix.Packed)
 	}
 
 	return total
@@ -292,7 +292,7 @@ func getNonMemProofSize(nonMemProof *core.NonMembershipProof) int {
 
 func getLeafHashSize(leafHash *core.LeafHash) int {
 	//return binary.Size(leafHash.Prefix) + binary.Size(leafHash.NodeContentHash)
-	return binary.Size(leafHash.Prefix)/8 + binary.Size(leafHash.NodeContentHash)
+	return binary.Size(leafHash.Prefix.Packed) + binary.Size(leafHash.NodeContentHash)
 }
 
 func getKeyHashSize(keyHash *core.KeyHash) int {
@@ -161,6 +161,7 @@ func helperMonitorMicrobenchDebug(b *testing.B, numPartitions, numAppends int, n
 	} else {
 		partition = core.NewPartition()
 	}
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
 	masterSK, masterVK := crypto.GenerateKeypair()
 
 	numAppendsPerVerificationPeriod := 100
@@ -286,6 +287,7 @@ func helperMonitorMicrobenchDebugOptimized(b *testing.B, numPartitions int, numA
 	} else {
 		partition = core.NewPartition()
 	}
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
 	masterSK, masterVK := crypto.GenerateKeypair()
 
 	preloadAppends := 32
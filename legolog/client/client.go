@@ -1,10 +1,18 @@
 package legolog
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/huyuncong/MerkleSquare/core"
+	"github.com/huyuncong/MerkleSquare/legolog/auditor/auditorclt"
+	"github.com/huyuncong/MerkleSquare/legolog/gossip"
 	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
 
 	"github.com/immesys/bw2/crypto"
@@ -16,10 +24,38 @@ import (
 // client, and verifier client.
 type Client struct {
 	legologClient  BasicClient
-	auditorClient  *struct{} //auditorclt.Client
+	auditorClient  auditorclt.Client
 	verifierClient *struct{} // verifierclt.Client
 
+	// the auditors the client trusts as witnesses, if any, and how many of
+	// them must cosign a digest
+	witnesses     []auditorclt.Client
+	witnessPolicy *core.WitnessPolicy
+
+	// the signed digests the client gossips, if gossip is enabled
+	gossip *gossip.Pool
+
+	// how long ago a digest may have been published for the client to still
+	// verify against it; 0 accepts any digest
+	maxStaleness time.Duration
+
+	// checks proofs against digests bound to the log set with SetLog, or only
+	// against the identifier's partition if none was
+	verifier core.AggHistVerifier
+
 	masterKeys map[string]MasterKeyRecord
+
+	// the latest value this client appended for each identifier, checked by
+	// the monitor
+	ownedValues     map[string]*ownedValue
+	ownedValuesLock *sync.Mutex
+	// the started monitor, which saves the owned values in its state file
+	monitor *Monitor
+
+	// the newest audited digest the client has seen for each partition, whose
+	// hash chain every later digest must extend
+	seenDigests     map[uint64]*core.LegologDigest
+	seenDigestsLock sync.Mutex
 }
 
 type MasterKeyRecord struct {
@@ -27,6 +63,23 @@ type MasterKeyRecord struct {
 	masterVK []byte
 }
 
+// VerificationError is returned by the verifying lookups when the proof
+// returned by the server does not check out against the digest of the
+// identifier's partition that the auditor has verified.
+type VerificationError struct {
+	Identifier []byte
+	Partition  uint64
+	Err        error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("could not verify lookup of %q in partition %d: %v", e.Identifier, e.Partition, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
 // NewClient connects to the server and the daemon, and returns a Client
 // representing that connection.
 func NewClient(serverAddr string, auditorAddr string, verifierAddr string) (
@@ -34,18 +87,20 @@ func NewClient(serverAddr string, auditorAddr string, verifierAddr string) (
 
 	var err error
 	c := Client{
-		masterKeys: make(map[string]MasterKeyRecord),
+		masterKeys:      make(map[string]MasterKeyRecord),
+		ownedValues:     make(map[string]*ownedValue),
+		ownedValuesLock: &sync.Mutex{},
 	}
 	c.legologClient, err = NewLegologClient(serverAddr)
 	if err != nil {
 		return nil, err
 	}
-	// if auditorAddr != "" {
-	// 	c.auditorClient, err = auditorclt.NewAuditorClient(auditorAddr)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// }
+	if auditorAddr != "" {
+		c.auditorClient, err = auditorclt.NewAuditorClient(auditorAddr)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// if verifierAddr != "" {
 	// 	c.verifierClient, err = verifierclt.NewVerifierClient(verifierAddr)
 	// 	if err != nil {
@@ -156,11 +211,12 @@ func (c *Client) appendInt(ctx context.Context, username []byte, identifier []by
 		Identifier: &legolog_grpcint.Identifier{Identifier: identifier},
 		Value:      &legolog_grpcint.Value{Value: value},
 	}
-	response, _ /*signature*/, err := c.legologClient.Append(ctx, request,
+	response, signature, err := c.legologClient.Append(ctx, request,
 		masterKeyInfo.masterSK, masterKeyInfo.masterVK)
 	if err != nil {
 		return nil /* nil,*/, err
 	}
+	c.recordOwnedValue(identifier, value, signature, response.GetPos().GetPos(), masterKeyInfo.masterVK)
 
 	// var verifierErr error
 	// var verifierRequest *legolog_grpcint.VerifyAppendRequest
@@ -198,7 +254,9 @@ func (c *Client) LookUpMK(ctx context.Context, username []byte) ([]byte, uint64,
 }
 
 // LookUpMKVerify takes a name and looks up the associated key/proof.
-// Verification of the response is done synchronously during the API call.
+// Verification of the response is done synchronously during the API call,
+// against the digest verified by the auditor, if the client has one. A proof
+// that does not verify is reported as a *VerificationError.
 func (c *Client) LookUpMKVerify(ctx context.Context, username []byte) ([]byte, uint64, []byte, []byte, error) {
 	response, err := c.lookUpMKVerifyInt(ctx, username)
 	if err != nil {
@@ -253,47 +311,33 @@ func (c *Client) LookUpMKVerifyForThroughput(ctx context.Context, username []byt
 // and verify the response synchronously.
 func (c *Client) lookUpMKVerifyInt(ctx context.Context, username []byte) (
 	*legolog_grpcint.LookUpMKVerifyResponse, error) {
-	// var numLeaves uint64
-	// var digest = &core.Digest{}
-
-	if c.auditorClient != nil {
-		/*
-			auditorResponse, err := c.auditorClient.GetEpochUpdate(ctx)
-			if err != nil {
-				return nil, err
-			}
-			numLeaves = auditorResponse.CkPoint.GetNumLeaves()
-			marshaledDigest := auditorResponse.GetCkPoint().GetMarshaledDigest()
-			json.Unmarshal(marshaledDigest, &digest)
-		*/
-	} else {
-		// numLeaves = 0
-	}
-
 	var request = &legolog_grpcint.LookUpMKVerifyRequest{
 		// Size: numLeaves,
 		Usr: &legolog_grpcint.Username{Username: username},
 	}
-	response, err := c.legologClient.LookUpMKVerify(ctx, request)
-	if err != nil {
-		return nil, err
+	var response *legolog_grpcint.LookUpMKVerifyResponse
+	lookUp := func() ([]byte, []byte, error) {
+		var err error
+		response, err = c.legologClient.LookUpMKVerify(ctx, request)
+		return response.GetProof(), response.GetMarshaledDigest(), err
 	}
 
-	// masterKey := response.IndexedValue.Value.Value
-	// position := response.IndexedValue.Pos.Pos
-
-	/*
-		if c.auditorClient != nil {
-			var mkProof core.MKProof
-			json.Unmarshal(response.GetProof(), &mkProof)
-			proven := core.VerifyMKProof(digest,
-			masterKey, response.Signature, uint32(position), &mkProof) TODO:
-			if !proven {
-				fmt.Printf("WARNING: could not prove lookup at position %v \n", position)
-				return nil, errors.New("WARNING: could not prove lookup")
-			}
+	if c.auditorClient == nil {
+		if _, _, err := lookUp(); err != nil {
+			return nil, err
 		}
-	*/
+		return response, nil
+	}
+	identifier := append(append([]byte{}, username...), []byte("MK")...)
+	err := c.verifyLookUp(ctx, identifier, lookUp,
+		func(v core.AggHistVerifier, digest *core.LegologDigest, proof *core.LegologExistenceProof) (bool, error) {
+			masterKey := response.GetIndexedValue().GetValue().GetValue()
+			return v.ValidateMKProof(digest, proof, username, masterKey, response.GetSignature(),
+				response.GetIndexedValue().GetPos().GetPos(), masterKey)
+		})
+	if err != nil {
+		return nil, err
+	}
 	return response, nil
 }
 
@@ -342,7 +386,9 @@ func (c *Client) LookUpPK(ctx context.Context, identifier []byte) ([]byte, uint6
 }
 
 // LookUpPKVerify takes a name and looks up the associated key/proof.
-// Verification of the response is done synchronously during the API call.
+// Verification of the response is done synchronously during the API call,
+// against the digest verified by the auditor, if the client has one. A proof
+// that does not verify is reported as a *VerificationError.
 func (c *Client) LookUpPKVerify(ctx context.Context, username []byte, identifier []byte) ([]byte, uint64, []byte, []byte, error) {
 	response, err := c.lookUpPKVerifyInt(ctx, username, identifier)
 	if err != nil {
@@ -380,47 +426,354 @@ func (c *Client) LookUpPKVerifyForSize(ctx context.Context, username []byte, ide
 // for the user and verify the response synchronously.
 func (c *Client) lookUpPKVerifyInt(ctx context.Context, username []byte, identifer []byte) (
 	*legolog_grpcint.LookUpPKVerifyResponse, error) {
-	// var numLeaves uint64
-	// var digest = new(core.Digest)
-
-	/*
-		if c.auditorClient != nil {
-			auditorResponse, err := c.auditorClient.GetEpochUpdate(ctx)
-			if err != nil {
-				return nil, err
-			}
-			numLeaves = auditorResponse.CkPoint.GetNumLeaves()
-			marshaledDigest := auditorResponse.GetCkPoint().GetMarshaledDigest()
-			json.Unmarshal(marshaledDigest, &digest)
-		} else {
-			numLeaves = 0
-		}
-	*/
-
 	serverRequest := &legolog_grpcint.LookUpPKVerifyRequest{
 		// Size: numLeaves,
 		Identifier: &legolog_grpcint.Identifier{Identifier: identifer},
 	}
-	serverResponse, err := c.legologClient.LookUpPKVerify(ctx, serverRequest)
-	if err != nil {
+	var serverResponse *legolog_grpcint.LookUpPKVerifyResponse
+	lookUp := func() ([]byte, []byte, error) {
+		var err error
+		serverResponse, err = c.legologClient.LookUpPKVerify(ctx, serverRequest)
+		return serverResponse.GetProof(), serverResponse.GetMarshaledDigest(), err
+	}
+
+	if c.auditorClient == nil {
+		_, _, err := lookUp()
 		return serverResponse, err
 	}
+	masterVK, err := c.masterKeyForVerification(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	err = c.verifyLookUp(ctx, identifer, lookUp,
+		func(v core.AggHistVerifier, digest *core.LegologDigest, proof *core.LegologExistenceProof) (bool, error) {
+			return v.ValidatePKProof(digest, proof, identifer, serverResponse.GetIndexedValue().GetValue().GetValue(),
+				serverResponse.GetSignature(), serverResponse.GetIndexedValue().GetPos().GetPos(), masterVK)
+		})
+	return serverResponse, err
+}
+
+// masterKeyForVerification returns the master key that signed the user's
+// values, looking it up with a verified lookup if the user was not
+// registered through this client.
+func (c *Client) masterKeyForVerification(ctx context.Context, username []byte) ([]byte, error) {
+	if masterKeyInfo, ok := c.masterKeys[string(username)]; ok {
+		return masterKeyInfo.masterVK, nil
+	}
+	response, err := c.lookUpMKVerifyInt(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	return response.GetIndexedValue().GetValue().GetValue(), nil
+}
 
-	// encryptionKey := serverResponse.IndexedValue.Value.Value
-	// position := serverResponse.IndexedValue.Pos.Pos
+// SetMaxStaleness makes verifying lookups and the monitor reject digests
+// published more than maxStaleness ago, with a *VerificationError wrapping a
+// *core.StaleDigestError. This bounds how long a server that stops
+// publishing, or an auditor serving an old digest, can hide newer updates. A
+// maxStaleness of 0, the default, accepts digests of any age.
+func (c *Client) SetMaxStaleness(maxStaleness time.Duration) {
+	c.maxStaleness = maxStaleness
+}
 
-	/*
-		if c.auditorClient != nil {
-			var pkProof core.LatestPKProof
-			json.Unmarshal(serverResponse.GetProof(), &pkProof)
-			proven := core.VerifyPKProof(digest, serverResponse.GetVrfKey(), encryptionKey, serverResponse.Signature, uint32(position), &pkProof)
-			if !proven {
-				fmt.Printf("WARNING: could not prove lookup at position %v \n", position)
-				return serverResponse, errors.New("WARNING: could not prove lookup")
+// SetLog makes verifying lookups and the monitor only accept digests bound to
+// the log with logID and numPartitions partitions. A digest bound to another
+// log, or a global digest covering a different number of partitions, is
+// returned as a *VerificationError.
+func (c *Client) SetLog(logID []byte, numPartitions uint64) {
+	c.verifier = core.AggHistVerifier{LogID: logID, NumPartitions: numPartitions}
+}
+
+// auditedDigest fetches the auditor's digest of the identifier's partition,
+// cosigned by enough witnesses if the client has any, and checks it against
+// the digests seen before. With gossip enabled, the server's own checkpoint of
+// the partition is added to the client's pool too. A digest inconsistent with
+// those seen before, one published longer ago than the client's maximum
+// staleness, or a split view, is returned as a *VerificationError.
+func (c *Client) auditedDigest(ctx context.Context, identifier []byte) (*core.LegologDigest, uint64, error) {
+	globalDigest, err := c.auditorClient.GetGlobalDigest(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if globalDigest.NumPartitions == 0 {
+		return nil, 0, errors.New("auditor has not verified any digests yet")
+	}
+	partition := core.PartitionIndexForIdentifier(identifier, globalDigest.NumPartitions)
+	if c.verifier.NumPartitions != 0 && globalDigest.NumPartitions != c.verifier.NumPartitions {
+		return nil, 0, &VerificationError{Identifier: identifier, Partition: partition,
+			Err: fmt.Errorf("global digest covers %d partitions, expected %d", globalDigest.NumPartitions, c.verifier.NumPartitions)}
+	}
+	digest, err := c.partitionDigest(ctx, identifier, partition, globalDigest.NumPartitions)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.verifier.NumPartitions != 0 {
+		err = digest.Binding.CheckLog(c.verifier.LogID, partition, c.verifier.NumPartitions)
+		if err != nil {
+			return nil, 0, &VerificationError{Identifier: identifier, Partition: partition, Err: err}
+		}
+	}
+	err = core.CheckFreshness(digest, c.maxStaleness, time.Now())
+	if err != nil {
+		return nil, 0, &VerificationError{Identifier: identifier, Partition: partition, Err: err}
+	}
+	err = c.checkSeenDigest(ctx, identifier, partition, digest)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.gossip != nil {
+		err = c.gossipCheckpoint(ctx, identifier, partition)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return digest, partition, nil
+}
+
+// checkSeenDigest checks digest against the last digest seen for the
+// partition: their hash chains must extend one another, and the update log of
+// the newer one must extend that of the older one, with the proofs fetched
+// from the server. The newer of the two is remembered, so that every client
+// audits the partitions it touches. The first digest seen for a partition is
+// taken as is.
+//
+// The proofs are checked without holding seenDigestsLock, so that a slow
+// server does not hold up lookups in other partitions. If another lookup
+// remembered a digest in the meantime, digest is checked again against that.
+func (c *Client) checkSeenDigest(ctx context.Context, identifier []byte, partition uint64, digest *core.LegologDigest) error {
+	for {
+		c.seenDigestsLock.Lock()
+		if c.seenDigests == nil {
+			c.seenDigests = make(map[uint64]*core.LegologDigest)
+		}
+		seen := c.seenDigests[partition]
+		if seen == nil {
+			c.seenDigests[partition] = digest
+			c.seenDigestsLock.Unlock()
+			return nil
+		}
+		c.seenDigestsLock.Unlock()
+
+		newDigest, err := c.checkDigestConsistency(ctx, identifier, partition, seen, digest)
+		if err != nil {
+			return err
+		}
+
+		c.seenDigestsLock.Lock()
+		swapped := c.seenDigests[partition] == seen
+		if swapped {
+			c.seenDigests[partition] = newDigest
+		}
+		c.seenDigestsLock.Unlock()
+		if swapped {
+			return nil
+		}
+	}
+}
+
+// checkDigestConsistency checks that digest and the digest seen before for
+// the partition are consistent, and returns the newer of the two.
+func (c *Client) checkDigestConsistency(ctx context.Context, identifier []byte, partition uint64,
+	seen *core.LegologDigest, digest *core.LegologDigest) (*core.LegologDigest, error) {
+	// the auditor may hand out a digest older than one seen before
+	oldDigest, newDigest := seen, digest
+	if digest.VerificationPeriod < seen.VerificationPeriod ||
+		(digest.VerificationPeriod == seen.VerificationPeriod && digest.Epoch < seen.Epoch) {
+		oldDigest, newDigest = digest, seen
+	}
+	if !bytes.Equal(seen.HashChain, digest.HashChain) {
+		err := c.checkHashChain(ctx, identifier, partition, oldDigest, newDigest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := c.checkUpdateLogExtension(ctx, identifier, partition, oldDigest, newDigest)
+	if err != nil {
+		return nil, err
+	}
+	return newDigest, nil
+}
+
+// checkHashChain checks that the hash chain of newDigest extends that of
+// oldDigest, with the links between them fetched from the server.
+func (c *Client) checkHashChain(ctx context.Context, identifier []byte, partition uint64,
+	oldDigest *core.LegologDigest, newDigest *core.LegologDigest) error {
+	response, err := c.legologClient.GetHashChainProof(ctx, &legolog_grpcint.GetHashChainProofRequest{
+		PartitionIndex: partition,
+		OldHashChain:   oldDigest.HashChain,
+		NewHashChain:   newDigest.HashChain,
+	})
+	if err != nil {
+		return err
+	}
+	var proof core.HashChainProof
+	err = json.Unmarshal(response.GetProof(), &proof)
+	if err == nil {
+		err = core.VerifyHashChainExtension(oldDigest, newDigest, &proof)
+	}
+	if err != nil {
+		return &VerificationError{Identifier: identifier, Partition: partition, Err: err}
+	}
+	return nil
+}
+
+// consistencyCheckAttempts bounds how many times the client fetches the
+// server's checkpoint while the server keeps publishing new ones.
+const consistencyCheckAttempts = 3
+
+// checkUpdateLogExtension checks that the update log of newDigest extends
+// that of oldDigest. The server only proves consistency up to its current
+// checkpoint, so both update logs are proven to be prefixes of the same
+// checkpoint's, which makes the older one a prefix of the newer one.
+func (c *Client) checkUpdateLogExtension(ctx context.Context, identifier []byte, partition uint64,
+	oldDigest *core.LegologDigest, newDigest *core.LegologDigest) error {
+	if oldDigest.UpdateLogSize == 0 {
+		return nil
+	}
+	if newDigest.UpdateLogSize <= oldDigest.UpdateLogSize {
+		err := checkUpdateLogExtends(oldDigest, newDigest, nil)
+		if err != nil {
+			return &VerificationError{Identifier: identifier, Partition: partition, Err: err}
+		}
+		return nil
+	}
+
+	var target *core.LegologDigest
+	for attempt := 0; attempt < consistencyCheckAttempts; attempt++ {
+		current, err := c.proveUpdateLogPrefix(ctx, identifier, partition, oldDigest)
+		if err != nil {
+			return err
+		}
+		if sameUpdateLog(current, newDigest) || (target != nil && sameUpdateLog(current, target)) {
+			return nil
+		}
+		target, err = c.proveUpdateLogPrefix(ctx, identifier, partition, newDigest)
+		if err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("server kept publishing checkpoints of partition %d while the client checked their consistency", partition)
+}
+
+// proveUpdateLogPrefix fetches the server's current checkpoint of the
+// partition, with a consistency proof from the update log of digest, and
+// checks it.
+func (c *Client) proveUpdateLogPrefix(ctx context.Context, identifier []byte, partition uint64,
+	digest *core.LegologDigest) (*core.LegologDigest, error) {
+	response, err := c.legologClient.GetNewUpdateCheckPoint(ctx, &legolog_grpcint.GetNewCheckPointRequest{
+		OldSize:        uint64(digest.UpdateLogSize),
+		PartitionIndex: partition,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var current core.LegologDigest
+	var proof core.MerkleExtensionProof
+	err = json.Unmarshal(response.GetCheckpoint().GetMarshaledDigest(), &current)
+	if err == nil {
+		err = json.Unmarshal(response.GetProof(), &proof)
+	}
+	if err == nil {
+		err = checkUpdateLogExtends(digest, &current, &proof)
+	}
+	if err != nil {
+		return nil, &VerificationError{Identifier: identifier, Partition: partition, Err: err}
+	}
+	return &current, nil
+}
+
+// checkUpdateLogExtends checks with proof that the update log of newDigest
+// extends that of oldDigest. Update logs of the same size must be the same.
+func checkUpdateLogExtends(oldDigest *core.LegologDigest, newDigest *core.LegologDigest, proof *core.MerkleExtensionProof) error {
+	switch {
+	case newDigest.UpdateLogSize < oldDigest.UpdateLogSize:
+		return fmt.Errorf("update log shrank from %d to %d epochs", oldDigest.UpdateLogSize, newDigest.UpdateLogSize)
+	case newDigest.UpdateLogSize == oldDigest.UpdateLogSize:
+		if !sameUpdateLog(oldDigest, newDigest) {
+			return fmt.Errorf("update logs of %d epochs differ", newDigest.UpdateLogSize)
+		}
+	case !core.VerifyUpdateLogExtension(oldDigest, newDigest, proof):
+		return fmt.Errorf("update log of epoch %d does not extend that of epoch %d", newDigest.Epoch, oldDigest.Epoch)
+	}
+	return nil
+}
+
+func sameUpdateLog(a *core.LegologDigest, b *core.LegologDigest) bool {
+	return a.UpdateLogSize == b.UpdateLogSize && bytes.Equal(a.UpdateLogRoot, b.UpdateLogRoot)
+}
+
+// verifyLookUp fetches the auditor's digest of the identifier's partition,
+// looks the identifier up with lookUp, which returns the server's proof and
+// the digest it is of, and checks the proof with validate. The server's
+// digest may be a verification period ahead of the auditor's, in which case
+// the proof is checked against it once it is shown to extend the auditor's,
+// or behind it, in which case the identifier is looked up again. Failures to
+// verify are returned as a *VerificationError; failures to reach the auditor
+// are not.
+func (c *Client) verifyLookUp(ctx context.Context, identifier []byte, lookUp func() ([]byte, []byte, error),
+	validate func(core.AggHistVerifier, *core.LegologDigest, *core.LegologExistenceProof) (bool, error)) error {
+	audited, partition, err := c.auditedDigest(ctx, identifier)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < consistencyCheckAttempts; attempt++ {
+		marshaledProof, marshaledDigest, err := lookUp()
+		if err != nil {
+			return err
+		}
+		digest, err := c.lookUpDigest(ctx, identifier, partition, audited, marshaledDigest)
+		if err != nil {
+			return err
+		}
+		if digest == nil {
+			continue
+		}
+
+		var proof core.LegologExistenceProof
+		err = json.Unmarshal(marshaledProof, &proof)
+		if err != nil {
+			return &VerificationError{Identifier: identifier, Partition: partition, Err: err}
+		}
+		ok, err := validate(c.verifier, digest, &proof)
+		if !ok || err != nil {
+			if err == nil {
+				err = errors.New("proof does not verify")
 			}
+			return &VerificationError{Identifier: identifier, Partition: partition, Err: err}
 		}
-	*/
-	return serverResponse, nil
+		return nil
+	}
+	return fmt.Errorf("server kept proving lookups in partition %d against digests older than the auditor's", partition)
+}
+
+// lookUpDigest returns the digest to check a lookup proof against, given the
+// auditor's digest of the partition and the one the server proved the lookup
+// against, if it sent one: the auditor's if both are of the same
+// verification period, since the base trees the proof is of only change
+// between periods, or the server's if it is newer and extends the auditor's.
+// It returns nil if the server's digest is older than the auditor's.
+func (c *Client) lookUpDigest(ctx context.Context, identifier []byte, partition uint64, audited *core.LegologDigest,
+	marshaledDigest []byte) (*core.LegologDigest, error) {
+	if len(marshaledDigest) == 0 {
+		return audited, nil
+	}
+	var served core.LegologDigest
+	err := json.Unmarshal(marshaledDigest, &served)
+	if err != nil {
+		return nil, &VerificationError{Identifier: identifier, Partition: partition, Err: err}
+	}
+	switch {
+	case served.VerificationPeriod == audited.VerificationPeriod:
+		return audited, nil
+	case served.VerificationPeriod < audited.VerificationPeriod:
+		return nil, nil
+	}
+	_, err = c.checkDigestConsistency(ctx, identifier, partition, audited, &served)
+	if err != nil {
+		return nil, err
+	}
+	return &served, nil
 }
 
 // // LookUpPKVerifyForThroughput takes a name and looks up the associated key/proof.
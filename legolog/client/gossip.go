@@ -0,0 +1,44 @@
+package legolog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/huyuncong/MerkleSquare/core"
+	"github.com/huyuncong/MerkleSquare/legolog/gossip"
+	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
+)
+
+// EnableGossip makes the client fetch the server's signed checkpoint of every
+// partition it verifies a lookup in, and keep it in a pool of signed digests.
+// The returned pool is gossiped with auditors and other clients through
+// GossipWith, ExchangeDir, or by serving it as a Gossip service. report, if
+// not nil, is called with evidence of every split view the pool finds.
+func (c *Client) EnableGossip(serverVK []byte, report func(*core.MisbehaviorEvidence)) *gossip.Pool {
+	c.gossip = gossip.NewPool(serverVK, report)
+	return c.gossip
+}
+
+// gossipCheckpoint adds the server's signed checkpoint of the partition to
+// the client's pool. A checkpoint the server did not sign, or one that
+// conflicts with a digest in the pool, is returned as a *VerificationError.
+func (c *Client) gossipCheckpoint(ctx context.Context, identifier []byte, partition uint64) error {
+	response, err := c.legologClient.GetNewCheckPoint(ctx, &legolog_grpcint.GetNewCheckPointRequest{PartitionIndex: partition})
+	if err != nil {
+		return err
+	}
+	var digest core.LegologDigest
+	err = json.Unmarshal(response.GetCheckpoint().GetMarshaledDigest(), &digest)
+	if err == nil {
+		var evidence *core.MisbehaviorEvidence
+		evidence, err = c.gossip.Add(&core.SignedDigest{Digest: &digest, Signature: response.GetDigestSignature()})
+		if err == nil && evidence != nil {
+			err = fmt.Errorf("server showed a split view of epoch %d", digest.Epoch)
+		}
+	}
+	if err != nil {
+		return &VerificationError{Identifier: identifier, Partition: partition, Err: err}
+	}
+	return nil
+}
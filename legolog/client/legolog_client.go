@@ -27,6 +27,8 @@ type BasicClient interface {
 		*legolog_grpcint.LookUpMKVerifyResponse, error)
 	LookUpPKVerify(ctx context.Context, req *legolog_grpcint.LookUpPKVerifyRequest) (
 		*legolog_grpcint.LookUpPKVerifyResponse, error)
+	GetMonitoringProof(ctx context.Context, req *legolog_grpcint.GetMonitoringProofRequest) (
+		*legolog_grpcint.GetMonitoringProofResponse, error)
 
 	GetNewCheckPoint(ctx context.Context, req *legolog_grpcint.GetNewCheckPointRequest) (
 		*legolog_grpcint.GetNewCheckPointResponse, error)
@@ -35,6 +37,14 @@ type BasicClient interface {
 		*legolog_grpcint.GetNewCheckPointResponse, error)
 	GetNewVerifyCheckPoint(ctx context.Context, req *legolog_grpcint.GetNewCheckPointRequest) (
 		*legolog_grpcint.GetNewCheckPointResponse, error)
+	GetEpochUpdates(ctx context.Context, req *legolog_grpcint.GetEpochUpdatesRequest) (
+		*legolog_grpcint.GetEpochUpdatesResponse, error)
+	GetHashChainProof(ctx context.Context, req *legolog_grpcint.GetHashChainProofRequest) (
+		*legolog_grpcint.GetHashChainProofResponse, error)
+	GetUpdateLogChainProof(ctx context.Context, req *legolog_grpcint.GetUpdateLogChainProofRequest) (
+		*legolog_grpcint.GetUpdateLogChainProofResponse, error)
+	SubscribeCheckpoints(ctx context.Context, req *legolog_grpcint.SubscribeCheckpointsRequest) (
+		legolog_grpcint.LegoLog_SubscribeCheckpointsClient, error)
 	/*
 		GetMasterKeyProof(ctx context.Context, req *legolog_grpcint.GetMasterKeyProofRequest) (
 			*legolog_grpcint.GetMasterKeyProofResponse, error)
@@ -140,6 +150,12 @@ func (m *legologClient) LookUpPKVerify(ctx context.Context,
 	return m.client.LookUpPKVerify(ctx, req)
 }
 
+func (m *legologClient) GetMonitoringProof(ctx context.Context,
+	req *legolog_grpcint.GetMonitoringProofRequest) (
+	*legolog_grpcint.GetMonitoringProofResponse, error) {
+	return m.client.GetMonitoringProof(ctx, req)
+}
+
 func (m *legologClient) GetNewCheckPoint(ctx context.Context,
 	req *legolog_grpcint.GetNewCheckPointRequest) (
 	*legolog_grpcint.GetNewCheckPointResponse, error) {
@@ -158,6 +174,30 @@ func (m *legologClient) GetNewVerifyCheckPoint(ctx context.Context,
 	return m.client.GetNewVerifyCheckPoint(ctx, req)
 }
 
+func (m *legologClient) GetEpochUpdates(ctx context.Context,
+	req *legolog_grpcint.GetEpochUpdatesRequest) (
+	*legolog_grpcint.GetEpochUpdatesResponse, error) {
+	return m.client.GetEpochUpdates(ctx, req)
+}
+
+func (m *legologClient) GetHashChainProof(ctx context.Context,
+	req *legolog_grpcint.GetHashChainProofRequest) (
+	*legolog_grpcint.GetHashChainProofResponse, error) {
+	return m.client.GetHashChainProof(ctx, req)
+}
+
+func (m *legologClient) GetUpdateLogChainProof(ctx context.Context,
+	req *legolog_grpcint.GetUpdateLogChainProofRequest) (
+	*legolog_grpcint.GetUpdateLogChainProofResponse, error) {
+	return m.client.GetUpdateLogChainProof(ctx, req)
+}
+
+func (m *legologClient) SubscribeCheckpoints(ctx context.Context,
+	req *legolog_grpcint.SubscribeCheckpointsRequest) (
+	legolog_grpcint.LegoLog_SubscribeCheckpointsClient, error) {
+	return m.client.SubscribeCheckpoints(ctx, req)
+}
+
 // func (m *legologClient) GetMasterKeyProof(ctx context.Context,
 // 	req *legolog_grpcint.GetMasterKeyProofRequest) (
 // 	*legolog_grpcint.GetMasterKeyProofResponse, error) {
@@ -0,0 +1,513 @@
+package legolog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huyuncong/MerkleSquare/core"
+	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
+)
+
+// monitorGracePeriods is the number of checks a value may go without being
+// rolled up into a base tree before the monitor reports it as missing.
+const monitorGracePeriods = 2
+
+// ownedValue is the latest value the client appended for an identifier.
+type ownedValue struct {
+	value     []byte
+	signature []byte
+	pos       uint64
+	masterVK  []byte
+
+	verified      bool // seen in a base tree
+	pendingChecks int
+
+	// verifiedHead is the head of the identifier's value chain in the last
+	// base tree checked once the monitor first found a value it appended,
+	// and appended the hashes of the values the client appended before
+	// value that were not seen in a base tree as the newest one yet.
+	// verifiedPeriods is the number of verification periods checked up to
+	// verifiedHead, which later checks must start from so that every base
+	// tree they cover holds it.
+	verifiedHead    []byte
+	verifiedPeriods uint32
+	appended        [][]byte
+}
+
+// Alert reports that the server is not serving the value a user appended
+// for one of their identifiers.
+type Alert struct {
+	Identifier []byte
+	Expected   []byte
+	Found      []byte // the value the server served instead, if any
+	Err        error
+}
+
+func (a *Alert) String() string {
+	if a.Found != nil {
+		return fmt.Sprintf("identifier %q: expected value %x, server returned %x: %v", a.Identifier, a.Expected, a.Found, a.Err)
+	}
+	return fmt.Sprintf("identifier %q: %v", a.Identifier, a.Err)
+}
+
+// IncompleteCheckError lists the identifiers a check could not cover, for
+// instance because the server or the auditor was unreachable, with the
+// reason for each. They are checked over the same periods again next time.
+type IncompleteCheckError struct {
+	Errors map[string]error // by identifier
+}
+
+func (e *IncompleteCheckError) Error() string {
+	identifiers := make([]string, 0, len(e.Errors))
+	for identifier := range e.Errors {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Strings(identifiers)
+	reasons := make([]string, len(identifiers))
+	for i, identifier := range identifiers {
+		reasons[i] = fmt.Sprintf("%q: %v", identifier, e.Errors[identifier])
+	}
+	return fmt.Sprintf("could not check %d identifiers: %s", len(identifiers), strings.Join(reasons, "; "))
+}
+
+// Monitor checks, each time the server publishes a verification period, that
+// the server still serves the values the client appended, with proofs that
+// verify against the auditor's digests. Each problem found is passed to
+// onAlert, and each check that could not be completed to onError.
+//
+// Each check only covers the verification periods since the last complete
+// check. If the monitor is given a state file, it records the next period to
+// check there along with the values it checks, so that a client that was
+// offline, or restarted, catches up on exactly the periods it missed.
+type Monitor struct {
+	client    *Client
+	period    time.Duration
+	onAlert   func(*Alert)
+	onError   func(error)
+	statePath string
+	stopper   chan struct{}
+
+	state     monitorState
+	stateLock sync.Mutex
+}
+
+type monitorState struct {
+	NextVerificationPeriod uint64                      `json:"next_verification_period"`
+	OwnedValues            map[string]*ownedValueState `json:"owned_values,omitempty"` // by identifier
+}
+
+// ownedValueState is an ownedValue as saved in the state file.
+type ownedValueState struct {
+	Value           []byte   `json:"value"`
+	Signature       []byte   `json:"signature"`
+	Pos             uint64   `json:"pos"`
+	MasterVK        []byte   `json:"master_vk"`
+	Verified        bool     `json:"verified"`
+	PendingChecks   int      `json:"pending_checks"`
+	VerifiedHead    []byte   `json:"verified_head,omitempty"`
+	VerifiedPeriods uint32   `json:"verified_periods,omitempty"`
+	Appended        [][]byte `json:"appended,omitempty"`
+}
+
+func (o *ownedValue) state() *ownedValueState {
+	return &ownedValueState{
+		Value:           o.value,
+		Signature:       o.signature,
+		Pos:             o.pos,
+		MasterVK:        o.masterVK,
+		Verified:        o.verified,
+		PendingChecks:   o.pendingChecks,
+		VerifiedHead:    o.verifiedHead,
+		VerifiedPeriods: o.verifiedPeriods,
+		Appended:        o.appended,
+	}
+}
+
+func (s *ownedValueState) ownedValue() *ownedValue {
+	return &ownedValue{
+		value:           s.Value,
+		signature:       s.Signature,
+		pos:             s.Pos,
+		masterVK:        s.MasterVK,
+		verified:        s.Verified,
+		pendingChecks:   s.PendingChecks,
+		verifiedHead:    s.VerifiedHead,
+		verifiedPeriods: s.VerifiedPeriods,
+		appended:        s.Appended,
+	}
+}
+
+// recordOwnedValue makes value the one the monitor checks for identifier,
+// and saves it in the state file of the started monitor, if any.
+func (c *Client) recordOwnedValue(identifier []byte, value []byte, signature []byte, pos uint64, masterVK []byte) {
+	monitor := c.setOwnedValue(identifier, value, signature, pos, masterVK)
+	if monitor == nil {
+		return
+	}
+	if err := monitor.persistState(); err != nil && monitor.onError != nil {
+		monitor.onError(err)
+	}
+}
+
+// setOwnedValue makes value the one the monitor checks for identifier and
+// returns the started monitor, if any.
+func (c *Client) setOwnedValue(identifier []byte, value []byte, signature []byte, pos uint64, masterVK []byte) *Monitor {
+	c.ownedValuesLock.Lock()
+	defer c.ownedValuesLock.Unlock()
+	owned := &ownedValue{
+		value:     value,
+		signature: signature,
+		pos:       pos,
+		masterVK:  masterVK,
+	}
+	if previous, ok := c.ownedValues[string(identifier)]; ok {
+		owned.verifiedHead = previous.verifiedHead
+		owned.verifiedPeriods = previous.verifiedPeriods
+		owned.appended = append(append([][]byte{}, previous.appended...),
+			core.ConvertBitsToBytes(core.ComputeLeafNodeHash(identifier, previous.value, previous.signature, 0)))
+	}
+	c.ownedValues[string(identifier)] = owned
+	return c.monitor
+}
+
+// StartMonitor starts checking the identifiers appended through this client
+// as the server publishes verification periods, or every period, which should
+// be the server's verification period, while the server does not stream its
+// checkpoints. If statePath is not empty, the monitor resumes from the state
+// saved there, checking the values saved with it too. onError, if not nil, is
+// passed the error of each check that could not be completed.
+func (c *Client) StartMonitor(period time.Duration, statePath string, onAlert func(*Alert), onError func(error)) (
+	*Monitor, error) {
+	if c.auditorClient == nil {
+		return nil, errors.New("monitoring requires an auditor client")
+	}
+	m := &Monitor{
+		client:    c,
+		period:    period,
+		onAlert:   onAlert,
+		onError:   onError,
+		statePath: statePath,
+		stopper:   make(chan struct{}),
+	}
+	if err := m.loadState(); err != nil {
+		return nil, err
+	}
+	c.ownedValuesLock.Lock()
+	c.monitor = m
+	c.ownedValuesLock.Unlock()
+	go m.monitorLoop()
+	return m, nil
+}
+
+// Watch makes the monitor check value for identifier, as if it had just been
+// appended through this client, for instance when it was appended by another
+// client of the same user.
+func (m *Monitor) Watch(identifier []byte, value []byte, signature []byte, pos uint64, masterVK []byte) error {
+	m.client.setOwnedValue(identifier, value, signature, pos, masterVK)
+	return m.persistState()
+}
+
+func (m *Monitor) loadState() error {
+	if m.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		return err
+	}
+	m.client.ownedValuesLock.Lock()
+	defer m.client.ownedValuesLock.Unlock()
+	for identifier, saved := range m.state.OwnedValues {
+		owned, ok := m.client.ownedValues[identifier]
+		if !ok {
+			m.client.ownedValues[identifier] = saved.ownedValue()
+			continue
+		}
+		// values appended through the client since it started are newer
+		owned.verifiedHead, owned.verifiedPeriods = saved.VerifiedHead, saved.VerifiedPeriods
+		appended := append(append([][]byte{}, saved.Appended...),
+			core.ConvertBitsToBytes(core.ComputeLeafNodeHash([]byte(identifier), saved.Value, saved.Signature, 0)))
+		owned.appended = append(appended, owned.appended...)
+	}
+	return nil
+}
+
+// persistState saves the state after the values the client owns change.
+func (m *Monitor) persistState() error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if err := m.saveState(); err != nil {
+		return fmt.Errorf("could not save monitor state: %v", err)
+	}
+	return nil
+}
+
+// saveState writes the state, with the values the client owns, to the state
+// file. The caller must hold stateLock.
+func (m *Monitor) saveState() error {
+	if m.statePath == "" {
+		return nil
+	}
+	m.client.ownedValuesLock.Lock()
+	m.state.OwnedValues = make(map[string]*ownedValueState, len(m.client.ownedValues))
+	for identifier, owned := range m.client.ownedValues {
+		m.state.OwnedValues[identifier] = owned.state()
+	}
+	m.client.ownedValuesLock.Unlock()
+	data, err := json.Marshal(m.state)
+	if err != nil {
+		return err
+	}
+	tmpPath := m.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, m.statePath)
+}
+
+// NextVerificationPeriod returns the first verification period the next
+// check will cover.
+func (m *Monitor) NextVerificationPeriod() uint64 {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	return m.state.NextVerificationPeriod
+}
+
+// monitorLoop checks whenever the server publishes a new verification
+// period, and again on every publication after a check that could not be
+// completed. While the server does not stream its checkpoints, it checks
+// every period instead.
+func (m *Monitor) monitorLoop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ticker := time.NewTicker(m.period)
+	defer ticker.Stop()
+	periods := m.watchVerificationPeriods(ctx)
+	var lastPeriod uint64
+	retry := false
+	for {
+		select {
+		case period, ok := <-periods:
+			if !ok {
+				periods = nil
+				continue
+			}
+			if period <= lastPeriod && !retry {
+				continue
+			}
+			lastPeriod = period
+		case <-ticker.C:
+			if periods != nil {
+				continue
+			}
+			periods = m.watchVerificationPeriods(ctx)
+		case <-m.stopper:
+			return
+		}
+		alerts, err := m.Check(ctx)
+		var incomplete *IncompleteCheckError
+		retry = errors.As(err, &incomplete)
+		for _, alert := range alerts {
+			m.onAlert(alert)
+		}
+		if err != nil && m.onError != nil {
+			m.onError(err)
+		}
+	}
+}
+
+// watchVerificationPeriods subscribes to the server's checkpoints and sends
+// the verification period of each batch it pushes. Every partition is
+// published at once, so following the first one is enough. The channel is
+// closed when the stream ends.
+func (m *Monitor) watchVerificationPeriods(ctx context.Context) <-chan uint64 {
+	periods := make(chan uint64)
+	go func() {
+		defer close(periods)
+		stream, err := m.client.legologClient.SubscribeCheckpoints(ctx, &legolog_grpcint.SubscribeCheckpointsRequest{
+			PartitionIndices: []uint64{0},
+		})
+		if err != nil {
+			return
+		}
+		for {
+			response, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			var period uint64
+			for _, checkpoint := range response.GetCheckpoints() {
+				var digest core.LegologDigest
+				err = json.Unmarshal(checkpoint.GetCheckpoint().GetMarshaledDigest(), &digest)
+				if err == nil && digest.VerificationPeriod > period {
+					period = digest.VerificationPeriod
+				}
+			}
+			select {
+			case periods <- period:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return periods
+}
+
+// Check checks every identifier over the verification periods since the
+// last complete check and returns the alerts raised. Identifiers that cannot
+// be checked are returned in an *IncompleteCheckError, and are checked over
+// the same periods again next time. Check may be called while the monitor
+// loop runs.
+func (m *Monitor) Check(ctx context.Context) ([]*Alert, error) {
+	m.client.ownedValuesLock.Lock()
+	owned := make(map[string]*ownedValue, len(m.client.ownedValues))
+	for identifier, value := range m.client.ownedValues {
+		owned[identifier] = value
+	}
+	m.client.ownedValuesLock.Unlock()
+
+	since := m.NextVerificationPeriod()
+	var alerts []*Alert
+	unchecked := make(map[string]error)
+	var checkedPeriods uint32
+	for identifier, value := range owned {
+		alert, historySize, err := m.checkIdentifier(ctx, []byte(identifier), value, since)
+		if err != nil {
+			unchecked[identifier] = err
+			continue
+		}
+		if checkedPeriods == 0 || historySize < checkedPeriods {
+			checkedPeriods = historySize
+		}
+		if alert == nil {
+			continue
+		}
+		// the user may have appended a newer value while this one was checked
+		m.client.ownedValuesLock.Lock()
+		current := m.client.ownedValues[identifier] == value
+		m.client.ownedValuesLock.Unlock()
+		if current {
+			alerts = append(alerts, alert)
+		}
+	}
+	// the identifiers checked have moved on even if some could not be
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if len(unchecked) == 0 && uint64(checkedPeriods) > m.state.NextVerificationPeriod {
+		m.state.NextVerificationPeriod = uint64(checkedPeriods)
+	}
+	if err := m.saveState(); err != nil {
+		return alerts, fmt.Errorf("could not save monitor state: %v", err)
+	}
+	if len(unchecked) != 0 {
+		return alerts, &IncompleteCheckError{Errors: unchecked}
+	}
+	return alerts, nil
+}
+
+// checkIdentifier checks one identifier over the periods since the last
+// complete check, the first of which is since, or since the identifier was
+// last checked if that is later. It returns the number of verification
+// periods in the history the check covered, or an error if the identifier
+// could not be checked this time.
+func (m *Monitor) checkIdentifier(ctx context.Context, identifier []byte, owned *ownedValue, since uint64) (
+	*Alert, uint32, error) {
+	m.client.ownedValuesLock.Lock()
+	verifiedHead, appended := owned.verifiedHead, owned.appended
+	if verifiedHead != nil && uint64(owned.verifiedPeriods) > since {
+		since = uint64(owned.verifiedPeriods)
+	}
+	m.client.ownedValuesLock.Unlock()
+	response, err := m.client.legologClient.GetMonitoringProof(ctx, &legolog_grpcint.GetMonitoringProofRequest{
+		Identifier:              &legolog_grpcint.Identifier{Identifier: identifier},
+		SinceVerificationPeriod: since,
+		VerifiedHead:            verifiedHead,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	found := response.GetIndexedValue().GetValue().GetValue()
+	if !bytes.Equal(found, owned.value) || response.GetIndexedValue().GetPos().GetPos() != owned.pos {
+		return &Alert{
+			Identifier: identifier,
+			Expected:   owned.value,
+			Found:      found,
+			Err:        errors.New("server returned a value the user did not append"),
+		}, 0, nil
+	}
+
+	digest, partition, err := m.client.auditedDigest(ctx, identifier)
+	var verificationErr *VerificationError
+	if errors.As(err, &verificationErr) {
+		return &Alert{Identifier: identifier, Expected: owned.value, Err: err}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var proof core.MonitoringProof
+	err = json.Unmarshal(response.GetProof(), &proof)
+	if err != nil {
+		return &Alert{Identifier: identifier, Expected: owned.value,
+			Err: &VerificationError{Identifier: identifier, Partition: partition, Err: err}}, 0, nil
+	}
+	// the auditor's digest may lag behind the server by a period
+	if proof.HistoryForestSize != digest.HistoryForestSize {
+		return nil, 0, fmt.Errorf("proof covers %d verification periods, auditor's digest %d", proof.HistoryForestSize, digest.HistoryForestSize)
+	}
+
+	inBaseTree, head, err := m.client.verifier.ValidateMonitoringProof(digest, &proof, identifier, owned.value, owned.signature,
+		owned.pos, owned.masterVK, since, verifiedHead, appended)
+	if err != nil {
+		return &Alert{Identifier: identifier, Expected: owned.value,
+			Err: &VerificationError{Identifier: identifier, Partition: partition, Err: err}}, 0, nil
+	}
+
+	m.client.ownedValuesLock.Lock()
+	defer m.client.ownedValuesLock.Unlock()
+	owned.verifiedHead, owned.verifiedPeriods = head, digest.HistoryForestSize
+	if inBaseTree {
+		owned.verified = true
+		owned.pendingChecks = 0
+		owned.appended = nil
+		return nil, digest.HistoryForestSize, nil
+	}
+	if owned.verified && len(proof.BaseTreeProofs) != 0 {
+		return &Alert{
+			Identifier: identifier,
+			Expected:   owned.value,
+			Err:        errors.New("value is missing from base trees since it was last verified"),
+		}, digest.HistoryForestSize, nil
+	}
+	if owned.verified {
+		return nil, digest.HistoryForestSize, nil
+	}
+	owned.pendingChecks++
+	if owned.pendingChecks > monitorGracePeriods {
+		return &Alert{
+			Identifier: identifier,
+			Expected:   owned.value,
+			Err:        fmt.Errorf("value not in any base tree after %d checks", owned.pendingChecks),
+		}, digest.HistoryForestSize, nil
+	}
+	return nil, digest.HistoryForestSize, nil
+}
+
+// Stop ends the monitor loop.
+func (m *Monitor) Stop() {
+	close(m.stopper)
+}
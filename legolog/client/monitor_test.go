@@ -0,0 +1,479 @@
+package legolog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/huyuncong/MerkleSquare/core"
+	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
+	"github.com/immesys/bw2/crypto"
+	"google.golang.org/grpc"
+)
+
+// fakeServer answers monitoring requests from a single partition, serving
+// whatever value was last stored for an identifier.
+type fakeServer struct {
+	BasicClient
+	partition  *core.AggHistPartition
+	values     map[string][]byte
+	signatures map[string][]byte
+	down       bool
+	downFor    string // identifier the server fails to answer for
+
+	// lookups answered before any proof is generated, oldest first
+	queuedLookUps []*legolog_grpcint.LookUpPKVerifyResponse
+
+	// published signals the checkpoint stream that a new batch is out
+	published chan struct{}
+
+	// the key the server signs checkpoints with, if any
+	signingSK []byte
+	signingVK []byte
+}
+
+func (f *fakeServer) GetMonitoringProof(ctx context.Context, req *legolog_grpcint.GetMonitoringProofRequest) (
+	*legolog_grpcint.GetMonitoringProofResponse, error) {
+	identifier := req.GetIdentifier().GetIdentifier()
+	if f.down || string(identifier) == f.downFor {
+		return nil, errors.New("server unavailable")
+	}
+	value, signature := f.values[string(identifier)], f.signatures[string(identifier)]
+	proof, err := json.Marshal(f.partition.GenerateMonitoringProof(identifier, value, signature, req.GetSinceVerificationPeriod(),
+		req.GetVerifiedHead()))
+	if err != nil {
+		return nil, err
+	}
+	return &legolog_grpcint.GetMonitoringProofResponse{
+		IndexedValue: &legolog_grpcint.IndexedValue{
+			Pos:   &legolog_grpcint.Position{Pos: 0},
+			Value: &legolog_grpcint.Value{Value: value},
+		},
+		Signature: signature,
+		Proof:     proof,
+	}, nil
+}
+
+func (f *fakeServer) LookUpPKVerify(ctx context.Context, req *legolog_grpcint.LookUpPKVerifyRequest) (
+	*legolog_grpcint.LookUpPKVerifyResponse, error) {
+	if len(f.queuedLookUps) != 0 {
+		response := f.queuedLookUps[0]
+		f.queuedLookUps = f.queuedLookUps[1:]
+		return response, nil
+	}
+	identifier := req.GetIdentifier().GetIdentifier()
+	value, signature := f.values[string(identifier)], f.signatures[string(identifier)]
+	proof, _ := json.Marshal(f.partition.GenerateExistenceProof(identifier, value, signature))
+	marshaledDigest, _ := json.Marshal(f.partition.GetDigest())
+	return &legolog_grpcint.LookUpPKVerifyResponse{
+		IndexedValue: &legolog_grpcint.IndexedValue{
+			Pos:   &legolog_grpcint.Position{Pos: 0},
+			Value: &legolog_grpcint.Value{Value: value},
+		},
+		Signature:       signature,
+		Proof:           proof,
+		MarshaledDigest: marshaledDigest,
+	}, nil
+}
+
+func (f *fakeServer) GetHashChainProof(ctx context.Context, req *legolog_grpcint.GetHashChainProofRequest) (
+	*legolog_grpcint.GetHashChainProofResponse, error) {
+	proof, err := f.partition.GenerateHashChainProof(req.OldHashChain, req.NewHashChain)
+	if err != nil {
+		return nil, err
+	}
+	marshaledProof, _ := json.Marshal(proof)
+	return &legolog_grpcint.GetHashChainProofResponse{Proof: marshaledProof}, nil
+}
+
+func (f *fakeServer) SubscribeCheckpoints(ctx context.Context, req *legolog_grpcint.SubscribeCheckpointsRequest) (
+	legolog_grpcint.LegoLog_SubscribeCheckpointsClient, error) {
+	return &fakeCheckpointStream{ctx: ctx, server: f}, nil
+}
+
+// fakeCheckpointStream pushes the partition's digest right away and then
+// each time the server publishes.
+type fakeCheckpointStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	server *fakeServer
+	sent   bool
+}
+
+func (s *fakeCheckpointStream) Recv() (*legolog_grpcint.SubscribeCheckpointsResponse, error) {
+	if s.sent {
+		select {
+		case <-s.server.published:
+		case <-s.ctx.Done():
+			return nil, s.ctx.Err()
+		}
+	}
+	s.sent = true
+	marshaledDigest, _ := json.Marshal(s.server.partition.GetDigest())
+	return &legolog_grpcint.SubscribeCheckpointsResponse{
+		PartitionIndices: []uint64{0},
+		Checkpoints: []*legolog_grpcint.GetNewCheckPointResponse{
+			{Checkpoint: &legolog_grpcint.CheckPoint{MarshaledDigest: marshaledDigest}},
+		},
+	}, nil
+}
+
+func (f *fakeServer) GetNewCheckPoint(ctx context.Context, req *legolog_grpcint.GetNewCheckPointRequest) (
+	*legolog_grpcint.GetNewCheckPointResponse, error) {
+	digest := f.partition.GetDigest()
+	marshaledDigest, _ := json.Marshal(digest)
+	var signature []byte
+	if f.signingSK != nil {
+		signature = core.SignDigest(f.signingSK, f.signingVK, digest).Signature
+	}
+	return &legolog_grpcint.GetNewCheckPointResponse{
+		Checkpoint:      &legolog_grpcint.CheckPoint{MarshaledDigest: marshaledDigest},
+		DigestSignature: signature,
+	}, nil
+}
+
+func (f *fakeServer) GetNewUpdateCheckPoint(ctx context.Context, req *legolog_grpcint.GetNewCheckPointRequest) (
+	*legolog_grpcint.GetNewCheckPointResponse, error) {
+	marshaledDigest, _ := json.Marshal(f.partition.GetDigest())
+	marshaledProof, _ := json.Marshal(f.partition.GetUpdateEpochConsistencyProof(uint32(req.OldSize)))
+	return &legolog_grpcint.GetNewCheckPointResponse{
+		Checkpoint: &legolog_grpcint.CheckPoint{MarshaledDigest: marshaledDigest},
+		Proof:      marshaledProof,
+	}, nil
+}
+
+func (f *fakeServer) append(identifier []byte, value []byte, signature []byte) {
+	f.values[string(identifier)] = value
+	f.signatures[string(identifier)] = signature
+	f.partition.Append(identifier, identifier, value, signature)
+}
+
+func (f *fakeServer) nextPeriod() {
+	f.partition.IncrementUpdateEpoch()
+	f.partition.IncrementVerificationPeriod()
+}
+
+func TestMonitorCheck(t *testing.T) {
+	partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+	server := &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{}}
+	auditor := &fakeAuditorClient{}
+	c, _ := NewClient("localhost:0", "", "")
+	c.legologClient, c.auditorClient = server, auditor
+	statePath := filepath.Join(t.TempDir(), "monitor.json")
+	m := &Monitor{client: c, statePath: statePath}
+	check := func() []*Alert {
+		auditor.digests = []*core.LegologDigest{partition.GetDigest()}
+		alerts, _ := m.Check(context.Background())
+		return alerts
+	}
+
+	identifier := []byte("alice_key")
+	masterSK, masterVK := crypto.GenerateKeypair()
+	value := []byte("alice's key")
+	signature := make([]byte, 64)
+	crypto.SignBlob(masterSK, masterVK, signature, append(value, []byte("0")...))
+	server.append(identifier, value, signature)
+	c.recordOwnedValue(identifier, value, signature, 0, masterVK)
+
+	// until the value is rolled up into a base tree, it is only reported
+	// once the grace period runs out
+	server.nextPeriod()
+	for i := 0; i < monitorGracePeriods; i++ {
+		if alerts := check(); len(alerts) != 0 {
+			t.Fatalf("unexpected alert for a value not yet rolled up: %s", alerts[0])
+		}
+	}
+	server.nextPeriod()
+	server.nextPeriod()
+	if alerts := check(); len(alerts) != 0 {
+		t.Fatalf("unexpected alert: %s", alerts[0])
+	}
+	checked := m.NextVerificationPeriod()
+	if checked == 0 {
+		t.Fatal("monitor did not advance past the checked periods")
+	}
+
+	// periods missed while the server is unreachable are checked on the
+	// next successful check, by a monitor resumed from the saved state
+	server.down = true
+	server.nextPeriod()
+	if alerts := check(); len(alerts) != 0 || m.NextVerificationPeriod() != checked {
+		t.Fatalf("monitor advanced without checking: %v", alerts)
+	}
+	_, err := m.Check(context.Background())
+	var incomplete *IncompleteCheckError
+	if !errors.As(err, &incomplete) || incomplete.Errors[string(identifier)] == nil {
+		t.Fatalf("expected the unchecked identifier to be reported, got %v", err)
+	}
+	server.down = false
+	for i := 0; i < 4; i++ {
+		server.nextPeriod()
+	}
+	m = &Monitor{client: c, statePath: statePath}
+	if err := m.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if m.NextVerificationPeriod() != checked {
+		t.Fatalf("expected to resume from period %d, got %d", checked, m.NextVerificationPeriod())
+	}
+	if alerts := check(); len(alerts) != 0 {
+		t.Fatalf("unexpected alert: %s", alerts[0])
+	}
+	if m.NextVerificationPeriod() != uint64(partition.GetDigest().HistoryForestSize) {
+		t.Errorf("expected to have caught up to period %d, got %d", partition.GetDigest().HistoryForestSize, m.NextVerificationPeriod())
+	}
+
+	// the server swaps in a value the user never appended
+	server.append(identifier, []byte("mallory's key"), signature)
+	server.nextPeriod()
+	alerts := check()
+	if len(alerts) != 1 || string(alerts[0].Found) != "mallory's key" {
+		t.Fatalf("expected an alert for the swapped value, got %v", alerts)
+	}
+}
+
+func TestMonitorFollowsCheckpointStream(t *testing.T) {
+	partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+	server := &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{},
+		published: make(chan struct{})}
+	auditor := &fakeAuditorClient{}
+	c, _ := NewClient("localhost:0", "", "")
+	c.legologClient, c.auditorClient = server, auditor
+
+	identifier := []byte("alice_key")
+	masterSK, masterVK := crypto.GenerateKeypair()
+	value := []byte("alice's key")
+	signature := make([]byte, 64)
+	crypto.SignBlob(masterSK, masterVK, signature, append(value, []byte("0")...))
+	server.append(identifier, value, signature)
+	c.recordOwnedValue(identifier, value, signature, 0, masterVK)
+	for i := 0; i < 3; i++ {
+		server.nextPeriod()
+	}
+	server.append(identifier, []byte("mallory's key"), signature)
+	server.nextPeriod()
+	auditor.digests = []*core.LegologDigest{partition.GetDigest()}
+
+	// the monitor checks as soon as the stream pushes a verification
+	// period, long before its own period is up
+	alerts := make(chan *Alert, 1)
+	m := &Monitor{client: c, period: time.Hour, onAlert: func(a *Alert) { alerts <- a }, stopper: make(chan struct{})}
+	go m.monitorLoop()
+	defer m.Stop()
+	expectAlert := func(found string) {
+		select {
+		case alert := <-alerts:
+			if string(alert.Found) != found {
+				t.Fatalf("expected an alert for %q, got %s", found, alert)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no alert for %q", found)
+		}
+	}
+	expectAlert("mallory's key")
+
+	// the monitor can be checked on while its loop runs
+	if alerts, err := m.Check(context.Background()); err != nil || len(alerts) != 1 {
+		t.Fatalf("expected a check to find the swapped value, got %v, %v", alerts, err)
+	}
+	m.NextVerificationPeriod()
+
+	server.append(identifier, []byte("eve's key"), signature)
+	server.nextPeriod()
+	auditor.digests = []*core.LegologDigest{partition.GetDigest()}
+	server.published <- struct{}{}
+	expectAlert("eve's key")
+}
+
+func TestMonitorSeesValueReplacedWithinPeriod(t *testing.T) {
+	partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+	server := &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{}}
+	auditor := &fakeAuditorClient{}
+	c, _ := NewClient("localhost:0", "", "")
+	c.legologClient, c.auditorClient = server, auditor
+	m := &Monitor{client: c}
+	check := func() []*Alert {
+		auditor.digests = []*core.LegologDigest{partition.GetDigest()}
+		alerts, err := m.Check(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return alerts
+	}
+
+	identifier := []byte("alice_key")
+	masterSK, masterVK := crypto.GenerateKeypair()
+	sign := func(value []byte) []byte {
+		signature := make([]byte, 64)
+		crypto.SignBlob(masterSK, masterVK, signature, append(value, []byte("0")...))
+		return signature
+	}
+	value := []byte("alice's key")
+	server.append(identifier, value, sign(value))
+	c.recordOwnedValue(identifier, value, sign(value), 0, masterVK)
+	server.nextPeriod()
+	server.nextPeriod()
+	if alerts := check(); len(alerts) != 0 {
+		t.Fatalf("unexpected alert: %s", alerts[0])
+	}
+
+	// values the user appended within a period are not reported
+	newValue := []byte("alice's new key")
+	server.append(identifier, newValue, sign(newValue))
+	c.recordOwnedValue(identifier, newValue, sign(newValue), 0, masterVK)
+	server.append(identifier, value, sign(value))
+	c.recordOwnedValue(identifier, value, sign(value), 0, masterVK)
+	server.nextPeriod()
+	server.nextPeriod()
+	if alerts := check(); len(alerts) != 0 {
+		t.Fatalf("unexpected alert: %s", alerts[0])
+	}
+
+	// but a value the server appends and replaces again within a period is,
+	// even though the user's value is the newest one in every base tree
+	mallory := []byte("mallory's key")
+	server.append(identifier, mallory, sign(mallory))
+	server.append(identifier, value, sign(value))
+	server.nextPeriod()
+	server.nextPeriod()
+	alerts := check()
+	var verificationErr *VerificationError
+	if len(alerts) != 1 || !errors.As(alerts[0].Err, &verificationErr) {
+		t.Fatalf("expected an alert for the replaced value, got %v", alerts)
+	}
+}
+
+func TestMonitorResumesIdentifiersFromTheirVerifiedHead(t *testing.T) {
+	partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+	server := &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{}}
+	auditor := &fakeAuditorClient{}
+	c, _ := NewClient("localhost:0", "", "")
+	c.legologClient, c.auditorClient = server, auditor
+	m := &Monitor{client: c}
+
+	masterSK, masterVK := crypto.GenerateKeypair()
+	appendValue := func(identifier string, value string) {
+		signature := make([]byte, 64)
+		crypto.SignBlob(masterSK, masterVK, signature, append([]byte(value), []byte("0")...))
+		server.append([]byte(identifier), []byte(value), signature)
+		c.recordOwnedValue([]byte(identifier), []byte(value), signature, 0, masterVK)
+	}
+	appendValue("alice_key", "alice's key")
+	appendValue("bob_key", "bob's key")
+	for i := 0; i < 4; i++ {
+		server.nextPeriod()
+	}
+	// alice's new key is first in a base tree after the one the first
+	// history forest root ends with
+	appendValue("alice_key", "alice's new key")
+	for i := 0; i < 3; i++ {
+		server.nextPeriod()
+	}
+
+	// alice's identifier is checked while bob's is not, so the next check
+	// covers the same periods again for bob, but not for alice
+	server.downFor = "bob_key"
+	auditor.digests = []*core.LegologDigest{partition.GetDigest()}
+	alerts, err := m.Check(context.Background())
+	var incomplete *IncompleteCheckError
+	if len(alerts) != 0 || !errors.As(err, &incomplete) {
+		t.Fatalf("expected only bob's identifier to go unchecked, got %v, %v", alerts, err)
+	}
+	server.downFor = ""
+	server.nextPeriod()
+	auditor.digests = []*core.LegologDigest{partition.GetDigest()}
+	if alerts, err := m.Check(context.Background()); len(alerts) != 0 || err != nil {
+		t.Fatalf("unexpected alerts: %v, %v", alerts, err)
+	}
+	if m.NextVerificationPeriod() != uint64(partition.GetDigest().HistoryForestSize) {
+		t.Errorf("expected to have caught up to period %d, got %d", partition.GetDigest().HistoryForestSize, m.NextVerificationPeriod())
+	}
+}
+
+func TestMonitorCatchesUpAfterRestart(t *testing.T) {
+	partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+	server := &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{}}
+	auditor := &fakeAuditorClient{}
+	statePath := filepath.Join(t.TempDir(), "monitor.json")
+	// restart returns the monitor of a freshly constructed client resumed
+	// from the state file
+	restart := func() (*Client, *Monitor) {
+		c, _ := NewClient("localhost:0", "", "")
+		c.legologClient, c.auditorClient = server, auditor
+		m := &Monitor{client: c, statePath: statePath}
+		if err := m.loadState(); err != nil {
+			t.Fatal(err)
+		}
+		return c, m
+	}
+	check := func(m *Monitor) []*Alert {
+		auditor.digests = []*core.LegologDigest{partition.GetDigest()}
+		alerts, err := m.Check(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return alerts
+	}
+
+	identifier := []byte("alice_key")
+	masterSK, masterVK := crypto.GenerateKeypair()
+	sign := func(value []byte) []byte {
+		signature := make([]byte, 64)
+		crypto.SignBlob(masterSK, masterVK, signature, append(value, []byte("0")...))
+		return signature
+	}
+	value := []byte("alice's key")
+	c, m := restart()
+	server.append(identifier, value, sign(value))
+	c.recordOwnedValue(identifier, value, sign(value), 0, masterVK)
+	for i := 0; i < 3; i++ {
+		server.nextPeriod()
+	}
+	if alerts := check(m); len(alerts) != 0 {
+		t.Fatalf("unexpected alert: %s", alerts[0])
+	}
+
+	// the value is checked by the next run of the client too
+	for i := 0; i < 3; i++ {
+		server.nextPeriod()
+	}
+	c, m = restart()
+	if _, ok := c.ownedValues[string(identifier)]; !ok {
+		t.Fatal("restarted monitor does not check the saved value")
+	}
+	if alerts := check(m); len(alerts) != 0 {
+		t.Fatalf("unexpected alert: %s", alerts[0])
+	}
+
+	// values watched are saved right away, and a value replaced while the
+	// client was down is reported once it is back
+	bob := []byte("bob_key")
+	bobValue := []byte("bob's key")
+	server.append(bob, bobValue, sign(bobValue))
+	if err := m.Watch(bob, bobValue, sign(bobValue), 0, masterVK); err != nil {
+		t.Fatal(err)
+	}
+	mallory := []byte("mallory's key")
+	server.append(identifier, mallory, sign(mallory))
+	server.append(identifier, value, sign(value))
+	for i := 0; i < 3; i++ {
+		server.nextPeriod()
+	}
+	c, m = restart()
+	if _, ok := c.ownedValues[string(bob)]; !ok {
+		t.Fatal("restarted monitor does not check the watched value")
+	}
+	alerts := check(m)
+	var verificationErr *VerificationError
+	if len(alerts) != 1 || string(alerts[0].Identifier) != string(identifier) || !errors.As(alerts[0].Err, &verificationErr) {
+		t.Fatalf("expected an alert for the value replaced while the client was down, got %v", alerts)
+	}
+}
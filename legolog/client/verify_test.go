@@ -0,0 +1,568 @@
+package legolog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/huyuncong/MerkleSquare/core"
+	"github.com/huyuncong/MerkleSquare/legolog/gossip"
+	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
+	"github.com/immesys/bw2/crypto"
+	"google.golang.org/grpc"
+)
+
+// fakeAuditorClient serves the digests of a fixed set of partitions, included
+// in a global digest of globalDigests if set, or of digests otherwise. As a
+// witness, it cosigns them and the older digests in verified.
+type fakeAuditorClient struct {
+	digests       []*core.LegologDigest
+	globalDigests []*core.LegologDigest
+	verified      []*core.LegologDigest
+	witnessSK     []byte
+	witnessVK     []byte
+}
+
+func (f *fakeAuditorClient) GetEpochUpdate(ctx context.Context) ([]*core.LegologDigest, []uint64, []uint64, error) {
+	return f.digests, nil, nil, nil
+}
+
+func (f *fakeAuditorClient) GetEpochUpdateForPartition(ctx context.Context, partition uint64) (
+	*core.LegologDigest, *core.GlobalDigest, *core.PartitionInclusionProof, error) {
+	included := f.digests
+	if f.globalDigests != nil {
+		included = f.globalDigests
+	}
+	return f.digests[partition], core.NewGlobalDigest(included, 0), core.GeneratePartitionInclusionProof(included, partition), nil
+}
+
+func (f *fakeAuditorClient) GetGlobalDigest(ctx context.Context) (*core.GlobalDigest, error) {
+	return core.NewGlobalDigest(f.digests, 0), nil
+}
+
+func (f *fakeAuditorClient) GetMisbehaviorReports(ctx context.Context, startIndex uint64) ([]*core.MisbehaviorEvidence, error) {
+	return nil, nil
+}
+
+func (f *fakeAuditorClient) GetCosignedCheckpoint(ctx context.Context, partition uint64) (*core.LegologDigest, *core.Cosignature, error) {
+	digest := f.digests[partition]
+	return digest, core.CosignDigest(f.witnessSK, f.witnessVK, digest), nil
+}
+
+func (f *fakeAuditorClient) CosignCheckpoint(ctx context.Context, partition uint64, digest *core.LegologDigest) (*core.Cosignature, error) {
+	for _, verified := range append([]*core.LegologDigest{f.digests[partition]}, f.verified...) {
+		if bytes.Equal(verified.Hash(), digest.Hash()) {
+			return core.CosignDigest(f.witnessSK, f.witnessVK, digest), nil
+		}
+	}
+	return nil, errors.New("not verified")
+}
+
+// lookUpAnswering returns a lookup that answers with proof, without the
+// digest it is of.
+func lookUpAnswering(proof []byte) func() ([]byte, []byte, error) {
+	return func() ([]byte, []byte, error) {
+		return proof, nil, nil
+	}
+}
+
+func TestVerifyLookUp(t *testing.T) {
+	const numPartitions = 2
+	identifier := []byte("alice_key")
+	masterSK, masterVK := crypto.GenerateKeypair()
+	value := []byte("value")
+	signature := make([]byte, 64)
+	crypto.SignBlob(masterSK, masterVK, signature, append(value, []byte("0")...))
+
+	partitions := make([]*core.Partition, numPartitions)
+	digests := make([]*core.LegologDigest, numPartitions)
+	for i := range partitions {
+		partitions[i] = core.NewPartition()
+		partitions[i].Bind(core.PartitionBinding{PartitionIndex: uint64(i), NumPartitions: numPartitions})
+		partitions[i].Append(identifier, identifier, value, signature)
+		partitions[i].IncrementUpdateEpoch()
+		digests[i] = partitions[i].GetDigest()
+	}
+	index := core.PartitionIndexForIdentifier(identifier, numPartitions)
+	validate := func(v core.AggHistVerifier, digest *core.LegologDigest, proof *core.LegologExistenceProof) (bool, error) {
+		return v.ValidatePKProof(digest, proof, identifier, value, signature, 0, masterVK)
+	}
+
+	c := &Client{auditorClient: &fakeAuditorClient{digests: digests}}
+	proof, _ := json.Marshal(partitions[index].GenerateExistenceProof(identifier, value, signature))
+	if err := c.verifyLookUp(context.Background(), identifier, lookUpAnswering(proof), validate); err != nil {
+		t.Errorf("proof from the identifier's partition failed: %v", err)
+	}
+
+	// a client configured with its log only accepts digests bound to it
+	c.SetLog(nil, numPartitions)
+	if err := c.verifyLookUp(context.Background(), identifier, lookUpAnswering(proof), validate); err != nil {
+		t.Errorf("proof failed against the configured log: %v", err)
+	}
+	for _, config := range []struct {
+		logID         []byte
+		numPartitions uint64
+	}{{[]byte("other log"), numPartitions}, {nil, numPartitions + 1}} {
+		c.SetLog(config.logID, config.numPartitions)
+		var verificationErr *VerificationError
+		if err := c.verifyLookUp(context.Background(), identifier, lookUpAnswering(proof), validate); !errors.As(err, &verificationErr) {
+			t.Errorf("expected a VerificationError for log %q with %d partitions, got %v", config.logID, config.numPartitions, err)
+		}
+	}
+
+	// digests of partitions that do not hold the identifier are rejected,
+	// even if the proof checks out against them
+	swapped := []*core.LegologDigest{digests[1], digests[0]}
+	c = &Client{auditorClient: &fakeAuditorClient{digests: swapped}}
+	err := c.verifyLookUp(context.Background(), identifier, lookUpAnswering(proof), validate)
+	var verificationErr *VerificationError
+	if !errors.As(err, &verificationErr) {
+		t.Fatalf("expected a VerificationError, got %v", err)
+	}
+	if verificationErr.Partition != index {
+		t.Errorf("expected the error to name partition %d, got %d", index, verificationErr.Partition)
+	}
+
+	if err := c.verifyLookUp(context.Background(), identifier, lookUpAnswering([]byte("not a proof")), validate); !errors.As(err, &verificationErr) {
+		t.Errorf("expected a VerificationError for a malformed proof, got %v", err)
+	}
+
+	// the auditor's digest must be included in the global digest it serves
+	// with it
+	c = &Client{auditorClient: &fakeAuditorClient{digests: digests, globalDigests: swapped}}
+	if err := c.verifyLookUp(context.Background(), identifier, lookUpAnswering(proof), validate); !errors.As(err, &verificationErr) {
+		t.Errorf("expected a VerificationError for a digest outside the global digest, got %v", err)
+	}
+}
+
+func TestClientChecksHashChain(t *testing.T) {
+	newServer := func() *fakeServer {
+		partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+		partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+		return &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{}}
+	}
+	ctx := context.Background()
+	identifier := []byte("alice_key")
+	server := newServer()
+	auditor := &fakeAuditorClient{}
+	c := &Client{legologClient: server, auditorClient: auditor}
+	for i := 0; i < 3; i++ {
+		server.nextPeriod()
+	}
+	auditor.digests = []*core.LegologDigest{server.partition.GetDigest()}
+	if _, _, err := c.auditedDigest(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+	old := auditor.digests[0]
+
+	// periods skipped by the client are covered by the server's proof, and
+	// an auditor handing out an older digest again is fine
+	for i := 0; i < 3; i++ {
+		server.append(identifier, []byte{byte(i)}, []byte{byte(i)})
+		server.nextPeriod()
+	}
+	auditor.digests = []*core.LegologDigest{server.partition.GetDigest()}
+	if _, _, err := c.auditedDigest(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+	auditor.digests = []*core.LegologDigest{old}
+	if _, _, err := c.auditedDigest(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+
+	forked := newServer()
+	for i := 0; i < 7; i++ {
+		forked.append(identifier, []byte("forked"), []byte{byte(i)})
+		forked.nextPeriod()
+	}
+	auditor.digests = []*core.LegologDigest{forked.partition.GetDigest()}
+	c.legologClient = forked
+	if _, _, err := c.auditedDigest(ctx, identifier); err == nil {
+		t.Fatal("accepted a digest with a forked hash chain")
+	}
+	// a proof that does not link up the two digests is a verification error
+	c.legologClient = &forgedChainServer{forked}
+	_, _, err := c.auditedDigest(ctx, identifier)
+	var verificationErr *VerificationError
+	if !errors.As(err, &verificationErr) {
+		t.Fatalf("expected a VerificationError for a forged hash chain proof, got %v", err)
+	}
+}
+
+func TestVerifyLookUpAcrossVerificationPeriods(t *testing.T) {
+	ctx := context.Background()
+	partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+	server := &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{}}
+	auditor := &fakeAuditorClient{}
+	username, identifier := []byte("alice"), []byte("alice_key")
+	masterSK, masterVK := crypto.GenerateKeypair()
+	c := &Client{legologClient: server, auditorClient: auditor,
+		masterKeys: map[string]MasterKeyRecord{string(username): {masterSK: masterSK, masterVK: masterVK}}}
+	value := []byte("alice's key")
+	signature := make([]byte, 64)
+	crypto.SignBlob(masterSK, masterVK, signature, append(value, []byte("0")...))
+	server.append(identifier, value, signature)
+	for i := 0; i < 3; i++ {
+		server.nextPeriod()
+	}
+	auditor.digests = []*core.LegologDigest{partition.GetDigest()}
+	if _, err := c.lookUpPKVerifyInt(ctx, username, identifier); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server publishes a period the auditor has not verified yet
+	server.append([]byte("bob_key"), []byte("bob's key"), []byte("bob's signature"))
+	server.nextPeriod()
+	if _, err := c.lookUpPKVerifyInt(ctx, username, identifier); err != nil {
+		t.Fatalf("proof against a digest ahead of the auditor's failed: %v", err)
+	}
+
+	// the server publishes a period, which the auditor verifies, between
+	// proving the lookup and the client fetching the auditor's digest
+	stale, _ := server.LookUpPKVerify(ctx, &legolog_grpcint.LookUpPKVerifyRequest{
+		Identifier: &legolog_grpcint.Identifier{Identifier: identifier},
+	})
+	server.append([]byte("bob_key"), []byte("bob's new key"), []byte("bob's signature"))
+	server.nextPeriod()
+	auditor.digests = []*core.LegologDigest{partition.GetDigest()}
+	server.queuedLookUps = []*legolog_grpcint.LookUpPKVerifyResponse{stale}
+	if _, err := c.lookUpPKVerifyInt(ctx, username, identifier); err != nil {
+		t.Fatalf("proof against a digest behind the auditor's was not looked up again: %v", err)
+	}
+	if len(server.queuedLookUps) != 0 {
+		t.Fatal("stale lookup was not served")
+	}
+
+	// a digest ahead of the auditor's must extend it, base tree roots
+	// included
+	server.nextPeriod()
+	forged, _ := server.LookUpPKVerify(ctx, &legolog_grpcint.LookUpPKVerifyRequest{
+		Identifier: &legolog_grpcint.Identifier{Identifier: identifier},
+	})
+	var digest core.LegologDigest
+	json.Unmarshal(forged.MarshaledDigest, &digest)
+	digest.BaseTreeRoots[len(digest.BaseTreeRoots)-1] = []byte("forged base tree root")
+	forged.MarshaledDigest, _ = json.Marshal(&digest)
+	server.queuedLookUps = []*legolog_grpcint.LookUpPKVerifyResponse{forged}
+	_, err := c.lookUpPKVerifyInt(ctx, username, identifier)
+	var verificationErr *VerificationError
+	if !errors.As(err, &verificationErr) {
+		t.Fatalf("expected a VerificationError for a digest that does not extend the auditor's, got %v", err)
+	}
+}
+
+// forgedChainServer answers with the links of its whole hash chain,
+// whichever head it is asked to prove from.
+type forgedChainServer struct {
+	*fakeServer
+}
+
+func (f *forgedChainServer) GetHashChainProof(ctx context.Context, req *legolog_grpcint.GetHashChainProofRequest) (
+	*legolog_grpcint.GetHashChainProofResponse, error) {
+	req.OldHashChain = nil
+	return f.fakeServer.GetHashChainProof(ctx, req)
+}
+
+func TestClientRequiresWitnessCosignatures(t *testing.T) {
+	ctx := context.Background()
+	identifier := []byte("alice_key")
+	partition := core.NewPartition()
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+	partition.Append(identifier, identifier, []byte("0"), []byte("0"))
+	partition.IncrementUpdateEpoch()
+	older := partition.GetDigest()
+	partition.Append(identifier, identifier, []byte("1"), []byte("1"))
+	partition.IncrementUpdateEpoch()
+	newer := partition.GetDigest()
+
+	newWitness := func(digest *core.LegologDigest, verified ...*core.LegologDigest) *fakeAuditorClient {
+		sk, vk := crypto.GenerateKeypair()
+		return &fakeAuditorClient{digests: []*core.LegologDigest{digest}, verified: verified, witnessSK: sk, witnessVK: vk}
+	}
+	newClient := func(threshold int, witnesses ...*fakeAuditorClient) *Client {
+		c := &Client{witnessPolicy: &core.WitnessPolicy{Threshold: threshold}}
+		for _, witness := range witnesses {
+			c.witnesses = append(c.witnesses, witness)
+			c.witnessPolicy.WitnessKeys = append(c.witnessPolicy.WitnessKeys, witness.witnessVK)
+		}
+		c.auditorClient = c.witnesses[0]
+		return c
+	}
+	ahead := newWitness(newer, older)
+	current := newWitness(newer, older)
+	lagging := newWitness(older)
+
+	// the newest digest enough witnesses reached is accepted
+	digest, _, err := newClient(2, ahead, current, lagging).auditedDigest(ctx, identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.Epoch != newer.Epoch {
+		t.Errorf("expected the digest of epoch %d, got epoch %d", newer.Epoch, digest.Epoch)
+	}
+	// witnesses ahead of a lagging one cosign the older digest it serves
+	digest, _, err = newClient(3, ahead, current, lagging).auditedDigest(ctx, identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.Epoch != older.Epoch {
+		t.Errorf("expected the digest of epoch %d, got epoch %d", older.Epoch, digest.Epoch)
+	}
+	// a witness that no longer cosigns the older digest leaves it short
+	forgetful := newWitness(newer)
+	if _, _, err := newClient(3, ahead, forgetful, lagging).auditedDigest(ctx, identifier); err == nil {
+		t.Error("accepted a digest cosigned by fewer witnesses than the threshold")
+	}
+
+	// a witness that verified a forked digest does not cosign the one the
+	// others verified, and the fork does not get enough cosignatures either
+	forked := core.NewPartition()
+	forked.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+	forked.Append(identifier, identifier, []byte("0"), []byte("0"))
+	forked.IncrementUpdateEpoch()
+	forked.Append(identifier, identifier, []byte("forked"), []byte("forked"))
+	forked.IncrementUpdateEpoch()
+	split := newWitness(forked.GetDigest(), older)
+	if _, _, err := newClient(2, current, split).auditedDigest(ctx, identifier); err == nil {
+		t.Error("accepted a digest the witnesses disagree on")
+	}
+
+	// cosignatures by keys the client does not trust are not counted
+	c := newClient(2, ahead, current)
+	c.witnessPolicy.WitnessKeys[1] = lagging.witnessVK
+	if _, _, err := c.auditedDigest(ctx, identifier); err == nil {
+		t.Error("counted the cosignature of an untrusted witness")
+	}
+}
+
+// localGossipPeer calls the Gossip service of a pool directly.
+type localGossipPeer struct {
+	pool *gossip.Pool
+}
+
+func (l *localGossipPeer) ExchangeDigests(ctx context.Context, req *legolog_grpcint.ExchangeDigestsRequest,
+	opts ...grpc.CallOption) (*legolog_grpcint.ExchangeDigestsResponse, error) {
+	return l.pool.ExchangeDigests(ctx, req)
+}
+
+func TestClientGossipsServerCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	identifier := []byte("alice_key")
+	serverSK, serverVK := crypto.GenerateKeypair()
+	newServer := func(value string) *fakeServer {
+		partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+		partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+		server := &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{},
+			signingSK: serverSK, signingVK: serverVK}
+		for i := 0; i < 3; i++ {
+			server.nextPeriod()
+		}
+		server.append(identifier, []byte(value), []byte(value))
+		server.nextPeriod()
+		return server
+	}
+	server := newServer("value")
+	c := &Client{legologClient: server, auditorClient: &fakeAuditorClient{digests: []*core.LegologDigest{server.partition.GetDigest()}}}
+	pool := c.EnableGossip(serverVK, nil)
+	if _, _, err := c.auditedDigest(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Digests()) != 1 {
+		t.Fatalf("expected the server's checkpoint in the pool, got %d digests", len(pool.Digests()))
+	}
+
+	// another party was shown a different digest for the same epoch
+	forked := newServer("forked")
+	peer := gossip.NewPool(serverVK, nil)
+	peer.Add(core.SignDigest(serverSK, serverVK, forked.partition.GetDigest()))
+	evidence, err := pool.GossipWith(ctx, &localGossipPeer{peer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evidence) != 1 {
+		t.Fatalf("expected a split view, got %d", len(evidence))
+	}
+	if err := evidence[0].VerifySignatures(serverVK); err != nil {
+		t.Error(err)
+	}
+
+	// the server showing the client itself a second digest is caught when
+	// the client verifies a lookup
+	c.legologClient = forked
+	c.auditorClient = &fakeAuditorClient{digests: []*core.LegologDigest{forked.partition.GetDigest()}}
+	c.seenDigests = nil
+	c.EnableGossip(serverVK, nil).Add(core.SignDigest(serverSK, serverVK, server.partition.GetDigest()))
+	_, _, err = c.auditedDigest(ctx, identifier)
+	var verificationErr *VerificationError
+	if !errors.As(err, &verificationErr) {
+		t.Fatalf("expected a VerificationError for a split view, got %v", err)
+	}
+
+	// checkpoints the server did not sign are refused
+	forked.signingSK = nil
+	c.EnableGossip(serverVK, nil)
+	if _, _, err := c.auditedDigest(ctx, identifier); !errors.As(err, &verificationErr) {
+		t.Errorf("expected a VerificationError for an unsigned checkpoint, got %v", err)
+	}
+}
+
+func TestClientChecksUpdateLogConsistency(t *testing.T) {
+	ctx := context.Background()
+	identifier := []byte("alice_key")
+	newServer := func() *fakeServer {
+		partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+		partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+		server := &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{}}
+		for i := 0; i < 3; i++ {
+			server.nextPeriod()
+		}
+		return server
+	}
+	nextEpoch := func(server *fakeServer, value string) {
+		server.append(identifier, []byte(value), []byte(value))
+		server.partition.IncrementUpdateEpoch()
+	}
+	server := newServer()
+	nextEpoch(server, "0")
+	auditor := &fakeAuditorClient{digests: []*core.LegologDigest{server.partition.GetDigest()}}
+	c := &Client{legologClient: server, auditorClient: auditor}
+	if _, _, err := c.auditedDigest(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+	old := auditor.digests[0]
+
+	// the server has moved on past the digest the auditor hands out, in
+	// another verification period
+	nextEpoch(server, "1")
+	server.nextPeriod()
+	auditor.digests = []*core.LegologDigest{server.partition.GetDigest()}
+	nextEpoch(server, "2")
+	if _, _, err := c.auditedDigest(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+	auditor.digests = []*core.LegologDigest{old}
+	if _, _, err := c.auditedDigest(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+
+	// within a verification period, only the update log shows a fork
+	c = &Client{legologClient: server, auditorClient: auditor}
+	if _, _, err := c.auditedDigest(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+	forked := newServer()
+	nextEpoch(forked, "forked")
+	nextEpoch(forked, "1")
+	if !bytes.Equal(forked.partition.GetDigest().HashChain, old.HashChain) {
+		t.Fatal("expected the fork to keep the hash chain")
+	}
+	auditor.digests = []*core.LegologDigest{forked.partition.GetDigest()}
+	c.legologClient = forked
+	_, _, err := c.auditedDigest(ctx, identifier)
+	var verificationErr *VerificationError
+	if !errors.As(err, &verificationErr) {
+		t.Fatalf("expected a VerificationError for a forked update log, got %v", err)
+	}
+}
+
+func TestClientRejectsStaleDigests(t *testing.T) {
+	ctx := context.Background()
+	identifier := []byte("alice_key")
+	partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+	server := &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{}}
+	server.nextPeriod()
+	digest := partition.GetDigest()
+	digest.PublishedAt = time.Now().Add(-time.Hour).UnixNano()
+	auditor := &fakeAuditorClient{digests: []*core.LegologDigest{digest}}
+	c := &Client{legologClient: server, auditorClient: auditor}
+
+	// without a maximum staleness, digests of any age are accepted
+	if _, _, err := c.auditedDigest(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+	c.SetMaxStaleness(2 * time.Hour)
+	if _, _, err := c.auditedDigest(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetMaxStaleness(time.Minute)
+	_, _, err := c.auditedDigest(ctx, identifier)
+	var verificationErr *VerificationError
+	var staleErr *core.StaleDigestError
+	if !errors.As(err, &verificationErr) || !errors.As(err, &staleErr) {
+		t.Fatalf("expected a VerificationError for a stale digest, got %v", err)
+	}
+	if staleErr.MaxStaleness != time.Minute {
+		t.Errorf("expected the error to carry the maximum staleness, got %v", staleErr.MaxStaleness)
+	}
+
+	// a digest without a publication time cannot be shown to be fresh
+	unpublished := *digest
+	unpublished.PublishedAt = 0
+	auditor.digests = []*core.LegologDigest{&unpublished}
+	if _, _, err := c.auditedDigest(ctx, identifier); !errors.As(err, &staleErr) {
+		t.Errorf("expected a StaleDigestError for a digest without a publication time, got %v", err)
+	}
+}
+
+// stalledChainServer blocks hash chain proofs until released.
+type stalledChainServer struct {
+	*fakeServer
+	stalled chan struct{}
+	release chan struct{}
+}
+
+func (f *stalledChainServer) GetHashChainProof(ctx context.Context, req *legolog_grpcint.GetHashChainProofRequest) (
+	*legolog_grpcint.GetHashChainProofResponse, error) {
+	f.stalled <- struct{}{}
+	<-f.release
+	return f.fakeServer.GetHashChainProof(ctx, req)
+}
+
+func TestClientChecksSeenDigestsConcurrently(t *testing.T) {
+	ctx := context.Background()
+	identifier := []byte("alice_key")
+	partition := core.NewAggHistPartition(core.Config{AggHistoryDepth: 31}, "")
+	partition.Bind(core.PartitionBinding{PartitionIndex: 0, NumPartitions: 1})
+	server := &stalledChainServer{
+		fakeServer: &fakeServer{partition: partition, values: map[string][]byte{}, signatures: map[string][]byte{}},
+		stalled:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	server.nextPeriod()
+	old := partition.GetDigest()
+	server.append(identifier, []byte("0"), []byte("0"))
+	server.nextPeriod()
+	c := &Client{legologClient: server}
+	if err := c.checkSeenDigest(ctx, identifier, 0, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// a lookup waiting on the server's proofs does not hold up others
+	done := make(chan error)
+	go func() {
+		done <- c.checkSeenDigest(ctx, identifier, 0, partition.GetDigest())
+	}()
+	<-server.stalled
+	if err := c.checkSeenDigest(ctx, identifier, 1, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.checkSeenDigest(ctx, identifier, 0, old); err != nil {
+		t.Fatal(err)
+	}
+	close(server.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.seenDigestsLock.Lock()
+	seen := c.seenDigests[0]
+	c.seenDigestsLock.Unlock()
+	if !bytes.Equal(seen.Hash(), partition.GetDigest().Hash()) {
+		t.Error("expected the newer digest to be remembered")
+	}
+}
@@ -0,0 +1,127 @@
+package legolog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/huyuncong/MerkleSquare/core"
+	"github.com/huyuncong/MerkleSquare/legolog/auditor/auditorclt"
+)
+
+// NewClientWithWitnesses connects to the server and to a set of auditors that
+// act as witnesses. Verifying lookups then only accept a digest once at least
+// policy.Threshold of the witnesses in policy have cosigned it. The first
+// witness also serves global digests and misbehavior reports.
+func NewClientWithWitnesses(serverAddr string, witnessAddrs []string, policy core.WitnessPolicy) (
+	*Client, error) {
+
+	if len(witnessAddrs) == 0 {
+		return nil, errors.New("no witnesses to connect to")
+	}
+	if policy.Threshold <= 0 || policy.Threshold > len(policy.WitnessKeys) {
+		return nil, fmt.Errorf("threshold of %d is not satisfiable by %d witnesses", policy.Threshold, len(policy.WitnessKeys))
+	}
+	c, err := NewClient(serverAddr, "", "")
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range witnessAddrs {
+		witness, err := auditorclt.NewAuditorClient(addr)
+		if err != nil {
+			return nil, err
+		}
+		c.witnesses = append(c.witnesses, witness)
+	}
+	c.auditorClient = c.witnesses[0]
+	c.witnessPolicy = &policy
+	return c, nil
+}
+
+// witnessCheckpoint is the latest digest of a partition a witness verified.
+type witnessCheckpoint struct {
+	witness     auditorclt.Client
+	digest      *core.LegologDigest
+	cosignature *core.Cosignature
+}
+
+// partitionDigest fetches the auditor's digest of the identifier's partition
+// or, if the client trusts a set of witnesses, the newest digest enough of
+// them cosign. The auditor's digest must be included at the partition's index
+// in the global digest of numPartitions partitions it was verified under, or
+// it is returned as a *VerificationError.
+func (c *Client) partitionDigest(ctx context.Context, identifier []byte, partition uint64, numPartitions uint64) (*core.LegologDigest, error) {
+	if c.witnessPolicy != nil {
+		return c.cosignedDigest(ctx, partition)
+	}
+	digest, globalDigest, proof, err := c.auditorClient.GetEpochUpdateForPartition(ctx, partition)
+	if err != nil {
+		return nil, err
+	}
+	if globalDigest.NumPartitions != numPartitions || proof.Index != partition ||
+		!core.VerifyPartitionInclusionProof(globalDigest, digest, proof) {
+		return nil, &VerificationError{Identifier: identifier, Partition: partition,
+			Err: errors.New("digest is not included in the auditor's global digest")}
+	}
+	return digest, nil
+}
+
+// cosignedDigest fetches the latest digest of the partition from every
+// witness, and picks the newest one that at least the threshold of them have
+// reached. The witnesses that have reached it are asked to cosign it, since
+// each keeps cosigning the digests it verified recently.
+func (c *Client) cosignedDigest(ctx context.Context, partition uint64) (*core.LegologDigest, error) {
+	checkpoints := make([]*witnessCheckpoint, len(c.witnesses))
+	var wg sync.WaitGroup
+	for i, witness := range c.witnesses {
+		wg.Add(1)
+		go func(i int, witness auditorclt.Client) {
+			defer wg.Done()
+			digest, cosignature, err := witness.GetCosignedCheckpoint(ctx, partition)
+			if err == nil {
+				checkpoints[i] = &witnessCheckpoint{witness, digest, cosignature}
+			}
+		}(i, witness)
+	}
+	wg.Wait()
+
+	var served []*witnessCheckpoint
+	for _, checkpoint := range checkpoints {
+		if checkpoint != nil {
+			served = append(served, checkpoint)
+		}
+	}
+	threshold := c.witnessPolicy.Threshold
+	if len(served) < threshold {
+		return nil, fmt.Errorf("%d of %d witnesses served a digest of partition %d, %d needed",
+			len(served), len(c.witnesses), partition, threshold)
+	}
+	sort.SliceStable(served, func(i, j int) bool {
+		return served[i].digest.Epoch > served[j].digest.Epoch
+	})
+
+	candidate := served[threshold-1].digest
+	hash := candidate.Hash()
+	var cosignatures []*core.Cosignature
+	for _, checkpoint := range served {
+		if bytes.Equal(checkpoint.digest.Hash(), hash) {
+			cosignatures = append(cosignatures, checkpoint.cosignature)
+			continue
+		}
+		if checkpoint.digest.Epoch < candidate.Epoch {
+			continue
+		}
+		cosignature, err := checkpoint.witness.CosignCheckpoint(ctx, partition, candidate)
+		if err == nil {
+			cosignatures = append(cosignatures, cosignature)
+		}
+	}
+	err := c.witnessPolicy.Check(candidate, cosignatures)
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: %v", partition, err)
+	}
+	return candidate, nil
+}
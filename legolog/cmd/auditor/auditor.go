@@ -2,6 +2,7 @@ package main
 
 import (
 	"MerkleSquare/core"
+	"context"
 	"errors"
 	"flag"
 	"log"
@@ -11,6 +12,7 @@ import (
 	"github.com/huyuncong/MerkleSquare/constants"
 	"github.com/huyuncong/MerkleSquare/legolog/auditor/auditorsrv"
 	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
+	"github.com/huyuncong/MerkleSquare/lib/storage"
 	"google.golang.org/grpc"
 )
 
@@ -22,6 +24,7 @@ const AuditorPort = constants.AuditorPort
 func main() {
 	experimentConfigPtr := flag.String("exp_config", "../experiments/exp_configs/test.yaml", "experiment config file path")
 	configPtr := flag.String("config", "../experiments/configs/test.yaml", "config file path")
+	stateDirPtr := flag.String("state_dir", "auditor-state", "directory the auditor stores verified checkpoints in, with the config's storage backend")
 	flag.Parse()
 	expCfg, err := core.ParseExperimentConfig(*experimentConfigPtr)
 	if err != nil {
@@ -33,10 +36,25 @@ func main() {
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	serv, err := auditorsrv.NewAuditor(expCfg.ServerAddr+ServerPort, *configPtr)
+	cfg, err := core.ParseConfig(*configPtr)
+	if err != nil {
+		panic(errors.New("Failed to load config: " + err.Error()))
+	}
+	backend := cfg.Storage
+	if backend == "" {
+		backend = storage.LevelDBBackend
+	}
+	db, err := storage.Open(backend, *stateDirPtr)
+	if err != nil {
+		panic(errors.New("Failed to open auditor state: " + err.Error()))
+	}
+	defer db.Close(context.Background())
+
+	serv, err := auditorsrv.NewAuditor(expCfg.ServerAddr+ServerPort, *configPtr, db)
 	if err != nil {
 		panic(err)
 	}
+	log.Printf("witness key: %x", serv.WitnessKey())
 
 	listenSocket, err := net.Listen("tcp", AuditorPort)
 	if err != nil {
@@ -45,6 +63,9 @@ func main() {
 
 	s := grpc.NewServer()
 	legolog_grpcint.RegisterAuditorServer(s, serv)
+	if pool := serv.GossipPool(); pool != nil {
+		legolog_grpcint.RegisterGossipServer(s, pool)
+	}
 
 	if err = s.Serve(listenSocket); err != nil {
 		log.Fatalf("failed to serve: %v", err)
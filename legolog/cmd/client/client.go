@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -14,9 +12,7 @@ import (
 
 	"github.com/huyuncong/MerkleSquare/constants"
 	"github.com/huyuncong/MerkleSquare/core"
-	auditor_client "github.com/huyuncong/MerkleSquare/legolog/auditor/auditorclt"
 	client "github.com/huyuncong/MerkleSquare/legolog/client"
-	libcrypto "github.com/huyuncong/MerkleSquare/lib/crypto"
 	"github.com/immesys/bw2/crypto"
 )
 
@@ -31,7 +27,6 @@ var masterSK = []byte{
 var NumThreads = 32
 
 var c []*client.Client
-var a []auditor_client.Client
 
 const NumClients = 1 << 3
 const NumClientsMask = NumClients - 1
@@ -41,14 +36,9 @@ var ids []string
 
 func setUpClients(serverAddr string, auditorAddr string) {
 	c = make([]*client.Client, NumClients)
-	a = make([]auditor_client.Client, NumClients)
 	var err error
 	for i := range c {
-		c[i], err = client.NewClient(serverAddr, "", "")
-		if err != nil {
-			panic(err)
-		}
-		a[i], err = auditor_client.NewAuditorClient(auditorAddr)
+		c[i], err = client.NewClient(serverAddr, auditorAddr, "")
 		if err != nil {
 			panic(err)
 		}
@@ -112,9 +102,16 @@ func handler(id int, startC chan interface{}, closeC chan interface{}, result ch
 	}
 }
 
-func GetPartitionForIdentifier(identifier []byte, partitions uint) uint {
-	hash := uint(binary.BigEndian.Uint64(libcrypto.Hash(identifier)))
-	return hash % partitions
+// lookUpPKVerify looks up the key of the i-th id, which the client checks
+// against the auditor's digest of its partition.
+func lookUpPKVerify(ctx context.Context, i int) error {
+	id := ids[i]
+	_, pos, _, _, err := c[i&NumClientsMask].LookUpPKVerify(ctx, []byte(id), []byte(id))
+	if err != nil {
+		fmt.Println("pos", pos)
+		fmt.Println(i, err)
+	}
+	return nil
 }
 
 func spaceOutAppends(ctx context.Context, cfg core.Config, expCfg core.ExperimentConfig, _c *client.Client, idx int) {
@@ -191,28 +188,7 @@ func main() {
 			}
 		*/
 		res := measureThroughput(ctx, &expCfg, func(i int) error {
-			id := ids[i]
-			partition := GetPartitionForIdentifier([]byte(id), uint(cfg.Partitions))
-			digest, _, _, err := a[i&NumClientsMask].GetEpochUpdateForPartition(ctx, uint64(partition))
-			if err != nil {
-				fmt.Println(err)
-			}
-			var proof core.LegologExistenceProof
-			val, pos, sig, proofJson, err := c.LookUpPKVerify(ctx, []byte("0"), []byte(id))
-			if err != nil {
-				fmt.Println("pos", pos)
-				fmt.Println(err)
-			}
-			err = json.Unmarshal(proofJson, &proof)
-			if err != nil {
-				fmt.Println(err)
-			}
-			var v core.AggHistVerifier
-			ok, err := v.ValidatePKProof(digest, &proof, []byte(id), val, sig, pos, masterVK)
-			if !ok || err != nil {
-				fmt.Println(i, err)
-			}
-			return nil
+			return lookUpPKVerify(ctx, i)
 		})
 		fmt.Println("throughput: ", res, "ops/s")
 	}
@@ -5,10 +5,10 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 
 	"github.com/huyuncong/MerkleSquare/constants"
@@ -17,6 +17,7 @@ import (
 	legolog "github.com/huyuncong/MerkleSquare/legolog/server"
 
 	"github.com/huyuncong/MerkleSquare/lib/storage"
+	"github.com/immesys/bw2/crypto"
 	"google.golang.org/grpc"
 )
 
@@ -28,22 +29,8 @@ func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	ctx := context.Background()
 
-	dir, err := ioutil.TempDir("", "teststore")
-	if err != nil {
-		panic(err)
-	}
-	defer os.RemoveAll(dir)
-
-	// var err error
-	// dir := "./temp"
-
-	db := storage.OpenFile(dir)
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close(ctx)
-
 	configPtr := flag.String("config", "../experiments/configs/test.yaml", "config file path")
+	signingKeyPtr := flag.String("signing_key", "server-signing-key", "file holding the key the server signs published digests with; created if missing")
 	flag.Parse()
 
 	cfg, err := core.ParseConfig(*configPtr)
@@ -57,7 +44,19 @@ func main() {
 	}
 	defer os.RemoveAll(tmpdir)
 
-	serv := legolog.NewStoppedServer(storage.NewMapStorage(), &cfg, tmpdir)
+	db, err := openStorage(&cfg, tmpdir)
+	if err != nil {
+		panic(errors.New("Failed to open storage: " + err.Error()))
+	}
+	defer db.Close(ctx)
+
+	serv := legolog.NewStoppedServer(db, &cfg, tmpdir)
+	signingSK, signingVK, err := loadSigningKey(*signingKeyPtr)
+	if err != nil {
+		panic(errors.New("Failed to load signing key: " + err.Error()))
+	}
+	serv.SetSigningKey(signingSK, signingVK)
+	fmt.Printf("signing key: %x\n", signingVK)
 	fmt.Println("preloading server")
 	serv.PreloadServer(0, 1e6, 32, 32)
 	serv.IncrementUpdateEpoch()
@@ -117,3 +116,49 @@ func main() {
 	}
 
 }
+
+// openStorage opens the storage backend the config selects, in memory if it
+// selects none. A backend that keeps files keeps them in the config's storage
+// directory or, if it names none, under tmpdir, which is removed on exit: the
+// storage then lasts no longer than the process.
+//
+// The server rebuilds its partitions from scratch on start, and cannot yet
+// rebuild them from the value records in storage, so a storage directory
+// holding a previous run's files is refused rather than served out of step
+// with the partitions.
+func openStorage(cfg *core.Config, tmpdir string) (storage.Storage, error) {
+	if cfg.Storage == "" {
+		return storage.NewMapStorage(), nil
+	}
+	dir := cfg.StorageDir
+	if dir == "" {
+		dir = filepath.Join(tmpdir, "storage")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(entries) != 0 {
+		return nil, fmt.Errorf("storage directory %s is not empty, and the server cannot resume from a previous run's storage", dir)
+	}
+	return storage.Open(cfg.Storage, dir)
+}
+
+// loadSigningKey reads the server's signing key pair from path, or generates
+// one and writes it there, so that auditors can keep the key they were
+// configured with across restarts.
+func loadSigningKey(path string) ([]byte, []byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != 64 {
+			return nil, nil, fmt.Errorf("%s holds %d bytes, not a key pair", path, len(key))
+		}
+		return key[:32], key[32:], nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	sk, vk := crypto.GenerateKeypair()
+	err = os.WriteFile(path, append(append([]byte{}, sk...), vk...), 0600)
+	return sk, vk, err
+}
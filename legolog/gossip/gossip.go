@@ -0,0 +1,262 @@
+// Package gossip lets auditors and clients exchange the signed digests they
+// have seen from the server, so that a server showing them different digests
+// for the same epoch is caught. Digests are exchanged over the Gossip gRPC
+// service or, for air-gapped setups, through files dropped in a shared
+// directory.
+package gossip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/huyuncong/MerkleSquare/core"
+	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
+	"google.golang.org/grpc"
+)
+
+// retainedEpochs is how many epochs behind the newest digest of a partition a
+// pool still keeps digests for. Older digests are dropped and no longer
+// gossiped.
+const retainedEpochs = 256
+
+// Pool holds the signed digests its owner has seen or been gossiped. An
+// honest server signs one digest for each partition, epoch and verification
+// period; the pool keeps any others too, so that a split view it finds
+// spreads to its peers.
+type Pool struct {
+	serverVK []byte
+	report   func(*core.MisbehaviorEvidence)
+
+	partitions map[string]*partitionDigests // by partition binding
+	lock       sync.Mutex
+}
+
+// partitionDigests are the digests of a partition a pool holds.
+type partitionDigests struct {
+	newestEpoch uint64
+	digests     map[digestKey][]*core.SignedDigest
+}
+
+type digestKey struct {
+	epoch              uint64
+	verificationPeriod uint64
+}
+
+var _ legolog_grpcint.GossipServer = (*Pool)(nil)
+
+// NewPool creates a pool of digests signed by the server with key serverVK.
+// report, if not nil, is called with evidence of every split view the pool
+// finds, however the conflicting digest arrived.
+func NewPool(serverVK []byte, report func(*core.MisbehaviorEvidence)) *Pool {
+	return &Pool{
+		serverVK:   serverVK,
+		report:     report,
+		partitions: make(map[string]*partitionDigests),
+	}
+}
+
+// Add adds a digest the server signed to the pool, and returns evidence of a
+// split view if the pool held a different digest for the same epoch.
+func (p *Pool) Add(digest *core.SignedDigest) (*core.MisbehaviorEvidence, error) {
+	if !digest.Verify(p.serverVK) {
+		return nil, errors.New("digest is not signed by the server")
+	}
+	evidence := p.add(digest)
+	if evidence != nil && p.report != nil {
+		p.report(evidence)
+	}
+	return evidence, nil
+}
+
+func (p *Pool) add(digest *core.SignedDigest) *core.MisbehaviorEvidence {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	binding := string(digest.Digest.Binding.Hash())
+	partition := p.partitions[binding]
+	if partition == nil {
+		partition = &partitionDigests{digests: make(map[digestKey][]*core.SignedDigest)}
+		p.partitions[binding] = partition
+	}
+	epoch := digest.Digest.Epoch
+	if epoch+retainedEpochs < partition.newestEpoch {
+		return nil
+	}
+	key := digestKey{epoch, digest.Digest.VerificationPeriod}
+	held := partition.digests[key]
+	for _, h := range held {
+		if !h.ConflictsWith(digest) {
+			return nil
+		}
+	}
+	partition.digests[key] = append(held, digest)
+	if epoch > partition.newestEpoch {
+		partition.newestEpoch = epoch
+		for key := range partition.digests {
+			if key.epoch+retainedEpochs < epoch {
+				delete(partition.digests, key)
+			}
+		}
+	}
+	if len(held) != 0 {
+		return core.NewSplitViewEvidence(held[0], digest)
+	}
+	return nil
+}
+
+// Merge adds the digests gossiped by a peer to the pool, skipping those the
+// server did not sign, and returns the evidence of split views they show.
+func (p *Pool) Merge(digests []*core.SignedDigest) []*core.MisbehaviorEvidence {
+	var evidence []*core.MisbehaviorEvidence
+	for _, digest := range digests {
+		e, err := p.Add(digest)
+		if err == nil && e != nil {
+			evidence = append(evidence, e)
+		}
+	}
+	return evidence
+}
+
+// Digests returns the digests the pool holds.
+func (p *Pool) Digests() []*core.SignedDigest {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var digests []*core.SignedDigest
+	for _, partition := range p.partitions {
+		for _, held := range partition.digests {
+			digests = append(digests, held...)
+		}
+	}
+	return digests
+}
+
+// ExchangeDigests implements the Gossip service: the peer's digests are
+// merged into the pool, and the pool's digests are sent back.
+func (p *Pool) ExchangeDigests(ctx context.Context, req *legolog_grpcint.ExchangeDigestsRequest) (
+	*legolog_grpcint.ExchangeDigestsResponse, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	digests, err := unmarshalDigests(req.GetMarshaledSignedDigests())
+	if err != nil {
+		return nil, err
+	}
+	p.Merge(digests)
+
+	marshaledDigests, err := marshalDigests(p.Digests())
+	if err != nil {
+		return nil, err
+	}
+	return &legolog_grpcint.ExchangeDigestsResponse{MarshaledSignedDigests: marshaledDigests}, nil
+}
+
+// Dial connects to the Gossip service of a peer.
+func Dial(address string) (legolog_grpcint.GossipClient, error) {
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	return legolog_grpcint.NewGossipClient(conn), nil
+}
+
+// GossipWith sends the pool's digests to a peer and merges the peer's into
+// the pool, returning the evidence of split views found in them.
+func (p *Pool) GossipWith(ctx context.Context, peer legolog_grpcint.GossipClient) ([]*core.MisbehaviorEvidence, error) {
+	marshaledDigests, err := marshalDigests(p.Digests())
+	if err != nil {
+		return nil, err
+	}
+	response, err := peer.ExchangeDigests(ctx, &legolog_grpcint.ExchangeDigestsRequest{MarshaledSignedDigests: marshaledDigests})
+	if err != nil {
+		return nil, err
+	}
+	digests, err := unmarshalDigests(response.GetMarshaledSignedDigests())
+	if err != nil {
+		return nil, err
+	}
+	return p.Merge(digests), nil
+}
+
+// WriteFile writes the pool's digests to path, replacing the file whole so
+// that a peer never reads it half written.
+func (p *Pool) WriteFile(path string) error {
+	marshaledDigests, err := json.Marshal(p.Digests())
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, marshaledDigests, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// ReadFile merges the digests a peer wrote to path into the pool, returning
+// the evidence of split views found in them.
+func (p *Pool) ReadFile(path string) ([]*core.MisbehaviorEvidence, error) {
+	marshaledDigests, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var digests []*core.SignedDigest
+	err = json.Unmarshal(marshaledDigests, &digests)
+	if err != nil {
+		return nil, err
+	}
+	return p.Merge(digests), nil
+}
+
+// ExchangeDir gossips through files in dir: the pool's digests are written to
+// name.json, and those in every other .json file are merged into the pool.
+func (p *Pool) ExchangeDir(dir string, name string) ([]*core.MisbehaviorEvidence, error) {
+	err := p.WriteFile(filepath.Join(dir, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var evidence []*core.MisbehaviorEvidence
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") || entry.Name() == name+".json" {
+			continue
+		}
+		e, err := p.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return evidence, err
+		}
+		evidence = append(evidence, e...)
+	}
+	return evidence, nil
+}
+
+func marshalDigests(digests []*core.SignedDigest) ([][]byte, error) {
+	marshaledDigests := make([][]byte, len(digests))
+	for i, digest := range digests {
+		marshaledDigest, err := json.Marshal(digest)
+		if err != nil {
+			return nil, err
+		}
+		marshaledDigests[i] = marshaledDigest
+	}
+	return marshaledDigests, nil
+}
+
+func unmarshalDigests(marshaledDigests [][]byte) ([]*core.SignedDigest, error) {
+	digests := make([]*core.SignedDigest, len(marshaledDigests))
+	for i, marshaledDigest := range marshaledDigests {
+		digests[i] = new(core.SignedDigest)
+		err := json.Unmarshal(marshaledDigest, digests[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return digests, nil
+}
@@ -0,0 +1,141 @@
+package gossip
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/huyuncong/MerkleSquare/core"
+	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
+	"github.com/immesys/bw2/crypto"
+)
+
+// localPeer calls the Gossip service of a pool directly.
+type localPeer struct {
+	pool *Pool
+}
+
+func (l *localPeer) ExchangeDigests(ctx context.Context, req *legolog_grpcint.ExchangeDigestsRequest,
+	opts ...grpc.CallOption) (*legolog_grpcint.ExchangeDigestsResponse, error) {
+	return l.pool.ExchangeDigests(ctx, req)
+}
+
+type testServer struct {
+	sk []byte
+	vk []byte
+}
+
+// publish signs the digest of a partition holding keys, one per epoch.
+func (s *testServer) publish(keys ...string) *core.SignedDigest {
+	p := core.NewPartition()
+	p.Bind(core.PartitionBinding{LogID: []byte("log"), PartitionIndex: 0, NumPartitions: 1})
+	for _, key := range keys {
+		p.Append([]byte(key), []byte(key), []byte(key), []byte(key))
+		p.IncrementUpdateEpoch()
+	}
+	return core.SignDigest(s.sk, s.vk, p.GetDigest())
+}
+
+func newTestPool(server *testServer) (*Pool, *[]*core.MisbehaviorEvidence) {
+	var reports []*core.MisbehaviorEvidence
+	return NewPool(server.vk, func(e *core.MisbehaviorEvidence) { reports = append(reports, e) }), &reports
+}
+
+func TestGossipFindsSplitView(t *testing.T) {
+	ctx := context.Background()
+	server := &testServer{}
+	server.sk, server.vk = crypto.GenerateKeypair()
+	auditor, auditorReports := newTestPool(server)
+	client, clientReports := newTestPool(server)
+
+	if _, err := auditor.Add(server.publish("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Add(server.publish("a")); err != nil {
+		t.Fatal(err)
+	}
+	evidence, err := client.GossipWith(ctx, &localPeer{auditor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evidence) != 0 || len(*auditorReports) != 0 || len(*clientReports) != 0 {
+		t.Fatal("digests of different epochs were reported as a split view")
+	}
+
+	// the server shows the client a different second epoch than the one the
+	// client was gossiped, and the split view spreads back to the auditor
+	found, err := client.Add(server.publish("a", "forked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || len(*clientReports) != 1 {
+		t.Fatal("client did not find the split view")
+	}
+	if _, err := client.GossipWith(ctx, &localPeer{auditor}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*auditorReports) != 1 {
+		t.Fatalf("expected the auditor to find a single split view, got %d", len(*auditorReports))
+	}
+	// gossiping again finds nothing new
+	if _, err := client.GossipWith(ctx, &localPeer{auditor}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*auditorReports) != 1 || len(*clientReports) != 1 {
+		t.Error("the same split view was reported again")
+	}
+	for _, e := range []*core.MisbehaviorEvidence{found, (*auditorReports)[0]} {
+		if err := e.Verify([]byte("log"), 1, server.vk); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// digests the server did not sign are not gossiped
+	impostor := &testServer{}
+	impostor.sk, impostor.vk = crypto.GenerateKeypair()
+	if _, err := client.Add(impostor.publish("a", "b", "c")); err == nil {
+		t.Error("accepted a digest not signed by the server")
+	}
+}
+
+func TestGossipThroughFiles(t *testing.T) {
+	dir := t.TempDir()
+	server := &testServer{}
+	server.sk, server.vk = crypto.GenerateKeypair()
+	first, firstReports := newTestPool(server)
+	second, secondReports := newTestPool(server)
+	first.Add(server.publish("a"))
+	second.Add(server.publish("b"))
+
+	if _, err := first.ExchangeDir(dir, "first"); err != nil {
+		t.Fatal(err)
+	}
+	evidence, err := second.ExchangeDir(dir, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evidence) != 1 || len(*secondReports) != 1 {
+		t.Fatalf("expected a split view in the dropped file, got %d", len(evidence))
+	}
+	if _, err := first.ExchangeDir(dir, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*firstReports) != 1 {
+		t.Fatalf("expected a split view in the dropped file, got %d", len(*firstReports))
+	}
+}
+
+func TestGossipForgetsOldEpochs(t *testing.T) {
+	server := &testServer{}
+	server.sk, server.vk = crypto.GenerateKeypair()
+	pool, _ := newTestPool(server)
+	pool.Add(server.publish("a"))
+	newest := &core.SignedDigest{Digest: pool.Digests()[0].Digest}
+	digest := *newest.Digest
+	digest.Epoch += retainedEpochs + 1
+	pool.Add(core.SignDigest(server.sk, server.vk, &digest))
+	if digests := pool.Digests(); len(digests) != 1 || digests[0].Digest.Epoch != digest.Epoch {
+		t.Errorf("expected only the digest of epoch %d to be kept", digest.Epoch)
+	}
+}
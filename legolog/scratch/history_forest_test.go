@@ -9,7 +9,7 @@ func TestHistoryForestAppend(t *testing.T) {
 	forest := NewHistoryForest(31)
 	tree0 := NewPrefixTree()
 	tree1 := NewPrefixTree()
-	tree1.PrefixAppend([]byte("1"), []byte("2"), 1)
+	tree1.PrefixAppend(NewBitString([]byte("1")), []byte("2"), 1)
 	forest.Append(tree0)
 	forest.Append(tree1)
 	forest.Append(tree1)
@@ -80,23 +80,14 @@ func (s *Server) Append(stream legolog_grpcint.LegoLog_AppendServer) error {
 	partitionServer.LastPosLock.Unlock()
 
 	//4. Add to K-V store
-	var serializedValue []byte
-	// Prepend to existing entry
-	original, _ := partitionServer.Storage.Get(ctx, identifier)
-	valueRecord := make([]ValueRecord, 1)
-	valueRecord[0] = ValueRecord{
+	err = partitionServer.storeValueRecord(ctx, identifier, ValueRecord{
 		Position:  position,
 		Signature: signature,
 		Value:     value,
+	})
+	if err != nil {
+		return err
 	}
-	if original == nil {
-		serializedValue, _ = json.Marshal(valueRecord)
-	} else {
-		var deserialized []ValueRecord
-		json.Unmarshal(original, &deserialized)
-		serializedValue, _ = json.Marshal(append(valueRecord, deserialized...))
-	}
-	partitionServer.Storage.Put(ctx, identifier, serializedValue)
 	// response.VrfKey = s.vrfPrivKey.Compute(req.GetUsr().GetUsername())
 	response.Completed = true
 	stream.Send(response)
@@ -211,21 +202,14 @@ func (s *Server) LookUpPK(ctx context.Context, req *legolog_grpcint.LookUpPKRequ
 	var identifier []byte = req.Identifier.GetIdentifier()
 	partitionServer := s.GetPartitionForIdentifier(identifier)
 
-	var keys []ValueRecord
-
-	var serializedKey, err = partitionServer.Storage.Get(ctx, identifier)
+	latest_key, err := partitionServer.latestValueRecord(ctx, identifier)
 	if err != nil {
 		return nil, err
 	}
-
-	json.Unmarshal(serializedKey, &keys)
-
-	if keys == nil || len(keys) == 0 {
+	if latest_key == nil {
 		return nil, errors.New("No keys found in storage for identifier " + string(identifier[:]))
 	}
 
-	var latest_key ValueRecord = keys[0] // convention is to store most recent element at the beginning
-
 	return &legolog_grpcint.LookUpPKResponse{
 		IndexedValue: &legolog_grpcint.IndexedValue{
 			Value: &legolog_grpcint.Value{Value: latest_key.Value},
@@ -282,19 +266,64 @@ func (s *Server) LookUpMKVerify(ctx context.Context,
 
 	masterKey, pos, sign := lookupMKResponse.Imk.MasterKey, lookupMKResponse.Imk.Pos, lookupMKResponse.Signature
 
-	proof := partitionServer.Partition.GenerateExistenceProof(identifier, masterKey.Mk, sign)
-
-	marshaledProof, err := json.Marshal(proof)
+	marshaledProof, marshaledDigest, marshaledGlobalDigest, marshaledPartitionProof, err := s.marshalPublishedProof(partitionServer,
+		func() interface{} {
+			return partitionServer.Partition.GenerateExistenceProof(identifier, masterKey.Mk, sign)
+		})
 	return &legolog_grpcint.LookUpMKVerifyResponse{
 		IndexedValue: &legolog_grpcint.IndexedValue{
 			Pos:   &legolog_grpcint.Position{Pos: pos.Pos},
 			Value: &legolog_grpcint.Value{Value: masterKey.Mk},
 		},
-		Signature: sign,
-		Proof:     marshaledProof,
+		Signature:             sign,
+		Proof:                 marshaledProof,
+		MarshaledDigest:       marshaledDigest,
+		MarshaledGlobalDigest: marshaledGlobalDigest,
+		PartitionProof:        marshaledPartitionProof,
 	}, err
 }
 
+// marshalPublishedDigests marshals the published digest of a partition, the
+// global digest and the partition's inclusion proof under it.
+func (s *Server) marshalPublishedDigests(partitionServer *PartitionServer) (
+	marshaledDigest []byte, marshaledGlobalDigest []byte, marshaledPartitionProof []byte, err error) {
+	digest, globalDigest, inclusionProof := s.PublishedDigests(partitionServer)
+	return marshalDigests(&digest, &globalDigest, &inclusionProof)
+}
+
+// marshalPublishedProof marshals the proof generated by prove along with the
+// published digests, as marshalPublishedDigests does. The proof and the
+// digests are taken under one epochLock, so that the proof is of the trees the
+// digest commits to.
+func (s *Server) marshalPublishedProof(partitionServer *PartitionServer, prove func() interface{}) (
+	marshaledProof []byte, marshaledDigest []byte, marshaledGlobalDigest []byte, marshaledPartitionProof []byte, err error) {
+	s.epochLock.RLock()
+	proof := prove()
+	digest, globalDigest, inclusionProof := partitionServer.PublishedDigest, s.GlobalDigest, partitionServer.PublishedInclusionProof
+	s.epochLock.RUnlock()
+
+	marshaledProof, err = json.Marshal(proof)
+	if err != nil {
+		return
+	}
+	marshaledDigest, marshaledGlobalDigest, marshaledPartitionProof, err = marshalDigests(&digest, &globalDigest, &inclusionProof)
+	return
+}
+
+func marshalDigests(digest *core.LegologDigest, globalDigest *core.GlobalDigest, inclusionProof *core.PartitionInclusionProof) (
+	marshaledDigest []byte, marshaledGlobalDigest []byte, marshaledPartitionProof []byte, err error) {
+	marshaledDigest, err = json.Marshal(digest)
+	if err != nil {
+		return
+	}
+	marshaledGlobalDigest, err = json.Marshal(globalDigest)
+	if err != nil {
+		return
+	}
+	marshaledPartitionProof, err = json.Marshal(inclusionProof)
+	return
+}
+
 func (s *Server) LookUpPKVerify(ctx context.Context, req *legolog_grpcint.LookUpPKVerifyRequest) (
 	*legolog_grpcint.LookUpPKVerifyResponse, error) {
 
@@ -317,20 +346,148 @@ func (s *Server) LookUpPKVerify(ctx context.Context, req *legolog_grpcint.LookUp
 		return nil, err
 	}
 
-	proof := partitionServer.Partition.GenerateExistenceProof(req.Identifier.Identifier, indexedValue.Value.Value, sign)
-
 	//fmt.Println("generated existence proof ", proof)
 	/* 	proof := s.MerkleSquare.ProveLatest(vrfKey, key, uint32(pos), uint32(req.Size))*/
-	marshaledProof, err := json.Marshal(proof)
+	marshaledProof, marshaledDigest, marshaledGlobalDigest, marshaledPartitionProof, err := s.marshalPublishedProof(partitionServer,
+		func() interface{} {
+			return partitionServer.Partition.GenerateExistenceProof(req.Identifier.Identifier, indexedValue.Value.Value, sign)
+		})
 
 	return &legolog_grpcint.LookUpPKVerifyResponse{
 		IndexedValue: indexedValue,
 		Signature:    sign,
 		/* 		VrfKey:       vrfKey, */
-		Proof: marshaledProof,
+		Proof:                 marshaledProof,
+		MarshaledDigest:       marshaledDigest,
+		MarshaledGlobalDigest: marshaledGlobalDigest,
+		PartitionProof:        marshaledPartitionProof,
 	}, err
 }
 
+// GetMonitoringProof proves the values of an identifier appended since the
+// head of its value chain the client last verified against the history
+// forest roots covering the verification periods since the one the client
+// last checked, so that a client coming back online can catch up.
+func (s *Server) GetMonitoringProof(ctx context.Context, req *legolog_grpcint.GetMonitoringProofRequest) (
+	*legolog_grpcint.GetMonitoringProofResponse, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	partitionServer := s.GetPartitionForIdentifier(req.GetIdentifier().GetIdentifier())
+	prover, ok := partitionServer.Partition.(core.MonitoringProver)
+	if !ok {
+		return nil, errors.New("partition does not keep an aggregated history")
+	}
+
+	lookupPKResponse, err := s.LookUpPK(ctx, &legolog_grpcint.LookUpPKRequest{Identifier: req.Identifier})
+	if err != nil {
+		return nil, err
+	}
+	indexedValue, sign := lookupPKResponse.IndexedValue, lookupPKResponse.Signature
+
+	marshaledProof, marshaledDigest, _, _, err := s.marshalPublishedProof(partitionServer, func() interface{} {
+		return prover.GenerateMonitoringProof(req.GetIdentifier().GetIdentifier(), indexedValue.Value.Value, sign,
+			req.GetSinceVerificationPeriod(), req.GetVerifiedHead())
+	})
+
+	return &legolog_grpcint.GetMonitoringProofResponse{
+		IndexedValue:    indexedValue,
+		Signature:       sign,
+		Proof:           marshaledProof,
+		MarshaledDigest: marshaledDigest,
+	}, err
+}
+
+// SubscribeCheckpoints streams the checkpoints of the requested partitions,
+// or of every partition if none are given: first the current ones, then new
+// ones each time an update epoch or verification period is published. Each
+// checkpoint comes with a consistency proof from the update log size last
+// sent on the stream, starting from the sizes in the request.
+func (s *Server) SubscribeCheckpoints(req *legolog_grpcint.SubscribeCheckpointsRequest,
+	stream legolog_grpcint.LegoLog_SubscribeCheckpointsServer) error {
+
+	partitions := req.GetPartitionIndices()
+	if len(partitions) == 0 {
+		for i := range s.PartitionServers {
+			partitions = append(partitions, uint64(i))
+		}
+	}
+	for _, i := range partitions {
+		if int(i) >= len(s.PartitionServers) {
+			return fmt.Errorf("Partition out of bounds: %d", i)
+		}
+	}
+	sizes := make([]uint32, len(partitions))
+	if len(req.GetOldSizes()) != 0 {
+		if len(req.GetOldSizes()) != len(partitions) {
+			return errors.New("expected an old size for each partition")
+		}
+		for j, oldSize := range req.GetOldSizes() {
+			sizes[j] = uint32(oldSize)
+		}
+	}
+
+	published, unsubscribe := s.subscribe()
+	defer unsubscribe()
+	for {
+		response := &legolog_grpcint.SubscribeCheckpointsResponse{PartitionIndices: partitions}
+		for j, i := range partitions {
+			checkpoint, size, err := s.publishedCheckpoint(s.PartitionServers[i], sizes[j])
+			if err != nil {
+				return err
+			}
+			response.Checkpoints = append(response.Checkpoints, checkpoint)
+			sizes[j] = size
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+
+		select {
+		case <-published:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.stopper:
+			return nil
+		}
+	}
+}
+
+// publishedCheckpoint returns the published checkpoint of a partition with a
+// consistency proof from oldSize, along with the size of its update log.
+func (s *Server) publishedCheckpoint(partitionServer *PartitionServer, oldSize uint32) (
+	*legolog_grpcint.GetNewCheckPointResponse, uint32, error) {
+	s.epochLock.RLock()
+	digest, globalDigest, inclusionProof := partitionServer.PublishedDigest, s.GlobalDigest, partitionServer.PublishedInclusionProof
+	proof := partitionServer.Partition.GetUpdateEpochConsistencyProof(oldSize)
+	s.epochLock.RUnlock()
+
+	marshaledDigest, err := json.Marshal(digest)
+	if err != nil {
+		return nil, 0, err
+	}
+	marshaledGlobalDigest, err := json.Marshal(globalDigest)
+	if err != nil {
+		return nil, 0, err
+	}
+	marshaledPartitionProof, err := json.Marshal(inclusionProof)
+	if err != nil {
+		return nil, 0, err
+	}
+	marshaledProof, err := json.Marshal(proof)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &legolog_grpcint.GetNewCheckPointResponse{
+		Checkpoint:            &legolog_grpcint.CheckPoint{MarshaledDigest: marshaledDigest},
+		Proof:                 marshaledProof,
+		MarshaledGlobalDigest: marshaledGlobalDigest,
+		PartitionProof:        marshaledPartitionProof,
+		DigestSignature:       s.signDigest(&digest),
+	}, digest.UpdateLogSize, nil
+}
+
 // TODO: for now will just return digest, but needs to be modified later
 func (s *Server) GetNewCheckPoint(ctx context.Context,
 	req *legolog_grpcint.GetNewCheckPointRequest) (
@@ -347,11 +504,13 @@ func (s *Server) GetNewCheckPoint(ctx context.Context,
 	partitionServer.Partition.GetDigest()
 
 	*/
-	d := partitionServer.PublishedDigest
-	marshalledDigest, _ := json.Marshal(d)
+	marshalledDigest, marshalledGlobalDigest, marshalledPartitionProof, err := s.marshalPublishedDigests(partitionServer)
+	if err != nil {
+		return nil, err
+	}
 	// fmt.Println("the marshalled digest is", marshalledDigest)
 	var unmarshalledDigest core.LegologDigest
-	err := json.Unmarshal(marshalledDigest, &unmarshalledDigest)
+	err = json.Unmarshal(marshalledDigest, &unmarshalledDigest)
 	if err != nil {
 		fmt.Println("Error unmarshalling digest in getnewcheckpoint", err)
 	}
@@ -361,6 +520,9 @@ func (s *Server) GetNewCheckPoint(ctx context.Context,
 		Checkpoint: &legolog_grpcint.CheckPoint{
 			MarshaledDigest: marshalledDigest,
 		},
+		MarshaledGlobalDigest: marshalledGlobalDigest,
+		PartitionProof:        marshalledPartitionProof,
+		DigestSignature:       s.signDigest(&unmarshalledDigest),
 	}, nil
 
 	// if err := ctx.Err(); err != nil {
@@ -407,6 +569,21 @@ func (s *Server) GetNewUpdateCheckPoint(ctx context.Context,
 	req *legolog_grpcint.GetNewCheckPointRequest) (
 	*legolog_grpcint.GetNewCheckPointResponse, error) {
 
+	// return partition's diges
+	if int(req.PartitionIndex) >= len(s.PartitionServers) {
+		return nil, fmt.Errorf("Partition out of bounds: %d", req.PartitionIndex)
+	}
+	// the digest and the proof are taken under one epochLock, so that the
+	// proof is of the tree the digest commits to
+	response, _, err := s.publishedCheckpoint(s.PartitionServers[req.PartitionIndex], uint32(req.OldSize))
+	return response, err
+}
+
+// TODO: for now will just return digest, but needs to be modified later
+func (s *Server) GetNewVerifyCheckPoint(ctx context.Context,
+	req *legolog_grpcint.GetNewCheckPointRequest) (
+	*legolog_grpcint.GetNewCheckPointResponse, error) {
+
 	// return partition's diges
 	if int(req.PartitionIndex) >= len(s.PartitionServers) {
 		return nil, fmt.Errorf("Partition out of bounds: %d", req.PartitionIndex)
@@ -418,61 +595,124 @@ func (s *Server) GetNewUpdateCheckPoint(ctx context.Context,
 	partitionServer.Partition.GetDigest()
 
 	*/
-	d := partitionServer.PublishedDigest
-	marshalledDigest, _ := json.Marshal(d)
+	marshalledDigest, marshalledGlobalDigest, marshalledPartitionProof, err := s.marshalPublishedDigests(partitionServer)
+	if err != nil {
+		return nil, err
+	}
 	// fmt.Println("the marshalled digest is", marshalledDigest)
 	var unmarshalledDigest core.LegologDigest
-	err := json.Unmarshal(marshalledDigest, &unmarshalledDigest)
+	err = json.Unmarshal(marshalledDigest, &unmarshalledDigest)
 	if err != nil {
 		fmt.Println("Error unmarshalling digest in getnewcheckpoint", err)
-		return nil, err
 	}
 	// fmt.Println("unmarshalled digest is ", unmarshalledDigest)
-	proof := partitionServer.Partition.GetUpdateEpochConsistencyProof(uint32(req.OldSize))
-	marshalledProof, err := json.Marshal(proof)
-	if err != nil {
-		fmt.Println("Error marshalling proof in getnewcheckpoint", err)
-		return nil, err
-	}
+
 	return &legolog_grpcint.GetNewCheckPointResponse{
 		Checkpoint: &legolog_grpcint.CheckPoint{
 			MarshaledDigest: marshalledDigest,
 		},
-		Proof: marshalledProof,
+		MarshaledGlobalDigest: marshalledGlobalDigest,
+		PartitionProof:        marshalledPartitionProof,
+		DigestSignature:       s.signDigest(&unmarshalledDigest),
 	}, nil
 }
 
-// TODO: for now will just return digest, but needs to be modified later
-func (s *Server) GetNewVerifyCheckPoint(ctx context.Context,
-	req *legolog_grpcint.GetNewCheckPointRequest) (
-	*legolog_grpcint.GetNewCheckPointResponse, error) {
+// GetEpochUpdates returns the updates a partition rolled up in an epoch, so
+// that an auditor can replay them into its own base trees. A full auditor
+// acknowledges the updates it stored with the request, so that the server can
+// prune them.
+func (s *Server) GetEpochUpdates(ctx context.Context,
+	req *legolog_grpcint.GetEpochUpdatesRequest) (
+	*legolog_grpcint.GetEpochUpdatesResponse, error) {
 
-	// return partition's diges
 	if int(req.PartitionIndex) >= len(s.PartitionServers) {
 		return nil, fmt.Errorf("Partition out of bounds: %d", req.PartitionIndex)
 	}
 	partitionServer := s.PartitionServers[req.PartitionIndex]
+	history, ok := partitionServer.Partition.(core.UpdateHistoryProvider)
+	if !ok {
+		return nil, errors.New("partition does not keep its update history")
+	}
+	if len(req.GetMarshaledAck()) != 0 {
+		var ack core.StoredUpdatesAck
+		err := json.Unmarshal(req.GetMarshaledAck(), &ack)
+		if err != nil {
+			return nil, err
+		}
+		err = s.recordStoredUpdates(partitionServer, history, &ack)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	/* partitionServer.Partition.PublishedDigest
+	s.epochLock.RLock()
+	updates, err := history.GetEpochUpdates(req.GetEpoch())
+	s.epochLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	marshaledUpdates, err := json.Marshal(updates)
+	if err != nil {
+		return nil, err
+	}
+	return &legolog_grpcint.GetEpochUpdatesResponse{MarshaledUpdates: marshaledUpdates}, nil
+}
 
-	partitionServer.Partition.GetDigest()
+// GetHashChainProof returns the links a partition added to its hash chain
+// between two of its heads, so that an observer that skipped verification
+// periods can check that the newer digest extends the older one.
+func (s *Server) GetHashChainProof(ctx context.Context,
+	req *legolog_grpcint.GetHashChainProofRequest) (
+	*legolog_grpcint.GetHashChainProofResponse, error) {
 
-	*/
-	d := partitionServer.PublishedDigest
-	marshalledDigest, _ := json.Marshal(d)
-	// fmt.Println("the marshalled digest is", marshalledDigest)
-	var unmarshalledDigest core.LegologDigest
-	err := json.Unmarshal(marshalledDigest, &unmarshalledDigest)
+	if int(req.PartitionIndex) >= len(s.PartitionServers) {
+		return nil, fmt.Errorf("Partition out of bounds: %d", req.PartitionIndex)
+	}
+	prover, ok := s.PartitionServers[req.PartitionIndex].Partition.(core.HashChainProver)
+	if !ok {
+		return nil, errors.New("partition does not keep its hash chain")
+	}
+
+	s.epochLock.RLock()
+	proof, err := prover.GenerateHashChainProof(req.GetOldHashChain(), req.GetNewHashChain())
+	s.epochLock.RUnlock()
 	if err != nil {
-		fmt.Println("Error unmarshalling digest in getnewcheckpoint", err)
+		return nil, err
 	}
-	// fmt.Println("unmarshalled digest is ", unmarshalledDigest)
+	marshaledProof, err := json.Marshal(proof)
+	if err != nil {
+		return nil, err
+	}
+	return &legolog_grpcint.GetHashChainProofResponse{Proof: marshaledProof}, nil
+}
 
-	return &legolog_grpcint.GetNewCheckPointResponse{
-		Checkpoint: &legolog_grpcint.CheckPoint{
-			MarshaledDigest: marshalledDigest,
-		},
-	}, nil
+// GetUpdateLogChainProof proves that a partition's update log grew from an
+// old size to a new one through its state at the end of every verification
+// period in between, so that an auditor that missed periods can catch up.
+func (s *Server) GetUpdateLogChainProof(ctx context.Context,
+	req *legolog_grpcint.GetUpdateLogChainProofRequest) (
+	*legolog_grpcint.GetUpdateLogChainProofResponse, error) {
+
+	if int(req.PartitionIndex) >= len(s.PartitionServers) {
+		return nil, fmt.Errorf("Partition out of bounds: %d", req.PartitionIndex)
+	}
+	prover, ok := s.PartitionServers[req.PartitionIndex].Partition.(core.UpdateLogChainProver)
+	if !ok {
+		return nil, errors.New("partition does not keep its update log checkpoints")
+	}
+
+	s.epochLock.RLock()
+	proof, err := prover.GenerateUpdateLogChainProof(req.GetOldVerificationPeriod(), uint32(req.GetOldSize()),
+		req.GetNewVerificationPeriod(), uint32(req.GetNewSize()))
+	s.epochLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	marshaledProof, err := json.Marshal(proof)
+	if err != nil {
+		return nil, err
+	}
+	return &legolog_grpcint.GetUpdateLogChainProofResponse{Proof: marshaledProof}, nil
 }
 
 func (s *Server) GetMasterKeyProof(ctx context.Context,
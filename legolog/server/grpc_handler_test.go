@@ -0,0 +1,78 @@
+package legolog
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/huyuncong/MerkleSquare/core"
+	legolog_grpcint "github.com/huyuncong/MerkleSquare/legolog/legolog-grpcint"
+	"github.com/huyuncong/MerkleSquare/lib/storage"
+	"github.com/immesys/bw2/crypto"
+)
+
+func TestServerPrunesUpdatesFullAuditorsStored(t *testing.T) {
+	ctx := context.Background()
+	skA, vkA := crypto.GenerateKeypair()
+	skB, vkB := crypto.GenerateKeypair()
+	skC, vkC := crypto.GenerateKeypair()
+	cfg := &core.Config{
+		Partitions:   1,
+		LogID:        "log",
+		FullAudit:    true,
+		FullAuditors: []string{hex.EncodeToString(vkA), hex.EncodeToString(vkB)},
+	}
+	s := NewStoppedServer(storage.NewMapStorage(), cfg, t.TempDir())
+	for i := 0; i < 3; i++ {
+		s.IncrementUpdateEpoch()
+	}
+	binding := s.PartitionServers[0].PublishedDigest.Binding
+	getUpdates := func(epoch uint64, ack *core.StoredUpdatesAck) error {
+		req := &legolog_grpcint.GetEpochUpdatesRequest{Epoch: epoch}
+		if ack != nil {
+			req.MarshaledAck, _ = json.Marshal(ack)
+		}
+		_, err := s.GetEpochUpdates(ctx, req)
+		return err
+	}
+
+	if err := getUpdates(3, core.AckStoredUpdates(skA, vkA, &binding, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := getUpdates(1, nil); err != nil {
+		t.Errorf("server pruned updates before every full auditor stored them: %v", err)
+	}
+	// auditors the server does not wait for cannot prune updates
+	if err := getUpdates(3, core.AckStoredUpdates(skC, vkC, &binding, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := getUpdates(1, nil); err != nil {
+		t.Errorf("server pruned updates another auditor stored: %v", err)
+	}
+
+	if err := getUpdates(3, core.AckStoredUpdates(skB, vkB, &binding, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := getUpdates(1, nil); err == nil {
+		t.Error("server kept updates every full auditor stored")
+	}
+	if err := getUpdates(2, nil); err != nil {
+		t.Errorf("server pruned updates a full auditor did not store: %v", err)
+	}
+
+	forged := core.AckStoredUpdates(skB, vkB, &binding, 1)
+	forged.Through = 3
+	if err := getUpdates(3, forged); err == nil {
+		t.Error("server accepted an acknowledgement its auditor did not sign")
+	}
+}
+
+func TestServerKeepsUpdatesOnlyForFullAudit(t *testing.T) {
+	s := NewStoppedServer(storage.NewMapStorage(), &core.Config{Partitions: 1}, t.TempDir())
+	s.IncrementUpdateEpoch()
+	_, err := s.GetEpochUpdates(context.Background(), &legolog_grpcint.GetEpochUpdatesRequest{Epoch: 1})
+	if err == nil {
+		t.Error("server kept updates without a full audit")
+	}
+}
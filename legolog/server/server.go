@@ -3,17 +3,17 @@ package legolog
 import (
 	"context"
 	"crypto/rand"
-	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/huyuncong/MerkleSquare/core"
-	libcrypto "github.com/huyuncong/MerkleSquare/lib/crypto"
 	"github.com/huyuncong/MerkleSquare/lib/storage"
 
 	"github.com/immesys/bw2/crypto"
@@ -28,6 +28,26 @@ type Server struct {
 	updateEpochDuration   time.Duration
 	verifyEpochDuration   time.Duration
 	stopper               chan struct{}
+
+	// GlobalDigest commits to the published digests of all partitions. It is
+	// rebuilt whenever they change, under epochLock.
+	GlobalDigest core.GlobalDigest
+
+	// subscribers are signaled each time new digests are published; the map
+	// is created on the first subscription
+	subscribers     map[chan struct{}]struct{}
+	subscribersLock sync.Mutex
+
+	// the key the server signs the digests it publishes with, so that it can
+	// be held to them
+	signingSK []byte
+	signingVK []byte
+
+	// with a full audit, the hex keys of the full auditors, and how far each
+	// of them stored the updates of each partition, guarded by epochLock;
+	// the updates every full auditor stored are pruned
+	fullAuditors  []string
+	storedUpdates []map[string]uint64
 }
 
 type PartitionServer struct {
@@ -40,11 +60,15 @@ type PartitionServer struct {
 	NeedToRollUp     bool
 	NeedToRollUpLock *sync.Mutex
 
-	PublishedPos    uint64
-	PublishedDigest core.LegologDigest
+	PublishedPos            uint64
+	PublishedDigest         core.LegologDigest
+	PublishedInclusionProof core.PartitionInclusionProof
 
 	AppendLock *sync.Mutex
 	Index      int
+
+	// ValueIndexLock guards the index of each identifier's latest value
+	ValueIndexLock *sync.Mutex
 }
 
 type ValueRecord struct {
@@ -66,7 +90,9 @@ func (s *Server) IncrementUpdateEpoch() error {
 		}(partitionServer)
 	}
 	wg.Wait()
+	s.publishGlobalDigest()
 	s.epochLock.Unlock()
+	s.notifySubscribers()
 	return nil
 }
 
@@ -82,7 +108,7 @@ func (partitionServer *PartitionServer) IncrementUpdateEpoch() {
 	*/
 	partitionServer.PublishedPos = partitionServer.LastPos
 	partitionServer.Partition.IncrementUpdateEpoch()
-	partitionServer.PublishedDigest = *partitionServer.Partition.GetDigest()
+	partitionServer.publishDigest()
 	// fmt.Printf("Just set the digest for partition server %d with roots[0] as %s\n", partitionServer.Index, partitionServer.PublishedDigest.UpdateSetRoots[0])
 	partitionServer.LastPosLock.RUnlock()
 
@@ -100,30 +126,112 @@ func (s *Server) IncrementVerificationPeriod() error {
 	s.epochLock.Lock()
 	s.verificationEpoch += 1
 	var wg sync.WaitGroup
+	errs := make([]error, len(s.PartitionServers))
 	for i, partitionServer := range s.PartitionServers {
 		wg.Add(1)
 		go func(i int, partitionServer *PartitionServer) {
-			partitionServer.IncrementVerificationPeriod()
+			errs[i] = partitionServer.IncrementVerificationPeriod()
 			//fmt.Println(i, "pos is ", partitionServer.PublishedPos)
 			wg.Done()
 
 		}(i, partitionServer)
 	}
 	wg.Wait()
+	s.publishGlobalDigest()
 	s.epochLock.Unlock()
+	s.notifySubscribers()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("partition %d: %v", i, err)
+		}
+	}
 	return nil
 }
 
+// publishGlobalDigest rebuilds the global digest over the published digests
+// of all partitions and hands each partition its inclusion proof. Must be
+// called with epochLock held.
+func (s *Server) publishGlobalDigest() {
+	digests := make([]*core.LegologDigest, len(s.PartitionServers))
+	for i, partitionServer := range s.PartitionServers {
+		digests[i] = &partitionServer.PublishedDigest
+	}
+	s.GlobalDigest = *core.NewGlobalDigest(digests, s.epoch)
+	for i, partitionServer := range s.PartitionServers {
+		partitionServer.PublishedInclusionProof = *core.GeneratePartitionInclusionProof(digests, uint64(i))
+	}
+}
+
+// subscribe returns a channel that is signaled whenever new digests are
+// published, along with a function to stop the signals. Signals are not
+// queued, so a subscriber that falls behind only learns that it should read
+// the latest digests.
+func (s *Server) subscribe() (<-chan struct{}, func()) {
+	published := make(chan struct{}, 1)
+	s.subscribersLock.Lock()
+	defer s.subscribersLock.Unlock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan struct{}]struct{})
+	}
+	s.subscribers[published] = struct{}{}
+	return published, func() {
+		s.subscribersLock.Lock()
+		defer s.subscribersLock.Unlock()
+		delete(s.subscribers, published)
+	}
+}
+
+func (s *Server) notifySubscribers() {
+	s.subscribersLock.Lock()
+	defer s.subscribersLock.Unlock()
+	for published := range s.subscribers {
+		select {
+		case published <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SetSigningKey replaces the key the server signs published digests with, so
+// that auditors and clients can keep checking them across restarts. It must
+// be called before the server starts serving.
+func (s *Server) SetSigningKey(sk []byte, vk []byte) {
+	s.signingSK, s.signingVK = sk, vk
+}
+
+// SigningKey returns the key auditors and clients check the server's
+// published digests against.
+func (s *Server) SigningKey() []byte {
+	return s.signingVK
+}
+
+// signDigest returns the server's signature of a digest it published.
+func (s *Server) signDigest(digest *core.LegologDigest) []byte {
+	return core.SignDigest(s.signingSK, s.signingVK, digest).Signature
+}
+
+// PublishedDigests returns the published digest of a partition along with the
+// global digest and the partition's inclusion proof under it.
+func (s *Server) PublishedDigests(partitionServer *PartitionServer) (
+	core.LegologDigest, core.GlobalDigest, core.PartitionInclusionProof) {
+	s.epochLock.RLock()
+	defer s.epochLock.RUnlock()
+	return partitionServer.PublishedDigest, s.GlobalDigest, partitionServer.PublishedInclusionProof
+}
+
 // Should only be called from server's increment epoch
-func (partitionServer *PartitionServer) IncrementVerificationPeriod() {
+func (partitionServer *PartitionServer) IncrementVerificationPeriod() error {
 	partitionServer.NeedToRollUpLock.Lock()
 
 	if !partitionServer.NeedToRollUp {
 		partitionServer.NeedToRollUpLock.Unlock()
-		return
+		return nil
 	}
-	partitionServer.Partition.IncrementVerificationPeriod()
-	partitionServer.PublishedDigest = *partitionServer.Partition.GetDigest()
+	if err := partitionServer.Partition.IncrementVerificationPeriod(); err != nil {
+		partitionServer.NeedToRollUpLock.Unlock()
+		return err
+	}
+	partitionServer.publishDigest()
 	// fmt.Printf("Just set the digest for partition server %d with roots[0] as %s\n", partitionServer.Index, partitionServer.PublishedDigest.UpdateSetRoots[0])
 	partitionServer.NeedToRollUp = false
 	partitionServer.NeedToRollUpLock.Unlock()
@@ -131,15 +239,87 @@ func (partitionServer *PartitionServer) IncrementVerificationPeriod() {
 	//Dump obsolete extension proofs.
 	/* 	s.extensionProofCache = make(map[ExtensionProofKey][]byte)
 	 */
+	return nil
 }
 
 func (s *Server) GetPartitionForIdentifier(identifier []byte) *PartitionServer {
-	hash := uint(binary.BigEndian.Uint64(libcrypto.Hash(identifier)))
-	// if err != nil {
-	// 	fmt.Printf("Error while getting partition for identifier: %v\n", err)
-	// }
-	// fmt.Printf("hash is %d\n", hash)
-	return s.PartitionServers[hash%uint(len(s.PartitionServers))]
+	return s.PartitionServers[core.PartitionIndexForIdentifier(identifier, uint64(len(s.PartitionServers)))]
+}
+
+// publishDigest publishes the partition's current digest, stamped with the
+// time it is published at, which the server's signature then covers.
+func (partitionServer *PartitionServer) publishDigest() {
+	partitionServer.PublishedDigest = *partitionServer.Partition.GetDigest()
+	partitionServer.PublishedDigest.PublishedAt = time.Now().UnixNano()
+}
+
+// bindPartitions commits each partition's digests to its index, the number
+// of partitions and the log identifier, and publishes the bound digests.
+func (s *Server) bindPartitions(logID string) {
+	for _, partitionServer := range s.PartitionServers {
+		partitionServer.Partition.Bind(core.PartitionBinding{
+			LogID:          []byte(logID),
+			PartitionIndex: uint64(partitionServer.Index),
+			NumPartitions:  uint64(len(s.PartitionServers)),
+		})
+		partitionServer.publishDigest()
+	}
+}
+
+// keepUpdateHistory makes every partition keep the updates of each epoch for
+// the auditors to replay, if the config enables a full audit.
+func (s *Server) keepUpdateHistory(cfg *core.Config) {
+	if !cfg.FullAudit {
+		return
+	}
+	for _, partitionServer := range s.PartitionServers {
+		if history, ok := partitionServer.Partition.(core.UpdateHistoryProvider); ok {
+			history.KeepUpdateHistory()
+		}
+	}
+	for _, key := range cfg.FullAuditors {
+		s.fullAuditors = append(s.fullAuditors, strings.ToLower(key))
+	}
+	s.storedUpdates = make([]map[string]uint64, len(s.PartitionServers))
+}
+
+// recordStoredUpdates records how far a full auditor stored the updates of a
+// partition, and prunes the updates every full auditor stored. Other auditors
+// are not waited for, so their acknowledgements are ignored.
+func (s *Server) recordStoredUpdates(partitionServer *PartitionServer, history core.UpdateHistoryProvider,
+	ack *core.StoredUpdatesAck) error {
+	s.epochLock.Lock()
+	defer s.epochLock.Unlock()
+	if !ack.Verify(&partitionServer.PublishedDigest.Binding) {
+		return errors.New("acknowledgement is not signed by its auditor")
+	}
+	if s.storedUpdates == nil {
+		return nil
+	}
+	key := hex.EncodeToString(ack.AuditorKey)
+	known := false
+	for _, fullAuditor := range s.fullAuditors {
+		known = known || fullAuditor == key
+	}
+	if !known {
+		return nil
+	}
+	stored := s.storedUpdates[partitionServer.Index]
+	if stored == nil {
+		stored = make(map[string]uint64)
+		s.storedUpdates[partitionServer.Index] = stored
+	}
+	if ack.Through > stored[key] {
+		stored[key] = ack.Through
+	}
+	through := stored[key]
+	for _, fullAuditor := range s.fullAuditors {
+		if stored[fullAuditor] < through {
+			through = stored[fullAuditor]
+		}
+	}
+	history.PruneEpochUpdates(through)
+	return nil
 }
 
 // Stores user key to a key-value store on the server.
@@ -257,6 +437,7 @@ func NewServer(storage storage.Storage, updateEpochDuration time.Duration, numPa
 		verifyEpochDuration: cfg.VerificationPeriod,
 		stopper:             make(chan struct{}),
 	}
+	server.signingSK, server.signingVK = crypto.GenerateKeypair()
 
 	for i := 0; i < numPartitions; i += 1 {
 		var partitionServer *PartitionServer
@@ -269,6 +450,7 @@ func NewServer(storage storage.Storage, updateEpochDuration time.Duration, numPa
 				NeedToRollUp:     false,
 				NeedToRollUpLock: &sync.Mutex{},
 				AppendLock:       &sync.Mutex{},
+				ValueIndexLock:   &sync.Mutex{},
 				Index:            i,
 			}
 		} else {
@@ -280,11 +462,15 @@ func NewServer(storage storage.Storage, updateEpochDuration time.Duration, numPa
 				NeedToRollUp:     false,
 				NeedToRollUpLock: &sync.Mutex{},
 				AppendLock:       &sync.Mutex{},
+				ValueIndexLock:   &sync.Mutex{},
 				Index:            i,
 			}
 		}
 		server.PartitionServers = append(server.PartitionServers, partitionServer)
 	}
+	server.bindPartitions(cfg.LogID)
+	server.keepUpdateHistory(cfg)
+	server.publishGlobalDigest()
 
 	// server.PublishedDigest = server.MerkleSquare.GetDigest()
 
@@ -305,6 +491,7 @@ func NewStoppedServer(storage storage.Storage, cfg *core.Config, tmpdir string)
 		verifyEpochDuration: cfg.VerificationPeriod,
 		stopper:             make(chan struct{}),
 	}
+	server.signingSK, server.signingVK = crypto.GenerateKeypair()
 
 	for i := 0; i < int(cfg.Partitions); i += 1 {
 		var partitionServer *PartitionServer
@@ -317,6 +504,7 @@ func NewStoppedServer(storage storage.Storage, cfg *core.Config, tmpdir string)
 				NeedToRollUp:     false,
 				NeedToRollUpLock: &sync.Mutex{},
 				AppendLock:       &sync.Mutex{},
+				ValueIndexLock:   &sync.Mutex{},
 				Index:            i,
 			}
 		} else {
@@ -328,11 +516,15 @@ func NewStoppedServer(storage storage.Storage, cfg *core.Config, tmpdir string)
 				NeedToRollUp:     false,
 				NeedToRollUpLock: &sync.Mutex{},
 				AppendLock:       &sync.Mutex{},
+				ValueIndexLock:   &sync.Mutex{},
 				Index:            i,
 			}
 		}
 		server.PartitionServers = append(server.PartitionServers, partitionServer)
 	}
+	server.bindPartitions(cfg.LogID)
+	server.keepUpdateHistory(cfg)
+	server.publishGlobalDigest()
 	return server
 }
 
@@ -343,7 +535,7 @@ func (s *Server) Start() {
 }
 
 // NOT THREAD SAFE
-func (s *Server) append(user []byte, id []byte, val []byte, sig []byte) {
+func (s *Server) append(user []byte, id []byte, val []byte, sig []byte) error {
 	partitionServer := s.GetPartitionForIdentifier(id)
 
 	position := partitionServer.LastPos
@@ -353,24 +545,37 @@ func (s *Server) append(user []byte, id []byte, val []byte, sig []byte) {
 	partitionServer.Partition.Append(user, id, val, sig)
 
 	// Add to KV store
-	var serializedValue []byte
-	// Prepend to existing entry
-	ctx := context.Background()
-	original, _ := partitionServer.Storage.Get(ctx, id)
-	valueRecord := make([]ValueRecord, 1)
-	valueRecord[0] = ValueRecord{
+	return partitionServer.storeValueRecord(context.Background(), id, ValueRecord{
 		Position:  position,
 		Signature: sig,
 		Value:     val,
+	})
+}
+
+// NOT THREAD SAFE
+func (partitionServer *PartitionServer) bulkAppend(ids [][]byte, vals [][]byte, sigs [][]byte) error {
+	position := partitionServer.LastPos
+	partitionServer.LastPos += uint64(len(ids))
+
+	// Add to merkle tree
+	err := partitionServer.Partition.BulkAppend(ids, vals, sigs)
+	if err != nil {
+		return err
 	}
-	if original == nil {
-		serializedValue, _ = json.Marshal(valueRecord)
-	} else {
-		var deserialized []ValueRecord
-		json.Unmarshal(original, &deserialized)
-		serializedValue, _ = json.Marshal(append(valueRecord, deserialized...))
+
+	// Add to KV store
+	ctx := context.Background()
+	for i, id := range ids {
+		err = partitionServer.storeValueRecord(ctx, id, ValueRecord{
+			Position:  position + uint64(i),
+			Signature: sigs[i],
+			Value:     vals[i],
+		})
+		if err != nil {
+			return err
+		}
 	}
-	partitionServer.Storage.Put(ctx, id, serializedValue)
+	return nil
 }
 
 func (s *Server) PreloadServer(startIdx int, numAppends int, idSize int, valSize int) {
@@ -385,13 +590,35 @@ func (s *Server) PreloadServer(startIdx int, numAppends int, idSize int, valSize
 	sig := make([]byte, 64)
 	value := generateRandomByteArray(valSize)
 	crypto.SignBlob(masterSK, masterVK, sig, append(value, []byte("1")...))
+
+	// Group the appends by partition so each partition's base tree can be
+	// bulk loaded in one pass.
+	ids := make([][][]byte, len(s.PartitionServers))
 	for i := 0; i < numAppends; i += 1 {
 		id := []byte(strconv.Itoa(i + startIdx))
-		s.append([]byte{}, id, value, sig)
-		if i%1e5 == 0 {
-			fmt.Println("Loaded", i, "entries")
+		partitionIndex := s.GetPartitionForIdentifier(id).Index
+		ids[partitionIndex] = append(ids[partitionIndex], id)
+	}
+
+	var wg sync.WaitGroup
+	for i, partitionServer := range s.PartitionServers {
+		vals := make([][]byte, len(ids[i]))
+		sigs := make([][]byte, len(ids[i]))
+		for j := range ids[i] {
+			vals[j] = value
+			sigs[j] = sig
 		}
+		wg.Add(1)
+		go func(partitionServer *PartitionServer, ids [][]byte) {
+			defer wg.Done()
+			err := partitionServer.bulkAppend(ids, vals, sigs)
+			if err != nil {
+				panic(err)
+			}
+		}(partitionServer, ids[i])
 	}
+	wg.Wait()
+	fmt.Println("Loaded", numAppends, "entries")
 }
 
 func generateRandomByteArray(size int) []byte {
@@ -443,7 +670,10 @@ queryLoop:
 				untilNextVerificationPeriod = verificationTicker.C
 			}
 			log.Println("start incrementing verification epoch")
-			s.IncrementVerificationPeriod()
+			if err := s.IncrementVerificationPeriod(); err != nil {
+				log.Println("stopping epoch loop, could not increment verification epoch:", err)
+				break queryLoop
+			}
 			log.Println("stop incrementing verification epoch")
 
 		case <-s.stopper:
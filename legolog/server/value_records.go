@@ -0,0 +1,158 @@
+package legolog
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/huyuncong/MerkleSquare/lib/storage"
+)
+
+// The values appended to an identifier are stored one record per position,
+// under valueRecordKey(id, position), so that an append writes a single
+// record whatever the identifier's history. latestPositionKey(id) indexes the
+// position of the identifier's latest value.
+//
+// Older servers stored the identifier's whole history as one JSON list under
+// the identifier itself, newest record first. Such a list is migrated to the
+// layout above the first time the identifier is read or appended to.
+
+const (
+	valueRecordPrefix    = "value/"
+	latestPositionPrefix = "latest/"
+)
+
+// valueRecordKey is the storage key of the record of the value appended to
+// id at position. The identifier is length prefixed, so that no two
+// identifiers and positions share a key.
+func valueRecordKey(id []byte, position uint64) []byte {
+	key := appendIdentifier([]byte(valueRecordPrefix), id)
+	return binary.BigEndian.AppendUint64(key, position)
+}
+
+// latestPositionKey is the storage key of the position of id's latest value.
+func latestPositionKey(id []byte) []byte {
+	return appendIdentifier([]byte(latestPositionPrefix), id)
+}
+
+func appendIdentifier(key []byte, id []byte) []byte {
+	key = binary.AppendUvarint(key, uint64(len(id)))
+	return append(key, id...)
+}
+
+// storeValueRecord stores the record of a value appended to an identifier
+// and, unless a later value was stored first, indexes it as the latest one.
+// The record and the index are written in one batch.
+func (partitionServer *PartitionServer) storeValueRecord(ctx context.Context, id []byte, record ValueRecord) error {
+	serializedRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	batch := new(storage.Batch)
+	batch.Put(valueRecordKey(id, record.Position), serializedRecord)
+
+	// appenders to the same identifier may store their records out of order,
+	// so the index only ever moves forward
+	partitionServer.ValueIndexLock.Lock()
+	defer partitionServer.ValueIndexLock.Unlock()
+	latest, found, err := partitionServer.latestPosition(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !found || record.Position > latest {
+		batch.Put(latestPositionKey(id), encodePosition(record.Position))
+	}
+	return partitionServer.Storage.Write(ctx, batch)
+}
+
+// latestValueRecord returns the record of the latest value appended to id,
+// or nil if none was.
+func (partitionServer *PartitionServer) latestValueRecord(ctx context.Context, id []byte) (*ValueRecord, error) {
+	partitionServer.ValueIndexLock.Lock()
+	latest, found, err := partitionServer.latestPosition(ctx, id)
+	partitionServer.ValueIndexLock.Unlock()
+	if err != nil || !found {
+		return nil, err
+	}
+	return partitionServer.valueRecord(ctx, id, latest)
+}
+
+// valueRecord returns the record of the value appended to id at position, or
+// nil if there is none.
+func (partitionServer *PartitionServer) valueRecord(ctx context.Context, id []byte, position uint64) (*ValueRecord, error) {
+	serializedRecord, err := partitionServer.Storage.Get(ctx, valueRecordKey(id, position))
+	if err != nil || len(serializedRecord) == 0 {
+		return nil, err
+	}
+	var record ValueRecord
+	err = json.Unmarshal(serializedRecord, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// latestPosition returns the indexed position of id's latest value,
+// migrating a list stored by an older server first. The caller must hold
+// ValueIndexLock.
+func (partitionServer *PartitionServer) latestPosition(ctx context.Context, id []byte) (uint64, bool, error) {
+	serializedPosition, err := partitionServer.Storage.Get(ctx, latestPositionKey(id))
+	if err != nil {
+		return 0, false, err
+	}
+	if len(serializedPosition) != 0 {
+		position, err := decodePosition(serializedPosition)
+		return position, err == nil, err
+	}
+	return partitionServer.migrateValueRecords(ctx, id)
+}
+
+// migrateValueRecords moves the JSON list an older server stored under id to
+// one record per position, and returns the position of its latest value. The
+// records, the index and the removal of the list are written in one batch, so
+// that a migration is never left half done. The caller must hold
+// ValueIndexLock.
+func (partitionServer *PartitionServer) migrateValueRecords(ctx context.Context, id []byte) (uint64, bool, error) {
+	serializedList, err := partitionServer.Storage.Get(ctx, id)
+	if err != nil || len(serializedList) == 0 {
+		return 0, false, err
+	}
+	var records []ValueRecord
+	if json.Unmarshal(serializedList, &records) != nil || len(records) == 0 {
+		// not a list of value records, such as a master key record stored
+		// under the same key
+		return 0, false, nil
+	}
+
+	batch := new(storage.Batch)
+	var latest uint64
+	for i, record := range records {
+		serializedRecord, err := json.Marshal(record)
+		if err != nil {
+			return 0, false, err
+		}
+		batch.Put(valueRecordKey(id, record.Position), serializedRecord)
+		if i == 0 || record.Position > latest {
+			latest = record.Position
+		}
+	}
+	batch.Put(latestPositionKey(id), encodePosition(latest))
+	batch.Delete(id)
+	err = partitionServer.Storage.Write(ctx, batch)
+	if err != nil {
+		return 0, false, err
+	}
+	return latest, true, nil
+}
+
+func encodePosition(position uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, position)
+}
+
+func decodePosition(serializedPosition []byte) (uint64, error) {
+	if len(serializedPosition) != 8 {
+		return 0, errors.New("malformed latest position index")
+	}
+	return binary.BigEndian.Uint64(serializedPosition), nil
+}
@@ -0,0 +1,91 @@
+package legolog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/huyuncong/MerkleSquare/lib/storage"
+)
+
+func newTestPartitionServer() *PartitionServer {
+	return &PartitionServer{
+		Storage:        storage.NewMapStorage(),
+		ValueIndexLock: &sync.Mutex{},
+	}
+}
+
+func TestValueRecordsKeepLatest(t *testing.T) {
+	ctx := context.Background()
+	partitionServer := newTestPartitionServer()
+	id := []byte("alice")
+	if record, err := partitionServer.latestValueRecord(ctx, id); err != nil || record != nil {
+		t.Fatalf("expected no record for a new identifier, got %v, %v", record, err)
+	}
+
+	// appenders may store their records out of order
+	for _, position := range []uint64{3, 7, 5} {
+		err := partitionServer.storeValueRecord(ctx, id, ValueRecord{Position: position, Value: []byte{byte(position)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	record, err := partitionServer.latestValueRecord(ctx, id)
+	if err != nil || record == nil || record.Position != 7 || !bytes.Equal(record.Value, []byte{7}) {
+		t.Fatalf("expected the record at position 7, got %+v, %v", record, err)
+	}
+	if record, _ := partitionServer.valueRecord(ctx, id, 5); record == nil || record.Position != 5 {
+		t.Errorf("expected the record at position 5 to be kept, got %+v", record)
+	}
+
+	// identifiers that prefix one another do not share records
+	other := []byte("alice/")
+	if record, _ := partitionServer.latestValueRecord(ctx, other); record != nil {
+		t.Errorf("expected no record for %q, got %+v", other, record)
+	}
+}
+
+func TestValueRecordsMigrateLegacyList(t *testing.T) {
+	ctx := context.Background()
+	partitionServer := newTestPartitionServer()
+	id := []byte("alice")
+	legacy, _ := json.Marshal([]ValueRecord{
+		{Position: 4, Value: []byte("new")},
+		{Position: 1, Value: []byte("old")},
+	})
+	partitionServer.Storage.Put(ctx, id, legacy)
+	// a master key record is not a value list, and is left alone
+	masterKey, _ := json.Marshal(ValueRecord{Position: 0, Value: []byte("mk")})
+	partitionServer.Storage.Put(ctx, []byte("bobMK"), masterKey)
+
+	record, err := partitionServer.latestValueRecord(ctx, id)
+	if err != nil || record == nil || !bytes.Equal(record.Value, []byte("new")) {
+		t.Fatalf("expected the newest legacy record, got %+v, %v", record, err)
+	}
+	if record, _ := partitionServer.valueRecord(ctx, id, 1); record == nil || !bytes.Equal(record.Value, []byte("old")) {
+		t.Errorf("expected the older legacy record to be migrated, got %+v", record)
+	}
+	if list, _ := partitionServer.Storage.Get(ctx, id); len(list) != 0 {
+		t.Error("expected the legacy list to be cleared")
+	}
+
+	// appends to an identifier with a legacy list extend its history
+	other := []byte("carol")
+	partitionServer.Storage.Put(ctx, other, legacy)
+	err = partitionServer.storeValueRecord(ctx, other, ValueRecord{Position: 2, Value: []byte("stale")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := partitionServer.latestValueRecord(ctx, other); record == nil || record.Position != 4 {
+		t.Errorf("expected the legacy record at position 4 to stay latest, got %+v", record)
+	}
+
+	if record, err := partitionServer.latestValueRecord(ctx, []byte("bobMK")); err != nil || record != nil {
+		t.Errorf("expected no value record under a master key, got %+v, %v", record, err)
+	}
+	if mk, _ := partitionServer.Storage.Get(ctx, []byte("bobMK")); !bytes.Equal(mk, masterKey) {
+		t.Error("master key record was modified")
+	}
+}
@@ -4,7 +4,8 @@ import (
 	"golang.org/x/crypto/sha3"
 )
 
-const hashSize = 32
+// HashSize is the length in bytes of every output of Hash.
+const HashSize = 32
 
 // Hash takes in []byte inputs and outputs a hash value
 func Hash(ms ...[]byte) []byte {
@@ -14,7 +15,7 @@ func Hash(ms ...[]byte) []byte {
 	for _, m := range ms {
 		h.Write(m)
 	}
-	ret := make([]byte, hashSize)
+	ret := make([]byte, HashSize)
 	h.Read(ret)
 
 	return ret
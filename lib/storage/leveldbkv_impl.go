@@ -5,6 +5,7 @@ import (
 
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type leveldbkv leveldb.DB
@@ -25,13 +26,56 @@ func Wrap(db *leveldb.DB) Storage {
 }
 
 func (db *leveldbkv) Get(ctx context.Context, key []byte) ([]byte, error) {
-	return (*leveldb.DB)(db).Get(key, nil)
+	value, err := (*leveldb.DB)(db).Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return value, err
 }
 
 func (db *leveldbkv) Put(ctx context.Context, key, value []byte) error {
 	return (*leveldb.DB)(db).Put(key, value, &opt.WriteOptions{Sync: true})
 }
 
+func (db *leveldbkv) Delete(ctx context.Context, key []byte) error {
+	return (*leveldb.DB)(db).Delete(key, &opt.WriteOptions{Sync: true})
+}
+
+// Iterate reads from an iterator over an implicit snapshot of the database,
+// copying each pair since the iterator reuses its buffers.
+func (db *leveldbkv) Iterate(ctx context.Context, r Range, fn func(key []byte, value []byte) error) error {
+	iter := (*leveldb.DB)(db).NewIterator(&util.Range{Start: r.Start, Limit: r.Limit}, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := fn(append([]byte{}, iter.Key()...), append([]byte{}, iter.Value()...))
+		if err == ErrStopIteration {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+func (db *leveldbkv) Write(ctx context.Context, batch *Batch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	levelBatch := new(leveldb.Batch)
+	for _, write := range batch.writes {
+		if write.delete {
+			levelBatch.Delete(write.key)
+		} else {
+			levelBatch.Put(write.key, write.value)
+		}
+	}
+	return (*leveldb.DB)(db).Write(levelBatch, &opt.WriteOptions{Sync: true})
+}
+
 func (db *leveldbkv) Close(ctx context.Context) error {
 	return (*leveldb.DB)(db).Close()
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"sort"
 	"sync"
 )
 
@@ -50,6 +51,72 @@ func (ms *MapStorage) Put(ctx context.Context, key []byte, value []byte) error {
 	return nil
 }
 
+// Delete removes the key from the underlying map.
+func (ms *MapStorage) Delete(ctx context.Context, key []byte) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delete(ms.data, string(key))
+	return nil
+}
+
+// Iterate calls fn with the pairs in range r of a copy of the underlying map,
+// so that fn may write to the storage.
+func (ms *MapStorage) Iterate(ctx context.Context, r Range, fn func(key []byte, value []byte) error) error {
+	ms.lock.RLock()
+	if err := ctx.Err(); err != nil {
+		ms.lock.RUnlock()
+		return err
+	}
+	var keys []string
+	snapshot := make(map[string][]byte)
+	for key, value := range ms.data {
+		if r.Contains([]byte(key)) {
+			keys = append(keys, key)
+			snapshot[key] = value
+		}
+	}
+	ms.lock.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := fn([]byte(key), append([]byte{}, snapshot[key]...))
+		if err == ErrStopIteration {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write applies the batch to the underlying map under a single lock.
+func (ms *MapStorage) Write(ctx context.Context, batch *Batch) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, write := range batch.writes {
+		if write.delete {
+			delete(ms.data, string(write.key))
+		} else {
+			ms.data[string(write.key)] = write.value
+		}
+	}
+	return nil
+}
+
 func (ms *MapStorage) Close(ctx context.Context) error {
 	ms.data = nil
 	return nil
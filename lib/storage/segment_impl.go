@@ -0,0 +1,509 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SegmentStorage is a storage system for append-mostly workloads. Every write
+// is appended to the newest of a sequence of segment files, which are never
+// rewritten, and an in-memory index maps each key to where its value lies in
+// them. There is no compaction, so overwritten and deleted values keep taking
+// space.
+//
+// Each write is one frame: its length, a checksum, and the operations it
+// applies. A write torn by a crash fails its checksum, and is truncated away
+// when the storage is reopened.
+type SegmentStorage struct {
+	dir     string
+	options SegmentOptions
+
+	segments   []*os.File // by segment number; the last one is written to
+	activeSize int64
+	index      map[string][]valueRef
+	closed     bool
+	// broken is set when a failed write could not be undone, leaving bytes
+	// the index does not account for at the end of the active segment; no
+	// more writes are taken after it
+	broken error
+	lock   sync.RWMutex
+}
+
+// SegmentOptions tune a SegmentStorage.
+type SegmentOptions struct {
+	// MaxSegmentSize is the size past which a new segment file is started.
+	MaxSegmentSize int64
+	// NoSync skips syncing each write to disk, so that a crash may lose the
+	// latest writes, though never part of one.
+	NoSync bool
+}
+
+// DefaultSegmentOptions are used when none are given.
+var DefaultSegmentOptions = SegmentOptions{
+	MaxSegmentSize: 64 << 20,
+}
+
+// valueRef locates part of a value in a segment. A value Append was called on
+// is made of several parts.
+type valueRef struct {
+	segment int
+	offset  int64
+	length  int
+}
+
+const (
+	segmentSuffix     = ".seg"
+	frameHeaderLength = 8 // payload length and checksum
+
+	opPut    byte = 1
+	opDelete byte = 2
+	opAppend byte = 3
+)
+
+var (
+	errStorageClosed  = errors.New("storage is closed")
+	errCorruptSegment = errors.New("corrupt segment")
+	crcTable          = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// OpenSegmentStorage opens the segment storage in dir, creating it if needed,
+// and rebuilds its index from the segments. options may be nil for
+// DefaultSegmentOptions.
+func OpenSegmentStorage(dir string, options *SegmentOptions) (*SegmentStorage, error) {
+	s := &SegmentStorage{
+		dir:     dir,
+		options: DefaultSegmentOptions,
+		index:   make(map[string][]valueRef),
+	}
+	if options != nil {
+		s.options = *options
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	numbers, err := segmentNumbers(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, number := range numbers {
+		if number != i {
+			s.closeSegments()
+			return nil, fmt.Errorf("segment %d is missing from %s", i, dir)
+		}
+		err = s.openSegment(i == len(numbers)-1)
+		if err != nil {
+			s.closeSegments()
+			return nil, err
+		}
+	}
+	if len(s.segments) == 0 {
+		err = s.startSegment()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// segmentNumbers returns the numbers of the segments in dir, in order.
+func segmentNumbers(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var numbers []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimSuffix(name, segmentSuffix))
+		if err != nil {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (s *SegmentStorage) segmentPath(number int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d%s", number, segmentSuffix))
+}
+
+// openSegment opens the next segment and replays its frames into the index.
+// A bad frame in the last segment is a torn write, and it and anything after
+// it are truncated away; in an earlier one, the storage is corrupt.
+func (s *SegmentStorage) openSegment(last bool) error {
+	number := len(s.segments)
+	f, err := os.OpenFile(s.segmentPath(number), os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.segments = append(s.segments, f)
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	for offset < info.Size() {
+		payload, err := readFrame(f, offset, info.Size())
+		if err == nil {
+			err = s.apply(payload, number, offset+frameHeaderLength)
+		}
+		if err == errCorruptSegment && last {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("segment %d at offset %d: %v", number, offset, err)
+		}
+		offset += frameHeaderLength + int64(len(payload))
+	}
+	if offset < info.Size() {
+		err = f.Truncate(offset)
+		if err != nil {
+			return err
+		}
+	}
+	s.activeSize = offset
+	return nil
+}
+
+// readFrame reads the payload of the frame at offset, or returns
+// errCorruptSegment if the frame is cut short or fails its checksum.
+func readFrame(f *os.File, offset int64, size int64) ([]byte, error) {
+	if size-offset < frameHeaderLength {
+		return nil, errCorruptSegment
+	}
+	header := make([]byte, frameHeaderLength)
+	_, err := f.ReadAt(header, offset)
+	if err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(header))
+	if size-offset-frameHeaderLength < length {
+		return nil, errCorruptSegment
+	}
+	payload := make([]byte, length)
+	_, err = f.ReadAt(payload, offset+frameHeaderLength)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errCorruptSegment
+	}
+	return payload, nil
+}
+
+// apply applies the operations of a frame payload, written at offset of
+// segment, to the index. The payload is checked in full first, so that a
+// frame is applied whole or not at all.
+func (s *SegmentStorage) apply(payload []byte, segment int, offset int64) error {
+	ops, err := decodeOps(payload)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		key := string(payload[op.keyStart:op.keyEnd])
+		ref := valueRef{segment, offset + int64(op.valueStart), op.valueEnd - op.valueStart}
+		switch op.kind {
+		case opPut:
+			s.index[key] = []valueRef{ref}
+		case opDelete:
+			delete(s.index, key)
+		case opAppend:
+			s.index[key] = append(s.index[key], ref)
+		}
+	}
+	return nil
+}
+
+// decodedOp is an operation of a frame payload, with the bounds of its key
+// and value in the payload.
+type decodedOp struct {
+	kind                 byte
+	keyStart, keyEnd     int
+	valueStart, valueEnd int
+}
+
+func decodeOps(payload []byte) ([]decodedOp, error) {
+	var ops []decodedOp
+	for i := 0; i < len(payload); {
+		op := decodedOp{kind: payload[i]}
+		if op.kind != opPut && op.kind != opDelete && op.kind != opAppend {
+			return nil, errCorruptSegment
+		}
+		i++
+		var ok bool
+		op.keyStart, op.keyEnd, ok = decodeField(payload, i)
+		if !ok {
+			return nil, errCorruptSegment
+		}
+		op.valueStart, op.valueEnd, ok = decodeField(payload, op.keyEnd)
+		if !ok {
+			return nil, errCorruptSegment
+		}
+		i = op.valueEnd
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// decodeField returns the bounds of the length-prefixed field at i.
+func decodeField(payload []byte, i int) (int, int, bool) {
+	length, n := binary.Uvarint(payload[i:])
+	if n <= 0 || length > uint64(len(payload)-i-n) {
+		return 0, 0, false
+	}
+	return i + n, i + n + int(length), true
+}
+
+func encodeOp(payload []byte, kind byte, key []byte, value []byte) []byte {
+	payload = append(payload, kind)
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = binary.AppendUvarint(payload, uint64(len(value)))
+	return append(payload, value...)
+}
+
+// write appends a frame with payload to the active segment, starting a new
+// one first if the frame would take it past the maximum size, and applies it
+// to the index. The caller must hold the write lock.
+func (s *SegmentStorage) write(payload []byte) error {
+	if s.closed {
+		return errStorageClosed
+	}
+	if s.broken != nil {
+		return s.broken
+	}
+	frame := make([]byte, frameHeaderLength, frameHeaderLength+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:], crc32.Checksum(payload, crcTable))
+	frame = append(frame, payload...)
+
+	if s.activeSize > 0 && s.activeSize+int64(len(frame)) > s.options.MaxSegmentSize {
+		err := s.startSegment()
+		if err != nil {
+			return err
+		}
+	}
+	active := s.segments[len(s.segments)-1]
+	_, err := active.Write(frame)
+	if err == nil && !s.options.NoSync {
+		err = active.Sync()
+	}
+	if err != nil {
+		// drop whatever part of the frame made it to the file, so that later
+		// frames, appended at its end, are where the index expects them
+		truncateErr := active.Truncate(s.activeSize)
+		if truncateErr != nil {
+			s.broken = fmt.Errorf("segment storage is broken: could not undo a failed write: %v", truncateErr)
+		}
+		return err
+	}
+	offset := s.activeSize + frameHeaderLength
+	s.activeSize += int64(len(frame))
+	return s.apply(payload, len(s.segments)-1, offset)
+}
+
+// startSegment creates the next segment and makes it the active one.
+func (s *SegmentStorage) startSegment() error {
+	f, err := os.OpenFile(s.segmentPath(len(s.segments)), os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if !s.options.NoSync {
+		err = syncDir(s.dir)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	s.segments = append(s.segments, f)
+	s.activeSize = 0
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// read reads the value made of refs. The caller must hold the read lock.
+func (s *SegmentStorage) read(refs []valueRef) ([]byte, error) {
+	length := 0
+	for _, ref := range refs {
+		length += ref.length
+	}
+	value := make([]byte, length)
+	i := 0
+	for _, ref := range refs {
+		_, err := s.segments[ref.segment].ReadAt(value[i:i+ref.length], ref.offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		i += ref.length
+	}
+	return value, nil
+}
+
+// Get reads the key's value from the segments it was written to.
+func (s *SegmentStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.closed {
+		return nil, errStorageClosed
+	}
+
+	refs, ok := s.index[string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return s.read(refs)
+}
+
+// Put appends the key-value pair to the active segment.
+func (s *SegmentStorage) Put(ctx context.Context, key []byte, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.write(encodeOp(nil, opPut, key, value))
+}
+
+// Append appends data to the key's value by writing only data to the active
+// segment.
+func (s *SegmentStorage) Append(ctx context.Context, key string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.write(encodeOp(nil, opAppend, []byte(key), data))
+}
+
+// Delete appends a deletion of the key to the active segment.
+func (s *SegmentStorage) Delete(ctx context.Context, key []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.write(encodeOp(nil, opDelete, key, nil))
+}
+
+// Iterate takes the locations of the values in range r from the index and
+// then reads them, so that fn may write to the storage. Values are never
+// moved once written, so the locations stay valid.
+func (s *SegmentStorage) Iterate(ctx context.Context, r Range, fn func(key []byte, value []byte) error) error {
+	s.lock.RLock()
+	if err := ctx.Err(); err != nil {
+		s.lock.RUnlock()
+		return err
+	}
+	if s.closed {
+		s.lock.RUnlock()
+		return errStorageClosed
+	}
+	var keys []string
+	snapshot := make(map[string][]valueRef)
+	for key, refs := range s.index {
+		if r.Contains([]byte(key)) {
+			keys = append(keys, key)
+			snapshot[key] = refs
+		}
+	}
+	s.lock.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.lock.RLock()
+		value, err := s.read(snapshot[key])
+		if s.closed {
+			err = errStorageClosed
+		}
+		s.lock.RUnlock()
+		if err != nil {
+			return err
+		}
+		err = fn([]byte(key), value)
+		if err == ErrStopIteration {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write appends the whole batch to the active segment as a single frame.
+func (s *SegmentStorage) Write(ctx context.Context, batch *Batch) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(batch.writes) == 0 {
+		return nil
+	}
+	var payload []byte
+	for _, write := range batch.writes {
+		if write.delete {
+			payload = encodeOp(payload, opDelete, write.key, nil)
+		} else {
+			payload = encodeOp(payload, opPut, write.key, write.value)
+		}
+	}
+	return s.write(payload)
+}
+
+// Close closes the segment files.
+func (s *SegmentStorage) Close(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.closeSegments()
+}
+
+func (s *SegmentStorage) closeSegments() error {
+	var err error
+	for _, f := range s.segments {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
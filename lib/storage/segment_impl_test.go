@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestSegmentStorage(t *testing.T, dir string, options *SegmentOptions) *SegmentStorage {
+	s, err := OpenSegmentStorage(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSegmentStorageReopens(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := openTestSegmentStorage(t, dir, &SegmentOptions{MaxSegmentSize: 64})
+	for i := 0; i < 10; i++ {
+		s.Put(ctx, []byte{'k', byte(i)}, []byte("a value long enough to fill segments"))
+	}
+	s.Append(ctx, "log", []byte("ab"))
+	s.Append(ctx, "log", []byte("cd"))
+	s.Delete(ctx, []byte{'k', 3})
+	batch := new(Batch)
+	batch.Put([]byte("journal"), []byte("entry"))
+	batch.Delete([]byte{'k', 4})
+	s.Write(ctx, batch)
+	s.Close(ctx)
+
+	if files := segmentFiles(t, dir); len(files) < 10 {
+		t.Errorf("expected the writes to be spread over segments, got %d", len(files))
+	}
+	s = openTestSegmentStorage(t, dir, nil)
+	defer s.Close(ctx)
+	expected := map[string]string{
+		"log":     "abcd",
+		"journal": "entry",
+		"k\x02":   "a value long enough to fill segments",
+		"k\x03":   "",
+		"k\x04":   "",
+	}
+	for key, value := range expected {
+		if stored, _ := s.Get(ctx, []byte(key)); string(stored) != value {
+			t.Errorf("expected %q to map to %q after reopening, got %q", key, value, stored)
+		}
+	}
+}
+
+func TestSegmentStorageTruncatesTornWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := openTestSegmentStorage(t, dir, nil)
+	s.Put(ctx, []byte("kept"), []byte("value"))
+	s.Close(ctx)
+	path := segmentFiles(t, dir)[0]
+	info, _ := os.Stat(path)
+	intact := info.Size()
+
+	// a crash part way through a batch leaves a frame cut short, or one with
+	// garbage in it
+	for _, torn := range [][]byte{{0, 0, 0}, {0, 0, 0, 4, 1, 2, 3, 4, 5, 6, 7, 8}} {
+		f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		f.Write(torn)
+		f.Close()
+
+		s = openTestSegmentStorage(t, dir, nil)
+		if value, _ := s.Get(ctx, []byte("kept")); string(value) != "value" {
+			t.Errorf("expected the intact write to survive, got %q", value)
+		}
+		if info, _ := os.Stat(path); info.Size() != intact {
+			t.Errorf("expected the torn write to be truncated to %d bytes, got %d", intact, info.Size())
+		}
+		// writes after recovery are not lost behind the torn frame
+		s.Put(ctx, []byte("after"), []byte("recovery"))
+		s.Close(ctx)
+		s = openTestSegmentStorage(t, dir, nil)
+		if value, _ := s.Get(ctx, []byte("after")); string(value) != "recovery" {
+			t.Errorf("expected the write after recovery to survive, got %q", value)
+		}
+		s.Delete(ctx, []byte("after"))
+		s.Close(ctx)
+		info, _ := os.Stat(path)
+		intact = info.Size()
+	}
+}
+
+func TestSegmentStorageRefusesCorruptEarlierSegment(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := openTestSegmentStorage(t, dir, &SegmentOptions{MaxSegmentSize: 16})
+	s.Put(ctx, []byte("first"), []byte("segment"))
+	s.Put(ctx, []byte("second"), []byte("segment"))
+	s.Close(ctx)
+	files := segmentFiles(t, dir)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(files))
+	}
+	f, _ := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{0, 0})
+	f.Close()
+	if _, err := OpenSegmentStorage(dir, nil); err == nil {
+		t.Error("expected a segment corrupt before the last one to be refused")
+	}
+
+	os.Remove(files[0])
+	if _, err := OpenSegmentStorage(dir, nil); err == nil {
+		t.Error("expected a missing segment to be refused")
+	}
+}
+
+func TestSegmentStorageBreaksOnUndoneWrite(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSegmentStorage(t, t.TempDir(), nil)
+	s.Put(ctx, []byte("kept"), []byte("value"))
+
+	// a write that fails and cannot be truncated away leaves the end of the
+	// active segment unknown, so later writes are refused
+	active := s.segments[len(s.segments)-1]
+	active.Close()
+	if err := s.Put(ctx, []byte("lost"), []byte("value")); err == nil {
+		t.Fatal("expected the write to a closed segment to fail")
+	}
+	if err := s.Put(ctx, []byte("after"), []byte("value")); err == nil || s.broken == nil || err != s.broken {
+		t.Errorf("expected writes after an undone failure to be refused, got %v", err)
+	}
+	if value, _ := s.Get(ctx, []byte("lost")); value != nil {
+		t.Errorf("expected the failed write not to be indexed, got %q", value)
+	}
+}
@@ -1,17 +1,37 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
 
 // Storage is an interface for an internally synchronized key-value storage
 // system that a server can use to store data.
 type Storage interface {
-	// Get returns the value associated with a certain key.
+	// Get returns the value associated with a certain key, or nil if the
+	// key is not mapped.
 	Get(ctx context.Context, key []byte) ([]byte, error)
 
 	// Put maps a key to a value, creating a new mapping if the key is not
 	// already mapped.
 	Put(ctx context.Context, key []byte, value []byte) error
 
+	// Delete removes the mapping of a key, if there is one.
+	Delete(ctx context.Context, key []byte) error
+
+	// Iterate calls fn with each key-value pair whose key is in r, in
+	// increasing key order. It sees the storage as it was when called, and
+	// fn may write to the storage and keep the slices it is passed. If fn
+	// returns an error, Iterate stops and returns it, or nil if it is
+	// ErrStopIteration.
+	Iterate(ctx context.Context, r Range, fn func(key []byte, value []byte) error) error
+
+	// Write applies all the writes of a batch, or none of them.
+	Write(ctx context.Context, batch *Batch) error
+
 	// Closes the database.
 	Close(ctx context.Context) error
 }
@@ -22,3 +42,86 @@ type AppendableStorage interface {
 	Storage
 	Append(ctx context.Context, key string, data []byte) error
 }
+
+// ErrStopIteration can be returned by the function passed to Iterate to stop
+// iterating early without failing.
+var ErrStopIteration = errors.New("stop iteration")
+
+// Range is the range of keys from Start, inclusive, to Limit, exclusive. A
+// nil Start or Limit leaves the range unbounded on that side.
+type Range struct {
+	Start []byte
+	Limit []byte
+}
+
+// PrefixRange returns the range of the keys that start with prefix.
+func PrefixRange(prefix []byte) Range {
+	r := Range{Start: prefix}
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			r.Limit = append([]byte{}, prefix[:i+1]...)
+			r.Limit[i]++
+			break
+		}
+	}
+	return r
+}
+
+// Contains reports whether key is in the range.
+func (r Range) Contains(key []byte) bool {
+	return (r.Start == nil || string(key) >= string(r.Start)) &&
+		(r.Limit == nil || string(key) < string(r.Limit))
+}
+
+// Batch is a sequence of writes applied together by Storage.Write. Later
+// writes to a key override earlier ones.
+type Batch struct {
+	writes []batchWrite
+}
+
+type batchWrite struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// Put adds a write mapping key to value to the batch.
+func (b *Batch) Put(key []byte, value []byte) {
+	b.writes = append(b.writes, batchWrite{key: key, value: value})
+}
+
+// Delete adds a write removing the mapping of key to the batch.
+func (b *Batch) Delete(key []byte) {
+	b.writes = append(b.writes, batchWrite{key: key, delete: true})
+}
+
+// Len returns the number of writes in the batch.
+func (b *Batch) Len() int {
+	return len(b.writes)
+}
+
+// The storage backends Open can select.
+const (
+	MemoryBackend  = "memory"
+	LevelDBBackend = "leveldb"
+	SegmentBackend = "segment"
+)
+
+// Open opens the storage backend named backend, which keeps its files in dir
+// unless it is the memory one.
+func Open(backend string, dir string) (Storage, error) {
+	switch backend {
+	case MemoryBackend:
+		return NewMapStorage(), nil
+	case LevelDBBackend:
+		db, err := leveldb.OpenFile(dir, nil)
+		if err != nil {
+			return nil, err
+		}
+		return Wrap(db), nil
+	case SegmentBackend:
+		return OpenSegmentStorage(dir, nil)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
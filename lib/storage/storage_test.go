@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -17,6 +18,139 @@ func storageGetPutTest(ctx context.Context, t *testing.T, storage Storage) {
 	}
 }
 
+func storageDeleteTest(ctx context.Context, t *testing.T, storage Storage) {
+	key := []byte("hello")
+	storage.Put(ctx, key, []byte("world"))
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.Get(ctx, key); err != nil || value != nil {
+		t.Errorf("expected a deleted key to have no value, got %q, %v", value, err)
+	}
+	if err := storage.Delete(ctx, []byte("never stored")); err != nil {
+		t.Errorf("deleting a key that is not mapped failed: %v", err)
+	}
+}
+
+func storageIterateTest(ctx context.Context, t *testing.T, storage Storage) {
+	for _, key := range []string{"b/2", "a/1", "b/1", "b\xff", "c", "b/3"} {
+		storage.Put(ctx, []byte(key), []byte("value of "+key))
+	}
+	iterate := func(r Range) []string {
+		var keys []string
+		err := storage.Iterate(ctx, r, func(key []byte, value []byte) error {
+			if string(value) != "value of "+string(key) {
+				t.Errorf("key %q has value %q", key, value)
+			}
+			keys = append(keys, string(key))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+
+	if keys := iterate(PrefixRange([]byte("b/"))); !reflect.DeepEqual(keys, []string{"b/1", "b/2", "b/3"}) {
+		t.Errorf("prefix iteration returned %q", keys)
+	}
+	if keys := iterate(Range{Start: []byte("b/2"), Limit: []byte("c")}); !reflect.DeepEqual(keys, []string{"b/2", "b/3", "b\xff"}) {
+		t.Errorf("range iteration returned %q", keys)
+	}
+	if keys := iterate(Range{}); len(keys) != 6 {
+		t.Errorf("expected an unbounded range to return all 6 keys, got %q", keys)
+	}
+
+	// the iteration sees the storage as it was when it started, and can
+	// stop early
+	var keys []string
+	err := storage.Iterate(ctx, PrefixRange([]byte("b")), func(key []byte, value []byte) error {
+		storage.Put(ctx, []byte("b/25"), []byte("added"))
+		storage.Delete(ctx, []byte("b/3"))
+		keys = append(keys, string(key))
+		if len(keys) == 3 {
+			return ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(keys, []string{"b/1", "b/2", "b/3"}) {
+		t.Errorf("expected to stop after the snapshot's first 3 keys, got %q, %v", keys, err)
+	}
+
+	failure := errors.New("failure")
+	err = storage.Iterate(ctx, Range{}, func(key []byte, value []byte) error {
+		return failure
+	})
+	if err != failure {
+		t.Errorf("expected the error returned by fn, got %v", err)
+	}
+}
+
+func storageWriteTest(ctx context.Context, t *testing.T, storage Storage) {
+	storage.Put(ctx, []byte("journal"), []byte("old"))
+	batch := new(Batch)
+	batch.Put([]byte("record"), []byte("appended"))
+	batch.Put([]byte("journal"), []byte("new"))
+	batch.Delete([]byte("journal"))
+	batch.Put([]byte("journal"), []byte("newer"))
+	batch.Delete([]byte("record"))
+	batch.Put([]byte("record"), []byte("final"))
+	if batch.Len() != 6 {
+		t.Errorf("expected 6 writes in the batch, got %d", batch.Len())
+	}
+	if err := storage.Write(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]string{"journal": "newer", "record": "final"} {
+		if value, _ := storage.Get(ctx, []byte(key)); string(value) != expected {
+			t.Errorf("expected %q to map to %q, got %q", key, expected, value)
+		}
+	}
+	if err := storage.Write(ctx, new(Batch)); err != nil {
+		t.Errorf("writing an empty batch failed: %v", err)
+	}
+}
+
+func storageAppendTest(ctx context.Context, t *testing.T, storage AppendableStorage) {
+	key := "log"
+	for _, data := range []string{"a", "bc", "", "d"} {
+		if err := storage.Append(ctx, key, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, _ := storage.Get(ctx, []byte(key)); string(value) != "abcd" {
+		t.Errorf("expected the appended data, got %q", value)
+	}
+	storage.Put(ctx, []byte(key), []byte("reset"))
+	storage.Append(ctx, key, []byte("!"))
+	if value, _ := storage.Get(ctx, []byte(key)); string(value) != "reset!" {
+		t.Errorf("expected appends to extend the value put, got %q", value)
+	}
+}
+
+// storageConformanceTest runs the tests every Storage implementation must
+// pass, each against a fresh storage.
+func storageConformanceTest(t *testing.T, open func(t *testing.T) Storage) {
+	tests := map[string]func(context.Context, *testing.T, Storage){
+		"GetPut":  storageGetPutTest,
+		"Delete":  storageDeleteTest,
+		"Iterate": storageIterateTest,
+		"Write":   storageWriteTest,
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			storage := open(t)
+			defer storage.Close(ctx)
+			test(ctx, t, storage)
+		})
+	}
+}
+
+func openTestLeveldbkv(t *testing.T) Storage {
+	return OpenFile(t.TempDir())
+}
+
 func TestMapGetPut(t *testing.T) {
 	ctx := context.Background()
 	storageGetPutTest(ctx, t, NewMapStorage())
@@ -24,12 +158,57 @@ func TestMapGetPut(t *testing.T) {
 
 func TestLeveldbkvGetPut(t *testing.T) {
 	ctx := context.Background()
-	//dir, err := ioutil.TempDir("", "teststore")
-	//if err != nil {
-	//	panic(err)
-	//}
-	//defer os.RemoveAll(dir)
-	db := OpenFile("teststore")
+	db := openTestLeveldbkv(t)
 	defer db.Close(ctx)
 	storageGetPutTest(ctx, t, db)
 }
+
+func TestMapConformance(t *testing.T) {
+	storageConformanceTest(t, func(t *testing.T) Storage {
+		return NewMapStorage()
+	})
+	storageAppendTest(context.Background(), t, NewMapStorage())
+}
+
+func TestLeveldbkvConformance(t *testing.T) {
+	storageConformanceTest(t, openTestLeveldbkv)
+}
+
+func TestSegmentConformance(t *testing.T) {
+	storageConformanceTest(t, func(t *testing.T) Storage {
+		return openTestSegmentStorage(t, t.TempDir(), nil)
+	})
+	storageAppendTest(context.Background(), t, openTestSegmentStorage(t, t.TempDir(), nil))
+}
+
+func TestOpenBackend(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range []string{MemoryBackend, LevelDBBackend, SegmentBackend} {
+		storage, err := Open(backend, t.TempDir())
+		if err != nil {
+			t.Fatalf("could not open the %s backend: %v", backend, err)
+		}
+		storageGetPutTest(ctx, t, storage)
+		storage.Close(ctx)
+	}
+	if _, err := Open("unknown", t.TempDir()); err == nil {
+		t.Error("expected an unknown backend to be refused")
+	}
+}
+
+func TestPrefixRange(t *testing.T) {
+	cases := []struct {
+		prefix string
+		limit  []byte
+	}{
+		{"ab", []byte("ac")},
+		{"a\xff", []byte("b")},
+		{"\xff\xff", nil},
+		{"", nil},
+	}
+	for _, c := range cases {
+		if r := PrefixRange([]byte(c.prefix)); !reflect.DeepEqual(r.Limit, c.limit) {
+			t.Errorf("expected the range of prefix %q to end at %q, got %q", c.prefix, c.limit, r.Limit)
+		}
+	}
+}
@@ -69,7 +69,14 @@ func (s *Server) Append(stream grpcint.MerkleSquare_AppendServer) error {
 		return errors.New("Verification failed")
 	}
 	//Add to merkle tree
-	s.MerkleSquare.Append(s.vrfPrivKey.Compute(user), key, signature)
+	err = s.MerkleSquare.Append(s.vrfPrivKey.Compute(user), key, signature)
+	if err != nil {
+		// the position was not used, e.g. because the forest is full
+		s.LastPos--
+		s.appendLock.Unlock()
+		s.LastPosLock.Unlock()
+		return err
+	}
 	s.appendLock.Unlock()
 	s.LastPosLock.Unlock()
 
@@ -209,10 +209,15 @@ func (s *Server) RegisterUserKey(ctx context.Context, user []byte,
 	s.LastPosLock.Lock()
 	s.appendLock.Lock()
 	position := s.LastPos
-	s.LastPos++
-	s.MerkleSquare.Append(s.vrfPrivKey.Compute(user), key, signature)
+	err := s.MerkleSquare.Append(s.vrfPrivKey.Compute(user), key, signature)
+	if err == nil {
+		s.LastPos++
+	}
 	s.appendLock.Unlock()
 	s.LastPosLock.Unlock()
+	if err != nil {
+		return 0, err
+	}
 
 	serializedKey, _ := json.Marshal(
 		KeyRecord{
@@ -236,9 +241,15 @@ func (s *Server) AppendUserKey(ctx context.Context, user []byte,
 	crypto.SignBlob(privkey, key, signature,
 		append(key, []byte(strconv.Itoa(int(position)))...))
 	//Add to merkle tree
-	s.MerkleSquare.Append(s.vrfPrivKey.Compute(user), key, signature)
+	err := s.MerkleSquare.Append(s.vrfPrivKey.Compute(user), key, signature)
+	if err != nil {
+		s.LastPos--
+	}
 	s.appendLock.Unlock()
 	s.LastPosLock.Unlock()
+	if err != nil {
+		return 0, err
+	}
 
 	//4. Add to K-V store
 	var serializedKey []byte